@@ -21,21 +21,45 @@ These certificates are suitable for development and testing only.`,
 		certPath, _ := cmd.Flags().GetString("cert-path")
 		keyPath, _ := cmd.Flags().GetString("key-path")
 		certDir, _ := cmd.Flags().GetString("cert-dir")
+		isCA, _ := cmd.Flags().GetBool("ca")
+
+		certName, keyName := "server.crt", "server.key"
+		if isCA {
+			certName, keyName = "jarvis-ca.crt", "jarvis-ca.key"
+		}
 
 		if certDir != "" {
 			if certPath == "" {
-				certPath = filepath.Join(certDir, "server.crt")
+				certPath = filepath.Join(certDir, certName)
 			}
 			if keyPath == "" {
-				keyPath = filepath.Join(certDir, "server.key")
+				keyPath = filepath.Join(certDir, keyName)
 			}
 		}
 
 		if certPath == "" {
-			certPath = "./certs/server.crt"
+			certPath = filepath.Join("./certs", certName)
 		}
 		if keyPath == "" {
-			keyPath = "./certs/server.key"
+			keyPath = filepath.Join("./certs", keyName)
+		}
+
+		if isCA {
+			slog.Info("Generating interception CA", "cert_path", certPath, "key_path", keyPath)
+			if err := certs.GenerateCA(certPath, keyPath); err != nil {
+				slog.Error("Failed to generate CA", "error", err)
+				os.Exit(1)
+			}
+
+			fmt.Println("✅ CA generation complete!")
+			fmt.Println("-----------------------------------")
+			fmt.Printf("📝 CA Certificate: %s\n", certPath)
+			fmt.Printf("📝 CA Private Key: %s\n", keyPath)
+			fmt.Println("-----------------------------------")
+			fmt.Println("⚠️  Note: Trust this CA only on test machines; it can impersonate any host.")
+			fmt.Println("   To use it with the forward proxy:")
+			fmt.Printf("   jarvis proxy --forward --ca-cert %s --ca-key %s\n", certPath, keyPath)
+			return
 		}
 
 		slog.Info("Generating self-signed certificate", "cert_path", certPath)
@@ -75,6 +99,7 @@ func init() {
 	certCmd.Flags().StringP("cert-path", "c", "", "Output path for certificate file")
 	certCmd.Flags().StringP("key-path", "k", "", "Output path for private key file")
 	certCmd.Flags().StringP("cert-dir", "d", "", "Directory to store certificate and key files")
+	certCmd.Flags().Bool("ca", false, "Generate a CA for forward-proxy TLS interception instead of a server certificate")
 
 	viper.BindPFlag("tls.cert_file", certCmd.Flags().Lookup("cert-path"))
 	viper.BindPFlag("tls.key_file", certCmd.Flags().Lookup("key-path"))
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"time"
+
+	conf "github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+	"github.com/dipjyotimetia/jarvis/internal/proxy"
+	"github.com/dipjyotimetia/jarvis/pkg/logger"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var mockCmd = &cobra.Command{
+	Use:   "mock",
+	Short: "Serve an OpenAPI spec as a mock backend",
+	Long: `Serve every operation of an OpenAPI spec without an upstream. Responses use the
+spec's example or examples, or a payload generated from the schema. Requests are
+validated against the spec like in the proxy, and served traffic is recorded to
+the traffic database so it shows up in the web UI.
+
+Clients choose a response with the Prefer header:
+  Prefer: code=404        serve the 404 response (or the default response)
+  Prefer: example=admin   serve the example named admin
+  Prefer: dynamic=true    generate the body from the schema, ignoring examples`,
+	Example: `  # Mock an API on port 8080
+  jarvis mock --spec api.yaml
+
+  # Ask for an error response
+  curl -H "Prefer: code=404" http://localhost:8080/users/42
+
+  # Serve requests that fail validation instead of rejecting them
+  jarvis mock --spec api.yaml --continue-on-error`,
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		// Bound here rather than in init so the proxy command keeps its own flags
+		_ = viper.BindPFlag("mock.spec_path", cmd.Flags().Lookup("spec"))
+		_ = viper.BindPFlag("http_port", cmd.Flags().Lookup("http-port"))
+		_ = viper.BindPFlag("ui_port", cmd.Flags().Lookup("ui-port"))
+		_ = viper.BindPFlag("api_validation.validate_requests", cmd.Flags().Lookup("validate-req"))
+		_ = viper.BindPFlag("api_validation.continue_on_validation", cmd.Flags().Lookup("continue-on-error"))
+		if viper.GetString("mock.spec_path") == "" {
+			return fmt.Errorf("--spec or mock.spec_path is required")
+		}
+		return nil
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := conf.LoadConfig(viper.GetViper())
+		if err != nil {
+			logger.Fatal("❌ Failed to load configuration: %v", err)
+		}
+
+		database, stmt, err := db.Initialize(cfg.SQLiteDBPath)
+		if err != nil {
+			logger.Fatal("❌ Failed to initialize database: %v", err)
+		}
+		defer database.Close()
+		defer stmt.Close()
+
+		records := db.NewWriter(database, stmt, db.WriterOptions{
+			QueueSize:     cfg.Recorder.QueueSize,
+			BatchSize:     cfg.Recorder.BatchSize,
+			FlushInterval: cfg.Recorder.FlushInterval,
+			OnFull:        cfg.Recorder.OnFull,
+			BlockTimeout:  cfg.Recorder.BlockTimeout,
+		})
+
+		ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, os.Kill)
+		defer cancel()
+
+		server := proxy.StartMockServer(ctx, cfg, records)
+		if server == nil {
+			logger.Fatal("❌ Failed to start mock server for %s", cfg.Mock.SpecPath)
+		}
+		logger.Info("🎭 Mocking %s at http://localhost:%d", cfg.Mock.SpecPath, cfg.HTTPPort)
+
+		// Editing the spec or the config file serves the new version
+		reloader := proxy.NewReloader(cfg, reloadConfig)
+		reloader.Add(server)
+		if watch, _ := cmd.Flags().GetBool("watch"); watch {
+			reload := func() {
+				if _, err := reloader.Reload(); err == nil {
+					logger.Info("🔄 Mock spec reloaded")
+				}
+			}
+			for _, path := range []string{cfg.Mock.SpecPath, viper.ConfigFileUsed()} {
+				if path == "" {
+					continue
+				}
+				if err := conf.Watch(ctx, path, reload); err != nil {
+					logger.Error("⚠️ Failed to watch %s for changes: %v", path, err)
+				}
+			}
+		}
+
+		uiServer := &http.Server{
+			Addr:    fmt.Sprintf(":%d", cfg.UIPort),
+			Handler: newUIMux(cfg, database, records, reloader),
+		}
+		go func() {
+			logger.Info("🌐 Starting web UI at http://localhost:%d/ui/", cfg.UIPort)
+			if err := uiServer.ListenAndServe(); err != http.ErrServerClosed {
+				logger.Error("⚠️ Web UI server error: %v", err)
+			}
+		}()
+
+		<-ctx.Done()
+		logger.Info("🚨 Shutdown signal received, initiating graceful shutdown...")
+
+		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer shutdownCancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("⚠️ Mock server shutdown error: %v", err)
+		}
+		if err := uiServer.Shutdown(shutdownCtx); err != nil {
+			logger.Error("⚠️ UI server shutdown error: %v", err)
+		}
+
+		// Flush queued records before the database is closed
+		if err := records.Close(shutdownCtx); err != nil {
+			logger.Error("⚠️ Recorded traffic flush error: %v", err)
+		}
+		logger.Info("🏁 Mock server stopped")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(mockCmd)
+
+	mockCmd.Flags().String("spec", "", "Path to the OpenAPI specification to serve (JSON or YAML), overrides mock.spec_path")
+	mockCmd.Flags().Int("http-port", 8080, "Mock server port")
+	mockCmd.Flags().Int("ui-port", 9090, "Port for the web UI")
+	mockCmd.Flags().Bool("validate-req", true, "Validate requests against the spec")
+	mockCmd.Flags().Bool("continue-on-error", false, "Serve requests that fail validation instead of rejecting them with 400")
+	mockCmd.Flags().Bool("watch", true, "Reload when the spec or config file changes")
+}
@@ -2,16 +2,22 @@ package cmd
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"sync"
 	"time"
 
 	conf "github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/blob"
+	"github.com/dipjyotimetia/jarvis/internal/certs"
 	"github.com/dipjyotimetia/jarvis/internal/db"
+	"github.com/dipjyotimetia/jarvis/internal/metrics"
 	"github.com/dipjyotimetia/jarvis/internal/proxy"
+	"github.com/dipjyotimetia/jarvis/internal/tracing"
 	"github.com/dipjyotimetia/jarvis/internal/web"
 	"github.com/dipjyotimetia/jarvis/pkg/logger"
 	"github.com/spf13/cobra"
@@ -20,6 +26,12 @@ import (
 
 var timeout int
 
+// namedServer pairs a proxy server with the name used in shutdown logs
+type namedServer struct {
+	name   string
+	server proxy.Server
+}
+
 var proxyCmd = &cobra.Command{
 	Use:   "proxy",
 	Short: "Start the traffic inspector proxy server",
@@ -32,9 +44,21 @@ var proxyCmd = &cobra.Command{
   
   # Run in replay mode
   jarvis proxy --replay
+
+  # Replay recorded requests and record the ones that are missing
+  jarvis proxy --record-missing
+
+  # Replay only, failing loudly on any request without a recording
+  jarvis proxy --offline
   
   # Enable TLS support
-  jarvis proxy --tls --cert ./certs/server.crt --key ./certs/server.key`,
+  jarvis proxy --tls --cert ./certs/server.crt --key ./certs/server.key
+
+  # Run as a forward proxy (clients set HTTPS_PROXY=http://localhost:8080)
+  jarvis proxy --forward --record
+
+  # Proxy gRPC over h2c and decode recordings with local protos
+  jarvis proxy --grpc --grpc-target http://localhost:50051 --proto-path ./protos --record`,
 	Run: func(cmd *cobra.Command, args []string) {
 		cfg, err := conf.LoadConfig(viper.GetViper())
 		if err != nil {
@@ -42,6 +66,25 @@ var proxyCmd = &cobra.Command{
 		}
 		logger.Info("🔧 Configuration loaded: Mode=%s", getMode(cfg))
 
+		if cfg.ForwardProxy.Enabled {
+			created, err := certs.EnsureCA(cfg.ForwardProxy.CACert, cfg.ForwardProxy.CAKey)
+			if err != nil {
+				logger.Fatal("❌ Failed to prepare forward proxy CA: %v", err)
+			}
+			if created {
+				logger.Info("🔐 Generated forward proxy CA at %s, add it to your clients' trust store", cfg.ForwardProxy.CACert)
+			}
+		}
+
+		// Spans of proxied requests are exported to an OTLP collector
+		shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+		if err != nil {
+			logger.Fatal("❌ Failed to set up tracing: %v", err)
+		}
+		if cfg.Tracing.Enabled {
+			logger.Info("🔭 Exporting traces to %s", cfg.Tracing.Endpoint)
+		}
+
 		database, stmt, err := db.Initialize(cfg.SQLiteDBPath)
 		if err != nil {
 			logger.Fatal("❌ Failed to initialize database: %v", err)
@@ -49,6 +92,15 @@ var proxyCmd = &cobra.Command{
 		defer database.Close()
 		defer stmt.Close()
 
+		// Recorded traffic is queued and inserted in batches by a single writer
+		records := db.NewWriter(database, stmt, db.WriterOptions{
+			QueueSize:     cfg.Recorder.QueueSize,
+			BatchSize:     cfg.Recorder.BatchSize,
+			FlushInterval: cfg.Recorder.FlushInterval,
+			OnFull:        cfg.Recorder.OnFull,
+			BlockTimeout:  cfg.Recorder.BlockTimeout,
+		})
+
 		// Create context with timeout if specified
 		var ctx context.Context
 		var cancel context.CancelFunc
@@ -62,17 +114,29 @@ var proxyCmd = &cobra.Command{
 		defer cancel()
 
 		var wg sync.WaitGroup
-		var servers []proxy.Server
+		var serversMu sync.Mutex
+		var servers []namedServer
 		var uiServer *http.Server
 
+		// Reloading swaps routes, modes, validation and TLS settings while
+		// in-flight requests finish on the configuration they started with
+		reloader := proxy.NewReloader(cfg, reloadConfig)
+
+		addServer := func(name string, srv proxy.Server) {
+			if srv == nil {
+				return
+			}
+			serversMu.Lock()
+			servers = append(servers, namedServer{name: name, server: srv})
+			serversMu.Unlock()
+			reloader.Add(srv)
+		}
+
 		if cfg.HTTPPort > 0 {
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				httpServer := proxy.StartHTTPProxy(ctx, cfg, database, stmt)
-				if httpServer != nil {
-					servers = append(servers, httpServer)
-				}
+				addServer("HTTP", proxy.StartHTTPProxy(ctx, cfg, database, records))
 			}()
 		}
 
@@ -80,10 +144,15 @@ var proxyCmd = &cobra.Command{
 			wg.Add(1)
 			go func() {
 				defer wg.Done()
-				httpsServer := proxy.StartHTTPSProxy(ctx, cfg, database, stmt)
-				if httpsServer != nil {
-					servers = append(servers, httpsServer)
-				}
+				addServer("HTTPS", proxy.StartHTTPSProxy(ctx, cfg, database, records))
+			}()
+		}
+
+		if cfg.GRPC.Enabled {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				addServer("gRPC", proxy.StartGRPCProxy(ctx, cfg, database, records))
 			}()
 		}
 
@@ -96,12 +165,9 @@ var proxyCmd = &cobra.Command{
 					uiPort = 9090 // Default UI port
 				}
 
-				// Create UI handler
-				uiHandler := web.NewUIHandler(database)
-
 				// Create a mux and register routes
-				mux := http.NewServeMux()
-				uiHandler.RegisterRoutes(mux)
+				mux := newUIMux(cfg, database, records, reloader)
+				proxy.RegisterReplayRoutes(mux)
 
 				// Create the server
 				uiServer = &http.Server{
@@ -116,6 +182,19 @@ var proxyCmd = &cobra.Command{
 			}()
 		}
 
+		if watch, _ := cmd.Flags().GetBool("watch"); watch && viper.ConfigFileUsed() != "" {
+			err := conf.Watch(ctx, viper.ConfigFileUsed(), func() {
+				if cfg, err := reloader.Reload(); err == nil {
+					logger.Info("🔄 Configuration reloaded: Mode=%s", getMode(cfg))
+				}
+			})
+			if err != nil {
+				logger.Error("⚠️ Failed to watch %s for changes: %v", viper.ConfigFileUsed(), err)
+			} else {
+				logger.Info("👀 Watching %s for changes", viper.ConfigFileUsed())
+			}
+		}
+
 		<-ctx.Done()
 		logger.Info("🚨 Shutdown signal received, initiating graceful shutdown...")
 
@@ -123,22 +202,20 @@ var proxyCmd = &cobra.Command{
 		defer shutdownCancel()
 
 		var shutdownWg sync.WaitGroup
-		for i, server := range servers {
+		serversMu.Lock()
+		for _, named := range servers {
 			shutdownWg.Add(1)
-			go func(idx int, srv proxy.Server) {
+			go func(name string, srv proxy.Server) {
 				defer shutdownWg.Done()
-				serverType := "HTTP"
-				if idx == 1 {
-					serverType = "HTTPS"
-				}
-				logger.Info("⏳ Shutting down %s server...", serverType)
+				logger.Info("⏳ Shutting down %s server...", name)
 				if err := srv.Shutdown(shutdownCtx); err != nil {
-					logger.Error("⚠️ %s server shutdown error: %v", serverType, err)
+					logger.Error("⚠️ %s server shutdown error: %v", name, err)
 				} else {
-					logger.Info("✅ %s server stopped gracefully", serverType)
+					logger.Info("✅ %s server stopped gracefully", name)
 				}
-			}(i, server)
+			}(named.name, named.server)
 		}
+		serversMu.Unlock()
 
 		// Add UI server shutdown
 		if uiServer != nil {
@@ -157,6 +234,15 @@ var proxyCmd = &cobra.Command{
 		shutdownWg.Wait()
 
 		wg.Wait()
+
+		// Flush queued records before the database is closed
+		logger.Info("⏳ Flushing recorded traffic...")
+		if err := records.Close(shutdownCtx); err != nil {
+			logger.Error("⚠️ Recorded traffic flush error: %v", err)
+		}
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error("⚠️ Trace export error: %v", err)
+		}
 		logger.Info("🏁 All servers stopped")
 	},
 }
@@ -166,6 +252,15 @@ func init() {
 
 	proxyCmd.Flags().BoolP("record", "r", false, "Run in recording mode")
 	proxyCmd.Flags().BoolP("replay", "p", false, "Run in replay mode")
+	proxyCmd.Flags().Bool("record-missing", false, "Replay recorded matches and record misses from the target")
+	proxyCmd.Flags().Bool("offline", false, "Strict offline replay: requests without a recording fail with 502 and never reach the target")
+	proxyCmd.Flags().Bool("replay-debug", false, "Explain why recordings were or were not selected in replay mode")
+	proxyCmd.Flags().Bool("sequential", false, "Replay the Nth recording to the Nth matching request of a session (X-Session-ID/X-Test-ID)")
+	proxyCmd.Flags().String("on-exhausted", "repeat_last", "What sequential replay does when recordings run out: repeat_last, not_found or passthrough")
+	proxyCmd.Flags().Float64("stream-pacing", 1, "Scale of the recorded delays between replayed stream events; 0 sends them at once")
+	proxyCmd.Flags().String("replay-latency", "off", "Reproduce recorded latency in replayed responses: off, recorded or distribution")
+	proxyCmd.Flags().Float64("latency-factor", 1, "Scale of the reproduced replay latency")
+	proxyCmd.Flags().Bool("ai-fallback", false, "Generate responses with Ollama for replayed requests without a recording")
 	// HTTP options
 	proxyCmd.Flags().Int("http-port", 8080, "HTTP proxy port")
 	proxyCmd.Flags().String("target-url", "", "Default target URL for proxying (used when no route matches)")
@@ -181,7 +276,19 @@ func init() {
 	proxyCmd.Flags().String("client-cert", "", "Client certificate file for outbound mTLS connections")
 	proxyCmd.Flags().String("client-key", "", "Client key file for outbound mTLS connections")
 
+	// Forward proxy flags
+	proxyCmd.Flags().Bool("forward", false, "Run as a forward proxy, intercepting CONNECT tunnels")
+	proxyCmd.Flags().String("ca-cert", "", "CA certificate used to mint interception certificates (generated if missing)")
+	proxyCmd.Flags().String("ca-key", "", "CA private key used to mint interception certificates (generated if missing)")
+
+	// gRPC flags
+	proxyCmd.Flags().Bool("grpc", false, "Enable the gRPC (h2c) proxy")
+	proxyCmd.Flags().Int("grpc-port", 50052, "gRPC proxy port")
+	proxyCmd.Flags().String("grpc-target", "", "Target URL for gRPC traffic (defaults to target-url)")
+	proxyCmd.Flags().StringSlice("proto-path", nil, "Directories with .proto files used to decode gRPC messages")
+
 	proxyCmd.Flags().Int("ui-port", 9090, "Port for the web UI")
+	proxyCmd.Flags().Bool("watch", true, "Reload the configuration when the config file changes")
 
 	// Add OpenAPI validation flags
 	proxyCmd.Flags().Bool("api-validate", false, "Enable OpenAPI validation")
@@ -227,6 +334,33 @@ func init() {
 	conf.BindProxyFlags(proxyCmd)
 }
 
+// newUIMux registers the web UI and the reload, recorder and metrics
+// endpoints served on the UI port
+func newUIMux(cfg *conf.Config, database *sql.DB, records *db.Writer, reloader *proxy.Reloader) *http.ServeMux {
+	// Create UI handler, reading large bodies from the blob store
+	var blobs *blob.Store
+	if !cfg.BlobStore.Disabled {
+		blobs = blob.NewStore(cfg.BlobStore.Dir, cfg.BlobStore.MaxBodySize)
+	}
+	uiHandler := web.NewUIHandler(database, blobs)
+	uiHandler.SetTraceURL(cfg.Tracing.TraceURL)
+
+	mux := http.NewServeMux()
+	uiHandler.RegisterRoutes(mux)
+	reloader.RegisterRoutes(mux)
+	proxy.RegisterRecorderRoutes(mux, records)
+	metrics.RegisterRoutes(mux)
+	return mux
+}
+
+// reloadConfig reads the config file again and validates the result
+func reloadConfig() (*conf.Config, error) {
+	if err := viper.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+	return conf.LoadConfig(viper.GetViper())
+}
+
 func getMode(cfg *conf.Config) string {
 	mode := "Passthrough"
 	if cfg.RecordingMode {
@@ -234,6 +368,22 @@ func getMode(cfg *conf.Config) string {
 	}
 	if cfg.ReplayMode {
 		mode = "Replay"
+		if cfg.Replay.Sequential {
+			mode = "Sequential replay"
+		}
+		if cfg.StrictOffline {
+			mode = "Strict offline " + strings.ToLower(mode)
+		}
+	}
+	if cfg.RecordMissing {
+		mode = "Record missing (replay hits, record misses)"
+	}
+
+	if cfg.ForwardProxy.Enabled {
+		mode += " as forward proxy"
+	}
+	if cfg.GRPC.Enabled {
+		mode += " with gRPC"
 	}
 
 	if cfg.TLS.Enabled {
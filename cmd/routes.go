@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	conf "github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/pkg/logger"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var routesCmd = &cobra.Command{
+	Use:   "routes",
+	Short: "Show the proxy routing table or explain which route a request hits",
+	Long: `Show the target routes from the configuration. With --test, every route is
+evaluated against the given URL and the one the proxy would use is marked.
+The most specific route wins: an exact host beats a wildcard host, which beats
+no host; then the longest literal path, exact paths over templates and regexes
+over prefixes, and more method and header conditions. Equally specific routes
+keep configuration order.`,
+	Example: `  # List the routing table
+  jarvis proxy routes
+
+  # Explain which route a request would hit
+  jarvis proxy routes --test /api/v1/users/is-available
+
+  # Include the host, method and headers of the request
+  jarvis proxy routes --test https://api.example.com/users/42 -X POST -H "X-Tenant: blue"`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cfg, err := conf.LoadConfig(viper.GetViper())
+		if err != nil {
+			logger.Fatal("❌ Failed to load configuration: %v", err)
+		}
+
+		testURL, _ := cmd.Flags().GetString("test")
+		if testURL == "" {
+			printRoutes(cfg)
+			return
+		}
+
+		method, _ := cmd.Flags().GetString("method")
+		headers, _ := cmd.Flags().GetStringArray("header")
+		req, err := http.NewRequest(strings.ToUpper(method), testURL, nil)
+		if err != nil {
+			logger.Fatal("❌ Invalid test URL: %v", err)
+		}
+		for _, h := range headers {
+			name, value, ok := strings.Cut(h, ":")
+			if !ok {
+				logger.Fatal("❌ Invalid header %q, expected \"Name: value\"", h)
+			}
+			req.Header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+		}
+		explainRoute(cfg, req)
+	},
+}
+
+// printRoutes lists the configured routes
+func printRoutes(cfg *conf.Config) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tROUTE\tTARGET\tTIMEOUT\tTLS")
+	for i, route := range cfg.TargetRoutes {
+		timeout := "-"
+		if route.Timeout > 0 {
+			timeout = route.Timeout.String()
+		}
+		tlsOverride := "-"
+		if route.TLS.IsSet() {
+			tlsOverride = "custom"
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", i+1, route, describeTargets(&route), timeout, tlsOverride)
+	}
+	if cfg.HTTPTargetURL != "" {
+		fmt.Fprintf(w, "-\t(default)\t%s\t-\t-\n", cfg.HTTPTargetURL)
+	}
+	w.Flush()
+}
+
+// explainRoute prints how every route was evaluated for req and where the
+// proxy would send it
+func explainRoute(cfg *conf.Config, req *http.Request) {
+	fmt.Printf("Request: %s %s%s\n\n", req.Method, req.Host, req.URL.RequestURI())
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tROUTE\tRESULT")
+	var selected *conf.RouteCandidate
+	candidates := cfg.ExplainRoutes(req)
+	for i := range candidates {
+		c := &candidates[i]
+		result := "✗ " + c.Reason
+		switch {
+		case c.Selected:
+			selected = c
+			result = "✓ selected, " + describeSpecificity(c.Specificity)
+		case c.Matched:
+			result = "✓ matched, less specific: " + describeSpecificity(c.Specificity)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", c.Index+1, c.Route, result)
+	}
+	w.Flush()
+	fmt.Println()
+
+	if selected == nil {
+		if cfg.HTTPTargetURL == "" {
+			fmt.Println("No route matches and no http_target_url is set; the proxy would fail the request")
+			return
+		}
+		fmt.Printf("No route matches, using http_target_url\n→ %s%s\n", strings.TrimSuffix(cfg.HTTPTargetURL, "/"), req.URL.RequestURI())
+		return
+	}
+
+	route := selected.Route
+	path := req.URL.Path
+	if route.StripPrefix {
+		path = strings.TrimPrefix(path, strings.TrimSuffix(route.PathPrefix, "/*"))
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+	}
+	for _, target := range route.Targets() {
+		upstream := strings.TrimSuffix(target.URL, "/") + path
+		if req.URL.RawQuery != "" {
+			upstream += "?" + req.URL.RawQuery
+		}
+		fmt.Printf("Route %d → %s\n", selected.Index+1, upstream)
+	}
+	if len(selected.Params) > 0 {
+		names := make([]string, 0, len(selected.Params))
+		for name := range selected.Params {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("  %s = %s\n", name, selected.Params[name])
+		}
+	}
+	if route.Timeout > 0 {
+		fmt.Printf("  timeout %s\n", route.Timeout)
+	}
+}
+
+// describeTargets lists the upstreams of a route with its balancing strategy
+func describeTargets(route *conf.TargetRoute) string {
+	if len(route.Upstreams) == 0 {
+		return route.TargetURL
+	}
+	urls := make([]string, len(route.Upstreams))
+	for i, u := range route.Upstreams {
+		urls[i] = u.URL
+		if route.LoadBalancing == conf.LoadBalanceWeighted {
+			urls[i] += fmt.Sprintf("(%d)", max(u.Weight, 1))
+		}
+	}
+	strategy := route.LoadBalancing
+	if strategy == "" {
+		strategy = conf.LoadBalanceRoundRobin
+	}
+	return strategy + ": " + strings.Join(urls, ", ")
+}
+
+func describeSpecificity(s conf.RouteSpecificity) string {
+	parts := []string{fmt.Sprintf("%d literal path chars", s.Path)}
+	switch s.Host {
+	case 2:
+		parts = append([]string{"exact host"}, parts...)
+	case 1:
+		parts = append([]string{"wildcard host"}, parts...)
+	}
+	switch s.Kind {
+	case 2:
+		parts = append(parts, "exact path")
+	case 1:
+		parts = append(parts, "path pattern")
+	}
+	if s.Conditions > 0 {
+		parts = append(parts, fmt.Sprintf("%d conditions", s.Conditions))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func init() {
+	proxyCmd.AddCommand(routesCmd)
+
+	routesCmd.Flags().String("test", "", "URL or path to evaluate against the routes")
+	routesCmd.Flags().StringP("method", "X", http.MethodGet, "Request method used with --test")
+	routesCmd.Flags().StringArrayP("header", "H", nil, "Request header used with --test, as \"Name: value\" (repeatable)")
+}
@@ -0,0 +1,178 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// AuthProvider supplies credentials for the outbound requests of the target
+// routes that reference it by name. Exactly one of oauth2, api_key, hmac or
+// aws_sigv4 must be set.
+type AuthProvider struct {
+	Name     string              `mapstructure:"name"`
+	OAuth2   *OAuth2AuthConfig   `mapstructure:"oauth2"`
+	APIKey   *APIKeyAuthConfig   `mapstructure:"api_key"`
+	HMAC     *HMACAuthConfig     `mapstructure:"hmac"`
+	AWSSigV4 *AWSSigV4AuthConfig `mapstructure:"aws_sigv4"`
+}
+
+// OAuth2AuthConfig fetches bearer tokens with the OAuth2 client credentials
+// grant
+type OAuth2AuthConfig struct {
+	TokenURL      string        `mapstructure:"token_url"`
+	ClientID      Secret        `mapstructure:"client_id"`
+	ClientSecret  Secret        `mapstructure:"client_secret"`
+	Scopes        []string      `mapstructure:"scopes"`
+	Audience      string        `mapstructure:"audience"`       // Sent as the audience parameter when set
+	RefreshBefore time.Duration `mapstructure:"refresh_before"` // Refresh tokens this long before they expire (default 1m)
+}
+
+// APIKeyAuthConfig sends a static key in a header or query parameter
+type APIKeyAuthConfig struct {
+	Key        Secret `mapstructure:"key"`
+	Header     string `mapstructure:"header"`      // Default X-API-Key
+	Prefix     string `mapstructure:"prefix"`      // Prepended to the key, e.g. "Bearer "
+	QueryParam string `mapstructure:"query_param"` // Send the key as this query parameter instead of a header
+}
+
+// HMACAuthConfig signs requests with HMAC-SHA256 over the method, path and
+// query, timestamp and body hash
+type HMACAuthConfig struct {
+	Key             Secret `mapstructure:"key"`
+	Header          string `mapstructure:"header"`           // Signature header (default X-Signature)
+	TimestampHeader string `mapstructure:"timestamp_header"` // Unix time of the signature (default X-Timestamp)
+}
+
+// AWSSigV4AuthConfig signs requests with AWS Signature Version 4
+type AWSSigV4AuthConfig struct {
+	AccessKeyID     Secret `mapstructure:"access_key_id"`
+	SecretAccessKey Secret `mapstructure:"secret_access_key"`
+	SessionToken    Secret `mapstructure:"session_token"` // Optional, for temporary credentials
+	Region          string `mapstructure:"region"`
+	Service         string `mapstructure:"service"`
+}
+
+// Secret names where a credential is read from: an environment variable or a
+// file. Credentials are never written in the configuration itself.
+type Secret struct {
+	Env  string `mapstructure:"env"`
+	File string `mapstructure:"file"`
+}
+
+// IsSet reports whether the secret names a source
+func (s Secret) IsSet() bool {
+	return s.Env != "" || s.File != ""
+}
+
+// Value reads the secret. Trailing newlines are trimmed from files.
+func (s Secret) Value() (string, error) {
+	if s.Env != "" {
+		value, ok := os.LookupEnv(s.Env)
+		if !ok || value == "" {
+			return "", fmt.Errorf("environment variable %s is not set", s.Env)
+		}
+		return value, nil
+	}
+	b, err := os.ReadFile(s.File)
+	if err != nil {
+		return "", fmt.Errorf("reading secret file: %w", err)
+	}
+	value := strings.TrimRight(string(b), "\r\n")
+	if value == "" {
+		return "", fmt.Errorf("secret file %s is empty", s.File)
+	}
+	return value, nil
+}
+
+// GetAuthProvider returns the auth provider with the given name, or nil
+func (c *Config) GetAuthProvider(name string) *AuthProvider {
+	for i := range c.AuthProviders {
+		if c.AuthProviders[i].Name == name {
+			return &c.AuthProviders[i]
+		}
+	}
+	return nil
+}
+
+// validateAuthProviders checks the auth providers and the routes referring
+// to them
+func validateAuthProviders(config *Config) error {
+	names := make(map[string]bool, len(config.AuthProviders))
+	for _, p := range config.AuthProviders {
+		if p.Name == "" {
+			return errors.New("auth providers must have a name")
+		}
+		if names[p.Name] {
+			return fmt.Errorf("duplicate auth provider %q", p.Name)
+		}
+		names[p.Name] = true
+		if err := validateAuthProvider(p); err != nil {
+			return fmt.Errorf("auth provider %s: %w", p.Name, err)
+		}
+	}
+	for _, route := range config.TargetRoutes {
+		if route.Auth != "" && !names[route.Auth] {
+			return fmt.Errorf("route %s refers to unknown auth provider %q", route, route.Auth)
+		}
+	}
+	return nil
+}
+
+// validateAuthProvider checks the settings of a single auth provider
+func validateAuthProvider(p AuthProvider) error {
+	set := 0
+	for _, configured := range []bool{p.OAuth2 != nil, p.APIKey != nil, p.HMAC != nil, p.AWSSigV4 != nil} {
+		if configured {
+			set++
+		}
+	}
+	if set != 1 {
+		return errors.New("exactly one of oauth2, api_key, hmac or aws_sigv4 must be set")
+	}
+
+	type namedSecret struct {
+		name   string
+		secret Secret
+	}
+	var secrets []namedSecret
+	switch {
+	case p.OAuth2 != nil:
+		if u, err := url.Parse(p.OAuth2.TokenURL); err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("invalid oauth2.token_url %q", p.OAuth2.TokenURL)
+		}
+		if p.OAuth2.RefreshBefore < 0 {
+			return errors.New("oauth2.refresh_before cannot be negative")
+		}
+		secrets = append(secrets, namedSecret{"oauth2.client_id", p.OAuth2.ClientID})
+		secrets = append(secrets, namedSecret{"oauth2.client_secret", p.OAuth2.ClientSecret})
+	case p.APIKey != nil:
+		if p.APIKey.Header != "" && p.APIKey.QueryParam != "" {
+			return errors.New("api_key.header and api_key.query_param cannot both be set")
+		}
+		secrets = append(secrets, namedSecret{"api_key.key", p.APIKey.Key})
+	case p.HMAC != nil:
+		secrets = append(secrets, namedSecret{"hmac.key", p.HMAC.Key})
+	case p.AWSSigV4 != nil:
+		if p.AWSSigV4.Region == "" || p.AWSSigV4.Service == "" {
+			return errors.New("aws_sigv4.region and aws_sigv4.service must be set")
+		}
+		secrets = append(secrets, namedSecret{"aws_sigv4.access_key_id", p.AWSSigV4.AccessKeyID})
+		secrets = append(secrets, namedSecret{"aws_sigv4.secret_access_key", p.AWSSigV4.SecretAccessKey})
+		if p.AWSSigV4.SessionToken.IsSet() {
+			secrets = append(secrets, namedSecret{"aws_sigv4.session_token", p.AWSSigV4.SessionToken})
+		}
+	}
+	for _, s := range secrets {
+		if s.secret.Env != "" && s.secret.File != "" {
+			return fmt.Errorf("%s must set only one of env or file", s.name)
+		}
+		if !s.secret.IsSet() {
+			return fmt.Errorf("%s must be read from env or file", s.name)
+		}
+	}
+	return nil
+}
@@ -3,17 +3,43 @@ package config
 import (
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"log/slog"
+	"net/url"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
-// TargetRoute defines a mapping between a path prefix and a target URL
+// TargetRoute maps requests to a target URL. A route matches on one of
+// path_prefix, path (exact or a template such as /users/{id}) or path_regex,
+// plus optional host, method and header conditions.
 type TargetRoute struct {
-	PathPrefix string `mapstructure:"path_prefix"`
-	TargetURL  string `mapstructure:"target_url"`
+	Name        string             `mapstructure:"name"`
+	Host        string             `mapstructure:"host"` // Exact host or wildcard such as *.example.com
+	Methods     []string           `mapstructure:"methods"`
+	Headers     map[string]string  `mapstructure:"headers"` // Required headers; "*" only requires presence
+	PathPrefix  string             `mapstructure:"path_prefix"`
+	Path        string             `mapstructure:"path"`
+	PathRegex   string             `mapstructure:"path_regex"`
+	TargetURL   string             `mapstructure:"target_url"`
+	StripPrefix bool               `mapstructure:"strip_prefix"` // Remove PathPrefix from the path before forwarding
+	Timeout     time.Duration      `mapstructure:"timeout"`      // Upstream timeout, including reading the response
+	TLS         RouteTLSConfig     `mapstructure:"tls"`          // Outbound TLS overrides for this route
+	OpenAPI     RouteOpenAPIConfig `mapstructure:"openapi"`      // Spec validating this route instead of api_validation.spec_path
+	Auth        string             `mapstructure:"auth"`         // Name of the auth provider adding credentials to outbound requests
+
+	// Load balancing across several targets, used instead of target_url
+	Upstreams        []Upstream             `mapstructure:"upstreams"`
+	LoadBalancing    string                 `mapstructure:"load_balancing"` // "round_robin" (default), "least_connections" or "weighted"
+	HealthCheck      HealthCheckConfig      `mapstructure:"health_check"`
+	OutlierDetection OutlierDetectionConfig `mapstructure:"outlier_detection"`
+
+	compiled *compiledRoute
 }
 
 // TLSConfig holds TLS configuration
@@ -40,16 +66,239 @@ type APIValidationConfig struct {
 	ContinueOnValidation bool   `mapstructure:"continue_on_validation"` // If true, continue even if validation fails
 }
 
+// ForwardProxyConfig holds configuration for forward-proxy mode, where clients
+// reach arbitrary hosts through the proxy (HTTP_PROXY/HTTPS_PROXY) and TLS
+// tunnels are intercepted with certificates minted from a local CA
+type ForwardProxyConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	CACert  string `mapstructure:"ca_cert"`
+	CAKey   string `mapstructure:"ca_key"`
+}
+
+// GRPCConfig holds configuration for the gRPC-aware proxy
+type GRPCConfig struct {
+	Enabled     bool     `mapstructure:"enabled"`
+	Port        int      `mapstructure:"port"`
+	TargetURL   string   `mapstructure:"target_url"`   // Upstream gRPC server; http:// targets use h2c
+	ImportPaths []string `mapstructure:"import_paths"` // Proto import paths used to decode messages
+	ProtoFiles  []string `mapstructure:"proto_files"`  // Proto files relative to the import paths; all are compiled if empty
+}
+
+// MatchRule customizes how replayed requests under a path prefix are matched
+// against recordings
+type MatchRule struct {
+	PathPrefix  string   `mapstructure:"path_prefix"`
+	Query       string   `mapstructure:"query"`        // "sort" (default), "exact" or "ignore"
+	IgnoreQuery []string `mapstructure:"ignore_query"` // Query params left out of the comparison
+	Headers     []string `mapstructure:"headers"`      // Request headers that must match
+	Body        string   `mapstructure:"body"`         // "" (ignored), "hash" or "jsonpath"
+	BodyPaths   []string `mapstructure:"body_paths"`   // JSONPath subset compared when body is "jsonpath", e.g. $.user.id
+
+	Latency *ReplayLatencyConfig `mapstructure:"latency"` // Overrides replay.latency for the route
+}
+
+// ReplayConfig holds configuration for matching requests in replay mode
+type ReplayConfig struct {
+	MatchRules   []MatchRule `mapstructure:"match_rules"`
+	MinScore     float64     `mapstructure:"min_score"`     // Fraction of match criteria a recording must satisfy (default 1)
+	Debug        bool        `mapstructure:"debug"`         // Log and return an explanation of each match decision
+	Sequential   bool        `mapstructure:"sequential"`    // Replay the Nth recording to the Nth request of a session
+	OnExhausted  string      `mapstructure:"on_exhausted"`  // "repeat_last" (default), "not_found" or "passthrough"
+	StreamPacing float64     `mapstructure:"stream_pacing"` // Scale of the recorded delays between stream events (default 1; 0 sends them at once)
+	LogHits      bool        `mapstructure:"log_hits"`      // Store the exchanges record-missing mode replays, for the web UI
+
+	Latency    ReplayLatencyConfig `mapstructure:"latency"`     // Reproduce recorded latency in replayed responses
+	AIFallback AIFallbackConfig    `mapstructure:"ai_fallback"` // Generate responses for requests without a recording
+}
+
+// ReplayLatencyConfig makes replayed responses take as long as the recorded
+// ones. Mode is "off" (default), "recorded" (the duration of the replayed
+// recording) or "distribution" (a duration drawn from the recordings of the
+// same route).
+type ReplayLatencyConfig struct {
+	Mode   string   `mapstructure:"mode"`
+	Factor *float64 `mapstructure:"factor"` // Scale of the reproduced latency (default 1); 0 replays without delay
+	Split  bool     `mapstructure:"split"`  // Reproduce time to first byte and body transfer separately from recorded stream timings
+}
+
+// Scale returns the factor reproduced latency is scaled by, 1 unless set
+func (l ReplayLatencyConfig) Scale() float64 {
+	if l.Factor == nil {
+		return 1
+	}
+	return *l.Factor
+}
+
+// AIFallbackConfig asks an Ollama model for a plausible response when replay
+// has no recording for a request. Generated responses are stored and replayed
+// like recordings, flagged with the "ai" source.
+type AIFallbackConfig struct {
+	Enabled  bool          `mapstructure:"enabled"`
+	Model    string        `mapstructure:"model"`    // Ollama model (default llama3.2)
+	Host     string        `mapstructure:"host"`     // Ollama URL; defaults to OLLAMA_HOST or http://localhost:11434
+	Examples int           `mapstructure:"examples"` // Recordings of the same route included in the prompt (default 3)
+	Timeout  time.Duration `mapstructure:"timeout"`  // Longest wait for a generated response (default 60s)
+}
+
+// RecorderConfig tunes the asynchronous writer that stores recorded traffic
+type RecorderConfig struct {
+	QueueSize     int           `mapstructure:"queue_size"`     // Records buffered before backpressure applies (default 1024)
+	BatchSize     int           `mapstructure:"batch_size"`     // Records inserted per transaction (default 100)
+	FlushInterval time.Duration `mapstructure:"flush_interval"` // Longest wait for a batch to fill (default 100ms)
+	OnFull        string        `mapstructure:"on_full"`        // "block" (default) waits up to block_timeout, "drop" drops at once
+	BlockTimeout  time.Duration `mapstructure:"block_timeout"`  // How long "block" waits for room (default 1s)
+}
+
+// LatencyConfig describes added latency. Distribution is "fixed" (Delay),
+// "uniform" (between Min and Max) or "normal" (mean Delay, deviation StdDev).
+type LatencyConfig struct {
+	Distribution string        `mapstructure:"distribution"`
+	Delay        time.Duration `mapstructure:"delay"`
+	Min          time.Duration `mapstructure:"min"`
+	Max          time.Duration `mapstructure:"max"`
+	StdDev       time.Duration `mapstructure:"stddev"`
+}
+
+// FaultRule injects latency and failures into the requests it selects.
+// Percentages are 0-100 of the selected requests. A request gets at most one
+// of a reset, an error or truncation, so their sum must not exceed 100.
+type FaultRule struct {
+	PathPrefix      string            `mapstructure:"path_prefix"`
+	Methods         []string          `mapstructure:"methods"`          // Empty selects every method
+	Headers         map[string]string `mapstructure:"headers"`          // Header values that must match; "*" only requires presence
+	Latency         LatencyConfig     `mapstructure:"latency"`          // Added before the request is served
+	ErrorPercent    float64           `mapstructure:"error_percent"`    // Requests answered with ErrorStatus instead of the target
+	ErrorStatus     int               `mapstructure:"error_status"`     // Defaults to 503
+	ResetPercent    float64           `mapstructure:"reset_percent"`    // Connections reset without a response
+	TruncatePercent float64           `mapstructure:"truncate_percent"` // Responses cut off after TruncateAfter bytes
+	TruncateAfter   int               `mapstructure:"truncate_after"`
+	Bandwidth       int               `mapstructure:"bandwidth"` // Response bytes per second; 0 is unlimited
+}
+
+// HeaderRewrite changes headers. Header names are case-insensitive.
+type HeaderRewrite struct {
+	Set    map[string]string `mapstructure:"set"` // Replaces existing values
+	Add    map[string]string `mapstructure:"add"` // Appended to existing values
+	Remove []string          `mapstructure:"remove"`
+}
+
+// BodyPatch is a JSON Patch (RFC 6902) operation on a JSON body. Path and From
+// accept JSON Pointers ("/items/0/sku") or JSONPath ("$.items[0].sku").
+type BodyPatch struct {
+	Op    string `mapstructure:"op"` // add, remove, replace, move, copy or test
+	Path  string `mapstructure:"path"`
+	From  string `mapstructure:"from"` // Source of move and copy
+	Value any    `mapstructure:"value"`
+}
+
+// QueryParam is a query parameter set by a rewrite rule
+type QueryParam struct {
+	Name  string `mapstructure:"name"`
+	Value string `mapstructure:"value"`
+}
+
+// RequestRewrite changes a request before it is forwarded. Path changes are
+// applied in order: strip_prefix, add_prefix, then path_pattern.
+type RequestRewrite struct {
+	Headers         HeaderRewrite `mapstructure:"headers"`
+	StripPrefix     string        `mapstructure:"strip_prefix"`
+	AddPrefix       string        `mapstructure:"add_prefix"`
+	PathPattern     string        `mapstructure:"path_pattern"`     // Regular expression matched against the path
+	PathReplacement string        `mapstructure:"path_replacement"` // May reference groups as $1 or ${name}
+	SetQuery        []QueryParam  `mapstructure:"set_query"`
+	RemoveQuery     []string      `mapstructure:"remove_query"`
+	Body            []BodyPatch   `mapstructure:"body"`
+}
+
+// ResponseRewrite changes a response before it reaches the client
+type ResponseRewrite struct {
+	Headers HeaderRewrite `mapstructure:"headers"`
+	Body    []BodyPatch   `mapstructure:"body"`
+}
+
+// RewriteRule rewrites the requests it selects and their responses. Every
+// matching rule is applied, in order.
+type RewriteRule struct {
+	PathPrefix string          `mapstructure:"path_prefix"`
+	Methods    []string        `mapstructure:"methods"` // Empty selects every method
+	Request    RequestRewrite  `mapstructure:"request"`
+	Response   ResponseRewrite `mapstructure:"response"`
+}
+
+// RedactionRule masks body fields selected by a JSONPath, or text matching a
+// regular expression in bodies, header values and URLs
+type RedactionRule struct {
+	Path     string `mapstructure:"path"`      // JSONPath such as "$.customer.email" or "$.cards[*].number"
+	Pattern  string `mapstructure:"pattern"`   // Only capture groups are masked when the pattern has any
+	Mask     string `mapstructure:"mask"`      // "replace" (default) or "preserve" to keep length and separators
+	KeepLast int    `mapstructure:"keep_last"` // Trailing letters and digits left visible with "preserve"
+}
+
+// RedactionConfig controls the masking of secrets and PII before traffic is
+// stored, logged or exported. Authorization, cookie and API key headers are
+// always redacted unless Disabled is set.
+type RedactionConfig struct {
+	Disabled    bool            `mapstructure:"disabled"`
+	Headers     []string        `mapstructure:"headers"`      // Redacted in addition to the built-in headers
+	QueryParams []string        `mapstructure:"query_params"` // Redacted in addition to api_key, access_token, token, ...
+	Rules       []RedactionRule `mapstructure:"rules"`
+}
+
+// BlobStoreConfig controls the on-disk store for bodies larger than the 1MB
+// kept in SQLite
+type BlobStoreConfig struct {
+	Disabled    bool   `mapstructure:"disabled"`      // Store only a size placeholder for large bodies
+	Dir         string `mapstructure:"dir"`           // Defaults to the SQLite path with a .blobs extension
+	MaxBodySize int64  `mapstructure:"max_body_size"` // Largest body captured in bytes (default 100MB)
+}
+
+// MockConfig serves responses generated from an OpenAPI spec instead of
+// proxying to a target
+type MockConfig struct {
+	SpecPath string `mapstructure:"spec_path"`
+}
+
+// PluginConfig loads a WebAssembly module that hooks into proxied exchanges
+type PluginConfig struct {
+	Name    string            `mapstructure:"name"`    // Defaults to the file name without extension
+	Path    string            `mapstructure:"path"`    // Path to the .wasm module
+	Timeout time.Duration     `mapstructure:"timeout"` // Limit on each hook call (default 100ms)
+	Config  map[string]string `mapstructure:"config"`  // Passed to the plugin with every call
+}
+
+// TracingConfig controls OpenTelemetry tracing of proxied requests
+type TracingConfig struct {
+	Enabled     bool    `mapstructure:"enabled"`
+	Endpoint    string  `mapstructure:"endpoint"`     // OTLP/HTTP traces URL (default http://localhost:4318/v1/traces)
+	ServiceName string  `mapstructure:"service_name"` // Defaults to jarvis
+	SampleRatio float64 `mapstructure:"sample_ratio"` // Fraction of new traces sampled (default 1); joined traces follow their parent
+	TraceURL    string  `mapstructure:"trace_url"`    // Link from the web UI to a trace, with {trace_id} replaced
+}
+
 // Config holds the application configuration
 type Config struct {
 	HTTPPort      int                 `mapstructure:"http_port"`
 	HTTPTargetURL string              `mapstructure:"http_target_url"` // Default target for backward compatibility
 	TargetRoutes  []TargetRoute       `mapstructure:"target_routes"`   // New field for path-based routing
+	AuthProviders []AuthProvider      `mapstructure:"auth_providers"`  // Outbound credentials referenced by target routes
 	SQLiteDBPath  string              `mapstructure:"sqlite_db_path"`
 	RecordingMode bool                `mapstructure:"recording_mode"`
 	ReplayMode    bool                `mapstructure:"replay_mode"`
+	RecordMissing bool                `mapstructure:"record_missing"` // Replay matches, record and serve misses live
+	StrictOffline bool                `mapstructure:"strict_offline"` // Replay only; misses fail loudly and never reach the target
 	TLS           TLSConfig           `mapstructure:"tls"`            // TLS configuration
 	APIValidation APIValidationConfig `mapstructure:"api_validation"` // OpenAPI validation configuration
+	ForwardProxy  ForwardProxyConfig  `mapstructure:"forward_proxy"`  // Forward-proxy (CONNECT) configuration
+	GRPC          GRPCConfig          `mapstructure:"grpc"`           // gRPC proxy configuration
+	Replay        ReplayConfig        `mapstructure:"replay"`         // Replay matching configuration
+	Recorder      RecorderConfig      `mapstructure:"recorder"`       // Batched storage of recorded traffic
+	BlobStore     BlobStoreConfig     `mapstructure:"blob_store"`     // Storage of large bodies
+	Tracing       TracingConfig       `mapstructure:"tracing"`        // OpenTelemetry tracing
+	Mock          MockConfig          `mapstructure:"mock"`           // Spec-driven mock server
+	Faults        []FaultRule         `mapstructure:"faults"`         // Fault and latency injection rules
+	Rewrites      []RewriteRule       `mapstructure:"rewrites"`       // Request and response rewrite rules
+	Redaction     RedactionConfig     `mapstructure:"redaction"`      // Secret and PII masking
+	Plugins       []PluginConfig      `mapstructure:"plugins"`        // WebAssembly plugins run on each exchange
 	UIPort        int                 `mapstructure:"ui_port"`
 }
 
@@ -73,6 +322,7 @@ func SetupViper(cfgFile string) error {
 	viper.SetDefault("http_port", 8080)
 	viper.SetDefault("ui_port", 9090)
 	viper.SetDefault("tls.port", 8443)
+	viper.SetDefault("grpc.port", 50052)
 	viper.SetDefault("api_validation.validate_requests", true)
 	viper.SetDefault("api_validation.validate_responses", true)
 
@@ -95,6 +345,15 @@ func BindProxyFlags(cmd *cobra.Command) {
 	_ = viper.BindPFlag("http_target_url", cmd.Flags().Lookup("target-url"))
 	_ = viper.BindPFlag("recording_mode", cmd.Flags().Lookup("record"))
 	_ = viper.BindPFlag("replay_mode", cmd.Flags().Lookup("replay"))
+	_ = viper.BindPFlag("record_missing", cmd.Flags().Lookup("record-missing"))
+	_ = viper.BindPFlag("strict_offline", cmd.Flags().Lookup("offline"))
+	_ = viper.BindPFlag("replay.debug", cmd.Flags().Lookup("replay-debug"))
+	_ = viper.BindPFlag("replay.sequential", cmd.Flags().Lookup("sequential"))
+	_ = viper.BindPFlag("replay.on_exhausted", cmd.Flags().Lookup("on-exhausted"))
+	_ = viper.BindPFlag("replay.ai_fallback.enabled", cmd.Flags().Lookup("ai-fallback"))
+	_ = viper.BindPFlag("replay.stream_pacing", cmd.Flags().Lookup("stream-pacing"))
+	_ = viper.BindPFlag("replay.latency.mode", cmd.Flags().Lookup("replay-latency"))
+	_ = viper.BindPFlag("replay.latency.factor", cmd.Flags().Lookup("latency-factor"))
 
 	// TLS + mTLS
 	_ = viper.BindPFlag("tls.enabled", cmd.Flags().Lookup("tls"))
@@ -107,6 +366,17 @@ func BindProxyFlags(cmd *cobra.Command) {
 	_ = viper.BindPFlag("tls.client_cert_file", cmd.Flags().Lookup("client-cert"))
 	_ = viper.BindPFlag("tls.client_key_file", cmd.Flags().Lookup("client-key"))
 
+	// gRPC proxy
+	_ = viper.BindPFlag("grpc.enabled", cmd.Flags().Lookup("grpc"))
+	_ = viper.BindPFlag("grpc.port", cmd.Flags().Lookup("grpc-port"))
+	_ = viper.BindPFlag("grpc.target_url", cmd.Flags().Lookup("grpc-target"))
+	_ = viper.BindPFlag("grpc.import_paths", cmd.Flags().Lookup("proto-path"))
+
+	// Forward proxy
+	_ = viper.BindPFlag("forward_proxy.enabled", cmd.Flags().Lookup("forward"))
+	_ = viper.BindPFlag("forward_proxy.ca_cert", cmd.Flags().Lookup("ca-cert"))
+	_ = viper.BindPFlag("forward_proxy.ca_key", cmd.Flags().Lookup("ca-key"))
+
 	// OpenAPI validation
 	_ = viper.BindPFlag("api_validation.enabled", cmd.Flags().Lookup("api-validate"))
 	_ = viper.BindPFlag("api_validation.spec_path", cmd.Flags().Lookup("api-spec"))
@@ -131,11 +401,97 @@ func LoadConfig(v *viper.Viper) (*Config, error) {
 		config.SQLiteDBPath = "traffic_inspector.db"
 	}
 
+	// Large bodies are kept next to the SQLite file by default
+	if config.BlobStore.Dir == "" {
+		config.BlobStore.Dir = strings.TrimSuffix(config.SQLiteDBPath, filepath.Ext(config.SQLiteDBPath)) + ".blobs"
+	}
+	if config.BlobStore.MaxBodySize == 0 {
+		config.BlobStore.MaxBodySize = 100 * 1024 * 1024
+	}
+
+	// Traces go to a local collector and every new trace is sampled by default
+	if config.Tracing.Enabled {
+		if config.Tracing.Endpoint == "" {
+			config.Tracing.Endpoint = "http://localhost:4318/v1/traces"
+		}
+		if config.Tracing.ServiceName == "" {
+			config.Tracing.ServiceName = "jarvis"
+		}
+		// 0 keeps tracing on while sampling only traces started upstream
+		if !v.IsSet("tracing.sample_ratio") {
+			config.Tracing.SampleRatio = 1
+		}
+	}
+
+	// Strict offline is a replay mode
+	if config.StrictOffline {
+		config.ReplayMode = true
+	}
+
 	// Set default TLS port if TLS is enabled but no port specified
 	if config.TLS.Enabled && config.TLS.Port == 0 {
 		config.TLS.Port = 8443 // Default HTTPS port for the proxy
 	}
 
+	// Set default gRPC port if gRPC is enabled but no port specified
+	if config.GRPC.Enabled && config.GRPC.Port == 0 {
+		config.GRPC.Port = 50052
+	}
+
+	// Replayed requests must satisfy every match criterion unless configured
+	// otherwise, and exhausted sequences keep repeating their last response;
+	// 0 is a valid score
+	if !v.IsSet("replay.min_score") {
+		config.Replay.MinScore = 1
+	}
+	if config.Replay.OnExhausted == "" {
+		config.Replay.OnExhausted = "repeat_last"
+	}
+	// Streams replay at their recorded pace unless scaled; 0 is a valid scale
+	if !v.IsSet("replay.stream_pacing") {
+		config.Replay.StreamPacing = 1
+	}
+	for _, provider := range config.AuthProviders {
+		if provider.OAuth2 != nil && provider.OAuth2.RefreshBefore == 0 {
+			provider.OAuth2.RefreshBefore = time.Minute
+		}
+	}
+	for i := range config.Plugins {
+		plugin := &config.Plugins[i]
+		if plugin.Name == "" {
+			plugin.Name = strings.TrimSuffix(filepath.Base(plugin.Path), filepath.Ext(plugin.Path))
+		}
+		if plugin.Timeout == 0 {
+			plugin.Timeout = 100 * time.Millisecond
+		}
+	}
+	if fallback := &config.Replay.AIFallback; fallback.Enabled {
+		if fallback.Model == "" {
+			fallback.Model = "llama3.2"
+		}
+		if fallback.Examples == 0 {
+			fallback.Examples = 3
+		}
+		if fallback.Timeout == 0 {
+			fallback.Timeout = 60 * time.Second
+		}
+	}
+
+	// Injected errors default to 503 Service Unavailable
+	for i := range config.Faults {
+		if config.Faults[i].ErrorStatus == 0 {
+			config.Faults[i].ErrorStatus = 503
+		}
+	}
+
+	// Set default CA paths for TLS interception in forward-proxy mode
+	if config.ForwardProxy.CACert == "" {
+		config.ForwardProxy.CACert = "./certs/jarvis-ca.crt"
+	}
+	if config.ForwardProxy.CAKey == "" {
+		config.ForwardProxy.CAKey = "./certs/jarvis-ca.key"
+	}
+
 	// Validate config
 	if err := validateConfig(&config); err != nil {
 		return nil, err
@@ -152,30 +508,27 @@ func validateConfig(config *Config) error {
 	if config.RecordingMode && config.ReplayMode {
 		return errors.New("cannot enable both RecordingMode and ReplayMode simultaneously")
 	}
+	if config.RecordMissing && (config.RecordingMode || config.ReplayMode) {
+		return errors.New("record_missing already replays and records; it cannot be combined with recording_mode, replay_mode or strict_offline")
+	}
 
 	if config.HTTPPort <= 0 {
 		return errors.New("http_port must be configured")
 	}
 
-	// Check if we have either a default target URL or at least one route
-	if config.HTTPTargetURL == "" && len(config.TargetRoutes) == 0 {
+	// Check if we have either a default target URL or at least one route;
+	// a forward proxy takes its targets from the requests themselves and the
+	// mock server has none
+	if config.HTTPTargetURL == "" && len(config.TargetRoutes) == 0 && !config.ForwardProxy.Enabled && config.Mock.SpecPath == "" {
 		return errors.New("either http_target_url or at least one target_route must be set")
 	}
 
 	// Validate each target route
-	for _, route := range config.TargetRoutes {
-		if route.PathPrefix == "" {
-			return errors.New("path_prefix cannot be empty for target routes")
-		}
-
-		// Ensure path_prefix starts with "/"
-		if !strings.HasPrefix(route.PathPrefix, "/") {
-			return errors.New("path_prefix must start with a '/' character")
-		}
-
-		if route.TargetURL == "" {
-			return errors.New("target_url cannot be empty for target routes")
-		}
+	if err := validateRoutes(config.TargetRoutes); err != nil {
+		return err
+	}
+	if err := validateAuthProviders(config); err != nil {
+		return err
 	}
 
 	// Validate TLS config if enabled
@@ -188,34 +541,266 @@ func validateConfig(config *Config) error {
 		}
 	}
 
-	// Validate API validation config if enabled
-	if config.APIValidation.Enabled {
-		if config.APIValidation.SpecPath == "" {
-			return errors.New("api_validation.spec_path must be provided when API validation is enabled")
+	// Validate gRPC config if enabled
+	if config.GRPC.Enabled {
+		if config.GRPC.TargetURL == "" && config.HTTPTargetURL == "" && len(config.TargetRoutes) == 0 {
+			return errors.New("grpc.target_url must be provided when gRPC proxying is enabled")
+		}
+		if config.GRPC.Port == config.HTTPPort {
+			return errors.New("grpc.port and http_port cannot be the same")
+		}
+	}
+
+	if config.BlobStore.MaxBodySize < 0 {
+		return errors.New("blob_store.max_body_size cannot be negative")
+	}
+
+	// Validate the recorder queue
+	if config.Recorder.QueueSize < 0 || config.Recorder.BatchSize < 0 {
+		return errors.New("recorder.queue_size and recorder.batch_size cannot be negative")
+	}
+	if config.Recorder.FlushInterval < 0 || config.Recorder.BlockTimeout < 0 {
+		return errors.New("recorder.flush_interval and recorder.block_timeout cannot be negative")
+	}
+	switch config.Recorder.OnFull {
+	case "", "block", "drop":
+	default:
+		return fmt.Errorf("invalid recorder.on_full %q, expected block or drop", config.Recorder.OnFull)
+	}
+
+	// Validate tracing
+	if config.Tracing.Enabled {
+		u, err := url.Parse(config.Tracing.Endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid tracing.endpoint %q, expected an http or https URL", config.Tracing.Endpoint)
+		}
+		if config.Tracing.SampleRatio < 0 || config.Tracing.SampleRatio > 1 {
+			return errors.New("tracing.sample_ratio must be between 0 and 1")
+		}
+	}
+
+	// Validate plugins
+	pluginNames := make(map[string]bool, len(config.Plugins))
+	for i, plugin := range config.Plugins {
+		if plugin.Path == "" {
+			return fmt.Errorf("plugins[%d]: path is required", i)
+		}
+		if plugin.Timeout < 0 {
+			return fmt.Errorf("plugins[%d]: timeout cannot be negative", i)
+		}
+		if plugin.Name != "" && pluginNames[plugin.Name] {
+			return fmt.Errorf("duplicate plugin name %q", plugin.Name)
+		}
+		pluginNames[plugin.Name] = true
+	}
+
+	// Validate replay match rules
+	if config.Replay.MinScore < 0 || config.Replay.MinScore > 1 {
+		return errors.New("replay.min_score must be between 0 and 1")
+	}
+	switch config.Replay.OnExhausted {
+	case "repeat_last", "not_found", "passthrough":
+	default:
+		return fmt.Errorf("invalid replay.on_exhausted %q", config.Replay.OnExhausted)
+	}
+	if config.Replay.StreamPacing < 0 {
+		return errors.New("replay.stream_pacing must not be negative")
+	}
+	if err := validateReplayLatency(config.Replay.Latency, "replay.latency"); err != nil {
+		return err
+	}
+	if fallback := config.Replay.AIFallback; fallback.Enabled {
+		if config.StrictOffline || config.RecordMissing {
+			return errors.New("replay.ai_fallback cannot be combined with strict_offline or record_missing, which handle misses themselves")
+		}
+		if fallback.Examples < 0 {
+			return errors.New("replay.ai_fallback.examples must not be negative")
+		}
+		if fallback.Host != "" {
+			if u, err := url.Parse(fallback.Host); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid replay.ai_fallback.host %q, expected an http or https URL", fallback.Host)
+			}
+		}
+	}
+	for _, rule := range config.Replay.MatchRules {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return errors.New("path_prefix must start with a '/' character for replay match rules")
+		}
+		switch rule.Query {
+		case "", "sort", "exact", "ignore":
+		default:
+			return fmt.Errorf("invalid replay query mode %q for %s", rule.Query, rule.PathPrefix)
+		}
+		switch rule.Body {
+		case "", "hash":
+		case "jsonpath":
+			if len(rule.BodyPaths) == 0 {
+				return fmt.Errorf("body_paths must be set for jsonpath body matching on %s", rule.PathPrefix)
+			}
+		default:
+			return fmt.Errorf("invalid replay body mode %q for %s", rule.Body, rule.PathPrefix)
+		}
+		if rule.Latency != nil {
+			if err := validateReplayLatency(*rule.Latency, "latency for "+rule.PathPrefix); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Validate fault injection rules
+	for _, rule := range config.Faults {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return errors.New("path_prefix must start with a '/' character for fault rules")
+		}
+		for _, pct := range []float64{rule.ErrorPercent, rule.ResetPercent, rule.TruncatePercent} {
+			if pct < 0 || pct > 100 {
+				return fmt.Errorf("fault percentages must be between 0 and 100 for %s", rule.PathPrefix)
+			}
+		}
+		if rule.ErrorPercent+rule.ResetPercent+rule.TruncatePercent > 100 {
+			return fmt.Errorf("fault percentages must not add up to more than 100 for %s", rule.PathPrefix)
+		}
+		if rule.ErrorStatus < 100 || rule.ErrorStatus > 599 {
+			return fmt.Errorf("invalid fault error_status %d for %s", rule.ErrorStatus, rule.PathPrefix)
+		}
+		switch rule.Latency.Distribution {
+		case "", "fixed", "normal":
+		case "uniform":
+			if rule.Latency.Max < rule.Latency.Min {
+				return fmt.Errorf("latency max must not be below min for %s", rule.PathPrefix)
+			}
+		default:
+			return fmt.Errorf("invalid latency distribution %q for %s", rule.Latency.Distribution, rule.PathPrefix)
 		}
 	}
 
+	// Validate rewrite rules
+	for _, rule := range config.Rewrites {
+		if !strings.HasPrefix(rule.PathPrefix, "/") {
+			return errors.New("path_prefix must start with a '/' character for rewrite rules")
+		}
+		if rule.Request.PathPattern != "" {
+			if _, err := regexp.Compile(rule.Request.PathPattern); err != nil {
+				return fmt.Errorf("invalid path_pattern for %s: %w", rule.PathPrefix, err)
+			}
+		}
+		for _, patch := range append(rule.Request.Body, rule.Response.Body...) {
+			switch patch.Op {
+			case "add", "remove", "replace", "test":
+			case "move", "copy":
+				if patch.From == "" {
+					return fmt.Errorf("%s body patch requires from for %s", patch.Op, rule.PathPrefix)
+				}
+			default:
+				return fmt.Errorf("invalid body patch op %q for %s", patch.Op, rule.PathPrefix)
+			}
+		}
+	}
+
+	// Validate redaction rules
+	for _, rule := range config.Redaction.Rules {
+		if (rule.Path == "") == (rule.Pattern == "") {
+			return errors.New("redaction rules need exactly one of path or pattern")
+		}
+		if rule.Path != "" && !strings.HasPrefix(rule.Path, "$") {
+			return fmt.Errorf("redaction path %q must be a JSONPath starting with $", rule.Path)
+		}
+		if rule.Pattern != "" {
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				return fmt.Errorf("invalid redaction pattern %q: %w", rule.Pattern, err)
+			}
+		}
+		switch rule.Mask {
+		case "", "replace", "preserve":
+		default:
+			return fmt.Errorf("invalid redaction mask %q", rule.Mask)
+		}
+	}
+
+	// Validate API validation config if enabled
+	if config.APIValidation.Enabled && config.APIValidation.SpecPath == "" && !config.HasRouteSpecs() {
+		return errors.New("api_validation.spec_path or a target route openapi.spec_path must be provided when API validation is enabled")
+	}
+
+	return nil
+}
+
+// validateReplayLatency checks latency settings for replayed responses; name
+// identifies them in errors
+func validateReplayLatency(latency ReplayLatencyConfig, name string) error {
+	switch latency.Mode {
+	case "", "off", "recorded", "distribution":
+	default:
+		return fmt.Errorf("invalid %s mode %q, expected off, recorded or distribution", name, latency.Mode)
+	}
+	if latency.Factor != nil && *latency.Factor < 0 {
+		return fmt.Errorf("%s factor must not be negative", name)
+	}
 	return nil
 }
 
 // GetTargetURL returns the appropriate target URL for a given path
 func (c *Config) GetTargetURL(path string) string {
 	// First check if we have any matching target routes
-	for _, route := range c.TargetRoutes {
-		// Support simple wildcard suffixes like "/foo/*"
-		prefix := route.PathPrefix
-		if strings.HasSuffix(prefix, "/*") {
-			prefix = strings.TrimSuffix(prefix, "/*")
-		}
-		if strings.HasPrefix(path, prefix) {
-			return route.TargetURL
-		}
+	if route := c.GetTargetRoute(path); route != nil {
+		return route.PrimaryURL()
 	}
 
 	// Fall back to default target URL
 	return c.HTTPTargetURL
 }
 
+// GetTargetRoute returns the most specific target route matching path, or
+// nil. Routes with host, method or header conditions need the request and
+// are only matched by MatchRoute.
+func (c *Config) GetTargetRoute(path string) *TargetRoute {
+	if m := c.MatchRoute(pathRequest(path)); m != nil {
+		return m.Route
+	}
+	return nil
+}
+
+// HasRouteSpecs reports whether any target route has its own OpenAPI spec
+func (c *Config) HasRouteSpecs() bool {
+	for i := range c.TargetRoutes {
+		if c.TargetRoutes[i].OpenAPI.SpecPath != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsRecording reports whether live responses are recorded
+func (c *Config) IsRecording() bool {
+	return c.RecordingMode || c.RecordMissing
+}
+
+// IsReplaying reports whether responses are served from recordings
+func (c *Config) IsReplaying() bool {
+	return c.ReplayMode || c.RecordMissing
+}
+
+// GetMatchRule returns the replay match rule with the longest prefix matching path, or nil
+func (c *Config) GetMatchRule(path string) *MatchRule {
+	var best *MatchRule
+	for i, rule := range c.Replay.MatchRules {
+		prefix := strings.TrimSuffix(rule.PathPrefix, "/*")
+		if strings.HasPrefix(path, prefix) && (best == nil || len(prefix) > len(strings.TrimSuffix(best.PathPrefix, "/*"))) {
+			best = &c.Replay.MatchRules[i]
+		}
+	}
+	return best
+}
+
+// GetReplayLatency returns the latency settings for replayed responses on
+// path: those of its match rule when the rule sets them, or replay.latency
+func (c *Config) GetReplayLatency(path string) ReplayLatencyConfig {
+	if rule := c.GetMatchRule(path); rule != nil && rule.Latency != nil {
+		return *rule.Latency
+	}
+	return c.Replay.Latency
+}
+
 // GetTLSConfig returns a TLS configuration for clients
 func (c *Config) GetTLSConfig() *tls.Config {
 	clientConfig := &tls.Config{
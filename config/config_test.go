@@ -2,6 +2,7 @@ package config
 
 import (
 	"testing"
+	"time"
 
 	"github.com/spf13/viper"
 )
@@ -85,6 +86,565 @@ func TestLoadConfig(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name: "Forward proxy without target URL",
+			configMap: map[string]interface{}{
+				"http_port": 8080,
+				"forward_proxy": map[string]interface{}{
+					"enabled": true,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Mock server without target URL",
+			configMap: map[string]interface{}{
+				"http_port": 8080,
+				"mock":      map[string]interface{}{"spec_path": "api.yaml"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Valid gRPC config",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"grpc": map[string]interface{}{
+					"enabled":    true,
+					"target_url": "http://localhost:50051",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "gRPC port clashes with HTTP port",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"grpc": map[string]interface{}{
+					"enabled": true,
+					"port":    8080,
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Valid replay match rules",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"replay": map[string]interface{}{
+					"match_rules": []map[string]interface{}{
+						{"path_prefix": "/orders", "body": "jsonpath", "body_paths": []string{"$.customer.id"}},
+						{"path_prefix": "/search", "query": "ignore", "headers": []string{"X-Tenant"}},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Replay jsonpath rule without body paths",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"replay": map[string]interface{}{
+					"match_rules": []map[string]interface{}{
+						{"path_prefix": "/orders", "body": "jsonpath"},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid replay exhaustion policy",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"replay": map[string]interface{}{
+					"sequential":   true,
+					"on_exhausted": "loop",
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Record missing mode",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"record_missing":  true,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Record missing combined with replay mode",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"record_missing":  true,
+				"replay_mode":     true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Strict offline combined with recording mode",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"strict_offline":  true,
+				"recording_mode":  true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Valid fault rules",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"faults": []map[string]interface{}{
+					{
+						"path_prefix":   "/orders",
+						"methods":       []string{"POST"},
+						"headers":       map[string]string{"X-Chaos": "*"},
+						"latency":       map[string]interface{}{"distribution": "uniform", "min": "50ms", "max": "200ms"},
+						"error_percent": 10,
+					},
+					{"path_prefix": "/downloads", "bandwidth": 1024, "truncate_percent": 5, "truncate_after": 512},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Fault percentage out of range",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"faults": []map[string]interface{}{
+					{"path_prefix": "/orders", "error_percent": 150},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Fault percentages add up to more than 100",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"faults": []map[string]interface{}{
+					{"path_prefix": "/orders", "error_percent": 60, "reset_percent": 50},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid latency distribution",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"faults": []map[string]interface{}{
+					{"path_prefix": "/orders", "latency": map[string]interface{}{"distribution": "pareto"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Valid rewrite rules",
+			configMap: map[string]interface{}{
+				"http_port": 8080,
+				"target_routes": []map[string]interface{}{
+					{"path_prefix": "/staging/*", "target_url": "http://staging.example.com", "strip_prefix": true},
+				},
+				"rewrites": []map[string]interface{}{
+					{
+						"path_prefix": "/staging",
+						"request": map[string]interface{}{
+							"headers":      map[string]interface{}{"set": map[string]string{"X-Env": "staging"}},
+							"path_pattern": "^/v1/(.*)$",
+							"set_query":    []map[string]string{{"name": "tenant", "value": "blue"}},
+							"body":         []map[string]interface{}{{"op": "add", "path": "$.source", "value": "jarvis"}},
+						},
+						"response": map[string]interface{}{
+							"body": []map[string]interface{}{{"op": "move", "from": "/data", "path": "/items"}},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid rewrite path pattern",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"rewrites": []map[string]interface{}{
+					{"path_prefix": "/", "request": map[string]interface{}{"path_pattern": "(unclosed"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Rewrite copy without from",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"rewrites": []map[string]interface{}{
+					{"path_prefix": "/", "response": map[string]interface{}{
+						"body": []map[string]interface{}{{"op": "copy", "path": "/a"}},
+					}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Valid redaction rules",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"redaction": map[string]interface{}{
+					"headers":      []string{"X-Customer-Email"},
+					"query_params": []string{"ssn"},
+					"rules": []map[string]interface{}{
+						{"path": "$.customer.email"},
+						{"path": "$.cards[*].number", "mask": "preserve", "keep_last": 4},
+						{"pattern": `password=([^&]+)`},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Redaction rule with both path and pattern",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"redaction": map[string]interface{}{
+					"rules": []map[string]interface{}{{"path": "$.email", "pattern": "@"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid redaction mask",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"redaction": map[string]interface{}{
+					"rules": []map[string]interface{}{{"path": "$.email", "mask": "hash"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Valid target routes with host, method, template and regex matchers",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"target_routes": []map[string]interface{}{
+					{"host": "*.example.com", "methods": []string{"GET"}, "path": "/users/{id}", "target_url": "http://users", "timeout": "5s"},
+					{"path_regex": `^/orders/\d+$`, "headers": map[string]string{"x-tenant": "*"}, "target_url": "http://orders"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Target route with both path and path_prefix",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"target_routes": []map[string]interface{}{
+					{"path": "/users/{id}", "path_prefix": "/users", "target_url": "http://users"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Target route with an invalid path template",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"target_routes": []map[string]interface{}{
+					{"path": "/users/{id", "target_url": "http://users"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Target route with strip_prefix but no path_prefix",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"target_routes": []map[string]interface{}{
+					{"path_regex": "^/v1/", "strip_prefix": true, "target_url": "http://users"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "API validation with only route specs",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"api_validation":  map[string]interface{}{"enabled": true},
+				"target_routes": []map[string]interface{}{
+					{"path_prefix": "/api/v1/products/", "target_url": "http://products", "openapi": map[string]interface{}{"spec_path": "products.yaml", "base_path": "/api/v1"}},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "API validation without any spec",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"api_validation":  map[string]interface{}{"enabled": true},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Route spec base path without spec",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"target_routes": []map[string]interface{}{
+					{"path_prefix": "/api/v1/", "target_url": "http://products", "openapi": map[string]interface{}{"base_path": "/api/v1"}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Valid auth providers",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"auth_providers": []map[string]interface{}{
+					{"name": "staging", "oauth2": map[string]interface{}{
+						"token_url":     "https://auth.example.com/oauth/token",
+						"client_id":     map[string]interface{}{"env": "STAGING_CLIENT_ID"},
+						"client_secret": map[string]interface{}{"file": "/run/secrets/staging"},
+					}},
+					{"name": "search", "api_key": map[string]interface{}{"key": map[string]interface{}{"env": "SEARCH_KEY"}, "query_param": "key"}},
+					{"name": "s3", "aws_sigv4": map[string]interface{}{
+						"access_key_id":     map[string]interface{}{"env": "AWS_ACCESS_KEY_ID"},
+						"secret_access_key": map[string]interface{}{"env": "AWS_SECRET_ACCESS_KEY"},
+						"region":            "eu-west-1",
+						"service":           "s3",
+					}},
+				},
+				"target_routes": []map[string]interface{}{
+					{"path_prefix": "/orders/", "target_url": "http://orders", "auth": "staging"},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Route with an unknown auth provider",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"target_routes": []map[string]interface{}{
+					{"path_prefix": "/orders/", "target_url": "http://orders", "auth": "staging"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Auth provider secret from both env and file",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"auth_providers": []map[string]interface{}{
+					{"name": "signed", "hmac": map[string]interface{}{"key": map[string]interface{}{"env": "HMAC_KEY", "file": "/run/secrets/hmac"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Auth provider with two schemes",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"auth_providers": []map[string]interface{}{
+					{
+						"name":    "mixed",
+						"api_key": map[string]interface{}{"key": map[string]interface{}{"env": "KEY"}},
+						"hmac":    map[string]interface{}{"key": map[string]interface{}{"env": "KEY"}},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Valid load-balanced route",
+			configMap: map[string]interface{}{
+				"http_port": 8080,
+				"target_routes": []map[string]interface{}{
+					{
+						"path_prefix":    "/api",
+						"upstreams":      []map[string]interface{}{{"url": "http://10.0.0.1:8080", "weight": 3}, {"url": "http://10.0.0.2:8080"}},
+						"load_balancing": "weighted",
+						"health_check":   map[string]interface{}{"path": "/health", "interval": "5s"},
+						"outlier_detection": map[string]interface{}{
+							"consecutive_5xx": 5,
+							"ejection_time":   "30s",
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Route with both target_url and upstreams",
+			configMap: map[string]interface{}{
+				"http_port": 8080,
+				"target_routes": []map[string]interface{}{
+					{"path_prefix": "/api", "target_url": "http://a", "upstreams": []map[string]interface{}{{"url": "http://b"}}},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid load balancing strategy",
+			configMap: map[string]interface{}{
+				"http_port": 8080,
+				"target_routes": []map[string]interface{}{
+					{"path_prefix": "/api", "upstreams": []map[string]interface{}{{"url": "http://b"}}, "load_balancing": "random"},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Valid recorder settings",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"recorder":        map[string]interface{}{"queue_size": 4096, "batch_size": 200, "flush_interval": "250ms", "on_full": "drop"},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Invalid recorder on_full",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"recorder":        map[string]interface{}{"on_full": "wait"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Negative recorder queue size",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"recorder":        map[string]interface{}{"queue_size": -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Negative blob store max body size",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"blob_store":      map[string]interface{}{"max_body_size": -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid tracing sample ratio",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"tracing":         map[string]interface{}{"enabled": true, "sample_ratio": 1.5},
+			},
+			wantErr: true,
+		},
+		{
+			name: "AI fallback with strict offline",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"strict_offline":  true,
+				"replay":          map[string]interface{}{"ai_fallback": map[string]interface{}{"enabled": true}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid AI fallback host",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"replay_mode":     true,
+				"replay":          map[string]interface{}{"ai_fallback": map[string]interface{}{"enabled": true, "host": "localhost:11434"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Negative stream pacing",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"replay":          map[string]interface{}{"stream_pacing": -1},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid replay latency mode",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"replay":          map[string]interface{}{"latency": map[string]interface{}{"mode": "random"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Negative route latency factor",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"replay": map[string]interface{}{"match_rules": []interface{}{
+					map[string]interface{}{"path_prefix": "/slow/", "latency": map[string]interface{}{"mode": "recorded", "factor": -2}},
+				}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid tracing endpoint",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"tracing":         map[string]interface{}{"enabled": true, "endpoint": "localhost:4318"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Valid plugin",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"plugins":         []interface{}{map[string]interface{}{"path": "plugins/tagger.wasm"}},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Plugin without path",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"plugins":         []interface{}{map[string]interface{}{"name": "tagger"}},
+			},
+			wantErr: true,
+		},
+		{
+			name: "Duplicate plugin names",
+			configMap: map[string]interface{}{
+				"http_port":       8080,
+				"http_target_url": "http://example.com",
+				"plugins": []interface{}{
+					map[string]interface{}{"path": "a/tagger.wasm"},
+					map[string]interface{}{"path": "b/tagger.wasm"},
+				},
+			},
+			wantErr: true,
+		},
 		{
 			name: "Invalid target routes - missing path prefix",
 			configMap: map[string]interface{}{
@@ -136,11 +696,82 @@ func TestLoadConfig(t *testing.T) {
 					t.Error("Default TLS port not set when TLS enabled")
 				}
 
+				// Strict offline is a replay mode
+				if config.StrictOffline && !config.ReplayMode {
+					t.Error("Strict offline should enable replay mode")
+				}
+
+				// Verify replay matching requires every criterion by default
+				if config.Replay.MinScore != 1 {
+					t.Errorf("Default replay min score = %v, want 1", config.Replay.MinScore)
+				}
+
+				// Verify fault rules default to 503
+				for _, rule := range config.Faults {
+					if rule.ErrorStatus != 503 {
+						t.Errorf("Default fault error status = %d, want 503", rule.ErrorStatus)
+					}
+				}
+
+				// Verify large bodies are stored next to the database
+				if !config.BlobStore.Disabled && (config.BlobStore.Dir == "" || config.BlobStore.MaxBodySize <= 0) {
+					t.Error("Default blob store settings not set")
+				}
+
+				// Verify forward proxy CA defaults
+				if config.ForwardProxy.CACert == "" || config.ForwardProxy.CAKey == "" {
+					t.Error("Default forward proxy CA paths not set")
+				}
+
+				// Verify plugins are named after their module and time limited
+				for _, plugin := range config.Plugins {
+					if plugin.Name != "tagger" || plugin.Timeout != 100*time.Millisecond {
+						t.Errorf("Default plugin settings not set, got %+v", plugin)
+					}
+				}
+
 			}
 		})
 	}
 }
 
+// TestLoadConfigKeepsExplicitZeros checks that settings whose zero is
+// meaningful are only defaulted when unset
+func TestLoadConfigKeepsExplicitZeros(t *testing.T) {
+	v := viper.New()
+	v.Set("http_port", 8080)
+	v.Set("http_target_url", "http://example.com")
+	v.Set("replay", map[string]interface{}{
+		"min_score": 0,
+		"latency":   map[string]interface{}{"mode": "recorded", "factor": 0},
+		"match_rules": []map[string]interface{}{
+			{"path_prefix": "/instant/", "latency": map[string]interface{}{"mode": "recorded", "factor": 0}},
+			{"path_prefix": "/recorded/", "latency": map[string]interface{}{"mode": "recorded"}},
+		},
+	})
+	v.Set("tracing", map[string]interface{}{"enabled": true, "sample_ratio": 0})
+
+	config, err := LoadConfig(v)
+	if err != nil {
+		t.Fatalf("LoadConfig() error = %v", err)
+	}
+	if config.Replay.MinScore != 0 {
+		t.Errorf("Replay min score = %v, want 0", config.Replay.MinScore)
+	}
+	if config.Tracing.SampleRatio != 0 {
+		t.Errorf("Tracing sample ratio = %v, want 0", config.Tracing.SampleRatio)
+	}
+	if got := config.Replay.Latency.Scale(); got != 0 {
+		t.Errorf("Replay latency factor = %v, want 0", got)
+	}
+	if got := config.GetReplayLatency("/instant/1").Scale(); got != 0 {
+		t.Errorf("Route latency factor = %v, want 0", got)
+	}
+	if got := config.GetReplayLatency("/recorded/1").Scale(); got != 1 {
+		t.Errorf("Default route latency factor = %v, want 1", got)
+	}
+}
+
 func TestGetTargetURL(t *testing.T) {
 	config := &Config{
 		HTTPTargetURL: "https://default.example.com",
@@ -188,4 +819,25 @@ func TestGetTargetURL(t *testing.T) {
 	}
 }
 
+func TestGetMatchRule(t *testing.T) {
+	config := &Config{
+		Replay: ReplayConfig{
+			MatchRules: []MatchRule{
+				{PathPrefix: "/api/*", Query: "ignore"},
+				{PathPrefix: "/api/orders", Body: "hash"},
+			},
+		},
+	}
+
+	if rule := config.GetMatchRule("/api/orders/1"); rule == nil || rule.Body != "hash" {
+		t.Errorf("Expected the longest prefix rule for /api/orders/1, got %+v", rule)
+	}
+	if rule := config.GetMatchRule("/api/users"); rule == nil || rule.Query != "ignore" {
+		t.Errorf("Expected the wildcard rule for /api/users, got %+v", rule)
+	}
+	if rule := config.GetMatchRule("/other"); rule != nil {
+		t.Errorf("Expected no rule for /other, got %+v", rule)
+	}
+}
+
 // TestGetWebSocketTargetURL tests the GetWebSocketTargetURL method
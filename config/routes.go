@@ -0,0 +1,492 @@
+package config
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// RouteTLSConfig overrides the outbound TLS settings for a single route
+type RouteTLSConfig struct {
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+	ServerName         string `mapstructure:"server_name"` // SNI and verification name when it differs from the target host
+	CACert             string `mapstructure:"ca_cert"`     // Trust only this CA for the target
+	ClientCertFile     string `mapstructure:"client_cert_file"`
+	ClientKeyFile      string `mapstructure:"client_key_file"`
+}
+
+// IsSet reports whether any route TLS option is configured
+func (t RouteTLSConfig) IsSet() bool {
+	return t != RouteTLSConfig{}
+}
+
+// RouteOpenAPIConfig sets the OpenAPI spec that validates a route's traffic
+type RouteOpenAPIConfig struct {
+	SpecPath string `mapstructure:"spec_path"`
+	BasePath string `mapstructure:"base_path"` // Server base path stripped from request paths before matching the spec, e.g. /api/v1
+}
+
+// Upstream is one target of a load-balanced route
+type Upstream struct {
+	URL    string `mapstructure:"url"`
+	Weight int    `mapstructure:"weight"` // Relative share of traffic with the weighted strategy (default 1)
+}
+
+// HealthCheckConfig enables active HTTP health checks of a route's upstreams.
+// Targets failing UnhealthyThreshold checks in a row stop receiving traffic
+// until they pass HealthyThreshold checks in a row.
+type HealthCheckConfig struct {
+	Path               string        `mapstructure:"path"`                // Requested with GET; empty disables health checks
+	Interval           time.Duration `mapstructure:"interval"`            // Default 10s
+	Timeout            time.Duration `mapstructure:"timeout"`             // Default 2s
+	UnhealthyThreshold int           `mapstructure:"unhealthy_threshold"` // Default 2
+	HealthyThreshold   int           `mapstructure:"healthy_threshold"`   // Default 2
+}
+
+// OutlierDetectionConfig ejects upstreams that keep failing live traffic
+type OutlierDetectionConfig struct {
+	Consecutive5xx int           `mapstructure:"consecutive_5xx"` // 5xx responses or connection errors in a row; 0 disables
+	EjectionTime   time.Duration `mapstructure:"ejection_time"`   // Default 30s
+}
+
+// Load balancing strategies
+const (
+	LoadBalanceRoundRobin       = "round_robin"
+	LoadBalanceLeastConnections = "least_connections"
+	LoadBalanceWeighted         = "weighted"
+)
+
+// Targets returns the upstreams of the route, or target_url as a single upstream
+func (r *TargetRoute) Targets() []Upstream {
+	if len(r.Upstreams) > 0 {
+		return r.Upstreams
+	}
+	if r.TargetURL == "" {
+		return nil
+	}
+	return []Upstream{{URL: r.TargetURL, Weight: 1}}
+}
+
+// PrimaryURL returns target_url, or the first upstream of a load-balanced route
+func (r *TargetRoute) PrimaryURL() string {
+	if targets := r.Targets(); len(targets) > 0 {
+		return targets[0].URL
+	}
+	return ""
+}
+
+// compiledRoute is the parsed form of a route's path matcher
+type compiledRoute struct {
+	regex    *regexp.Regexp
+	segments []string // Path template segments; "{name}" captures one segment, a trailing "*" the rest
+	literal  int      // Literal characters in the path pattern, longer is more specific
+	kind     int      // RouteSpecificity.Kind
+}
+
+// RouteSpecificity orders matching routes: host first, then the literal
+// length of the path pattern, then exact paths over templates and regexes
+// over prefixes, then the number of method and header conditions
+type RouteSpecificity struct {
+	Host       int // 2 for an exact host, 1 for a wildcard host
+	Path       int // Literal characters in the path pattern
+	Kind       int // 2 for an exact path, 1 for a template or regex, 0 for a prefix
+	Conditions int
+}
+
+// Less reports whether s is less specific than o
+func (s RouteSpecificity) Less(o RouteSpecificity) bool {
+	switch {
+	case s.Host != o.Host:
+		return s.Host < o.Host
+	case s.Path != o.Path:
+		return s.Path < o.Path
+	case s.Kind != o.Kind:
+		return s.Kind < o.Kind
+	}
+	return s.Conditions < o.Conditions
+}
+
+// RouteMatch is a target route selected for a request
+type RouteMatch struct {
+	Route       *TargetRoute
+	Index       int               // Position of the route in target_routes
+	Params      map[string]string // Path template and named regex groups
+	Specificity RouteSpecificity
+}
+
+// RouteCandidate explains how a single route was evaluated for a request
+type RouteCandidate struct {
+	RouteMatch
+	Matched  bool
+	Selected bool
+	Reason   string // What did not match, empty when Matched
+}
+
+// String describes the route conditions, e.g. "GET api.example.com/users/{id}"
+func (r TargetRoute) String() string {
+	var b strings.Builder
+	if r.Name != "" {
+		fmt.Fprintf(&b, "%s: ", r.Name)
+	}
+	if len(r.Methods) > 0 {
+		b.WriteString(strings.Join(r.Methods, ","))
+		b.WriteByte(' ')
+	}
+	b.WriteString(r.Host)
+	switch {
+	case r.Path != "":
+		b.WriteString(r.Path)
+	case r.PathRegex != "":
+		fmt.Fprintf(&b, "~%s", r.PathRegex)
+	default:
+		b.WriteString(r.PathPrefix)
+		if !strings.HasSuffix(r.PathPrefix, "*") {
+			b.WriteByte('*')
+		}
+	}
+	for name, value := range r.Headers {
+		fmt.Fprintf(&b, " [%s: %s]", name, value)
+	}
+	return b.String()
+}
+
+// compileRoute parses the path matcher of a route
+func compileRoute(r *TargetRoute) (*compiledRoute, error) {
+	switch {
+	case r.PathRegex != "":
+		re, err := regexp.Compile(r.PathRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path_regex %q: %w", r.PathRegex, err)
+		}
+		c := &compiledRoute{regex: re, kind: 1}
+		if unanchored, err := regexp.Compile(strings.TrimPrefix(r.PathRegex, "^")); err == nil {
+			prefix, _ := unanchored.LiteralPrefix()
+			c.literal = len(prefix)
+		}
+		return c, nil
+	case r.Path != "":
+		if !strings.HasPrefix(r.Path, "/") {
+			return nil, fmt.Errorf("path %q must start with a '/' character", r.Path)
+		}
+		c := &compiledRoute{segments: strings.Split(r.Path[1:], "/"), kind: 2}
+		for i, seg := range c.segments {
+			switch {
+			case seg == "*" && i == len(c.segments)-1:
+				c.kind = 1
+			case strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") && len(seg) > 2:
+				c.kind = 1
+				c.literal++ // The separator
+			case strings.ContainsAny(seg, "{}*"):
+				return nil, fmt.Errorf("invalid path segment %q in %q", seg, r.Path)
+			default:
+				c.literal += len(seg) + 1
+			}
+		}
+		return c, nil
+	}
+	return &compiledRoute{literal: len(strings.TrimSuffix(r.PathPrefix, "/*"))}, nil
+}
+
+// matcher returns the compiled path matcher, compiling it for routes that
+// did not go through LoadConfig
+func (r *TargetRoute) matcher() *compiledRoute {
+	if r.compiled != nil {
+		return r.compiled
+	}
+	c, err := compileRoute(r)
+	if err != nil {
+		return nil
+	}
+	return c
+}
+
+// matchPath matches the URL path and returns any captured parameters
+func (r *TargetRoute) matchPath(c *compiledRoute, path string) (map[string]string, bool) {
+	switch {
+	case c.regex != nil:
+		m := c.regex.FindStringSubmatch(path)
+		if m == nil {
+			return nil, false
+		}
+		var params map[string]string
+		for i, name := range c.regex.SubexpNames() {
+			if name != "" {
+				if params == nil {
+					params = make(map[string]string)
+				}
+				params[name] = m[i]
+			}
+		}
+		return params, true
+	case c.segments != nil:
+		parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+		var params map[string]string
+		for i, seg := range c.segments {
+			if seg == "*" {
+				return params, true
+			}
+			if i >= len(parts) {
+				return nil, false
+			}
+			if strings.HasPrefix(seg, "{") {
+				if parts[i] == "" {
+					return nil, false
+				}
+				if params == nil {
+					params = make(map[string]string)
+				}
+				params[seg[1:len(seg)-1]] = parts[i]
+				continue
+			}
+			if seg != parts[i] {
+				return nil, false
+			}
+		}
+		return params, len(parts) == len(c.segments)
+	}
+	return nil, strings.HasPrefix(path, strings.TrimSuffix(r.PathPrefix, "/*"))
+}
+
+// evaluate matches a route against a request, returning what did not match
+// when it fails
+func (r *TargetRoute) evaluate(req *http.Request) (RouteMatch, string) {
+	m := RouteMatch{Route: r}
+	c := r.matcher()
+	if c == nil {
+		return m, "invalid path pattern"
+	}
+
+	if r.Host != "" {
+		host := requestHost(req)
+		pattern := strings.ToLower(r.Host)
+		switch {
+		case host == pattern:
+			m.Specificity.Host = 2
+		case strings.HasPrefix(pattern, "*.") && strings.HasSuffix(host, pattern[1:]):
+			m.Specificity.Host = 1
+		default:
+			return m, fmt.Sprintf("host %q does not match %q", host, r.Host)
+		}
+	}
+	if len(r.Methods) > 0 {
+		if !containsFold(r.Methods, req.Method) {
+			return m, fmt.Sprintf("method %s not in %v", req.Method, r.Methods)
+		}
+		m.Specificity.Conditions++
+	}
+	for name, want := range r.Headers {
+		got, ok := req.Header[http.CanonicalHeaderKey(name)]
+		if !ok || (want != "*" && !containsFold(got, want)) {
+			return m, fmt.Sprintf("header %s does not match %q", http.CanonicalHeaderKey(name), want)
+		}
+		m.Specificity.Conditions++
+	}
+
+	params, ok := r.matchPath(c, req.URL.Path)
+	if !ok {
+		return m, fmt.Sprintf("path %s does not match", req.URL.Path)
+	}
+	m.Params = params
+	m.Specificity.Path = c.literal
+	m.Specificity.Kind = c.kind
+	return m, ""
+}
+
+// requestHost returns the lower-cased request host without its port
+func requestHost(r *http.Request) string {
+	host := r.Host
+	if host == "" && r.URL != nil {
+		host = r.URL.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// ExplainRoutes evaluates every target route against a request and marks
+// the one MatchRoute would select
+func (c *Config) ExplainRoutes(r *http.Request) []RouteCandidate {
+	candidates := make([]RouteCandidate, len(c.TargetRoutes))
+	best := -1
+	for i := range c.TargetRoutes {
+		m, reason := c.TargetRoutes[i].evaluate(r)
+		m.Index = i
+		candidates[i] = RouteCandidate{RouteMatch: m, Matched: reason == "", Reason: reason}
+		if reason == "" && (best < 0 || candidates[best].Specificity.Less(m.Specificity)) {
+			best = i
+		}
+	}
+	if best >= 0 {
+		candidates[best].Selected = true
+	}
+	return candidates
+}
+
+// MatchRoute returns the most specific target route matching the request, or
+// nil. Equally specific routes are resolved in configuration order.
+func (c *Config) MatchRoute(r *http.Request) *RouteMatch {
+	var best *RouteMatch
+	for i := range c.TargetRoutes {
+		m, reason := c.TargetRoutes[i].evaluate(r)
+		if reason != "" {
+			continue
+		}
+		if best == nil || best.Specificity.Less(m.Specificity) {
+			m.Index = i
+			best = &m
+		}
+	}
+	return best
+}
+
+// TargetURLFor returns the target URL for a request, falling back to
+// http_target_url when no route matches
+func (c *Config) TargetURLFor(r *http.Request) string {
+	if m := c.MatchRoute(r); m != nil {
+		return m.Route.PrimaryURL()
+	}
+	return c.HTTPTargetURL
+}
+
+// pathRequest builds a request carrying only a path, for callers without one
+func pathRequest(path string) *http.Request {
+	return &http.Request{URL: &url.URL{Path: path}, Header: http.Header{}}
+}
+
+// validateRoutes checks the target routes and compiles their path matchers
+func validateRoutes(routes []TargetRoute) error {
+	for i := range routes {
+		route := &routes[i]
+		set := 0
+		for _, p := range []string{route.PathPrefix, route.Path, route.PathRegex} {
+			if p != "" {
+				set++
+			}
+		}
+		if set != 1 {
+			return errors.New("exactly one of path_prefix, path or path_regex must be set for target routes")
+		}
+
+		// Ensure path_prefix starts with "/"
+		if route.PathPrefix != "" && !strings.HasPrefix(route.PathPrefix, "/") {
+			return errors.New("path_prefix must start with a '/' character")
+		}
+		if route.StripPrefix && route.PathPrefix == "" {
+			return fmt.Errorf("strip_prefix requires path_prefix for route %s", route)
+		}
+		if route.TargetURL == "" && len(route.Upstreams) == 0 {
+			return errors.New("target_url cannot be empty for target routes")
+		}
+		if err := validateUpstreams(route); err != nil {
+			return fmt.Errorf("route %s: %w", route, err)
+		}
+		if route.Timeout < 0 {
+			return fmt.Errorf("timeout cannot be negative for route %s", route)
+		}
+		if (route.TLS.ClientCertFile == "") != (route.TLS.ClientKeyFile == "") {
+			return fmt.Errorf("tls.client_cert_file and tls.client_key_file must be set together for route %s", route)
+		}
+		if route.OpenAPI.BasePath != "" {
+			if route.OpenAPI.SpecPath == "" {
+				return fmt.Errorf("openapi.base_path requires openapi.spec_path for route %s", route)
+			}
+			if !strings.HasPrefix(route.OpenAPI.BasePath, "/") {
+				return fmt.Errorf("openapi.base_path must start with a '/' character for route %s", route)
+			}
+		}
+
+		compiled, err := compileRoute(route)
+		if err != nil {
+			return fmt.Errorf("route %s: %w", route, err)
+		}
+		route.compiled = compiled
+	}
+	return nil
+}
+
+// validateUpstreams checks the load balancing settings of a route
+func validateUpstreams(route *TargetRoute) error {
+	if route.TargetURL != "" && len(route.Upstreams) > 0 {
+		return errors.New("target_url and upstreams cannot both be set")
+	}
+	for _, u := range route.Upstreams {
+		if u.URL == "" {
+			return errors.New("upstream url cannot be empty")
+		}
+		if parsed, err := url.Parse(u.URL); err != nil || parsed.Host == "" {
+			return fmt.Errorf("invalid upstream url %q", u.URL)
+		}
+		if u.Weight < 0 {
+			return fmt.Errorf("upstream weight cannot be negative for %s", u.URL)
+		}
+	}
+	switch route.LoadBalancing {
+	case "", LoadBalanceRoundRobin, LoadBalanceLeastConnections, LoadBalanceWeighted:
+	default:
+		return fmt.Errorf("invalid load_balancing %q", route.LoadBalancing)
+	}
+	hc := route.HealthCheck
+	if hc.Path != "" && !strings.HasPrefix(hc.Path, "/") {
+		return errors.New("health_check.path must start with a '/' character")
+	}
+	if hc.Interval < 0 || hc.Timeout < 0 || hc.UnhealthyThreshold < 0 || hc.HealthyThreshold < 0 {
+		return errors.New("health_check values cannot be negative")
+	}
+	if route.OutlierDetection.Consecutive5xx < 0 || route.OutlierDetection.EjectionTime < 0 {
+		return errors.New("outlier_detection values cannot be negative")
+	}
+	return nil
+}
+
+// GetRouteTLSConfig returns the client TLS configuration for a route, based
+// on the global settings with the route's overrides applied
+func (c *Config) GetRouteTLSConfig(route *TargetRoute) (*tls.Config, error) {
+	tlsConfig := c.GetTLSConfig()
+	if route == nil {
+		return tlsConfig, nil
+	}
+	rt := route.TLS
+	if rt.InsecureSkipVerify {
+		tlsConfig.InsecureSkipVerify = true
+	}
+	if rt.ServerName != "" {
+		tlsConfig.ServerName = rt.ServerName
+	}
+	if rt.CACert != "" {
+		pem, err := os.ReadFile(rt.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("reading route CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", rt.CACert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if rt.ClientCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(rt.ClientCertFile, rt.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading route client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
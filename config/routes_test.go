@@ -0,0 +1,106 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMatchRoute(t *testing.T) {
+	cfg := &Config{
+		TargetRoutes: []TargetRoute{
+			{Name: "users", PathPrefix: "/api/v1/users/", TargetURL: "http://users"},
+			{Name: "availability", PathPrefix: "/api/v1/users/is-available", TargetURL: "http://availability"},
+			{Name: "user", Path: "/api/v1/users/{id}", TargetURL: "http://user"},
+			{Name: "me", Path: "/api/v1/users/me", TargetURL: "http://me"},
+			{Name: "writes", PathPrefix: "/api/v1/users/", Methods: []string{"POST", "PUT"}, TargetURL: "http://writes"},
+			{Name: "canary", PathPrefix: "/api/v1/users/", Headers: map[string]string{"x-canary": "*"}, Methods: []string{"POST"}, TargetURL: "http://canary"},
+			{Name: "tenant", Host: "*.tenant.example.com", PathPrefix: "/", TargetURL: "http://tenant"},
+			{Name: "admin", Host: "admin.tenant.example.com", PathPrefix: "/", TargetURL: "http://admin"},
+			{Name: "orders", PathRegex: `^/orders/(?P<id>\d+)/items$`, TargetURL: "http://orders"},
+			{Name: "files", Path: "/files/{bucket}/*", TargetURL: "http://files"},
+			{Name: "first", PathPrefix: "/dup", TargetURL: "http://first"},
+			{Name: "second", PathPrefix: "/dup", TargetURL: "http://second"},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		headers    map[string]string
+		wantRoute  string
+		wantParams map[string]string
+	}{
+		{name: "Longest prefix wins over an earlier shorter prefix", target: "/api/v1/users/is-available", wantRoute: "availability"},
+		{name: "Exact path beats a template", target: "/api/v1/users/me", wantRoute: "me"},
+		{name: "Template captures parameters", target: "/api/v1/users/42", wantRoute: "user", wantParams: map[string]string{"id": "42"}},
+		{name: "Template does not match deeper paths", target: "/api/v1/users/42/orders", wantRoute: "users"},
+		{name: "Method condition breaks the tie", method: http.MethodPut, target: "/api/v1/users/", wantRoute: "writes"},
+		{name: "More conditions win", method: http.MethodPost, target: "/api/v1/users/", headers: map[string]string{"X-Canary": "1"}, wantRoute: "canary"},
+		{name: "Wildcard host", target: "http://blue.tenant.example.com:8080/api/v1/users/is-available", wantRoute: "tenant"},
+		{name: "Exact host beats wildcard host", target: "http://admin.tenant.example.com/x", wantRoute: "admin"},
+		{name: "Regex with named groups", target: "/orders/7/items", wantRoute: "orders", wantParams: map[string]string{"id": "7"}},
+		{name: "Template with trailing wildcard", target: "/files/media/a/b.png", wantRoute: "files", wantParams: map[string]string{"bucket": "media"}},
+		{name: "Equal routes keep configuration order", target: "/dup/x", wantRoute: "first"},
+		{name: "No match", target: "/nothing", wantRoute: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			method := tt.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			req := httptest.NewRequest(method, tt.target, nil)
+			if tt.target[0] == '/' {
+				req.Host = ""
+			}
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+
+			m := cfg.MatchRoute(req)
+			got := ""
+			if m != nil {
+				got = m.Route.Name
+			}
+			if got != tt.wantRoute {
+				t.Fatalf("MatchRoute() = %q, want %q", got, tt.wantRoute)
+			}
+			for name, want := range tt.wantParams {
+				if m.Params[name] != want {
+					t.Errorf("Params[%s] = %q, want %q", name, m.Params[name], want)
+				}
+			}
+		})
+	}
+}
+
+func TestExplainRoutes(t *testing.T) {
+	cfg := &Config{
+		TargetRoutes: []TargetRoute{
+			{PathPrefix: "/api/", TargetURL: "http://a"},
+			{PathPrefix: "/api/users", Methods: []string{"POST"}, TargetURL: "http://b"},
+			{PathPrefix: "/api/users", TargetURL: "http://c"},
+		},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/users/1", nil)
+
+	candidates := cfg.ExplainRoutes(req)
+	if len(candidates) != 3 {
+		t.Fatalf("Expected 3 candidates, got %d", len(candidates))
+	}
+	if !candidates[0].Matched || candidates[0].Selected {
+		t.Errorf("Expected route 1 to match without being selected: %+v", candidates[0])
+	}
+	if candidates[1].Matched || candidates[1].Reason == "" {
+		t.Errorf("Expected route 2 to fail on the method with a reason: %+v", candidates[1])
+	}
+	if !candidates[2].Selected {
+		t.Errorf("Expected route 3 to be selected: %+v", candidates[2])
+	}
+	if m := cfg.MatchRoute(req); m == nil || m.Index != 2 {
+		t.Errorf("MatchRoute() disagrees with ExplainRoutes: %+v", m)
+	}
+}
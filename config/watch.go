@@ -0,0 +1,61 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce groups the burst of events editors produce for one save
+const watchDebounce = 200 * time.Millisecond
+
+// Watch calls onChange after the file at path is written, created or
+// replaced, until ctx is done. The directory is watched rather than the file
+// so editors that save by renaming a new file over the old one are noticed.
+func Watch(ctx context.Context, path string, onChange func()) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return fmt.Errorf("resolving config path: %w", err)
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating file watcher: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("watching %s: %w", filepath.Dir(path), err)
+	}
+
+	go func() {
+		defer watcher.Close()
+		timer := time.NewTimer(watchDebounce)
+		timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != path || !event.Has(fsnotify.Write|fsnotify.Create) {
+					continue
+				}
+				timer.Reset(watchDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Config file watcher error", "error", err)
+			case <-timer.C:
+				onChange()
+			}
+		}
+	}()
+	return nil
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("http_port: 8080\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan struct{}, 10)
+	if err := Watch(ctx, path, func() { changes <- struct{}{} }); err != nil {
+		t.Fatalf("Watch() error: %v", err)
+	}
+
+	expectChange := func(what string) {
+		t.Helper()
+		select {
+		case <-changes:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("No change reported after %s", what)
+		}
+	}
+
+	// Other files in the directory are ignored
+	if err := os.WriteFile(filepath.Join(dir, "other.yaml"), []byte("x: 1\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("http_port: 8081\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	expectChange("a write")
+
+	// Editors often save by renaming a new file over the old one
+	tmp := filepath.Join(dir, "config.yaml.tmp")
+	if err := os.WriteFile(tmp, []byte("http_port: 8082\n"), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatalf("Failed to replace config: %v", err)
+	}
+	expectChange("a rename")
+
+	select {
+	case <-changes:
+		t.Error("Expected each save to be reported once")
+	case <-time.After(3 * watchDebounce):
+	}
+}
@@ -0,0 +1,200 @@
+//go:build wasip1
+
+// Command tagger is an example JARVIS plugin. It tags exchanges with the
+// client and status class, answers health checks itself, marks requests sent
+// upstream and masks JSON fields before exchanges are recorded.
+//
+// Build it with:
+//
+//	GOOS=wasip1 GOARCH=wasm go build -buildmode=c-shared -o tagger.wasm ./examples/plugins/tagger
+//
+// and load it with:
+//
+//	plugins:
+//	  - path: tagger.wasm
+//	    config:
+//	      health_path: /healthz
+//	      mask_fields: password,token
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"unsafe"
+)
+
+type request struct {
+	Method  string              `json:"method,omitempty"`
+	URL     string              `json:"url,omitempty"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Body    []byte              `json:"body"`
+}
+
+type response struct {
+	Status  int                 `json:"status,omitempty"`
+	Headers map[string][]string `json:"headers,omitempty"`
+	Body    []byte              `json:"body"`
+}
+
+type input struct {
+	Hook     string            `json:"hook"`
+	Config   map[string]string `json:"config"`
+	Request  *request          `json:"request"`
+	Response *response         `json:"response"`
+	Tags     map[string]string `json:"tags"`
+}
+
+type result struct {
+	Request  *request          `json:"request,omitempty"`
+	Response *response         `json:"response,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+func main() {}
+
+// buffers keeps the memory handed to the host alive until it is used
+var buffers = map[uint32][]byte{}
+
+// output holds the last result until the host has copied it
+var output []byte
+
+//go:wasmexport jarvis_malloc
+func malloc(size uint32) uint32 {
+	buf := make([]byte, size)
+	ptr := uint32(uintptr(unsafe.Pointer(unsafe.SliceData(buf))))
+	buffers[ptr] = buf
+	return ptr
+}
+
+//go:wasmimport jarvis log
+func hostLog(level, ptr, size uint32)
+
+func logf(format string, args ...any) {
+	msg := []byte(fmt.Sprintf(format, args...))
+	if len(msg) == 0 {
+		return
+	}
+	hostLog(1, uint32(uintptr(unsafe.Pointer(&msg[0]))), uint32(len(msg)))
+}
+
+//go:wasmexport jarvis_on_request_received
+func onRequestReceived(ptr, size uint32) uint64 {
+	return handle(ptr, func(in *input) *result {
+		out := &result{Tags: map[string]string{}}
+		if agent := header(in.Request.Headers, "User-Agent"); agent != "" {
+			out.Tags["client"], _, _ = strings.Cut(agent, "/")
+		}
+		if u, err := url.Parse(in.Request.URL); err == nil && in.Config["health_path"] != "" && u.Path == in.Config["health_path"] {
+			logf("answering health check %s", u.Path)
+			out.Response = &response{
+				Status:  200,
+				Headers: map[string][]string{"Content-Type": {"application/json"}},
+				Body:    []byte(`{"status":"ok"}`),
+			}
+		}
+		return out
+	})
+}
+
+//go:wasmexport jarvis_on_before_upstream
+func onBeforeUpstream(ptr, size uint32) uint64 {
+	return handle(ptr, func(in *input) *result {
+		headers := in.Request.Headers
+		if headers == nil {
+			headers = map[string][]string{}
+		}
+		headers["X-Jarvis-Plugin"] = []string{"tagger"}
+		return &result{Request: &request{Headers: headers}}
+	})
+}
+
+//go:wasmexport jarvis_on_response_received
+func onResponseReceived(ptr, size uint32) uint64 {
+	return handle(ptr, func(in *input) *result {
+		headers := in.Response.Headers
+		if headers == nil {
+			headers = map[string][]string{}
+		}
+		headers["X-Jarvis-Tagged"] = []string{"true"}
+		return &result{
+			Response: &response{Headers: headers},
+			Tags:     map[string]string{"status_class": fmt.Sprintf("%dxx", in.Response.Status/100)},
+		}
+	})
+}
+
+//go:wasmexport jarvis_on_before_persist
+func onBeforePersist(ptr, size uint32) uint64 {
+	return handle(ptr, func(in *input) *result {
+		fields := strings.Split(in.Config["mask_fields"], ",")
+		out := &result{}
+		if in.Request != nil {
+			if body, ok := mask(in.Request.Body, fields); ok {
+				out.Request = &request{Body: body}
+			}
+		}
+		if in.Response != nil {
+			if body, ok := mask(in.Response.Body, fields); ok {
+				out.Response = &response{Body: body}
+			}
+		}
+		if out.Request == nil && out.Response == nil {
+			return nil
+		}
+		return out
+	})
+}
+
+// handle decodes the input at ptr, runs fn and returns the location of its
+// encoded result
+func handle(ptr uint32, fn func(*input) *result) uint64 {
+	buf := buffers[ptr]
+	delete(buffers, ptr)
+	var in input
+	if err := json.Unmarshal(buf, &in); err != nil {
+		logf("decoding input: %v", err)
+		return 0
+	}
+	out := fn(&in)
+	if out == nil {
+		return 0
+	}
+	encoded, err := json.Marshal(out)
+	if err != nil {
+		logf("encoding result: %v", err)
+		return 0
+	}
+	output = encoded
+	return uint64(uintptr(unsafe.Pointer(&output[0])))<<32 | uint64(len(output))
+}
+
+// mask replaces the top-level fields of a JSON object body with "***"
+func mask(body []byte, fields []string) ([]byte, bool) {
+	var doc map[string]any
+	if len(body) == 0 || json.Unmarshal(body, &doc) != nil {
+		return nil, false
+	}
+	masked := false
+	for _, field := range fields {
+		if _, ok := doc[strings.TrimSpace(field)]; ok {
+			doc[strings.TrimSpace(field)] = "***"
+			masked = true
+		}
+	}
+	if !masked {
+		return nil, false
+	}
+	out, err := json.Marshal(doc)
+	return out, err == nil
+}
+
+func header(headers map[string][]string, name string) string {
+	for key, values := range headers {
+		if strings.EqualFold(key, name) && len(values) > 0 {
+			return values[0]
+		}
+	}
+	return ""
+}
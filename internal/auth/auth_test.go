@@ -0,0 +1,214 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+func TestAPIKey(t *testing.T) {
+	t.Setenv("TEST_API_KEY", "s3cret")
+	keyFile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyFile, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write key file: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     config.APIKeyAuthConfig
+		wantURL string
+		header  string
+		want    string
+	}{
+		{
+			name:    "Default header",
+			cfg:     config.APIKeyAuthConfig{Key: config.Secret{Env: "TEST_API_KEY"}},
+			wantURL: "http://upstream/items?page=2&key=old&q=a,b",
+			header:  "X-API-Key",
+			want:    "s3cret",
+		},
+		{
+			name:    "Bearer prefix from a file",
+			cfg:     config.APIKeyAuthConfig{Key: config.Secret{File: keyFile}, Header: "Authorization", Prefix: "Bearer "},
+			wantURL: "http://upstream/items?page=2&key=old&q=a,b",
+			header:  "Authorization",
+			want:    "Bearer from-file",
+		},
+		{
+			name:    "Query parameter",
+			cfg:     config.APIKeyAuthConfig{Key: config.Secret{Env: "TEST_API_KEY"}, QueryParam: "key"},
+			wantURL: "http://upstream/items?page=2&q=a,b&key=s3cret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := New(config.AuthProvider{Name: "key", APIKey: &tt.cfg})
+			if err != nil {
+				t.Fatalf("Failed to create provider: %v", err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "http://upstream/items?page=2&key=old&q=a,b", nil)
+			if err := p.Authenticate(req); err != nil {
+				t.Fatalf("Authenticate failed: %v", err)
+			}
+			if req.URL.String() != tt.wantURL {
+				t.Errorf("Expected URL %s, got %s", tt.wantURL, req.URL)
+			}
+			if tt.header != "" && req.Header.Get(tt.header) != tt.want {
+				t.Errorf("Expected %s %q, got %q", tt.header, tt.want, req.Header.Get(tt.header))
+			}
+		})
+	}
+}
+
+func TestMissingSecret(t *testing.T) {
+	_, err := New(config.AuthProvider{Name: "key", APIKey: &config.APIKeyAuthConfig{Key: config.Secret{Env: "JARVIS_TEST_UNSET_KEY"}}})
+	if err == nil || !strings.Contains(err.Error(), "JARVIS_TEST_UNSET_KEY") {
+		t.Errorf("Expected an error naming the unset variable, got %v", err)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	t.Setenv("TEST_HMAC_KEY", "hmac-key")
+	p, err := newHMAC(config.HMACAuthConfig{Key: config.Secret{Env: "TEST_HMAC_KEY"}})
+	if err != nil {
+		t.Fatalf("Failed to create provider: %v", err)
+	}
+	p.now = func() time.Time { return time.Unix(1700000000, 0) }
+
+	req := httptest.NewRequest(http.MethodPost, "http://upstream/orders?dry_run=1", strings.NewReader(`{"qty":1}`))
+	if err := p.Authenticate(req); err != nil {
+		t.Fatalf("Authenticate failed: %v", err)
+	}
+
+	bodyHash := sha256.Sum256([]byte(`{"qty":1}`))
+	mac := hmac.New(sha256.New, []byte("hmac-key"))
+	fmt.Fprintf(mac, "POST\n/orders?dry_run=1\n1700000000\n%x", bodyHash)
+	if got, want := req.Header.Get("X-Signature"), hex.EncodeToString(mac.Sum(nil)); got != want {
+		t.Errorf("Expected signature %s, got %s", want, got)
+	}
+	if req.Header.Get("X-Timestamp") != "1700000000" {
+		t.Errorf("Expected the signing time, got %q", req.Header.Get("X-Timestamp"))
+	}
+	if body, _ := io.ReadAll(req.Body); string(body) != `{"qty":1}` {
+		t.Errorf("Expected the body to be readable after signing, got %q", body)
+	}
+}
+
+// TestSigV4 checks signatures against the examples of the AWS Signature
+// Version 4 documentation and test suite
+func TestSigV4(t *testing.T) {
+	t.Setenv("TEST_AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("TEST_AWS_SECRET_ACCESS_KEY", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY")
+
+	tests := []struct {
+		name        string
+		service     string
+		url         string
+		contentType string
+		want        string
+	}{
+		{
+			name:    "get-vanilla",
+			service: "service",
+			url:     "https://example.amazonaws.com/",
+			want:    "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:        "IAM ListUsers",
+			service:     "iam",
+			url:         "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			contentType: "application/x-www-form-urlencoded; charset=utf-8",
+			want:        "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newSigV4(config.AWSSigV4AuthConfig{
+				AccessKeyID:     config.Secret{Env: "TEST_AWS_ACCESS_KEY_ID"},
+				SecretAccessKey: config.Secret{Env: "TEST_AWS_SECRET_ACCESS_KEY"},
+				Region:          "us-east-1",
+				Service:         tt.service,
+			})
+			if err != nil {
+				t.Fatalf("Failed to create provider: %v", err)
+			}
+			p.now = func() time.Time { return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC) }
+
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			req.Header = http.Header{}
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			if err := p.Authenticate(req); err != nil {
+				t.Fatalf("Authenticate failed: %v", err)
+			}
+			if got := req.Header.Get("Authorization"); got != tt.want {
+				t.Errorf("Expected Authorization\n%s\ngot\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestOAuth2CachesAndRefreshesTokens(t *testing.T) {
+	var issued atomic.Int32
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "client_credentials" || r.Form.Get("audience") != "orders" {
+			http.Error(w, "bad grant", http.StatusBadRequest)
+			return
+		}
+		if id, secret, ok := r.BasicAuth(); !ok || id != "client" || secret != "client-secret" {
+			http.Error(w, "bad client", http.StatusUnauthorized)
+			return
+		}
+		n := issued.Add(1)
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":3600}`, n)
+	}))
+	defer tokenServer.Close()
+
+	t.Setenv("TEST_CLIENT_ID", "client")
+	t.Setenv("TEST_CLIENT_SECRET", "client-secret")
+	newProvider := func(refreshBefore time.Duration) Provider {
+		p, err := New(config.AuthProvider{Name: "oauth", OAuth2: &config.OAuth2AuthConfig{
+			TokenURL:      tokenServer.URL,
+			ClientID:      config.Secret{Env: "TEST_CLIENT_ID"},
+			ClientSecret:  config.Secret{Env: "TEST_CLIENT_SECRET"},
+			Audience:      "orders",
+			RefreshBefore: refreshBefore,
+		}})
+		if err != nil {
+			t.Fatalf("Failed to create provider: %v", err)
+		}
+		return p
+	}
+	authorize := func(p Provider) string {
+		req := httptest.NewRequest(http.MethodGet, "http://upstream/orders", nil)
+		if err := p.Authenticate(req); err != nil {
+			t.Fatalf("Authenticate failed: %v", err)
+		}
+		return req.Header.Get("Authorization")
+	}
+
+	cached := newProvider(time.Minute)
+	if first, second := authorize(cached), authorize(cached); first != "Bearer token-1" || second != first {
+		t.Errorf("Expected the token to be cached, got %q then %q", first, second)
+	}
+
+	// A refresh window longer than the token lifetime fetches a new token each time
+	refreshing := newProvider(2 * time.Hour)
+	if first, second := authorize(refreshing), authorize(refreshing); first == second {
+		t.Errorf("Expected tokens about to expire to be refreshed, got %q twice", first)
+	}
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+// tokenTimeout bounds a request to the token endpoint
+const tokenTimeout = 10 * time.Second
+
+// oauth2Bearer sends bearer tokens fetched with the client credentials grant.
+// Tokens are cached and fetched again refreshBefore their expiry.
+type oauth2Bearer struct {
+	tokens oauth2.TokenSource
+}
+
+func newOAuth2(cfg config.OAuth2AuthConfig) (*oauth2Bearer, error) {
+	clientID, err := cfg.ClientID.Value()
+	if err != nil {
+		return nil, fmt.Errorf("reading oauth2 client id: %w", err)
+	}
+	clientSecret, err := cfg.ClientSecret.Value()
+	if err != nil {
+		return nil, fmt.Errorf("reading oauth2 client secret: %w", err)
+	}
+	cc := &clientcredentials.Config{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		TokenURL:     cfg.TokenURL,
+		Scopes:       cfg.Scopes,
+	}
+	if cfg.Audience != "" {
+		cc.EndpointParams = url.Values{"audience": {cfg.Audience}}
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Timeout: tokenTimeout})
+	return &oauth2Bearer{tokens: oauth2.ReuseTokenSourceWithExpiry(nil, cc.TokenSource(ctx), cfg.RefreshBefore)}, nil
+}
+
+func (p *oauth2Bearer) Authenticate(req *http.Request) error {
+	token, err := p.tokens.Token()
+	if err != nil {
+		return fmt.Errorf("fetching oauth2 token: %w", err)
+	}
+	token.SetAuthHeader(req)
+	return nil
+}
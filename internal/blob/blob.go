@@ -0,0 +1,188 @@
+// Package blob stores large request and response bodies on disk, addressed by
+// the SHA-256 of their content so identical bodies are kept once.
+package blob
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// refPrefix starts every reference stored in traffic_records
+const refPrefix = "sha256:"
+
+// ErrTooLarge is returned when a body exceeds the store's maximum size
+var ErrTooLarge = errors.New("body exceeds the blob store's maximum size")
+
+// Store is a content-addressed directory of bodies
+type Store struct {
+	dir     string
+	maxSize int64
+}
+
+// NewStore returns a store in dir accepting bodies up to maxSize bytes
+// (unlimited when 0). The directory is created on first write.
+func NewStore(dir string, maxSize int64) *Store {
+	return &Store{dir: dir, maxSize: maxSize}
+}
+
+// Dir returns the directory of the store
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// path returns the file of a reference, rejecting anything that is not a hash
+func (s *Store) path(ref string) (string, error) {
+	sum, ok := strings.CutPrefix(ref, refPrefix)
+	if !ok || len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("invalid blob reference %q", ref)
+	}
+	if _, err := hex.DecodeString(sum); err != nil {
+		return "", fmt.Errorf("invalid blob reference %q", ref)
+	}
+	return filepath.Join(s.dir, sum[:2], sum[2:]), nil
+}
+
+// Open returns the body of a reference and its size
+func (s *Store) Open(ref string) (io.ReadCloser, int64, error) {
+	path, err := s.path(ref)
+	if err != nil {
+		return nil, 0, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, fmt.Errorf("opening blob: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("reading blob size: %w", err)
+	}
+	return f, info.Size(), nil
+}
+
+// Put stores data and returns its reference
+func (s *Store) Put(data []byte) (string, error) {
+	w, err := s.NewWriter()
+	if err != nil {
+		return "", err
+	}
+	w.Write(data)
+	return w.Commit(nil)
+}
+
+// NewWriter returns a writer that streams a body to a temporary file
+func (s *Store) NewWriter() (*Writer, error) {
+	if err := os.MkdirAll(s.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating blob directory: %w", err)
+	}
+	f, err := os.CreateTemp(s.dir, ".tmp-*")
+	if err != nil {
+		return nil, fmt.Errorf("creating blob file: %w", err)
+	}
+	return &Writer{store: s, file: f, hash: sha256.New()}, nil
+}
+
+// Writer captures one body. Writes never fail so it can be teed next to the
+// client connection; errors and oversized bodies are reported by Commit.
+type Writer struct {
+	store *Store
+	file  *os.File
+	hash  hash.Hash
+	size  int64 // Bytes seen, including any beyond the maximum size
+	err   error
+	done  bool // Committed or aborted
+}
+
+// Write appends p to the body
+func (w *Writer) Write(p []byte) (int, error) {
+	w.size += int64(len(p))
+	if w.err != nil {
+		return len(p), nil
+	}
+	if w.store.maxSize > 0 && w.size > w.store.maxSize {
+		w.err = ErrTooLarge
+		return len(p), nil
+	}
+	if _, err := w.file.Write(p); err != nil {
+		w.err = fmt.Errorf("writing blob: %w", err)
+		return len(p), nil
+	}
+	w.hash.Write(p)
+	return len(p), nil
+}
+
+// Size returns the number of bytes written, including any beyond the maximum
+func (w *Writer) Size() int64 {
+	return w.size
+}
+
+// Commit moves the body into the store and returns its reference. transform,
+// when set, rewrites the body first, e.g. to redact it; it is given the whole
+// body in memory.
+func (w *Writer) Commit(transform func([]byte) []byte) (string, error) {
+	w.done = true
+	tmp := w.file.Name()
+	defer os.Remove(tmp)
+	if w.err != nil {
+		w.file.Close()
+		return "", w.err
+	}
+
+	sum := w.hash.Sum(nil)
+	if transform != nil {
+		if _, err := w.file.Seek(0, io.SeekStart); err != nil {
+			w.file.Close()
+			return "", fmt.Errorf("rewinding blob: %w", err)
+		}
+		data, err := io.ReadAll(w.file)
+		if err != nil {
+			w.file.Close()
+			return "", fmt.Errorf("reading blob: %w", err)
+		}
+		data = transform(data)
+		if err := w.file.Truncate(0); err != nil {
+			w.file.Close()
+			return "", fmt.Errorf("rewriting blob: %w", err)
+		}
+		if _, err := w.file.WriteAt(data, 0); err != nil {
+			w.file.Close()
+			return "", fmt.Errorf("rewriting blob: %w", err)
+		}
+		digest := sha256.Sum256(data)
+		sum = digest[:]
+	}
+	if err := w.file.Close(); err != nil {
+		return "", fmt.Errorf("closing blob: %w", err)
+	}
+
+	ref := refPrefix + hex.EncodeToString(sum)
+	path, _ := w.store.path(ref)
+	if _, err := os.Stat(path); err == nil {
+		return ref, nil // Identical body already stored
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("creating blob directory: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return "", fmt.Errorf("storing blob: %w", err)
+	}
+	return ref, nil
+}
+
+// Abort discards the body. It does nothing after Commit, so it can be
+// deferred.
+func (w *Writer) Abort() {
+	if w.done {
+		return
+	}
+	w.done = true
+	w.file.Close()
+	os.Remove(w.file.Name())
+}
@@ -0,0 +1,108 @@
+package blob
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+func readBlob(t *testing.T, s *Store, ref string) []byte {
+	t.Helper()
+	body, size, err := s.Open(ref)
+	if err != nil {
+		t.Fatalf("Open(%q) error: %v", ref, err)
+	}
+	defer body.Close()
+	data, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("Failed to read blob: %v", err)
+	}
+	if int64(len(data)) != size {
+		t.Errorf("Open() size = %d, read %d bytes", size, len(data))
+	}
+	return data
+}
+
+func TestStorePutAndOpen(t *testing.T) {
+	s := NewStore(t.TempDir(), 0)
+	data := bytes.Repeat([]byte("jarvis"), 1000)
+
+	ref, err := s.Put(data)
+	if err != nil {
+		t.Fatalf("Put() error: %v", err)
+	}
+	if !strings.HasPrefix(ref, refPrefix) {
+		t.Errorf("Expected a sha256 reference, got %q", ref)
+	}
+	if got := readBlob(t, s, ref); !bytes.Equal(got, data) {
+		t.Error("Stored body does not match")
+	}
+
+	// Identical bodies share a reference and file
+	again, err := s.Put(data)
+	if err != nil || again != ref {
+		t.Errorf("Expected the same reference for the same body, got %q, %v", again, err)
+	}
+	entries, _ := os.ReadDir(s.Dir())
+	if len(entries) != 1 {
+		t.Errorf("Expected one blob directory and no temporary files, got %d entries", len(entries))
+	}
+
+	for _, bad := range []string{"", "sha256:zz", "sha256:../../etc/passwd", "md5:" + strings.Repeat("a", 64)} {
+		if _, _, err := s.Open(bad); err == nil {
+			t.Errorf("Expected Open(%q) to reject the reference", bad)
+		}
+	}
+}
+
+func TestWriterTooLarge(t *testing.T) {
+	s := NewStore(t.TempDir(), 10)
+	w, err := s.NewWriter()
+	if err != nil {
+		t.Fatalf("NewWriter() error: %v", err)
+	}
+	for range 3 {
+		if n, err := w.Write([]byte("12345")); n != 5 || err != nil {
+			t.Fatalf("Write() = %d, %v; writes must not fail", n, err)
+		}
+	}
+	if w.Size() != 15 {
+		t.Errorf("Size() = %d, want 15", w.Size())
+	}
+	if _, err := w.Commit(nil); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Expected ErrTooLarge, got %v", err)
+	}
+	w.Abort() // No-op after Commit
+
+	entries, _ := os.ReadDir(s.Dir())
+	if len(entries) != 0 {
+		t.Errorf("Expected the oversized body to be discarded, got %d entries", len(entries))
+	}
+}
+
+func TestWriterCommitTransform(t *testing.T) {
+	s := NewStore(t.TempDir(), 0)
+	w, err := s.NewWriter()
+	if err != nil {
+		t.Fatalf("NewWriter() error: %v", err)
+	}
+	w.Write([]byte(`{"password":"hunter2","name":"tony"}`))
+	ref, err := w.Commit(func(b []byte) []byte {
+		return bytes.ReplaceAll(b, []byte("hunter2"), []byte("[REDACTED]"))
+	})
+	if err != nil {
+		t.Fatalf("Commit() error: %v", err)
+	}
+
+	want := `{"password":"[REDACTED]","name":"tony"}`
+	if got := readBlob(t, s, ref); string(got) != want {
+		t.Errorf("Stored body = %s, want %s", got, want)
+	}
+	// The reference addresses the transformed body
+	if direct, _ := s.Put([]byte(want)); direct != ref {
+		t.Errorf("Expected the reference of the transformed body, got %q and %q", ref, direct)
+	}
+}
@@ -0,0 +1,93 @@
+package certs
+
+import (
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerateAndLoadCA(t *testing.T) {
+	tempDir := t.TempDir()
+	certPath := filepath.Join(tempDir, "ca.crt")
+	keyPath := filepath.Join(tempDir, "ca.key")
+
+	created, err := EnsureCA(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("EnsureCA failed: %v", err)
+	}
+	if !created {
+		t.Fatal("Expected a new CA to be created")
+	}
+
+	// A second call must keep the existing CA
+	created, err = EnsureCA(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("EnsureCA failed on existing CA: %v", err)
+	}
+	if created {
+		t.Error("EnsureCA should not overwrite an existing CA")
+	}
+
+	ca, err := LoadCA(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("LoadCA failed: %v", err)
+	}
+	if !ca.Certificate().IsCA {
+		t.Error("Loaded certificate is not a CA")
+	}
+}
+
+func TestCertificateFor(t *testing.T) {
+	tempDir := t.TempDir()
+	certPath := filepath.Join(tempDir, "ca.crt")
+	keyPath := filepath.Join(tempDir, "ca.key")
+	if err := GenerateCA(certPath, keyPath); err != nil {
+		t.Fatalf("GenerateCA failed: %v", err)
+	}
+	ca, err := LoadCA(certPath, keyPath)
+	if err != nil {
+		t.Fatalf("LoadCA failed: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate())
+
+	tests := []struct {
+		host string
+	}{
+		{"api.example.com"},
+		{"127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			cert, err := ca.CertificateFor(tt.host)
+			if err != nil {
+				t.Fatalf("CertificateFor failed: %v", err)
+			}
+			if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: tt.host, Roots: roots}); err != nil {
+				t.Errorf("Leaf certificate does not verify against the CA: %v", err)
+			}
+
+			again, err := ca.CertificateFor(tt.host)
+			if err != nil {
+				t.Fatalf("CertificateFor failed on second call: %v", err)
+			}
+			if again != cert {
+				t.Error("Expected cached certificate to be reused")
+			}
+		})
+	}
+}
+
+func TestLoadCA_RejectsLeafCertificate(t *testing.T) {
+	tempDir := t.TempDir()
+	certPath := filepath.Join(tempDir, "server.crt")
+	keyPath := filepath.Join(tempDir, "server.key")
+	if err := GenerateSelfSignedCert(certPath, keyPath); err != nil {
+		t.Fatalf("GenerateSelfSignedCert failed: %v", err)
+	}
+	if _, err := LoadCA(certPath, keyPath); err == nil {
+		t.Error("Expected LoadCA to reject a non-CA certificate")
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"log/slog"
 	"time"
@@ -25,11 +26,80 @@ type TrafficRecord struct {
 	ResponseBody    []byte    `json:"response_body"`
 	Duration        int64     `json:"duration_ms"` // Duration in milliseconds
 	ClientIP        string    `json:"client_ip"`
-	TestID          string    `json:"test_id"`       // Optional: Link to test case ID
-	SessionID       string    `json:"session_id"`    // For grouping related requests
-	ConnectionID    string    `json:"connection_id"` // WebSocket connection identifier
-	MessageType     int       `json:"message_type"`  // For WebSocket: text/binary/etc.
-	Direction       string    `json:"direction"`     // For WebSocket: inbound/outbound
+	TestID          string    `json:"test_id"`         // Optional: Link to test case ID
+	SessionID       string    `json:"session_id"`      // For grouping related requests
+	ConnectionID    string    `json:"connection_id"`   // WebSocket connection identifier
+	MessageType     int       `json:"message_type"`    // For WebSocket: text/binary/etc.
+	Direction       string    `json:"direction"`       // For WebSocket: inbound/outbound
+	Source          string    `json:"source"`          // Where the response came from: live or replay
+	Fault           string    `json:"fault"`           // Faults injected into the exchange, e.g. "latency=200ms,status=503"
+	Upstream        string    `json:"upstream"`        // JSON of the exchange as seen by the target when rewrite rules changed it
+	UpstreamTarget  string    `json:"upstream_target"` // Base URL of the upstream that served a live response, or the model of an AI one
+
+	// Blob store references of bodies too large for the table; the body
+	// columns are empty when these are set
+	RequestBodyBlob  string `json:"request_body_blob,omitempty"`
+	ResponseBodyBlob string `json:"response_body_blob,omitempty"`
+
+	// Content-Encoding the stored response body was decoded from, so replay
+	// can encode it again
+	ResponseEncoding string `json:"response_encoding,omitempty"`
+
+	// W3C trace ID of the request, linking the record to its trace
+	TraceID string `json:"trace_id,omitempty"`
+
+	// JSON array of the events of a streamed response with their offsets
+	// from the response headers, so replay can reproduce the pacing
+	ResponseChunks string `json:"response_chunks,omitempty"`
+
+	// JSON object of the tags plugins attached to the exchange
+	Tags string `json:"tags,omitempty"`
+
+	// OpenAPI violations of the request and response, stored in the
+	// validation_errors table
+	ValidationErrors []ValidationError `json:"validation_errors,omitempty"`
+}
+
+// ValidationError is one way a recorded request or response broke the
+// OpenAPI spec
+type ValidationError struct {
+	Kind       string          `json:"kind"`                  // "request" or "response"
+	In         string          `json:"in"`                    // body, query, path, header, cookie, status or route
+	Parameter  string          `json:"parameter,omitempty"`   // Name of the failing parameter or header
+	Pointer    string          `json:"pointer,omitempty"`     // JSON pointer to the failing value in the body
+	SchemaPath string          `json:"schema_path,omitempty"` // JSON pointer into the spec to the failing keyword
+	Keyword    string          `json:"keyword,omitempty"`     // Failing schema keyword, e.g. required
+	Expected   json.RawMessage `json:"expected,omitempty"`
+	Actual     json.RawMessage `json:"actual,omitempty"`
+	Message    string          `json:"message"`
+}
+
+// Response sources stored in TrafficRecord.Source
+const (
+	SourceLive   = "live"
+	SourceReplay = "replay"
+	SourceFault  = "fault"  // Produced by fault injection without reaching the target
+	SourceMock   = "mock"   // Generated from an OpenAPI spec by the mock server
+	SourceAI     = "ai"     // Generated by a language model for a replay miss, then replayed like a recording
+	SourcePlugin = "plugin" // Returned by a plugin without reaching the target
+)
+
+// addedColumns lists columns introduced after the original schema. They are
+// added to existing databases on startup so old recordings stay readable.
+var addedColumns = []struct {
+	name       string
+	definition string
+}{
+	{"source", "TEXT DEFAULT 'live'"},
+	{"fault", "TEXT"},
+	{"upstream", "TEXT"},
+	{"upstream_target", "TEXT"},
+	{"request_body_blob", "TEXT"},
+	{"response_body_blob", "TEXT"},
+	{"response_encoding", "TEXT"},
+	{"trace_id", "TEXT"},
+	{"response_chunks", "TEXT"},
+	{"tags", "TEXT"},
 }
 
 // Initialize sets up the database connection and schema
@@ -96,6 +166,22 @@ func setupDatabase(db *sql.DB) (*sql.Stmt, error) {
     -- Index for searching by session or test ID
     CREATE INDEX IF NOT EXISTS idx_session_id ON traffic_records(session_id) WHERE session_id IS NOT NULL;
     CREATE INDEX IF NOT EXISTS idx_test_id ON traffic_records(test_id) WHERE test_id IS NOT NULL;
+
+    -- OpenAPI violations of recorded requests and responses
+    CREATE TABLE IF NOT EXISTS validation_errors (
+        id INTEGER PRIMARY KEY AUTOINCREMENT,
+        record_id TEXT NOT NULL REFERENCES traffic_records(id),
+        kind TEXT NOT NULL,    -- request or response
+        location TEXT,         -- body, query, path, header, cookie, status or route
+        parameter TEXT,
+        pointer TEXT,          -- JSON pointer into the body
+        schema_path TEXT,      -- JSON pointer into the spec
+        keyword TEXT,
+        expected TEXT,         -- JSON
+        actual TEXT,           -- JSON
+        message TEXT
+    );
+    CREATE INDEX IF NOT EXISTS idx_validation_errors_record ON validation_errors(record_id);
     `
 
 	_, err := db.Exec(query)
@@ -103,14 +189,20 @@ func setupDatabase(db *sql.DB) (*sql.Stmt, error) {
 		return nil, fmt.Errorf("creating schema: %w", err)
 	}
 
+	if err := migrateSchema(db); err != nil {
+		return nil, fmt.Errorf("migrating schema: %w", err)
+	}
+
 	// Prepare statement for inserts
 	insertSQL := `INSERT INTO traffic_records (
         id, timestamp, protocol, method, url, service,
         request_headers, request_body, response_status,
         response_headers, response_body, duration_ms,
         client_ip, test_id, session_id, connection_id,
-        message_type, direction
-    ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+        message_type, direction, source, fault, upstream, upstream_target,
+        request_body_blob, response_body_blob, response_encoding, trace_id,
+        response_chunks, tags
+    ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	stmt, err := db.Prepare(insertSQL)
 	if err != nil {
@@ -120,3 +212,35 @@ func setupDatabase(db *sql.DB) (*sql.Stmt, error) {
 	slog.Info("Database schema verified and statement prepared")
 	return stmt, nil
 }
+
+// migrateSchema adds any of addedColumns missing from traffic_records
+func migrateSchema(db *sql.DB) error {
+	rows, err := db.Query(`SELECT name FROM pragma_table_info('traffic_records')`)
+	if err != nil {
+		return fmt.Errorf("reading table info: %w", err)
+	}
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return fmt.Errorf("scanning table info: %w", err)
+		}
+		existing[name] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("reading table info: %w", err)
+	}
+
+	for _, col := range addedColumns {
+		if existing[col.name] {
+			continue
+		}
+		if _, err := db.Exec(fmt.Sprintf("ALTER TABLE traffic_records ADD COLUMN %s %s", col.name, col.definition)); err != nil {
+			return fmt.Errorf("adding column %s: %w", col.name, err)
+		}
+		slog.Info("Added column to traffic_records", "column", col.name)
+	}
+	return nil
+}
@@ -167,6 +167,16 @@ func TestTrafficRecordStorage(t *testing.T) {
 			record.ConnectionID,
 			record.MessageType,
 			record.Direction,
+			record.Source,
+			record.Fault,
+			record.Upstream,
+			record.UpstreamTarget,
+			record.RequestBodyBlob,
+			record.ResponseBodyBlob,
+			record.ResponseEncoding,
+			record.TraceID,
+			record.ResponseChunks,
+			record.Tags,
 		)
 		if err != nil {
 			t.Fatalf("Failed to insert test record: %v", err)
@@ -448,6 +458,16 @@ func TestDatabaseConcurrency(t *testing.T) {
 				record.ConnectionID,
 				record.MessageType,
 				record.Direction,
+				record.Source,
+				record.Fault,
+				record.Upstream,
+				record.UpstreamTarget,
+				record.RequestBodyBlob,
+				record.ResponseBodyBlob,
+				record.ResponseEncoding,
+				record.TraceID,
+				record.ResponseChunks,
+				record.Tags,
 			)
 			if err != nil {
 				errCh <- err
@@ -505,3 +525,41 @@ func TestDatabaseConcurrency(t *testing.T) {
 		t.Errorf("Unexpected protocol distribution: HTTP=%d, WebSocket=%d", httpCount, wsCount)
 	}
 }
+
+func TestInitializeMigratesExistingSchema(t *testing.T) {
+	tempFile, err := os.CreateTemp("", "traffic_inspector_test_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	tempFile.Close()
+	defer os.Remove(tempFile.Name())
+
+	// A database created before the source column existed
+	database, stmt, err := Initialize(tempFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	stmt.Close()
+	if _, err := database.Exec(`ALTER TABLE traffic_records DROP COLUMN source`); err != nil {
+		t.Fatalf("Failed to drop column: %v", err)
+	}
+	if _, err := database.Exec(`INSERT INTO traffic_records (id, protocol, method, response_status) VALUES ('old', 'HTTP', 'GET', 200)`); err != nil {
+		t.Fatalf("Failed to insert old record: %v", err)
+	}
+	database.Close()
+
+	database, stmt, err = Initialize(tempFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize existing database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+
+	var source string
+	if err := database.QueryRow(`SELECT source FROM traffic_records WHERE id = 'old'`).Scan(&source); err != nil {
+		t.Fatalf("Failed to read migrated column: %v", err)
+	}
+	if source != SourceLive {
+		t.Errorf("Expected existing records to default to %q, got %q", SourceLive, source)
+	}
+}
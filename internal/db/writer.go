@@ -0,0 +1,329 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/internal/metrics"
+)
+
+// What Writer.Write does when the queue is full
+const (
+	OnFullBlock = "block" // Wait up to BlockTimeout for room, then drop
+	OnFullDrop  = "drop"  // Drop the record immediately
+)
+
+// Writer defaults
+const (
+	defaultQueueSize     = 1024
+	defaultBatchSize     = 100
+	defaultFlushInterval = 100 * time.Millisecond
+	defaultBlockTimeout  = time.Second
+	batchTimeout         = 30 * time.Second
+)
+
+// ErrWriterClosed is returned for records written after Close
+var ErrWriterClosed = errors.New("traffic writer is closed")
+
+// WriterOptions tune the queue and batching of a Writer. Zero values use the
+// defaults.
+type WriterOptions struct {
+	QueueSize     int           // Records buffered before backpressure applies
+	BatchSize     int           // Most records inserted per transaction
+	FlushInterval time.Duration // Longest time a record waits for its batch to fill
+	OnFull        string        // OnFullBlock (default) or OnFullDrop
+	BlockTimeout  time.Duration // How long OnFullBlock waits for room
+}
+
+// WriterStats counts the records that passed through a Writer
+type WriterStats struct {
+	Queued  uint64 `json:"queued"`  // Accepted into the queue
+	Written uint64 `json:"written"` // Inserted into the database
+	Dropped uint64 `json:"dropped"` // Rejected because the queue was full or closed
+	Failed  uint64 `json:"failed"`  // Accepted but failed to insert
+	Pending int    `json:"pending"` // Waiting in the queue now
+}
+
+// Writer stores traffic records from a bounded queue, inserting them in
+// batched transactions on a single goroutine
+type Writer struct {
+	db   *sql.DB
+	stmt *sql.Stmt
+	opts WriterOptions
+
+	mu     sync.RWMutex // Guards closing the queue against concurrent writes
+	closed bool
+	queue  chan TrafficRecord
+	done   chan struct{}
+
+	queued  atomic.Uint64
+	written atomic.Uint64
+	dropped atomic.Uint64
+	failed  atomic.Uint64
+}
+
+// NewWriter starts a writer inserting records with stmt, the statement
+// prepared by Initialize
+func NewWriter(database *sql.DB, stmt *sql.Stmt, opts WriterOptions) *Writer {
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = defaultQueueSize
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = defaultFlushInterval
+	}
+	if opts.OnFull == "" {
+		opts.OnFull = OnFullBlock
+	}
+	if opts.BlockTimeout <= 0 {
+		opts.BlockTimeout = defaultBlockTimeout
+	}
+
+	w := &Writer{
+		db:    database,
+		stmt:  stmt,
+		opts:  opts,
+		queue: make(chan TrafficRecord, opts.QueueSize),
+		done:  make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Write queues a record. When the queue is full it blocks for up to
+// BlockTimeout or drops the record, depending on OnFull. Dropped records are
+// counted and reported with an error.
+func (w *Writer) Write(record TrafficRecord) error {
+	if record.Source == "" {
+		record.Source = SourceLive
+	}
+
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if w.closed {
+		w.drop(record)
+		return ErrWriterClosed
+	}
+
+	select {
+	case w.queue <- record:
+		w.enqueued()
+		return nil
+	default:
+	}
+
+	if w.opts.OnFull == OnFullBlock {
+		timer := time.NewTimer(w.opts.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case w.queue <- record:
+			w.enqueued()
+			return nil
+		case <-timer.C:
+		}
+	}
+	w.drop(record)
+	return fmt.Errorf("dropping record %s: queue of %d records is full", record.ID, w.opts.QueueSize)
+}
+
+// enqueued counts a record accepted into the queue
+func (w *Writer) enqueued() {
+	w.queued.Add(1)
+	metrics.RecorderQueueDepth.Inc()
+}
+
+// drop counts a rejected record, warning on the first and every 1000th drop
+func (w *Writer) drop(record TrafficRecord) {
+	metrics.RecorderRecords.WithLabelValues(metrics.ResultDropped).Inc()
+	if n := w.dropped.Add(1); n == 1 || n%1000 == 0 {
+		slog.Warn("Dropping traffic records, the writer cannot keep up", "record_id", record.ID, "dropped", n, "queue_size", w.opts.QueueSize)
+	}
+}
+
+// Stats returns the current counters
+func (w *Writer) Stats() WriterStats {
+	return WriterStats{
+		Queued:  w.queued.Load(),
+		Written: w.written.Load(),
+		Dropped: w.dropped.Load(),
+		Failed:  w.failed.Load(),
+		Pending: len(w.queue),
+	}
+}
+
+// Close stops accepting records and waits until the queue is flushed or ctx
+// is done. Call it before closing the database.
+func (w *Writer) Close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+
+	select {
+	case <-w.done:
+		stats := w.Stats()
+		slog.Info("Traffic writer flushed", "written", stats.Written, "dropped", stats.Dropped, "failed", stats.Failed)
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("flushing traffic records: %w", ctx.Err())
+	}
+}
+
+// run collects records into batches until the queue is closed
+func (w *Writer) run() {
+	defer close(w.done)
+
+	batch := make([]TrafficRecord, 0, w.opts.BatchSize)
+	ticker := time.NewTicker(w.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case record, ok := <-w.queue:
+			if !ok {
+				w.flush(batch)
+				return
+			}
+			metrics.RecorderQueueDepth.Dec()
+			batch = append(batch, record)
+			if len(batch) >= w.opts.BatchSize {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			if len(batch) > 0 {
+				w.flush(batch)
+				batch = batch[:0]
+			}
+		}
+	}
+}
+
+// flush inserts a batch in one transaction
+func (w *Writer) flush(batch []TrafficRecord) {
+	if len(batch) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), batchTimeout)
+	defer cancel()
+
+	start := time.Now()
+	written, err := w.insertBatch(ctx, batch)
+	metrics.RecorderBatchDuration.Observe(time.Since(start).Seconds())
+	w.written.Add(uint64(written))
+	metrics.RecorderRecords.WithLabelValues(metrics.ResultWritten).Add(float64(written))
+	if failed := len(batch) - written; failed > 0 {
+		w.failed.Add(uint64(failed))
+		metrics.RecorderRecords.WithLabelValues(metrics.ResultFailed).Add(float64(failed))
+		slog.Warn("Error saving traffic records", "failed", failed, "batch_size", len(batch), "error", err)
+		return
+	}
+	slog.Debug("Saved traffic records", "count", written)
+}
+
+// insertBatch returns how many records of batch were committed. A record
+// that fails to insert does not fail the rest of the batch.
+func (w *Writer) insertBatch(ctx context.Context, batch []TrafficRecord) (int, error) {
+	tx, err := w.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("beginning transaction: %w", err)
+	}
+	stmt := tx.StmtContext(ctx, w.stmt)
+	defer stmt.Close()
+
+	var errs []error
+	inserted := 0
+	for _, record := range batch {
+		if err := Insert(ctx, stmt, record); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		inserted++
+		if err := insertValidationErrors(ctx, tx, record.ID, record.ValidationErrors); err != nil {
+			slog.Warn("Error saving validation errors", "record_id", record.ID, "error", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("committing transaction: %w", err)
+	}
+	return inserted, errors.Join(errs...)
+}
+
+// Insert stores a single record with stmt, the statement prepared by
+// Initialize or its transaction-bound copy
+func Insert(ctx context.Context, stmt *sql.Stmt, record TrafficRecord) error {
+	if record.Source == "" {
+		record.Source = SourceLive
+	}
+	_, err := stmt.ExecContext(ctx,
+		record.ID,
+		record.Timestamp,
+		record.Protocol,
+		record.Method,
+		record.URL,
+		record.Service,
+		record.RequestHeaders,
+		record.RequestBody,
+		record.ResponseStatus,
+		record.ResponseHeaders,
+		record.ResponseBody,
+		record.Duration,
+		record.ClientIP,
+		record.TestID,
+		record.SessionID,
+		record.ConnectionID,
+		record.MessageType,
+		record.Direction,
+		record.Source,
+		record.Fault,
+		record.Upstream,
+		record.UpstreamTarget,
+		record.RequestBodyBlob,
+		record.ResponseBodyBlob,
+		record.ResponseEncoding,
+		record.TraceID,
+		record.ResponseChunks,
+		record.Tags,
+	)
+	if err != nil {
+		return fmt.Errorf("saving record %s: %w", record.ID, err)
+	}
+	return nil
+}
+
+// insertValidationErrorSQL stores one violation of a record
+const insertValidationErrorSQL = `INSERT INTO validation_errors (
+        record_id, kind, location, parameter, pointer, schema_path, keyword, expected, actual, message
+    ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// insertValidationErrors stores the OpenAPI violations of the record with id
+// recordID, in the transaction of the record
+func insertValidationErrors(ctx context.Context, tx *sql.Tx, recordID string, errs []ValidationError) error {
+	for _, e := range errs {
+		_, err := tx.ExecContext(ctx, insertValidationErrorSQL,
+			recordID, e.Kind, e.In, e.Parameter, e.Pointer, e.SchemaPath, e.Keyword,
+			nullableJSON(e.Expected), nullableJSON(e.Actual), e.Message)
+		if err != nil {
+			return fmt.Errorf("saving validation error of record %s: %w", recordID, err)
+		}
+	}
+	return nil
+}
+
+// nullableJSON stores an absent JSON value as NULL
+func nullableJSON(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	return string(raw)
+}
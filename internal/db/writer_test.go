@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestWriter returns a writer on a fresh database
+func newTestWriter(t *testing.T, opts WriterOptions) *Writer {
+	t.Helper()
+	tempFile, err := os.CreateTemp("", "traffic_writer_test_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temporary file: %v", err)
+	}
+	tempFile.Close()
+	t.Cleanup(func() { os.Remove(tempFile.Name()) })
+
+	database, stmt, err := Initialize(tempFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	t.Cleanup(func() {
+		stmt.Close()
+		database.Close()
+	})
+	return NewWriter(database, stmt, opts)
+}
+
+func writerTestRecord(id string) TrafficRecord {
+	return TrafficRecord{
+		ID:             id,
+		Timestamp:      time.Now().UTC(),
+		Protocol:       "HTTP",
+		Method:         "GET",
+		URL:            "https://example.com/" + id,
+		ResponseStatus: 200,
+	}
+}
+
+func countRecords(t *testing.T, w *Writer) int {
+	t.Helper()
+	var count int
+	if err := w.db.QueryRow(`SELECT COUNT(*) FROM traffic_records`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count records: %v", err)
+	}
+	return count
+}
+
+func TestWriterBatchesAndFlushesOnClose(t *testing.T) {
+	w := newTestWriter(t, WriterOptions{BatchSize: 10, FlushInterval: time.Hour})
+
+	for i := range 25 {
+		if err := w.Write(writerTestRecord(fmt.Sprintf("rec-%d", i))); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+	// Two full batches are written without waiting for the flush interval
+	deadline := time.Now().Add(2 * time.Second)
+	for w.Stats().Written < 20 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Full batches were not written: %+v", w.Stats())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	if got := countRecords(t, w); got != 25 {
+		t.Errorf("Expected 25 stored records after Close, got %d", got)
+	}
+	stats := w.Stats()
+	if stats.Queued != 25 || stats.Written != 25 || stats.Dropped != 0 || stats.Pending != 0 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	if err := w.Write(writerTestRecord("late")); !errors.Is(err, ErrWriterClosed) {
+		t.Errorf("Expected ErrWriterClosed after Close, got %v", err)
+	}
+	if w.Stats().Dropped != 1 {
+		t.Errorf("Expected the late record to be counted as dropped")
+	}
+}
+
+func TestWriterBackpressure(t *testing.T) {
+	for _, onFull := range []string{OnFullDrop, OnFullBlock} {
+		t.Run(onFull, func(t *testing.T) {
+			w := newTestWriter(t, WriterOptions{QueueSize: 2, BatchSize: 1, OnFull: onFull, BlockTimeout: 50 * time.Millisecond})
+
+			// Hold the only database connection so the writer stalls on its first batch
+			tx, err := w.db.Begin()
+			if err != nil {
+				t.Fatalf("Failed to begin transaction: %v", err)
+			}
+			if err := w.Write(writerTestRecord("rec-0")); err != nil {
+				t.Fatalf("Write() error: %v", err)
+			}
+			for w.Stats().Pending > 0 {
+				time.Sleep(time.Millisecond)
+			}
+			for i := 1; i <= 2; i++ {
+				if err := w.Write(writerTestRecord(fmt.Sprintf("rec-%d", i))); err != nil {
+					t.Fatalf("Write() error: %v", err)
+				}
+			}
+
+			start := time.Now()
+			if err := w.Write(writerTestRecord("rec-3")); err == nil {
+				t.Fatal("Expected the record to be dropped when the queue is full")
+			}
+			waited := time.Since(start)
+			if onFull == OnFullBlock && waited < 50*time.Millisecond {
+				t.Errorf("Expected block to wait for room, returned after %s", waited)
+			}
+			if onFull == OnFullDrop && waited > 40*time.Millisecond {
+				t.Errorf("Expected drop to return at once, took %s", waited)
+			}
+
+			tx.Rollback()
+			if err := w.Close(context.Background()); err != nil {
+				t.Fatalf("Close() error: %v", err)
+			}
+			stats := w.Stats()
+			if stats.Queued != 3 || stats.Written != 3 || stats.Dropped != 1 {
+				t.Errorf("Unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestWriterCountsFailedInserts(t *testing.T) {
+	w := newTestWriter(t, WriterOptions{})
+
+	// The duplicate fails without losing the rest of its batch
+	for _, id := range []string{"a", "a", "b"} {
+		if err := w.Write(writerTestRecord(id)); err != nil {
+			t.Fatalf("Write() error: %v", err)
+		}
+	}
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+	stats := w.Stats()
+	if stats.Written != 2 || stats.Failed != 1 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+	if got := countRecords(t, w); got != 2 {
+		t.Errorf("Expected 2 stored records, got %d", got)
+	}
+}
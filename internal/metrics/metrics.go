@@ -0,0 +1,121 @@
+// Package metrics defines the Prometheus metrics of the proxy and recorder,
+// served on the UI server at /metrics.
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "jarvis"
+
+// registry holds the jarvis metrics and the Go runtime and process collectors
+var registry = prometheus.NewRegistry()
+
+var factory = promauto.With(registry)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// Label values of ValidationFailures, ReplayLookups and RecorderRecords
+const (
+	KindRequest  = "request"
+	KindResponse = "response"
+
+	ResultHit  = "hit"
+	ResultMiss = "miss"
+
+	ResultWritten = "written"
+	ResultDropped = "dropped"
+	ResultFailed  = "failed"
+)
+
+var (
+	// HTTPRequests counts proxied HTTP requests by route, method and status
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests handled by the proxy.",
+	}, []string{"route", "method", "status"})
+
+	// HTTPRequestDuration observes the time to serve proxied HTTP requests
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests, including the upstream.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	// UpstreamErrors counts requests that failed to reach an upstream
+	UpstreamErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Requests that failed to get a response from the upstream.",
+	}, []string{"protocol", "upstream"})
+
+	// ValidationFailures counts OpenAPI validation failures by kind, request
+	// or response
+	ValidationFailures = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "validation_failures_total",
+		Help:      "Requests and responses that failed OpenAPI validation.",
+	}, []string{"kind"})
+
+	// UnvalidatedRequests counts requests by route that no OpenAPI spec covers
+	// while validation is enabled
+	UnvalidatedRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "unvalidated_requests_total",
+		Help:      "Requests on routes without an OpenAPI spec while validation is enabled.",
+	}, []string{"route"})
+
+	// PluginErrors counts plugin hook calls that failed or timed out, by
+	// plugin and hook
+	PluginErrors = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "plugin_errors_total",
+		Help:      "Plugin hook calls that failed or timed out.",
+	}, []string{"plugin", "hook"})
+
+	// ReplayLookups counts replay lookups by protocol and result, hit or miss
+	ReplayLookups = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "replay_lookups_total",
+		Help:      "Replay lookups that found or missed a recording.",
+	}, []string{"protocol", "result"})
+
+	// RecorderQueueDepth is the number of records waiting to be stored
+	RecorderQueueDepth = factory.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "recorder_queue_depth",
+		Help:      "Traffic records waiting in the writer queue.",
+	})
+
+	// RecorderRecords counts records by outcome: written, dropped or failed
+	RecorderRecords = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "recorder_records_total",
+		Help:      "Traffic records written, dropped because the queue was full, or failed to insert.",
+	}, []string{"result"})
+
+	// RecorderBatchDuration observes the time to insert a batch of records
+	RecorderBatchDuration = factory.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "recorder_batch_duration_seconds",
+		Help:      "Time to insert a batch of traffic records into the database.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	})
+)
+
+// RegisterRoutes registers the metrics endpoint on the UI server mux
+func RegisterRoutes(mux *http.ServeMux) {
+	mux.Handle("GET /metrics", promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry}))
+}
@@ -0,0 +1,36 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+	HTTPRequests.WithLabelValues("default", "GET", "200").Inc()
+	HTTPRequestDuration.WithLabelValues("default", "GET", "200").Observe(0.02)
+	ValidationFailures.WithLabelValues(KindResponse).Inc()
+	RecorderQueueDepth.Set(3)
+
+	mux := http.NewServeMux()
+	RegisterRoutes(mux)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d", rr.Code)
+	}
+
+	body := rr.Body.String()
+	for _, want := range []string{
+		`jarvis_http_requests_total{method="GET",route="default",status="200"} 1`,
+		`jarvis_validation_failures_total{kind="response"} 1`,
+		`jarvis_recorder_queue_depth 3`,
+		"# TYPE jarvis_http_request_duration_seconds histogram",
+		"go_goroutines",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected %q in metrics output", want)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+// Package plugin runs WebAssembly plugins that inspect and modify proxied
+// exchanges. Plugins are sandboxed: they get no filesystem, network or
+// environment, their memory is capped and every call is time limited.
+//
+// A plugin exports its linear memory, an allocator and any of the hook
+// functions:
+//
+//	jarvis_malloc(size i32) i32
+//	jarvis_on_request_received(ptr, len i32) i64
+//	jarvis_on_before_upstream(ptr, len i32) i64
+//	jarvis_on_response_received(ptr, len i32) i64
+//	jarvis_on_before_persist(ptr, len i32) i64
+//
+// The host writes the JSON encoded Input to memory returned by jarvis_malloc
+// and calls the hook. The hook returns the location of its JSON encoded
+// Result packed as ptr<<32 | len, or 0 to leave the exchange unchanged.
+// Plugins may log through the jarvis.log(level, ptr, len i32) import.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+// Hook is a point in the life of an exchange where plugins are called
+type Hook string
+
+// Hooks in the order they run
+const (
+	RequestReceived  Hook = "request_received"  // Before validation, replay and recording
+	BeforeUpstream   Hook = "before_upstream"   // As the request leaves for the target
+	ResponseReceived Hook = "response_received" // When the target's response arrives
+	BeforePersist    Hook = "before_persist"    // Before the exchange is recorded
+)
+
+// Hooks lists every hook
+var Hooks = []Hook{RequestReceived, BeforeUpstream, ResponseReceived, BeforePersist}
+
+const (
+	memoryLimitPages = 1024 // 64 MiB of linear memory per instance
+	maxIdleInstances = 8    // Instances kept for reuse by each plugin
+	maxLogMessage    = 4096
+)
+
+// Request is the request of an exchange. A nil body was too large to buffer
+// or is streamed, and cannot be inspected.
+type Request struct {
+	Method  string      `json:"method,omitempty"`
+	URL     string      `json:"url,omitempty"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    []byte      `json:"body"` // Base64 in JSON
+}
+
+// Response is the response of an exchange, or the response a plugin returns
+// in place of the target's
+type Response struct {
+	Status  int         `json:"status,omitempty"`
+	Headers http.Header `json:"headers,omitempty"`
+	Body    []byte      `json:"body"` // Base64 in JSON
+}
+
+// Input is passed to a hook
+type Input struct {
+	Hook     Hook              `json:"hook"`
+	Config   map[string]string `json:"config,omitempty"`
+	Request  *Request          `json:"request,omitempty"`
+	Response *Response         `json:"response,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"` // Tags attached to the exchange so far
+}
+
+// Result is returned by a hook. Non-nil headers and bodies replace those of
+// the exchange. A response returned by the request_received or
+// before_upstream hooks is served without calling the target. Tags are added
+// to the recorded exchange.
+type Result struct {
+	Request  *Request          `json:"request,omitempty"`
+	Response *Response         `json:"response,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// Plugin is a loaded WebAssembly plugin. It is safe for concurrent use; each
+// call runs in its own instance of the module.
+type Plugin struct {
+	name      string
+	timeout   time.Duration
+	config    map[string]string
+	runtime   wazero.Runtime
+	compiled  wazero.CompiledModule
+	hooks     map[Hook]bool
+	instances chan api.Module
+}
+
+// Load compiles the module of cfg. Compiling can take a second or more for
+// large modules, so plugins should be loaded once and reused.
+func Load(ctx context.Context, cfg config.PluginConfig) (*Plugin, error) {
+	code, err := os.ReadFile(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("reading plugin %s: %w", cfg.Name, err)
+	}
+	return compile(ctx, cfg, code)
+}
+
+func compile(ctx context.Context, cfg config.PluginConfig, code []byte) (*Plugin, error) {
+	runtime := wazero.NewRuntimeWithConfig(ctx, wazero.NewRuntimeConfig().
+		WithCloseOnContextDone(true).
+		WithMemoryLimitPages(memoryLimitPages))
+	p := &Plugin{
+		name:      cfg.Name,
+		timeout:   cfg.Timeout,
+		config:    cfg.Config,
+		runtime:   runtime,
+		hooks:     make(map[Hook]bool, len(Hooks)),
+		instances: make(chan api.Module, maxIdleInstances),
+	}
+
+	// Modules built for WASI, such as Go's wasip1 port, import it; it is
+	// instantiated without access to the host
+	wasi_snapshot_preview1.MustInstantiate(ctx, runtime)
+	_, err := runtime.NewHostModuleBuilder("jarvis").
+		NewFunctionBuilder().WithFunc(p.log).Export("log").
+		Instantiate(ctx)
+	if err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("instantiating host functions for plugin %s: %w", cfg.Name, err)
+	}
+
+	if p.compiled, err = runtime.CompileModule(ctx, code); err != nil {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("compiling plugin %s: %w", cfg.Name, err)
+	}
+	exports := p.compiled.ExportedFunctions()
+	if _, ok := exports["jarvis_malloc"]; !ok {
+		runtime.Close(ctx)
+		return nil, fmt.Errorf("plugin %s does not export jarvis_malloc", cfg.Name)
+	}
+	for _, hook := range Hooks {
+		if _, ok := exports[exportName(hook)]; ok {
+			p.hooks[hook] = true
+		}
+	}
+	if len(p.hooks) == 0 {
+		slog.Warn("Plugin exports no hooks", "plugin", cfg.Name)
+	}
+	return p, nil
+}
+
+// Name returns the name of the plugin
+func (p *Plugin) Name() string { return p.name }
+
+// Has reports whether the plugin implements hook
+func (p *Plugin) Has(hook Hook) bool { return p.hooks[hook] }
+
+// Call runs a hook of the plugin with in, filling in the plugin's config. It
+// returns a nil result if the plugin does not implement the hook or left the
+// exchange unchanged. Instances that fail or run out of time are discarded.
+func (p *Plugin) Call(ctx context.Context, in Input) (*Result, error) {
+	if !p.hooks[in.Hook] {
+		return nil, nil
+	}
+	in.Config = p.config
+	payload, err := json.Marshal(in)
+	if err != nil {
+		return nil, fmt.Errorf("encoding %s input: %w", in.Hook, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+	m, err := p.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out, err := p.call(ctx, m, exportName(in.Hook), payload)
+	if err != nil {
+		m.Close(context.Background())
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("plugin %s %s hook: %w after %s", p.name, in.Hook, ctx.Err(), p.timeout)
+		}
+		return nil, fmt.Errorf("plugin %s %s hook: %w", p.name, in.Hook, err)
+	}
+	p.release(m)
+	if out == nil {
+		return nil, nil
+	}
+
+	var result Result
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("decoding plugin %s %s result: %w", p.name, in.Hook, err)
+	}
+	return &result, nil
+}
+
+// call copies payload into the instance, runs fn and copies out its result
+func (p *Plugin) call(ctx context.Context, m api.Module, fn string, payload []byte) ([]byte, error) {
+	ret, err := m.ExportedFunction("jarvis_malloc").Call(ctx, uint64(len(payload)))
+	if err != nil {
+		return nil, fmt.Errorf("allocating input: %w", err)
+	}
+	ptr := uint32(ret[0])
+	if !m.Memory().Write(ptr, payload) {
+		return nil, fmt.Errorf("input of %d bytes at %d is out of memory bounds", len(payload), ptr)
+	}
+	ret, err = m.ExportedFunction(fn).Call(ctx, uint64(ptr), uint64(len(payload)))
+	if err != nil {
+		return nil, err
+	}
+	if ret[0] == 0 {
+		return nil, nil
+	}
+	outPtr, outLen := uint32(ret[0]>>32), uint32(ret[0])
+	out, ok := m.Memory().Read(outPtr, outLen)
+	if !ok {
+		return nil, fmt.Errorf("result of %d bytes at %d is out of memory bounds", outLen, outPtr)
+	}
+	// The view aliases the instance's memory, which the next call reuses
+	return append([]byte(nil), out...), nil
+}
+
+// acquire returns an idle instance or starts a new one
+func (p *Plugin) acquire(ctx context.Context) (api.Module, error) {
+	select {
+	case m := <-p.instances:
+		return m, nil
+	default:
+	}
+	// Anonymous so any number of instances can coexist; reactor modules are
+	// initialized by _initialize
+	m, err := p.runtime.InstantiateModule(ctx, p.compiled,
+		wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize"))
+	if err != nil {
+		return nil, fmt.Errorf("instantiating plugin %s: %w", p.name, err)
+	}
+	return m, nil
+}
+
+// release keeps an instance for reuse, or closes it if enough are idle
+func (p *Plugin) release(m api.Module) {
+	select {
+	case p.instances <- m:
+	default:
+		m.Close(context.Background())
+	}
+}
+
+// log implements the jarvis.log import
+func (p *Plugin) log(ctx context.Context, m api.Module, level, ptr, size uint32) {
+	size = min(size, maxLogMessage)
+	msg, ok := m.Memory().Read(ptr, size)
+	if !ok {
+		return
+	}
+	slog.Log(ctx, slogLevel(level), string(msg), "plugin", p.name)
+}
+
+// slogLevel maps plugin log levels 0-3 to debug, info, warn and error
+func slogLevel(level uint32) slog.Level {
+	switch level {
+	case 0:
+		return slog.LevelDebug
+	case 1:
+		return slog.LevelInfo
+	case 2:
+		return slog.LevelWarn
+	}
+	return slog.LevelError
+}
+
+// Close releases the plugin's instances and compiled code
+func (p *Plugin) Close(ctx context.Context) error {
+	var errs []error
+	for {
+		select {
+		case m := <-p.instances:
+			errs = append(errs, m.Close(ctx))
+		default:
+			errs = append(errs, p.runtime.Close(ctx))
+			return errors.Join(errs...)
+		}
+	}
+}
+
+func exportName(hook Hook) string {
+	return "jarvis_on_" + string(hook)
+}
@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+// spinModule exports memory, a jarvis_malloc returning 0 and a
+// jarvis_on_request_received hook that never returns
+var spinModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// Types: (i32) -> i32, (i32, i32) -> i64
+	0x01, 0x0c, 0x02, 0x60, 0x01, 0x7f, 0x01, 0x7f, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7e,
+	0x03, 0x03, 0x02, 0x00, 0x01,
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	0x07, 0x37, 0x03,
+	0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+	0x0d, 'j', 'a', 'r', 'v', 'i', 's', '_', 'm', 'a', 'l', 'l', 'o', 'c', 0x00, 0x00,
+	0x1a, 'j', 'a', 'r', 'v', 'i', 's', '_', 'o', 'n', '_', 'r', 'e', 'q', 'u', 'e', 's', 't', '_', 'r', 'e', 'c', 'e', 'i', 'v', 'e', 'd', 0x00, 0x01,
+	// Code: malloc returns 0, the hook loops forever
+	0x0a, 0x0f, 0x02, 0x04, 0x00, 0x41, 0x00, 0x0b, 0x08, 0x00, 0x03, 0x40, 0x0c, 0x00, 0x0b, 0x00, 0x0b,
+}
+
+// memoryOnlyModule exports memory and nothing else
+var memoryOnlyModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	0x05, 0x03, 0x01, 0x00, 0x01,
+	0x07, 0x0a, 0x01, 0x06, 'm', 'e', 'm', 'o', 'r', 'y', 0x02, 0x00,
+}
+
+func TestCallTimesOut(t *testing.T) {
+	ctx := context.Background()
+	p, err := compile(ctx, config.PluginConfig{Name: "spin", Timeout: 50 * time.Millisecond}, spinModule)
+	if err != nil {
+		t.Fatalf("Failed to compile plugin: %v", err)
+	}
+	defer p.Close(ctx)
+
+	if !p.Has(RequestReceived) || p.Has(BeforePersist) {
+		t.Fatalf("Expected only the request_received hook, got %v", p.hooks)
+	}
+	// Hooks a plugin does not export are skipped
+	if result, err := p.Call(ctx, Input{Hook: BeforePersist}); result != nil || err != nil {
+		t.Errorf("Expected a missing hook to be skipped, got %v, %v", result, err)
+	}
+
+	// The stuck instance is discarded, so every call gets a fresh one
+	for i := 0; i < 2; i++ {
+		start := time.Now()
+		_, err := p.Call(ctx, Input{Hook: RequestReceived, Request: &Request{Method: "GET", URL: "/"}})
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Expected the call to time out, got %v", err)
+		}
+		if elapsed := time.Since(start); elapsed > 2*time.Second {
+			t.Errorf("Expected the call to stop at its timeout, took %s", elapsed)
+		}
+	}
+	if len(p.instances) != 0 {
+		t.Errorf("Expected timed out instances not to be reused, %d idle", len(p.instances))
+	}
+}
+
+func TestCompileRequiresMalloc(t *testing.T) {
+	_, err := compile(context.Background(), config.PluginConfig{Name: "bare", Timeout: time.Second}, memoryOnlyModule)
+	if err == nil || !strings.Contains(err.Error(), "jarvis_malloc") {
+		t.Errorf("Expected an error about the missing allocator, got %v", err)
+	}
+}
@@ -0,0 +1,470 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+	"github.com/dipjyotimetia/jarvis/internal/metrics"
+	"github.com/dipjyotimetia/jarvis/internal/validator"
+	"github.com/dipjyotimetia/jarvis/pkg/engine/ollama"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/google/uuid"
+	"github.com/ollama/ollama/api"
+)
+
+const (
+	maxAIAttempts   = 2         // Generations tried before a miss is served as a 404
+	maxPromptBody   = 2048      // Bytes of each body included in a prompt
+	aiUpstreamLabel = "ollama/" // Prefix of the model stored as the upstream target
+)
+
+// aiSystemPrompt tells the model how to answer a replay miss
+const aiSystemPrompt = `You simulate an HTTP API for testing. Answer the request with a single JSON
+object with the fields "status" (an integer HTTP status code), "headers" (an
+object of header names to string values) and "body" (the response body: a JSON
+value for JSON APIs, a string otherwise). Follow the data, naming and style of
+the recorded responses and the OpenAPI schema when they are given. Reply with
+the JSON object only.`
+
+// chatClient is the part of the Ollama client used to generate responses
+type chatClient interface {
+	Chat(ctx context.Context, req *api.ChatRequest, fn api.ChatResponseFunc) error
+}
+
+// newChatClient connects to the Ollama server of an AI fallback. Tests
+// replace it with a stub.
+var newChatClient = func(cfg config.AIFallbackConfig) (chatClient, error) {
+	if cfg.Host != "" {
+		return ollama.NewWithURL(cfg.Host)
+	}
+	return ollama.New(context.Background())
+}
+
+// chatClients caches the Ollama client of each configuration
+var chatClients sync.Map // *config.Config -> chatClient
+
+// chatClientFor returns the Ollama client for cfg's AI fallback
+func chatClientFor(cfg *config.Config) (chatClient, error) {
+	if c, ok := chatClients.Load(cfg); ok {
+		return c.(chatClient), nil
+	}
+	c, err := newChatClient(cfg.Replay.AIFallback)
+	if err != nil {
+		return nil, fmt.Errorf("creating Ollama client: %w", err)
+	}
+	actual, _ := chatClients.LoadOrStore(cfg, c)
+	return actual.(chatClient), nil
+}
+
+// aiResponse is the exchange a model is asked to produce
+type aiResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    json.RawMessage   `json:"body"`
+}
+
+// generateReplayResponse answers a replay miss with a response generated by
+// the AI fallback's model. The response is validated against the OpenAPI
+// spec when response validation is configured, served with the "ai" source
+// and stored so later requests replay it. It returns false when no valid
+// response could be generated.
+func generateReplayResponse(w http.ResponseWriter, r *http.Request, database *sql.DB, records *db.Writer, apiValidator *validator.APIValidator, cfg *config.Config, reqBody []byte) bool {
+	fallback := cfg.Replay.AIFallback
+	red := redactorFor(cfg)
+	startTime := time.Now()
+
+	client, err := chatClientFor(cfg)
+	if err != nil {
+		slog.Error("AI fallback unavailable", "error", err)
+		return false
+	}
+
+	route, template := routeTemplate(r, apiValidator)
+	examples, err := nearestRecordings(database, r, template, fallback.Examples)
+	if err != nil {
+		// The model can still answer from the request and the spec
+		slog.Warn("Error loading recordings for the AI fallback", "method", r.Method, "template", template, "error", err)
+	}
+	messages := []api.Message{
+		{Role: "system", Content: aiSystemPrompt},
+		{Role: "user", Content: aiPrompt(red.URL(r.URL.String()), r, red.Body(reqBody), template, examples, route)},
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), fallback.Timeout)
+	defer cancel()
+	ctx, span := tracer.Start(ctx, "generate response")
+
+	var status int
+	var header http.Header
+	var body []byte
+	for attempt := 1; ; attempt++ {
+		reply, err := chat(ctx, client, fallback.Model, messages)
+		if err == nil {
+			status, header, body, err = parseAIResponse(reply)
+		}
+		if err == nil && apiValidator != nil {
+			reqCopy := r.Clone(ctx)
+			reqCopy.Body = io.NopCloser(bytes.NewReader(reqBody))
+			if err = apiValidator.ValidateResponse(reqCopy, status, header, body); err != nil {
+				metrics.ValidationFailures.WithLabelValues(metrics.KindResponse).Inc()
+			}
+		}
+		if err == nil {
+			break
+		}
+		if attempt == maxAIAttempts || ctx.Err() != nil || reply == "" {
+			slog.Warn("AI fallback could not generate a response", "method", r.Method, "url", r.URL.String(), "attempts", attempt, "error", err)
+			endSpan(span, err)
+			return false
+		}
+		// Give the model a chance to correct itself
+		messages = append(messages,
+			api.Message{Role: "assistant", Content: reply},
+			api.Message{Role: "user", Content: fmt.Sprintf("That response is invalid: %v. Reply with a corrected JSON object only.", err)},
+		)
+	}
+	endSpan(span, nil)
+
+	for name, values := range header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	w.Header().Set(sourceHeader, db.SourceAI)
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		if _, err := w.Write(body); err != nil {
+			slog.Warn("Error writing AI-generated HTTP response", "method", r.Method, "url", r.URL.String(), "error", err)
+		}
+	}
+	slog.Info("Served AI-generated HTTP response", "status", status, "method", r.Method, "url", r.URL.String(), "model", fallback.Model, "duration", time.Since(startTime))
+
+	// Stored as a synthetic recording, the response is replayed from now on
+	if records != nil {
+		record := db.TrafficRecord{
+			ID:              generateID(),
+			Timestamp:       time.Now().UTC(),
+			Protocol:        "HTTP",
+			Method:          r.Method,
+			URL:             red.URL(r.URL.String()),
+			RequestHeaders:  string(redactedHeaderJSON(red, r.Header)),
+			RequestBody:     red.Body(reqBody),
+			ResponseStatus:  status,
+			ResponseHeaders: string(redactedHeaderJSON(red, header)),
+			ResponseBody:    red.Body(body),
+			Duration:        time.Since(startTime).Milliseconds(),
+			ClientIP:        getClientIP(r),
+			SessionID:       r.Header.Get("X-Session-ID"),
+			TestID:          r.Header.Get("X-Test-ID"),
+			Source:          db.SourceAI,
+			UpstreamTarget:  aiUpstreamLabel + fallback.Model,
+			TraceID:         traceIDOf(r),
+		}
+		if err := records.Write(record); err != nil {
+			slog.Warn("Error saving AI-generated HTTP traffic", "error", err)
+		}
+	}
+	return true
+}
+
+// chat sends the conversation to the model and returns its reply
+func chat(ctx context.Context, client chatClient, model string, messages []api.Message) (string, error) {
+	stream := false
+	req := &api.ChatRequest{
+		Model:    model,
+		Messages: messages,
+		Stream:   &stream,
+		Format:   json.RawMessage(`"json"`),
+	}
+	var reply strings.Builder
+	err := client.Chat(ctx, req, func(resp api.ChatResponse) error {
+		reply.WriteString(resp.Message.Content)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("generating response with %s: %w", model, err)
+	}
+	return reply.String(), nil
+}
+
+// parseAIResponse decodes a model's reply into a response. Bodies are JSON
+// unless the headers give another content type, in which case a string body
+// is served as it is.
+func parseAIResponse(reply string) (int, http.Header, []byte, error) {
+	reply = strings.TrimSpace(reply)
+	reply = strings.TrimPrefix(reply, "```json")
+	reply = strings.Trim(reply, "`\n ")
+
+	var resp aiResponse
+	if err := json.Unmarshal([]byte(reply), &resp); err != nil {
+		return 0, nil, nil, fmt.Errorf("decoding generated response: %w", err)
+	}
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+	if resp.Status < 100 || resp.Status > 599 {
+		return 0, nil, nil, fmt.Errorf("generated status %d is not an HTTP status", resp.Status)
+	}
+
+	header := http.Header{}
+	for name, value := range resp.Headers {
+		switch http.CanonicalHeaderKey(name) {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", "Connection":
+			// Framing is set for the body actually served
+			continue
+		}
+		header.Set(name, value)
+	}
+
+	var body []byte
+	raw := bytes.TrimSpace(resp.Body)
+	if len(raw) > 0 && !bytes.Equal(raw, []byte("null")) {
+		contentType := header.Get("Content-Type")
+		var text string
+		switch {
+		case json.Unmarshal(raw, &text) != nil:
+			body = raw
+		case contentType != "" && !strings.Contains(contentType, "json"):
+			body = []byte(text)
+		case json.Valid([]byte(text)):
+			// JSON sent as an encoded string
+			body = []byte(text)
+		default:
+			body = raw
+		}
+		if contentType == "" {
+			header.Set("Content-Type", "application/json")
+		}
+	}
+	return resp.Status, header, body, nil
+}
+
+// routeTemplate returns the path template of a request: the OpenAPI route
+// when the spec has one, or its path with ID-like segments replaced
+func routeTemplate(r *http.Request, apiValidator *validator.APIValidator) (*routers.Route, string) {
+	if apiValidator != nil {
+		if route, _, err := apiValidator.FindRoute(r); err == nil {
+			return route, route.Path
+		}
+	}
+	segments := strings.Split(r.URL.Path, "/")
+	for i, segment := range segments {
+		if looksLikeID(segment) {
+			segments[i] = "{id}"
+		}
+	}
+	return nil, strings.Join(segments, "/")
+}
+
+// looksLikeID reports whether a path segment is a number, a UUID or a long
+// hex string rather than a fixed part of the route
+func looksLikeID(segment string) bool {
+	if segment == "" {
+		return false
+	}
+	if _, err := strconv.ParseInt(segment, 10, 64); err == nil {
+		return true
+	}
+	if _, err := uuid.Parse(segment); err == nil {
+		return true
+	}
+	if len(segment) < 16 {
+		return false
+	}
+	for _, c := range segment {
+		if !strings.ContainsRune("0123456789abcdefABCDEF", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordedExample is a live recording shown to the model
+type recordedExample struct {
+	URL             string
+	ResponseStatus  int
+	ResponseHeaders string
+	ResponseBody    []byte
+}
+
+// nearestRecordings returns up to n live recordings of the route template
+// with the request's method, those sharing most of its path first
+func nearestRecordings(database *sql.DB, r *http.Request, template string, n int) ([]recordedExample, error) {
+	if database == nil || n <= 0 {
+		return nil, nil
+	}
+	prefix, _, _ := strings.Cut(template, "{")
+	query := `SELECT url, response_status, response_headers, response_body
+              FROM traffic_records
+              WHERE protocol = 'HTTP' AND method = ? AND url LIKE ? AND COALESCE(source, 'live') = 'live'
+              AND COALESCE(response_body_blob, '') = ''
+              ORDER BY timestamp DESC LIMIT ?`
+	rows, err := database.Query(query, r.Method, "%"+prefix+"%", maxReplayCandidates)
+	if err != nil {
+		return nil, fmt.Errorf("querying recordings of %s: %w", template, err)
+	}
+	defer rows.Close()
+
+	templates := map[string]struct{}{template: {}}
+	var examples []recordedExample
+	for rows.Next() {
+		var e recordedExample
+		if err := rows.Scan(&e.URL, &e.ResponseStatus, &e.ResponseHeaders, &e.ResponseBody); err != nil {
+			return nil, fmt.Errorf("scanning recording: %w", err)
+		}
+		u, err := url.Parse(e.URL)
+		if err != nil {
+			continue
+		}
+		if _, ok := validator.NormalizePathForSpec(u.Path, templates); ok {
+			examples = append(examples, e)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Recordings are newest first; the stable sort keeps that order among
+	// recordings equally close to the request
+	sort.SliceStable(examples, func(i, j int) bool {
+		return sharedSegments(examples[i].URL, r.URL.Path) > sharedSegments(examples[j].URL, r.URL.Path)
+	})
+	if len(examples) > n {
+		examples = examples[:n]
+	}
+	return examples, nil
+}
+
+// sharedSegments counts the path segments a recorded URL has in common with
+// path, position by position
+func sharedSegments(recorded, path string) int {
+	u, err := url.Parse(recorded)
+	if err != nil {
+		return 0
+	}
+	a, b := strings.Split(u.Path, "/"), strings.Split(path, "/")
+	count := 0
+	for i := 0; i < len(a) && i < len(b); i++ {
+		if a[i] == b[i] {
+			count++
+		}
+	}
+	return count
+}
+
+// aiPrompt describes the request, the recordings of its route and, when the
+// spec defines the route, its response schemas
+func aiPrompt(recordedURL string, r *http.Request, reqBody []byte, template string, examples []recordedExample, route *routers.Route) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Request:\n%s %s\n", r.Method, recordedURL)
+	for _, name := range []string{"Accept", "Content-Type"} {
+		if value := r.Header.Get(name); value != "" {
+			fmt.Fprintf(&b, "%s: %s\n", name, value)
+		}
+	}
+	if len(reqBody) > 0 {
+		fmt.Fprintf(&b, "\n%s\n", truncateForPrompt(reqBody))
+	}
+
+	if len(examples) > 0 {
+		fmt.Fprintf(&b, "\nRecorded responses for %s %s:\n", r.Method, template)
+		for _, e := range examples {
+			fmt.Fprintf(&b, "\n%s %s -> %d\n", r.Method, e.URL, e.ResponseStatus)
+			var headers http.Header
+			if json.Unmarshal([]byte(e.ResponseHeaders), &headers) == nil {
+				if contentType := headers.Get("Content-Type"); contentType != "" {
+					fmt.Fprintf(&b, "Content-Type: %s\n", contentType)
+				}
+			}
+			if len(e.ResponseBody) > 0 {
+				fmt.Fprintf(&b, "%s\n", truncateForPrompt(e.ResponseBody))
+			}
+		}
+	}
+
+	if route != nil && route.Operation != nil && route.Operation.Responses != nil {
+		responses := route.Operation.Responses.Map()
+		statuses := make([]string, 0, len(responses))
+		for status := range responses {
+			statuses = append(statuses, status)
+		}
+		sort.Strings(statuses)
+		fmt.Fprintf(&b, "\nOpenAPI responses for %s %s:\n", r.Method, template)
+		for _, status := range statuses {
+			response := responses[status]
+			if response == nil || response.Value == nil {
+				continue
+			}
+			contentType, media := negotiateMedia(response.Value.Content, r.Header.Get("Accept"))
+			if media == nil || media.Schema == nil {
+				fmt.Fprintf(&b, "%s: no body\n", status)
+				continue
+			}
+			schema, err := json.Marshal(inlineSchema(media.Schema, 0))
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(&b, "%s %s: %s\n", status, contentType, schema)
+		}
+	}
+	return b.String()
+}
+
+// truncateForPrompt shortens a body to maxPromptBody bytes
+func truncateForPrompt(body []byte) string {
+	if len(body) <= maxPromptBody {
+		return string(body)
+	}
+	return string(body[:maxPromptBody]) + "...(truncated)"
+}
+
+// inlineSchema copies a schema with its references replaced by the schemas
+// they point to, so the model sees the whole structure. Recursive schemas are
+// cut off at maxGenerateDepth.
+func inlineSchema(ref *openapi3.SchemaRef, depth int) *openapi3.SchemaRef {
+	if ref == nil || ref.Value == nil {
+		return ref
+	}
+	if depth >= maxGenerateDepth {
+		return openapi3.NewObjectSchema().NewRef()
+	}
+	schema := *ref.Value
+	schema.Items = inlineSchema(schema.Items, depth+1)
+	if len(schema.Properties) > 0 {
+		properties := make(openapi3.Schemas, len(schema.Properties))
+		for name, property := range schema.Properties {
+			properties[name] = inlineSchema(property, depth+1)
+		}
+		schema.Properties = properties
+	}
+	schema.AllOf = inlineSchemas(schema.AllOf, depth+1)
+	schema.OneOf = inlineSchemas(schema.OneOf, depth+1)
+	schema.AnyOf = inlineSchemas(schema.AnyOf, depth+1)
+	schema.AdditionalProperties.Schema = inlineSchema(schema.AdditionalProperties.Schema, depth+1)
+	return schema.NewRef()
+}
+
+func inlineSchemas(refs openapi3.SchemaRefs, depth int) openapi3.SchemaRefs {
+	if len(refs) == 0 {
+		return refs
+	}
+	inlined := make(openapi3.SchemaRefs, len(refs))
+	for i, ref := range refs {
+		inlined[i] = inlineSchema(ref, depth)
+	}
+	return inlined
+}
@@ -0,0 +1,245 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+	"github.com/ollama/ollama/api"
+)
+
+// stubChat answers chat requests with canned replies, in order
+type stubChat struct {
+	mu       sync.Mutex
+	replies  []string
+	err      error
+	requests []*api.ChatRequest
+}
+
+func (s *stubChat) Chat(ctx context.Context, req *api.ChatRequest, fn api.ChatResponseFunc) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, req)
+	if s.err != nil {
+		return s.err
+	}
+	reply := s.replies[0]
+	if len(s.replies) > 1 {
+		s.replies = s.replies[1:]
+	}
+	return fn(api.ChatResponse{Message: api.Message{Role: "assistant", Content: reply}, Done: true})
+}
+
+func (s *stubChat) calls() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.requests)
+}
+
+// useStubChat makes the AI fallback talk to stub for the rest of the test
+func useStubChat(t *testing.T, stub *stubChat) {
+	t.Helper()
+	previous := newChatClient
+	newChatClient = func(config.AIFallbackConfig) (chatClient, error) { return stub, nil }
+	t.Cleanup(func() { newChatClient = previous })
+}
+
+func TestAIFallbackReplayMiss(t *testing.T) {
+	tempDB, err := os.CreateTemp("", "test_ai_fallback_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	// A recording of the same route guides the model
+	if err := records.Write(db.TrafficRecord{
+		ID:              "recorded",
+		Timestamp:       time.Now().UTC(),
+		Protocol:        "HTTP",
+		Method:          http.MethodGet,
+		URL:             "/users/1",
+		RequestHeaders:  `{}`,
+		ResponseStatus:  http.StatusOK,
+		ResponseHeaders: `{"Content-Type":["application/json"]}`,
+		ResponseBody:    []byte(`{"id":1,"email":"ada@example.com","role":"admin"}`),
+	}); err != nil {
+		t.Fatalf("Failed to write recording: %v", err)
+	}
+	waitForSource(t, database, db.SourceLive, 1)
+
+	specPath := filepath.Join(t.TempDir(), "users.yaml")
+	if err := os.WriteFile(specPath, []byte(mockSpec), 0o644); err != nil {
+		t.Fatalf("Failed to write spec: %v", err)
+	}
+
+	// The first reply breaks the schema and is corrected after feedback
+	stub := &stubChat{replies: []string{
+		`{"status": 200, "body": {"id": 2, "role": "admin"}}`,
+		"```json\n" + `{"status": 200, "headers": {"Content-Type": "application/json", "Content-Length": "1"}, "body": {"id": 2, "email": "grace@example.com", "role": "member"}}` + "\n```",
+	}}
+	useStubChat(t, stub)
+
+	cfg := &config.Config{
+		HTTPTargetURL: "http://127.0.0.1:1",
+		ReplayMode:    true,
+		APIValidation: config.APIValidationConfig{
+			Enabled:           true,
+			SpecPath:          specPath,
+			ValidateResponses: true,
+		},
+		Replay: config.ReplayConfig{
+			MinScore:   1,
+			AIFallback: config.AIFallbackConfig{Enabled: true, Model: "llama3.2", Examples: 3, Timeout: 5 * time.Second},
+		},
+	}
+	target, _ := url.Parse(cfg.HTTPTargetURL)
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, database, records, pool)
+
+	get := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodGet, "/users/2", nil))
+		return rr
+	}
+
+	first := get()
+	if first.Code != http.StatusOK || first.Header().Get(sourceHeader) != db.SourceAI {
+		t.Fatalf("Expected an AI-generated 200, got %d from %q: %s", first.Code, first.Header().Get(sourceHeader), first.Body.String())
+	}
+	const want = `{"id": 2, "email": "grace@example.com", "role": "member"}`
+	if first.Body.String() != want {
+		t.Errorf("Expected body %s, got %s", want, first.Body.String())
+	}
+	if first.Header().Get("Content-Length") != strconv.Itoa(len(want)) {
+		t.Errorf("Expected Content-Length of the served body, got %q", first.Header().Get("Content-Length"))
+	}
+	if stub.calls() != 2 {
+		t.Fatalf("Expected the invalid reply to be retried, got %d calls", stub.calls())
+	}
+
+	// The prompt carries the recording, the schema and the validation error
+	req := stub.requests[0]
+	if req.Model != "llama3.2" {
+		t.Errorf("Expected model llama3.2, got %q", req.Model)
+	}
+	prompt := req.Messages[1].Content
+	for _, part := range []string{"GET /users/2", "Recorded responses for GET /users/{id}", "ada@example.com", `"writeOnly":true`, `"required":["email"]`} {
+		if !strings.Contains(prompt, part) {
+			t.Errorf("Expected the prompt to contain %q, got:\n%s", part, prompt)
+		}
+	}
+	retry := stub.requests[1].Messages
+	if feedback := retry[len(retry)-1].Content; !strings.Contains(feedback, "email") {
+		t.Errorf("Expected the validation error to be sent back, got %q", feedback)
+	}
+
+	// The generated response is stored flagged and replayed from then on
+	waitForSource(t, database, db.SourceAI, 1)
+	var upstream string
+	if err := database.QueryRow(`SELECT upstream_target FROM traffic_records WHERE source = ?`, db.SourceAI).Scan(&upstream); err != nil {
+		t.Fatalf("Failed to read generated record: %v", err)
+	}
+	if upstream != "ollama/llama3.2" {
+		t.Errorf("Expected the model as upstream target, got %q", upstream)
+	}
+	second := get()
+	if second.Body.String() != want || second.Header().Get(sourceHeader) != db.SourceAI {
+		t.Errorf("Expected the cached response flagged as AI-generated, got %q: %s", second.Header().Get(sourceHeader), second.Body.String())
+	}
+	if stub.calls() != 2 {
+		t.Errorf("Expected the cached response to be replayed without the model, got %d calls", stub.calls())
+	}
+}
+
+func TestAIFallbackFailureIsNotFound(t *testing.T) {
+	tests := []struct {
+		name string
+		stub *stubChat
+	}{
+		{"model unavailable", &stubChat{err: errors.New("connection refused")}},
+		{"invalid replies", &stubChat{replies: []string{"not json"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStubChat(t, tt.stub)
+			cfg := &config.Config{
+				Replay: config.ReplayConfig{AIFallback: config.AIFallbackConfig{Enabled: true, Model: "llama3.2", Timeout: time.Second}},
+			}
+			rr := httptest.NewRecorder()
+			handled := generateReplayResponse(rr, httptest.NewRequest(http.MethodGet, "/users/2", nil), nil, nil, nil, cfg, nil)
+			if handled || rr.Body.Len() != 0 {
+				t.Errorf("Expected the miss to be left to the caller, got %v: %s", handled, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestParseAIResponse(t *testing.T) {
+	tests := []struct {
+		name       string
+		reply      string
+		wantStatus int
+		wantBody   string
+		wantType   string
+		wantErr    bool
+	}{
+		{name: "json body", reply: `{"status": 201, "body": {"ok": true}}`, wantStatus: 201, wantBody: `{"ok": true}`, wantType: "application/json"},
+		{name: "default status", reply: `{"body": [1, 2]}`, wantStatus: 200, wantBody: `[1, 2]`, wantType: "application/json"},
+		{name: "text body", reply: `{"headers": {"content-type": "text/plain"}, "body": "pong"}`, wantStatus: 200, wantBody: "pong", wantType: "text/plain"},
+		{name: "json in a string", reply: `{"body": "{\"ok\":true}"}`, wantStatus: 200, wantBody: `{"ok":true}`, wantType: "application/json"},
+		{name: "no body", reply: `{"status": 204, "body": null}`, wantStatus: 204},
+		{name: "invalid status", reply: `{"status": 42}`, wantErr: true},
+		{name: "not json", reply: `Sure! Here is a response`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, header, body, err := parseAIResponse(tt.reply)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAIResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if status != tt.wantStatus || string(body) != tt.wantBody || header.Get("Content-Type") != tt.wantType {
+				t.Errorf("parseAIResponse() = %d %q %q, want %d %q %q", status, header.Get("Content-Type"), body, tt.wantStatus, tt.wantType, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRouteTemplateWithoutSpec(t *testing.T) {
+	tests := map[string]string{
+		"/users/42/orders": "/users/{id}/orders",
+		"/orders/3f2504e0-4f89-11d3-9a0c-0305e82c3301": "/orders/{id}",
+		"/blobs/0123456789abcdef0123":                  "/blobs/{id}",
+		"/users/me":                                    "/users/me",
+	}
+	for path, want := range tests {
+		if _, got := routeTemplate(httptest.NewRequest(http.MethodGet, path, nil), nil); got != want {
+			t.Errorf("routeTemplate(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/auth"
+)
+
+// authProviders caches the outbound auth providers built for each
+// configuration, so tokens are shared by all requests of a route
+var authProviders sync.Map // *config.Config -> map[string]auth.Provider
+
+// authProviderFor returns the auth provider of a route, or nil. Providers
+// whose credentials cannot be read fail the requests of their routes rather
+// than letting them reach the upstream unauthenticated.
+func authProviderFor(cfg *config.Config, route *config.TargetRoute) auth.Provider {
+	if route == nil || route.Auth == "" {
+		return nil
+	}
+	providers, ok := authProviders.Load(cfg)
+	if !ok {
+		built := make(map[string]auth.Provider, len(cfg.AuthProviders))
+		for _, p := range cfg.AuthProviders {
+			provider, err := auth.New(p)
+			if err != nil {
+				slog.Error("Failed to initialize auth provider, its routes will fail", "provider", p.Name, "error", err)
+				provider = failedProvider{err: err}
+			}
+			built[p.Name] = provider
+		}
+		providers, _ = authProviders.LoadOrStore(cfg, built)
+	}
+	provider, ok := providers.(map[string]auth.Provider)[route.Auth]
+	if !ok {
+		// Rejected by config validation; only reachable with a hand-built config
+		return failedProvider{err: fmt.Errorf("unknown auth provider %q", route.Auth)}
+	}
+	return provider
+}
+
+// failedProvider fails every request of a provider that could not be built
+type failedProvider struct{ err error }
+
+func (p failedProvider) Authenticate(*http.Request) error { return p.err }
+
+// authenticateUpstream adds the credentials of the request's route to an
+// outbound request. It runs after routing and rewrites so signatures cover
+// the request as sent; the recorded request never carries the credentials.
+func authenticateUpstream(cfg *config.Config, req *http.Request) error {
+	route := routeOf(routeMatchFor(req, cfg))
+	provider := authProviderFor(cfg, route)
+	if provider == nil {
+		return nil
+	}
+	if err := provider.Authenticate(req); err != nil {
+		return fmt.Errorf("authenticating with %s: %w", route.Auth, err)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+func TestUpstreamAuthentication(t *testing.T) {
+	t.Setenv("TEST_PARTNER_TOKEN", "partner-s3cret")
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Partner-Token") != "partner-s3cret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer targetServer.Close()
+
+	tempDB, err := os.CreateTemp("", "test_auth_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		RecordingMode: true,
+		TargetRoutes: []config.TargetRoute{
+			{PathPrefix: "/partner/", TargetURL: targetServer.URL, Auth: "partner"},
+			{PathPrefix: "/broken/", TargetURL: targetServer.URL, Auth: "broken"},
+		},
+		AuthProviders: []config.AuthProvider{
+			{Name: "partner", APIKey: &config.APIKeyAuthConfig{Key: config.Secret{Env: "TEST_PARTNER_TOKEN"}, Header: "X-Partner-Token"}},
+			{Name: "broken", APIKey: &config.APIKeyAuthConfig{Key: config.Secret{Env: "JARVIS_TEST_UNSET_TOKEN"}}},
+		},
+		// Rewritten requests store the request as sent upstream
+		Rewrites:  []config.RewriteRule{{PathPrefix: "/partner/", Request: config.RequestRewrite{Headers: config.HeaderRewrite{Set: map[string]string{"X-Env": "staging"}}}}},
+		Redaction: config.RedactionConfig{Disabled: true},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := buildHTTPHandler(ctx, cfg, database, records, "HTTP proxy error")
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/partner/orders", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected the upstream to accept the injected token, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/broken/orders", nil))
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("Expected a provider without credentials to fail the request, got %d", rr.Code)
+	}
+	waitForSource(t, database, db.SourceLive, 2)
+
+	var stored, upstream string
+	err = database.QueryRow(`SELECT url || request_headers || response_headers || COALESCE(upstream, ''), COALESCE(upstream, '')
+		FROM traffic_records WHERE url LIKE '%/partner/%'`).Scan(&stored, &upstream)
+	if err != nil {
+		t.Fatalf("Failed to query record: %v", err)
+	}
+	if !strings.Contains(upstream, "X-Env") {
+		t.Fatalf("Expected the upstream request to be recorded, got %q", upstream)
+	}
+	// Even with redaction disabled the injected credential is never stored
+	if strings.Contains(stored, "partner-s3cret") {
+		t.Errorf("Expected the injected token not to be stored, got %s", stored)
+	}
+}
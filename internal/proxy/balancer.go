@@ -0,0 +1,254 @@
+package proxy
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+// Health check and outlier detection defaults
+const (
+	defaultHealthInterval     = 10 * time.Second
+	defaultHealthTimeout      = 2 * time.Second
+	defaultHealthyThreshold   = 2
+	defaultUnhealthyThreshold = 2
+	defaultEjectionTime       = 30 * time.Second
+)
+
+// upstreamTarget is one upstream of a route with its balancing state
+type upstreamTarget struct {
+	raw    string
+	url    *url.URL
+	weight int
+
+	active       atomic.Int64 // In-flight requests, for least connections
+	unhealthy    atomic.Bool  // Failed its active health checks
+	ejectedUntil atomic.Int64 // Unix nanoseconds until which outlier detection ejected it
+	failures     atomic.Int32 // Consecutive 5xx responses or errors from live traffic
+
+	current int // Smooth weighted round-robin state, guarded by upstreamPool.mu
+}
+
+// available reports whether the target may receive traffic at now
+func (t *upstreamTarget) available(now int64) bool {
+	return !t.unhealthy.Load() && t.ejectedUntil.Load() <= now
+}
+
+// upstreamPool balances the requests of one route across its upstreams
+type upstreamPool struct {
+	route   *config.TargetRoute
+	targets []*upstreamTarget
+	next    atomic.Uint64
+	mu      sync.Mutex
+}
+
+// balancer holds the upstream pools of every target route
+type balancer struct {
+	pools      map[*config.TargetRoute]*upstreamPool
+	healthOnce sync.Once
+}
+
+// balancers caches a balancer per configuration so the HTTP and HTTPS
+// proxies share connection counts and health state
+var balancers sync.Map // *config.Config -> *balancer
+
+// balancerFor returns the balancer for cfg, creating it on first use
+func balancerFor(cfg *config.Config) *balancer {
+	if b, ok := balancers.Load(cfg); ok {
+		return b.(*balancer)
+	}
+	b, _ := balancers.LoadOrStore(cfg, newBalancer(cfg))
+	return b.(*balancer)
+}
+
+func newBalancer(cfg *config.Config) *balancer {
+	b := &balancer{pools: make(map[*config.TargetRoute]*upstreamPool)}
+	for i := range cfg.TargetRoutes {
+		route := &cfg.TargetRoutes[i]
+		pool := &upstreamPool{route: route}
+		for _, u := range route.Targets() {
+			parsed, err := url.Parse(u.URL)
+			if err != nil {
+				// Rejected by config validation; only reachable with a hand-built config
+				slog.Warn("Ignoring invalid upstream URL", "url", u.URL, "error", err)
+				continue
+			}
+			pool.targets = append(pool.targets, &upstreamTarget{raw: u.URL, url: parsed, weight: max(u.Weight, 1)})
+		}
+		if len(pool.targets) > 0 {
+			b.pools[route] = pool
+		}
+	}
+	return b
+}
+
+// pool returns the upstream pool of a route, or nil
+func (b *balancer) pool(route *config.TargetRoute) *upstreamPool {
+	return b.pools[route]
+}
+
+// pick chooses the upstream for the next request. When every upstream is
+// unhealthy or ejected, all of them are considered rather than failing.
+func (p *upstreamPool) pick() *upstreamTarget {
+	if len(p.targets) == 1 {
+		return p.targets[0]
+	}
+	now := time.Now().UnixNano()
+	candidates := make([]*upstreamTarget, 0, len(p.targets))
+	for _, t := range p.targets {
+		if t.available(now) {
+			candidates = append(candidates, t)
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = p.targets
+	}
+
+	switch p.route.LoadBalancing {
+	case config.LoadBalanceLeastConnections:
+		// Start from a rotating offset so ties are spread evenly
+		offset := int(p.next.Add(1) - 1)
+		best := candidates[offset%len(candidates)]
+		for i := 1; i < len(candidates); i++ {
+			t := candidates[(offset+i)%len(candidates)]
+			if t.active.Load() < best.active.Load() {
+				best = t
+			}
+		}
+		return best
+	case config.LoadBalanceWeighted:
+		// Smooth weighted round-robin, as in nginx
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		var best *upstreamTarget
+		total := 0
+		for _, t := range candidates {
+			t.current += t.weight
+			total += t.weight
+			if best == nil || t.current > best.current {
+				best = t
+			}
+		}
+		best.current -= total
+		return best
+	}
+	return candidates[int(p.next.Add(1)-1)%len(candidates)]
+}
+
+// report feeds the outcome of a live request to outlier detection
+func (p *upstreamPool) report(t *upstreamTarget, ok bool) {
+	od := p.route.OutlierDetection
+	if t == nil || od.Consecutive5xx <= 0 {
+		return
+	}
+	if ok {
+		t.failures.Store(0)
+		return
+	}
+	if t.failures.Add(1) < int32(od.Consecutive5xx) {
+		return
+	}
+	t.failures.Store(0)
+	ejection := od.EjectionTime
+	if ejection <= 0 {
+		ejection = defaultEjectionTime
+	}
+	t.ejectedUntil.Store(time.Now().Add(ejection).UnixNano())
+	slog.Warn("Ejecting upstream after consecutive failures", "route", p.route.String(), "upstream", t.raw, "failures", od.Consecutive5xx, "ejection_time", ejection)
+}
+
+// startHealthChecks probes the upstreams of every route with a health check
+// path until ctx is done. It only starts the checks once per balancer.
+func (b *balancer) startHealthChecks(ctx context.Context, cfg *config.Config) {
+	b.healthOnce.Do(func() {
+		for route, pool := range b.pools {
+			if route.HealthCheck.Path == "" {
+				continue
+			}
+			client := healthCheckClient(cfg, route)
+			for _, t := range pool.targets {
+				go pool.healthCheckLoop(ctx, client, t)
+			}
+		}
+	})
+}
+
+// healthCheckClient returns an HTTP client using the route's TLS settings
+func healthCheckClient(cfg *config.Config, route *config.TargetRoute) *http.Client {
+	timeout := route.HealthCheck.Timeout
+	if timeout <= 0 {
+		timeout = defaultHealthTimeout
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if tlsConfig, err := cfg.GetRouteTLSConfig(route); err == nil {
+		transport.TLSClientConfig = tlsConfig
+	} else {
+		slog.Warn("Invalid route TLS settings for health checks", "route", route.String(), "error", err)
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// healthCheckLoop checks one upstream every interval and flips its health
+// after enough consecutive results
+func (p *upstreamPool) healthCheckLoop(ctx context.Context, client *http.Client, t *upstreamTarget) {
+	hc := p.route.HealthCheck
+	interval := hc.Interval
+	if interval <= 0 {
+		interval = defaultHealthInterval
+	}
+	healthyThreshold := hc.HealthyThreshold
+	if healthyThreshold <= 0 {
+		healthyThreshold = defaultHealthyThreshold
+	}
+	unhealthyThreshold := hc.UnhealthyThreshold
+	if unhealthyThreshold <= 0 {
+		unhealthyThreshold = defaultUnhealthyThreshold
+	}
+	checkURL := strings.TrimSuffix(t.raw, "/") + hc.Path
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	passes, fails := 0, 0
+	for {
+		if checkHealth(ctx, client, checkURL) {
+			passes, fails = passes+1, 0
+			if t.unhealthy.Load() && passes >= healthyThreshold {
+				t.unhealthy.Store(false)
+				slog.Info("Upstream is healthy again", "route", p.route.String(), "upstream", t.raw)
+			}
+		} else {
+			passes, fails = 0, fails+1
+			if !t.unhealthy.Load() && fails >= unhealthyThreshold {
+				t.unhealthy.Store(true)
+				slog.Warn("Upstream failed its health checks", "route", p.route.String(), "upstream", t.raw, "failures", fails)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkHealth reports whether a GET of url answers with a 2xx or 3xx status
+func checkHealth(ctx context.Context, client *http.Client, url string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode < http.StatusBadRequest
+}
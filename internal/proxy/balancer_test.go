@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+func TestUpstreamPoolPick(t *testing.T) {
+	upstreams := []config.Upstream{{URL: "http://a", Weight: 3}, {URL: "http://b", Weight: 1}, {URL: "http://c"}}
+
+	tests := []struct {
+		name     string
+		strategy string
+		prepare  func(targets []*upstreamTarget)
+		want     map[string]int // Picks per upstream over 10 requests
+	}{
+		{
+			name: "Round robin",
+			want: map[string]int{"http://a": 4, "http://b": 3, "http://c": 3},
+		},
+		{
+			name:     "Weighted",
+			strategy: config.LoadBalanceWeighted,
+			want:     map[string]int{"http://a": 6, "http://b": 2, "http://c": 2},
+		},
+		{
+			name:     "Least connections",
+			strategy: config.LoadBalanceLeastConnections,
+			prepare: func(targets []*upstreamTarget) {
+				targets[0].active.Store(5)
+				targets[1].active.Store(5)
+			},
+			want: map[string]int{"http://c": 10},
+		},
+		{
+			name: "Unhealthy and ejected targets are skipped",
+			prepare: func(targets []*upstreamTarget) {
+				targets[0].unhealthy.Store(true)
+				targets[1].ejectedUntil.Store(time.Now().Add(time.Minute).UnixNano())
+			},
+			want: map[string]int{"http://c": 10},
+		},
+		{
+			name: "All targets down falls back to all of them",
+			prepare: func(targets []*upstreamTarget) {
+				for _, target := range targets {
+					target.unhealthy.Store(true)
+				}
+			},
+			want: map[string]int{"http://a": 4, "http://b": 3, "http://c": 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{TargetRoutes: []config.TargetRoute{{PathPrefix: "/", Upstreams: upstreams, LoadBalancing: tt.strategy}}}
+			pool := newBalancer(cfg).pool(&cfg.TargetRoutes[0])
+			if tt.prepare != nil {
+				tt.prepare(pool.targets)
+			}
+
+			got := make(map[string]int)
+			for range 10 {
+				got[pool.pick().raw]++
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("Picks = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOutlierDetection(t *testing.T) {
+	cfg := &config.Config{TargetRoutes: []config.TargetRoute{{
+		PathPrefix:       "/",
+		Upstreams:        []config.Upstream{{URL: "http://a"}, {URL: "http://b"}},
+		OutlierDetection: config.OutlierDetectionConfig{Consecutive5xx: 3, EjectionTime: time.Minute},
+	}}}
+	pool := newBalancer(cfg).pool(&cfg.TargetRoutes[0])
+	a := pool.targets[0]
+
+	pool.report(a, false)
+	pool.report(a, false)
+	pool.report(a, true) // A success resets the count
+	pool.report(a, false)
+	pool.report(a, false)
+	if !a.available(time.Now().UnixNano()) {
+		t.Fatal("Expected the target to stay available below the threshold")
+	}
+	pool.report(a, false)
+	if a.available(time.Now().UnixNano()) {
+		t.Fatal("Expected the target to be ejected after 3 consecutive failures")
+	}
+	if !a.available(time.Now().Add(2 * time.Minute).UnixNano()) {
+		t.Error("Expected the ejection to expire")
+	}
+}
+
+func TestHealthChecks(t *testing.T) {
+	var healthy atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/health" && !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{TargetRoutes: []config.TargetRoute{{
+		PathPrefix:  "/",
+		Upstreams:   []config.Upstream{{URL: server.URL}, {URL: "http://127.0.0.1:1"}},
+		HealthCheck: config.HealthCheckConfig{Path: "/health", Interval: 10 * time.Millisecond, UnhealthyThreshold: 1, HealthyThreshold: 2},
+	}}}
+	b := newBalancer(cfg)
+	pool := b.pool(&cfg.TargetRoutes[0])
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b.startHealthChecks(ctx, cfg)
+
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", what)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("both targets to fail", func() bool {
+		return pool.targets[0].unhealthy.Load() && pool.targets[1].unhealthy.Load()
+	})
+	healthy.Store(true)
+	waitFor("the server to recover", func() bool { return !pool.targets[0].unhealthy.Load() })
+	if !pool.targets[1].unhealthy.Load() {
+		t.Error("Expected the unreachable target to stay unhealthy")
+	}
+}
+
+func TestLoadBalancedRecording(t *testing.T) {
+	var servers []*httptest.Server
+	var upstreams []config.Upstream
+	for i := range 2 {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if i == 1 {
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			fmt.Fprintf(w, "replica %d", i)
+		}))
+		defer server.Close()
+		servers = append(servers, server)
+		upstreams = append(upstreams, config.Upstream{URL: server.URL})
+	}
+
+	tempDB, err := os.CreateTemp("", "test_balancer_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		RecordingMode: true,
+		TargetRoutes: []config.TargetRoute{{
+			PathPrefix:       "/",
+			Upstreams:        upstreams,
+			OutlierDetection: config.OutlierDetectionConfig{Consecutive5xx: 1, EjectionTime: time.Minute},
+		}},
+	}
+	proxy := &httputil.ReverseProxy{
+		Director:  newDirector(cfg),
+		Transport: newRouteTransport(cfg, http.DefaultTransport.(*http.Transport).Clone()),
+	}
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(proxy, cfg, database, records, pool)
+
+	var bodies []string
+	for range 4 {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodGet, "/items", nil))
+		body, _ := io.ReadAll(rr.Body)
+		bodies = append(bodies, string(body))
+	}
+	// The failing replica is ejected after its first 5xx
+	want := []string{"replica 0", "replica 1", "replica 0", "replica 0"}
+	if fmt.Sprint(bodies) != fmt.Sprint(want) {
+		t.Errorf("Responses = %v, want %v", bodies, want)
+	}
+
+	waitForSource(t, database, db.SourceLive, 4)
+	rows, err := database.Query(`SELECT upstream_target, COUNT(*) FROM traffic_records GROUP BY upstream_target`)
+	if err != nil {
+		t.Fatalf("Failed to query records: %v", err)
+	}
+	defer rows.Close()
+	got := make(map[string]int)
+	for rows.Next() {
+		var target string
+		var count int
+		if err := rows.Scan(&target, &count); err != nil {
+			t.Fatalf("Failed to scan: %v", err)
+		}
+		got[target] = count
+	}
+	if got[servers[0].URL] != 3 || got[servers[1].URL] != 1 {
+		t.Errorf("Unexpected upstream attribution %v", got)
+	}
+}
@@ -2,9 +2,14 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -23,7 +28,7 @@ func BenchmarkResponseRecorder(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		responseBuf := responseBufPool.Get().(*bytes.Buffer)
 		responseBuf.Reset()
-		
+
 		recorder := &responseRecorder{
 			ResponseWriter: httptest.NewRecorder(),
 			statusCode:     200,
@@ -37,7 +42,7 @@ func BenchmarkResponseRecorder(b *testing.B) {
 		data := []byte("test response data")
 		recorder.Write(data)
 		recorder.WriteHeader(200)
-		
+
 		responseBufPool.Put(responseBuf)
 	}
 }
@@ -146,4 +151,71 @@ func BenchmarkStringOperations(b *testing.B) {
 		// Simulate URL manipulation
 		_ = "http://default.example.com" + path
 	}
-}
\ No newline at end of file
+}
+
+// BenchmarkTrafficRecordStorage compares storing each record from its own
+// goroutine with queueing it on the batched writer
+func BenchmarkTrafficRecordStorage(b *testing.B) {
+	newRecord := func(i int) db.TrafficRecord {
+		return db.TrafficRecord{
+			ID:              fmt.Sprintf("bench-%d-%d", time.Now().UnixNano(), i),
+			Timestamp:       time.Now().UTC(),
+			Protocol:        "HTTP",
+			Method:          "GET",
+			URL:             "http://example.com/api/test",
+			RequestHeaders:  `{"Content-Type":["application/json"]}`,
+			ResponseStatus:  200,
+			ResponseHeaders: `{"Content-Type":["application/json"]}`,
+			ResponseBody:    []byte(`{"result": "success"}`),
+			Duration:        150,
+		}
+	}
+	openDB := func(b *testing.B) (*sql.DB, *sql.Stmt) {
+		b.Helper()
+		database, stmt, err := db.Initialize(filepath.Join(b.TempDir(), "bench.db"))
+		if err != nil {
+			b.Fatalf("Failed to initialize database: %v", err)
+		}
+		b.Cleanup(func() {
+			stmt.Close()
+			database.Close()
+		})
+		return database, stmt
+	}
+
+	b.Run("GoroutinePerRecord", func(b *testing.B) {
+		_, stmt := openDB(b)
+		var wg sync.WaitGroup
+		var failed atomic.Int64
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			wg.Add(1)
+			go func(record db.TrafficRecord) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				if db.Insert(ctx, stmt, record) != nil {
+					failed.Add(1)
+				}
+			}(newRecord(i))
+		}
+		wg.Wait()
+		b.ReportMetric(float64(failed.Load()), "lost")
+	})
+
+	for _, onFull := range []string{db.OnFullBlock, db.OnFullDrop} {
+		b.Run("BatchedWriter/"+onFull, func(b *testing.B) {
+			database, stmt := openDB(b)
+			records := db.NewWriter(database, stmt, db.WriterOptions{OnFull: onFull})
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				records.Write(newRecord(i))
+			}
+			if err := records.Close(context.Background()); err != nil {
+				b.Fatalf("Close() error: %v", err)
+			}
+			stats := records.Stats()
+			b.ReportMetric(float64(stats.Dropped+stats.Failed), "lost")
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/blob"
+	"github.com/dipjyotimetia/jarvis/internal/redact"
+)
+
+// blobStores caches the blob store opened for each configuration
+var blobStores sync.Map // *config.Config -> *blob.Store
+
+// blobsFor returns the store for bodies larger than streamThreshold, or nil
+// when large bodies are not captured
+func blobsFor(cfg *config.Config) *blob.Store {
+	if cfg.BlobStore.Disabled || cfg.BlobStore.Dir == "" {
+		return nil
+	}
+	if s, ok := blobStores.Load(cfg); ok {
+		return s.(*blob.Store)
+	}
+	actual, _ := blobStores.LoadOrStore(cfg, blob.NewStore(cfg.BlobStore.Dir, cfg.BlobStore.MaxBodySize))
+	return actual.(*blob.Store)
+}
+
+// commitBody moves a captured body into the store with the redaction rules
+// applied. It returns the reference, or "" when the body was not kept.
+func commitBody(w *blob.Writer, red *redact.Redactor) string {
+	var transform func([]byte) []byte
+	if red.MasksBodies() {
+		transform = red.Body
+	}
+	ref, err := w.Commit(transform)
+	switch {
+	case errors.Is(err, blob.ErrTooLarge):
+		slog.Info("Body exceeds blob_store.max_body_size, storing its size only", "size", w.Size())
+		return ""
+	case err != nil:
+		slog.Warn("Error storing body in the blob store", "size", w.Size(), "error", err)
+		return ""
+	}
+	return ref
+}
+
+// teeBody copies a request body into a blob as the upstream reads it, so large
+// uploads are captured without being buffered in memory
+type teeBody struct {
+	io.ReadCloser
+	mu   sync.Mutex // The transport may still be reading when the handler finishes
+	blob *blob.Writer
+	eof  bool
+	done bool
+}
+
+func (t *teeBody) Read(p []byte) (int, error) {
+	n, err := t.ReadCloser.Read(p)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.done {
+		t.blob.Write(p[:n])
+		if err == io.EOF {
+			t.eof = true
+		}
+	}
+	return n, err
+}
+
+// finish stops the copy and stores the body if it was read completely,
+// returning its reference or ""
+func (t *teeBody) finish(red *redact.Redactor) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.done = true
+	if !t.eof {
+		t.blob.Abort()
+		return ""
+	}
+	return commitBody(t.blob, red)
+}
@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+)
+
+// Content codings decoded for inspection and re-encoded on replay
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+	encodingBrotli  = "br"
+)
+
+// maxDecodedSize bounds a decoded body so a small compressed response cannot
+// expand without limit in memory
+const maxDecodedSize = 32 * streamThreshold
+
+// decodeBody removes the content coding from body. Stacked or unknown codings
+// are returned as an error and the body is kept as it is.
+func decodeBody(encoding string, body []byte) ([]byte, error) {
+	var r io.Reader
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case encodingGzip, "x-gzip":
+		gz, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return nil, fmt.Errorf("decoding gzip body: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	case encodingDeflate:
+		// HTTP deflate is zlib-wrapped, but some servers send raw deflate
+		zr, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			zr = flate.NewReader(bytes.NewReader(body))
+		}
+		defer zr.Close()
+		r = zr
+	case encodingBrotli:
+		r = brotli.NewReader(bytes.NewReader(body))
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+
+	decoded, err := io.ReadAll(io.LimitReader(r, maxDecodedSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("decoding %s body: %w", encoding, err)
+	}
+	if len(decoded) > maxDecodedSize {
+		return nil, fmt.Errorf("decoded %s body exceeds %d bytes", encoding, maxDecodedSize)
+	}
+	return decoded, nil
+}
+
+// encodeBody applies a content coding decoded by decodeBody
+func encodeBody(encoding string, body []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case encodingGzip, "x-gzip":
+		w = gzip.NewWriter(&buf)
+	case encodingDeflate:
+		w = zlib.NewWriter(&buf)
+	case encodingBrotli:
+		w = brotli.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, fmt.Errorf("encoding %s body: %w", encoding, err)
+	}
+	if err := w.Close(); err != nil {
+		return nil, fmt.Errorf("encoding %s body: %w", encoding, err)
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeResponse returns the body of a response without its Content-Encoding
+// and the coding that was removed, or the body unchanged and "" when it is
+// not encoded or cannot be decoded
+func decodeResponse(header http.Header, body []byte) ([]byte, string) {
+	encoding := header.Get("Content-Encoding")
+	if encoding == "" || strings.EqualFold(encoding, "identity") || len(body) == 0 {
+		return body, ""
+	}
+	decoded, err := decodeBody(encoding, body)
+	if err != nil {
+		slog.Warn("Keeping response body encoded", "content_encoding", encoding, "error", err)
+		return body, ""
+	}
+	return decoded, encoding
+}
+
+// acceptsEncoding reports whether the request's Accept-Encoding allows
+// encoding with a non-zero quality
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(name, encoding) && name != "*" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		return q > 0
+	}
+	return false
+}
+
+// encodeReplayBody prepares a body recorded decoded from encoding for the
+// client: re-encoded when the request accepts it, otherwise served with the
+// identity encoding. Headers must not have been written yet.
+func encodeReplayBody(w http.ResponseWriter, r *http.Request, encoding string, body []byte) []byte {
+	if acceptsEncoding(r, encoding) {
+		encoded, err := encodeBody(encoding, body)
+		if err == nil {
+			w.Header().Set("Content-Length", strconv.Itoa(len(encoded)))
+			return encoded
+		}
+		slog.Warn("Serving replayed response with identity encoding", "content_encoding", encoding, "error", err)
+	}
+	w.Header().Del("Content-Encoding")
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	return body
+}
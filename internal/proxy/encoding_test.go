@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+func TestDecodeBody(t *testing.T) {
+	body := []byte(`{"users":["user1","user2"]}`)
+	for _, encoding := range []string{encodingGzip, encodingDeflate, encodingBrotli} {
+		t.Run(encoding, func(t *testing.T) {
+			encoded, err := encodeBody(encoding, body)
+			if err != nil {
+				t.Fatalf("encodeBody() error: %v", err)
+			}
+			if bytes.Equal(encoded, body) {
+				t.Fatal("Expected the body to be encoded")
+			}
+			decoded, err := decodeBody(encoding, encoded)
+			if err != nil || !bytes.Equal(decoded, body) {
+				t.Errorf("decodeBody() = %q, %v", decoded, err)
+			}
+		})
+	}
+
+	// Some servers send deflate without the zlib wrapper
+	var raw bytes.Buffer
+	fw, _ := flate.NewWriter(&raw, flate.DefaultCompression)
+	fw.Write(body)
+	fw.Close()
+	if decoded, err := decodeBody("deflate", raw.Bytes()); err != nil || !bytes.Equal(decoded, body) {
+		t.Errorf("decodeBody(raw deflate) = %q, %v", decoded, err)
+	}
+
+	for _, encoding := range []string{"gzip, br", "compress", "zstd"} {
+		if _, err := decodeBody(encoding, body); err == nil {
+			t.Errorf("Expected %q to be rejected", encoding)
+		}
+	}
+	if _, err := decodeBody(encodingGzip, body); err == nil {
+		t.Error("Expected a corrupt gzip body to be rejected")
+	}
+
+	// A small body must not expand without limit
+	bomb, _ := encodeBody(encodingGzip, make([]byte, maxDecodedSize+1))
+	if _, err := decodeBody(encodingGzip, bomb); err == nil {
+		t.Error("Expected an oversized decoded body to be rejected")
+	}
+}
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		accept string
+		want   bool
+	}{
+		{"gzip, deflate, br", true},
+		{"br;q=1.0, gzip;q=0.8", true},
+		{"deflate", false},
+		{"gzip;q=0", false},
+		{"*", true},
+		{"", false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", tt.accept)
+		if got := acceptsEncoding(r, "gzip"); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, gzip) = %v, want %v", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestRecordingDecodesCompressedResponses(t *testing.T) {
+	body := []byte(`{"email":"ada@example.com","plan":"pro"}`)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write(body)
+	zw.Close()
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(gz.Bytes())
+	}))
+	defer targetServer.Close()
+
+	tempDB, err := os.CreateTemp("", "test_encoding_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		RecordingMode: true,
+		Redaction:     config.RedactionConfig{Rules: []config.RedactionRule{{Path: "$.email"}}},
+		Replay:        config.ReplayConfig{MinScore: 1},
+	}
+	target, _ := url.Parse(targetServer.URL)
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, database, records, pool)
+
+	request := func(acceptEncoding string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/account", nil)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr
+	}
+
+	// The client receives the original compressed bytes
+	rr := request("gzip")
+	if !bytes.Equal(rr.Body.Bytes(), gz.Bytes()) || rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected the compressed response to pass through unchanged, got %q", rr.Body.String())
+	}
+	waitForSource(t, database, db.SourceLive, 1)
+
+	// The record holds the decoded, redacted body and the original encoding
+	var stored []byte
+	var encoding string
+	if err := database.QueryRow(`SELECT response_body, response_encoding FROM traffic_records`).Scan(&stored, &encoding); err != nil {
+		t.Fatalf("Failed to read record: %v", err)
+	}
+	if encoding != "gzip" || !bytes.Contains(stored, []byte(`"plan":"pro"`)) || bytes.Contains(stored, []byte("ada@example.com")) {
+		t.Errorf("Expected the decoded and redacted body with its encoding, got %q, %q", stored, encoding)
+	}
+
+	// Replay encodes the body again for clients that accept it
+	cfg.RecordingMode, cfg.ReplayMode = false, true
+	rr = request("gzip, br")
+	if rr.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected a gzip replay, got headers %v", rr.Header())
+	}
+	if decoded, err := decodeBody("gzip", rr.Body.Bytes()); err != nil || !bytes.Equal(decoded, stored) {
+		t.Errorf("Expected the replayed body to decode to the recording, got %q, %v", decoded, err)
+	}
+
+	// and serves the identity encoding to clients that do not
+	rr = request("identity")
+	if rr.Header().Get("Content-Encoding") != "" || !bytes.Equal(rr.Body.Bytes(), stored) {
+		t.Errorf("Expected an identity replay, got %q with encoding %q", rr.Body.String(), rr.Header().Get("Content-Encoding"))
+	}
+	if rr.Header().Get("Content-Length") != "" && rr.Header().Get("Content-Length") != strconv.Itoa(len(stored)) {
+		t.Errorf("Content-Length %s does not match the body", rr.Header().Get("Content-Length"))
+	}
+}
@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+// faultHeader names the faults injected into a response
+const faultHeader = "X-Jarvis-Fault"
+
+// bandwidthTick is how often a bandwidth limited response releases bytes
+const bandwidthTick = 100 * time.Millisecond
+
+// injectedFault holds the faults rolled for a single request
+type injectedFault struct {
+	Delay         time.Duration
+	Status        int
+	Reset         bool
+	Truncate      bool
+	TruncateAfter int
+	Bandwidth     int
+}
+
+// String describes the fault for the response header and the stored record,
+// e.g. "latency=120ms, status=503"
+func (f *injectedFault) String() string {
+	var parts []string
+	if f.Delay > 0 {
+		parts = append(parts, "latency="+f.Delay.Round(time.Millisecond).String())
+	}
+	if f.Status != 0 {
+		parts = append(parts, fmt.Sprintf("status=%d", f.Status))
+	}
+	if f.Reset {
+		parts = append(parts, "reset")
+	}
+	if f.Truncate {
+		parts = append(parts, fmt.Sprintf("truncated=%d", f.TruncateAfter))
+	}
+	if f.Bandwidth > 0 {
+		parts = append(parts, fmt.Sprintf("bandwidth=%dB/s", f.Bandwidth))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// selectFaultRule returns the first rule whose path prefix, methods and
+// headers all match the request
+func selectFaultRule(rules []config.FaultRule, r *http.Request) *config.FaultRule {
+	for i := range rules {
+		rule := &rules[i]
+		prefix := strings.TrimSuffix(rule.PathPrefix, "/*")
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			continue
+		}
+		if len(rule.Methods) > 0 && !containsFold(rule.Methods, r.Method) {
+			continue
+		}
+		if !headersMatch(rule.Headers, r.Header) {
+			continue
+		}
+		return rule
+	}
+	return nil
+}
+
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// headersMatch reports whether every wanted header is present with the wanted
+// value; "*" only requires the header to be present
+func headersMatch(want map[string]string, got http.Header) bool {
+	for name, value := range want {
+		values := got.Values(name)
+		if len(values) == 0 {
+			return false
+		}
+		if value != "*" && !containsFold(values, value) {
+			return false
+		}
+	}
+	return true
+}
+
+// rollFault decides which of the rule's faults apply to this request. It
+// returns nil when none do. Resets, errors and truncation exclude each other,
+// so a single roll picks at most one with each configured share of requests.
+func rollFault(rule *config.FaultRule) *injectedFault {
+	if rule == nil {
+		return nil
+	}
+	f := &injectedFault{
+		Delay:     sampleLatency(rule.Latency),
+		Bandwidth: rule.Bandwidth,
+	}
+	switch n := rand.Float64() * 100; {
+	case n < rule.ResetPercent:
+		f.Reset = true
+	case n < rule.ResetPercent+rule.ErrorPercent:
+		f.Status = rule.ErrorStatus
+	case n < rule.ResetPercent+rule.ErrorPercent+rule.TruncatePercent:
+		f.Truncate = true
+		f.TruncateAfter = rule.TruncateAfter
+	}
+	if f.String() == "" {
+		return nil
+	}
+	return f
+}
+
+// sampleLatency draws a delay from the configured distribution
+func sampleLatency(l config.LatencyConfig) time.Duration {
+	var d time.Duration
+	switch l.Distribution {
+	case "uniform":
+		d = l.Min
+		if spread := l.Max - l.Min; spread > 0 {
+			d += rand.N(spread + 1)
+		}
+	case "normal":
+		d = l.Delay + time.Duration(rand.NormFloat64()*float64(l.StdDev))
+	default:
+		d = l.Delay
+	}
+	return max(d, 0)
+}
+
+// sleepContext waits for d, returning false if ctx is done first
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// resetConnection drops the client connection without a response. The
+// connection is closed with SO_LINGER 0 so the client sees a TCP reset.
+func resetConnection(w http.ResponseWriter) {
+	conn, _, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// Not hijackable (e.g. HTTP/2); aborting the handler resets the stream
+		panic(http.ErrAbortHandler)
+	}
+	if tcp, ok := conn.(*net.TCPConn); ok {
+		tcp.SetLinger(0)
+	}
+	conn.Close()
+}
+
+// faultWriter truncates and rate limits a response
+type faultWriter struct {
+	http.ResponseWriter
+	controller *http.ResponseController
+	fault      *injectedFault
+	written    int
+	truncated  bool
+}
+
+func newFaultWriter(w http.ResponseWriter, fault *injectedFault) *faultWriter {
+	fw := &faultWriter{ResponseWriter: w, controller: http.NewResponseController(w), fault: fault}
+	if fault.Bandwidth > 0 {
+		// A slow response may legitimately outlive the server's write timeout
+		fw.controller.SetWriteDeadline(time.Time{})
+	}
+	return fw
+}
+
+// Write discards bytes past the truncation point and paces the rest
+func (fw *faultWriter) Write(b []byte) (int, error) {
+	n := len(b)
+	if fw.fault.Truncate {
+		remaining := fw.fault.TruncateAfter - fw.written
+		if remaining <= 0 {
+			fw.truncated = true
+			return n, nil
+		}
+		if len(b) > remaining {
+			b = b[:remaining]
+			fw.truncated = true
+		}
+	}
+	if err := fw.write(b); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+func (fw *faultWriter) write(b []byte) error {
+	if fw.fault.Bandwidth <= 0 {
+		_, err := fw.ResponseWriter.Write(b)
+		fw.written += len(b)
+		return err
+	}
+	chunk := max(fw.fault.Bandwidth*int(bandwidthTick)/int(time.Second), 1)
+	for len(b) > 0 {
+		size := min(chunk, len(b))
+		if _, err := fw.ResponseWriter.Write(b[:size]); err != nil {
+			return err
+		}
+		fw.written += size
+		b = b[size:]
+		fw.controller.Flush()
+		if len(b) > 0 {
+			time.Sleep(bandwidthTick)
+		}
+	}
+	return nil
+}
+
+// Flush passes through to the underlying writer
+func (fw *faultWriter) Flush() {
+	fw.controller.Flush()
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (fw *faultWriter) Unwrap() http.ResponseWriter {
+	return fw.ResponseWriter
+}
+
+// finish aborts the connection of a truncated response so the client sees
+// an incomplete body rather than a shorter, well-formed one
+func (fw *faultWriter) finish(r *http.Request) {
+	if fw.truncated {
+		slog.Info("Truncated response", "method", r.Method, "url", r.URL.String(), "bytes", fw.written)
+		fw.controller.Flush()
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// serveFault answers the request with an injected status or connection reset
+// instead of the target. It reports whether it did.
+func serveFault(w http.ResponseWriter, fault *injectedFault) bool {
+	switch {
+	case fault == nil:
+		return false
+	case fault.Reset:
+		resetConnection(w)
+		return true
+	case fault.Status != 0:
+		http.Error(w, fmt.Sprintf("Injected fault: %s", http.StatusText(fault.Status)), fault.Status)
+		return true
+	}
+	return false
+}
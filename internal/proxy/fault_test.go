@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+func TestSelectFaultRule(t *testing.T) {
+	rules := []config.FaultRule{
+		{PathPrefix: "/orders", Methods: []string{"POST"}, Headers: map[string]string{"X-Chaos": "*"}},
+		{PathPrefix: "/orders/*", Headers: map[string]string{"X-Tenant": "blue"}},
+		{PathPrefix: "/users"},
+	}
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		want    int // index into rules, -1 for none
+	}{
+		{"Path only", httptest.NewRequest(http.MethodGet, "/users/1", nil), 2},
+		{"No matching path", httptest.NewRequest(http.MethodGet, "/health", nil), -1},
+		{"Method and header presence", requestWithHeader(http.MethodPost, "/orders", "X-Chaos", "1"), 0},
+		{"Header missing falls through", httptest.NewRequest(http.MethodPost, "/orders", nil), -1},
+		{"Header value", requestWithHeader(http.MethodGet, "/orders/7", "X-Tenant", "blue"), 1},
+		{"Wrong header value", requestWithHeader(http.MethodGet, "/orders/7", "X-Tenant", "red"), -1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := selectFaultRule(rules, tt.request)
+			switch {
+			case tt.want < 0 && got != nil:
+				t.Errorf("Expected no rule, got %s", got.PathPrefix)
+			case tt.want >= 0 && got != &rules[tt.want]:
+				t.Errorf("Expected rule %d, got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSampleLatency(t *testing.T) {
+	uniform := config.LatencyConfig{Distribution: "uniform", Min: 10 * time.Millisecond, Max: 20 * time.Millisecond}
+	for range 100 {
+		if d := sampleLatency(uniform); d < uniform.Min || d > uniform.Max {
+			t.Fatalf("Uniform latency %s outside [%s, %s]", d, uniform.Min, uniform.Max)
+		}
+	}
+	if d := sampleLatency(config.LatencyConfig{Delay: 5 * time.Millisecond}); d != 5*time.Millisecond {
+		t.Errorf("Expected fixed latency of 5ms, got %s", d)
+	}
+	normal := config.LatencyConfig{Distribution: "normal", Delay: time.Millisecond, StdDev: time.Second}
+	for range 100 {
+		if d := sampleLatency(normal); d < 0 {
+			t.Fatalf("Normal latency must not be negative, got %s", d)
+		}
+	}
+}
+
+func TestRollFaultShares(t *testing.T) {
+	rule := &config.FaultRule{ResetPercent: 10, ErrorPercent: 10, ErrorStatus: http.StatusServiceUnavailable, TruncatePercent: 10}
+	const rolls = 10000
+	var resets, statuses, truncations int
+	for range rolls {
+		f := rollFault(rule)
+		if f == nil {
+			continue
+		}
+		switch {
+		case f.Reset:
+			resets++
+		case f.Status != 0:
+			statuses++
+		case f.Truncate:
+			truncations++
+		}
+	}
+	// Every fault gets its configured share, not what the others leave over
+	for name, n := range map[string]int{"resets": resets, "errors": statuses, "truncations": truncations} {
+		if share := float64(n) * 100 / rolls; share < 8 || share > 12 {
+			t.Errorf("Expected about 10%% %s, got %.1f%%", name, share)
+		}
+	}
+}
+
+func TestFaultInjection(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       config.FaultRule
+		check      func(t *testing.T, resp *http.Response, err error)
+		wantFault  string
+		wantSource string
+		wantStatus int
+	}{
+		{
+			name: "Injected status",
+			rule: config.FaultRule{PathPrefix: "/", ErrorPercent: 100, ErrorStatus: http.StatusServiceUnavailable},
+			check: func(t *testing.T, resp *http.Response, err error) {
+				if err != nil {
+					t.Fatalf("Request failed: %v", err)
+				}
+				if resp.StatusCode != http.StatusServiceUnavailable {
+					t.Errorf("Expected injected 503, got %d", resp.StatusCode)
+				}
+				if got := resp.Header.Get(faultHeader); got != "status=503" {
+					t.Errorf("Expected %s header status=503, got %q", faultHeader, got)
+				}
+			},
+			wantFault:  "status=503",
+			wantSource: db.SourceFault,
+			wantStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name: "Added latency",
+			rule: config.FaultRule{PathPrefix: "/", Latency: config.LatencyConfig{Delay: 50 * time.Millisecond}},
+			check: func(t *testing.T, resp *http.Response, err error) {
+				if err != nil {
+					t.Fatalf("Request failed: %v", err)
+				}
+				if body, _ := io.ReadAll(resp.Body); len(body) != 100 {
+					t.Errorf("Expected the full body, got %d bytes", len(body))
+				}
+			},
+			wantFault:  "latency=50ms",
+			wantSource: db.SourceLive,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "Truncated body",
+			rule: config.FaultRule{PathPrefix: "/", TruncatePercent: 100, TruncateAfter: 10},
+			check: func(t *testing.T, resp *http.Response, err error) {
+				if err != nil {
+					t.Fatalf("Request failed: %v", err)
+				}
+				body, err := io.ReadAll(resp.Body)
+				if err == nil {
+					t.Errorf("Expected an incomplete body error, got %d bytes", len(body))
+				}
+				if len(body) != 10 {
+					t.Errorf("Expected 10 bytes before the cut, got %d", len(body))
+				}
+			},
+			wantFault:  "truncated=10",
+			wantSource: db.SourceLive,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name: "Connection reset",
+			rule: config.FaultRule{PathPrefix: "/", ResetPercent: 100},
+			check: func(t *testing.T, resp *http.Response, err error) {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("Expected a connection error, got status %d", resp.StatusCode)
+				}
+			},
+			wantFault:  "reset",
+			wantSource: db.SourceFault,
+			wantStatus: 0,
+		},
+		{
+			name: "Bandwidth limit",
+			rule: config.FaultRule{PathPrefix: "/", Bandwidth: 500},
+			check: func(t *testing.T, resp *http.Response, err error) {
+				if err != nil {
+					t.Fatalf("Request failed: %v", err)
+				}
+				start := time.Now()
+				if body, _ := io.ReadAll(resp.Body); len(body) != 100 {
+					t.Errorf("Expected the full body, got %d bytes", len(body))
+				}
+				// 100 bytes at 50 bytes per tick take at least one tick
+				if elapsed := time.Since(start); elapsed < bandwidthTick {
+					t.Errorf("Expected the body to be paced, took %s", elapsed)
+				}
+			},
+			wantFault:  "bandwidth=500B/s",
+			wantSource: db.SourceLive,
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDB, err := os.CreateTemp("", "test_fault_*.db")
+			if err != nil {
+				t.Fatalf("Failed to create temp database: %v", err)
+			}
+			tempDB.Close()
+			defer os.Remove(tempDB.Name())
+
+			database, stmt, err := db.Initialize(tempDB.Name())
+			if err != nil {
+				t.Fatalf("Failed to initialize database: %v", err)
+			}
+			defer database.Close()
+			defer stmt.Close()
+			records := newTestWriter(database, stmt)
+			defer records.Close(context.Background())
+
+			target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte(strings.Repeat("x", 100)))
+			}))
+			defer target.Close()
+
+			cfg := &config.Config{HTTPTargetURL: target.URL, RecordingMode: true, Faults: []config.FaultRule{tt.rule}}
+			targetURL, _ := url.Parse(target.URL)
+			pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+			handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(targetURL), cfg, database, records, pool)
+			proxyServer := httptest.NewServer(http.HandlerFunc(handler))
+			defer proxyServer.Close()
+
+			resp, err := http.Get(proxyServer.URL + "/items")
+			tt.check(t, resp, err)
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			waitForSource(t, database, tt.wantSource, 1)
+			var fault string
+			var status int
+			err = database.QueryRow(`SELECT fault, response_status FROM traffic_records`).Scan(&fault, &status)
+			if err != nil {
+				t.Fatalf("Failed to read record: %v", err)
+			}
+			if fault != tt.wantFault {
+				t.Errorf("Expected recorded fault %q, got %q", tt.wantFault, fault)
+			}
+			if status != tt.wantStatus {
+				t.Errorf("Expected recorded status %d, got %d", tt.wantStatus, status)
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/tls"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/internal/certs"
+)
+
+// tlsRecordTypeHandshake is the first byte of a TLS ClientHello record
+const tlsRecordTypeHandshake = 0x16
+
+// handleConnect terminates a CONNECT tunnel and serves the tunnelled requests
+// through handle. TLS tunnels are intercepted with a leaf certificate minted
+// for the requested host so the decrypted traffic can be recorded and validated.
+func handleConnect(w http.ResponseWriter, r *http.Request, ca *certs.CA, handle http.HandlerFunc) {
+	authority := r.Host
+	hostname, port, err := net.SplitHostPort(authority)
+	if err != nil {
+		hostname, port = authority, "443"
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "CONNECT not supported", http.StatusInternalServerError)
+		return
+	}
+	conn, buf, err := hijacker.Hijack()
+	if err != nil {
+		slog.Error("Error hijacking CONNECT connection", "host", authority, "error", err)
+		return
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n")); err != nil {
+		conn.Close()
+		return
+	}
+
+	// Peek at the first byte to tell TLS tunnels from plain HTTP ones
+	tunnel := &bufferedConn{Conn: conn, reader: buf.Reader}
+	first, err := tunnel.reader.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+
+	scheme := "http"
+	var served net.Conn = tunnel
+	var tlsConn *tls.Conn
+	if first[0] == tlsRecordTypeHandshake {
+		scheme = "https"
+		tlsConn = tls.Server(tunnel, &tls.Config{
+			GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+				name := hello.ServerName
+				if name == "" {
+					// Clients do not send SNI for IP addresses
+					name = hostname
+				}
+				return ca.CertificateFor(name)
+			},
+			NextProtos: []string{"http/1.1"},
+		})
+		served = tlsConn
+	}
+
+	// Default ports are dropped so recorded URLs look like the client's
+	host := authority
+	if (scheme == "https" && port == "443") || (scheme == "http" && port == "80") {
+		host = hostname
+	}
+
+	slog.Info("Intercepting CONNECT tunnel", "host", authority, "scheme", scheme, "client_ip", getClientIP(r))
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			req.URL.Scheme = scheme
+			req.URL.Host = host
+			req.RemoteAddr = r.RemoteAddr
+			if tlsConn != nil {
+				state := tlsConn.ConnectionState()
+				req.TLS = &state
+			}
+			handle(w, req)
+		}),
+		ReadHeaderTimeout: 15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+		ErrorLog:          slog.NewLogLogger(slog.Default().Handler(), slog.LevelDebug),
+	}
+	_ = server.Serve(newSingleConnListener(served))
+}
+
+// bufferedConn is a net.Conn whose reads go through a bufio.Reader that may
+// already hold data read past the CONNECT request
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+// singleConnListener hands out one connection and then blocks until it is closed,
+// so http.Server.Serve returns only once the tunnel is finished
+type singleConnListener struct {
+	conn   net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func newSingleConnListener(conn net.Conn) *singleConnListener {
+	l := &singleConnListener{closed: make(chan struct{})}
+	l.conn = &notifyCloseConn{Conn: conn, onClose: l.markClosed}
+	return l
+}
+
+func (l *singleConnListener) markClosed() {
+	l.once.Do(func() { close(l.closed) })
+}
+
+func (l *singleConnListener) Accept() (net.Conn, error) {
+	if conn := l.conn; conn != nil {
+		l.conn = nil
+		return conn, nil
+	}
+	<-l.closed
+	return nil, io.EOF
+}
+
+func (l *singleConnListener) Close() error {
+	l.markClosed()
+	return nil
+}
+
+func (l *singleConnListener) Addr() net.Addr {
+	return &net.TCPAddr{}
+}
+
+// notifyCloseConn calls onClose when the connection is closed
+type notifyCloseConn struct {
+	net.Conn
+	onClose func()
+}
+
+func (c *notifyCloseConn) Close() error {
+	c.onClose()
+	return c.Conn.Close()
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/certs"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+func TestForwardProxyInterceptsTLS(t *testing.T) {
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"path":"` + r.URL.Path + `"}`))
+	}))
+	defer upstream.Close()
+
+	tempDir := t.TempDir()
+	cfg := &config.Config{
+		HTTPPort:      8080,
+		RecordingMode: true,
+		TLS:           config.TLSConfig{AllowInsecure: true}, // upstream uses a test certificate
+		ForwardProxy: config.ForwardProxyConfig{
+			Enabled: true,
+			CACert:  filepath.Join(tempDir, "ca.crt"),
+			CAKey:   filepath.Join(tempDir, "ca.key"),
+		},
+	}
+	if _, err := certs.EnsureCA(cfg.ForwardProxy.CACert, cfg.ForwardProxy.CAKey); err != nil {
+		t.Fatalf("Failed to create CA: %v", err)
+	}
+
+	tempDB, err := os.CreateTemp("", "test_forward_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	reverseProxy := &httputil.ReverseProxy{
+		Director:  newDirector(cfg),
+		Transport: &http.Transport{TLSClientConfig: cfg.GetTLSConfig()},
+	}
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	proxyServer := httptest.NewServer(http.HandlerFunc(createHTTPHandler(reverseProxy, cfg, database, records, pool)))
+	defer proxyServer.Close()
+
+	// The client trusts only the interception CA, not the upstream's certificate
+	ca, err := certs.LoadCA(cfg.ForwardProxy.CACert, cfg.ForwardProxy.CAKey)
+	if err != nil {
+		t.Fatalf("Failed to load CA: %v", err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Certificate())
+	proxyURL, _ := url.Parse(proxyServer.URL)
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(proxyURL),
+			TLSClientConfig: &tls.Config{
+				RootCAs: roots,
+			},
+		},
+		Timeout: 10 * time.Second,
+	}
+
+	resp, err := client.Get(upstream.URL + "/orders?id=7")
+	if err != nil {
+		t.Fatalf("Request through forward proxy failed: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if string(body) != `{"path":"/orders"}` {
+		t.Errorf("Unexpected response body: %s", body)
+	}
+
+	// The decrypted exchange must be recorded with the upstream's URL
+	wantURL := upstream.URL + "/orders?id=7"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var recordedBody []byte
+		err := database.QueryRow(`SELECT response_body FROM traffic_records WHERE protocol = 'HTTP' AND url = ?`, wantURL).Scan(&recordedBody)
+		if err == nil {
+			if !bytes.Equal(recordedBody, body) {
+				t.Errorf("Recorded body %q does not match response %q", recordedBody, body)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("No decrypted record found for %s: %v", wantURL, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestConnectRejectedWithoutForwardProxy(t *testing.T) {
+	cfg := &config.Config{HTTPPort: 8080, HTTPTargetURL: "http://example.com"}
+	reverseProxy := &httputil.ReverseProxy{Director: newDirector(cfg)}
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(reverseProxy, cfg, nil, nil, pool)
+
+	req := httptest.NewRequest(http.MethodConnect, "http://example.com:443", nil)
+	req.Host = "example.com:443"
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected 405 for CONNECT outside forward proxy mode, got %d", rr.Code)
+	}
+}
+
+func TestNewDirectorKeepsAbsoluteURLInForwardMode(t *testing.T) {
+	cfg := &config.Config{
+		HTTPTargetURL: "http://default.example.com",
+		ForwardProxy:  config.ForwardProxyConfig{Enabled: true},
+	}
+	req := httptest.NewRequest(http.MethodGet, "http://api.example.com/users?page=2", nil)
+	newDirector(cfg)(req)
+
+	if got := req.URL.String(); got != "http://api.example.com/users?page=2" {
+		t.Errorf("Forward request should keep its URL, got %s", got)
+	}
+
+	relative := httptest.NewRequest(http.MethodGet, "/users", nil)
+	newDirector(cfg)(relative)
+	if got := relative.URL.String(); got != "http://default.example.com/users" {
+		t.Errorf("Relative request should use the routing table, got %s", got)
+	}
+}
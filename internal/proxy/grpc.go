@@ -0,0 +1,462 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// grpcFrameHeaderSize is the length-prefix size of a gRPC message: 1 byte
+// compression flag followed by a 4 byte big-endian length
+const grpcFrameHeaderSize = 5
+
+// grpcDescriptors indexes RPC methods by their HTTP/2 path ("/pkg.Service/Method")
+type grpcDescriptors struct {
+	methods map[string]protoreflect.MethodDescriptor
+}
+
+// loadGRPCDescriptors compiles proto files with protocompile. If no files are
+// listed, every .proto file found under the import paths is compiled.
+func loadGRPCDescriptors(importPaths, protoFiles []string) (*grpcDescriptors, error) {
+	if len(importPaths) == 0 {
+		importPaths = []string{"."}
+	}
+
+	if len(protoFiles) == 0 {
+		for _, root := range importPaths {
+			err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if !d.IsDir() && strings.HasSuffix(path, ".proto") {
+					rel, err := filepath.Rel(root, path)
+					if err != nil {
+						return err
+					}
+					protoFiles = append(protoFiles, filepath.ToSlash(rel))
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, fmt.Errorf("discovering proto files in %s: %w", root, err)
+			}
+		}
+	}
+	if len(protoFiles) == 0 {
+		return nil, errors.New("no proto files found")
+	}
+
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+			ImportPaths: importPaths,
+		}),
+	}
+	files, err := compiler.Compile(context.Background(), protoFiles...)
+	if err != nil {
+		return nil, fmt.Errorf("compiling proto files: %w", err)
+	}
+
+	descriptors := &grpcDescriptors{methods: make(map[string]protoreflect.MethodDescriptor)}
+	for _, file := range files {
+		services := file.Services()
+		for i := 0; i < services.Len(); i++ {
+			service := services.Get(i)
+			methods := service.Methods()
+			for j := 0; j < methods.Len(); j++ {
+				method := methods.Get(j)
+				descriptors.methods["/"+string(service.FullName())+"/"+string(method.Name())] = method
+			}
+		}
+	}
+	return descriptors, nil
+}
+
+// method looks up the descriptor for an RPC path
+func (d *grpcDescriptors) method(path string) protoreflect.MethodDescriptor {
+	if d == nil {
+		return nil
+	}
+	return d.methods[path]
+}
+
+// isGRPCRequest reports whether the request carries a gRPC payload
+func isGRPCRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+// grpcServiceName turns "/pkg.Service/Method" into "pkg.Service/Method"
+func grpcServiceName(path string) string {
+	return strings.TrimPrefix(path, "/")
+}
+
+// splitGRPCFrames splits a gRPC stream into its length-prefixed messages,
+// decompressing gzip-compressed messages
+func splitGRPCFrames(data []byte, encoding string) ([][]byte, error) {
+	var messages [][]byte
+	for len(data) > 0 {
+		if len(data) < grpcFrameHeaderSize {
+			return messages, fmt.Errorf("truncated gRPC frame header (%d bytes)", len(data))
+		}
+		compressed := data[0] == 1
+		length := binary.BigEndian.Uint32(data[1:grpcFrameHeaderSize])
+		if uint64(len(data)-grpcFrameHeaderSize) < uint64(length) {
+			return messages, fmt.Errorf("truncated gRPC message: want %d bytes, have %d", length, len(data)-grpcFrameHeaderSize)
+		}
+		msg := data[grpcFrameHeaderSize : grpcFrameHeaderSize+int(length)]
+		data = data[grpcFrameHeaderSize+int(length):]
+
+		if compressed {
+			if encoding != "gzip" {
+				return messages, fmt.Errorf("unsupported gRPC message encoding %q", encoding)
+			}
+			zr, err := gzip.NewReader(bytes.NewReader(msg))
+			if err != nil {
+				return messages, fmt.Errorf("decompressing gRPC message: %w", err)
+			}
+			msg, err = io.ReadAll(io.LimitReader(zr, maxRequestSize))
+			zr.Close()
+			if err != nil {
+				return messages, fmt.Errorf("decompressing gRPC message: %w", err)
+			}
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
+
+// decodeGRPCMessages decodes a gRPC stream into a JSON array of messages
+func decodeGRPCMessages(data []byte, encoding string, desc protoreflect.MessageDescriptor) ([]byte, error) {
+	frames, err := splitGRPCFrames(data, encoding)
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]json.RawMessage, 0, len(frames))
+	for _, frame := range frames {
+		msg := dynamicpb.NewMessage(desc)
+		if err := proto.Unmarshal(frame, msg); err != nil {
+			return nil, fmt.Errorf("unmarshaling %s: %w", desc.FullName(), err)
+		}
+		jsonBytes, err := protojson.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("marshaling %s to JSON: %w", desc.FullName(), err)
+		}
+		messages = append(messages, jsonBytes)
+	}
+	return json.Marshal(messages)
+}
+
+// grpcResponseRecorder captures a streamed gRPC response while flushing every write
+type grpcResponseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	body       bytes.Buffer
+}
+
+func (r *grpcResponseRecorder) WriteHeader(statusCode int) {
+	r.statusCode = statusCode
+	r.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (r *grpcResponseRecorder) Write(b []byte) (int, error) {
+	if r.body.Len() < maxRequestSize {
+		r.body.Write(b)
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush is needed so streaming responses reach the client message by message
+func (r *grpcResponseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (r *grpcResponseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// grpcTrailers collects the trailers written by the reverse proxy, which are
+// either announced in "Trailer" or sent with the http.TrailerPrefix
+func grpcTrailers(header http.Header) http.Header {
+	trailers := http.Header{}
+	for _, name := range header.Values("Trailer") {
+		for _, key := range strings.Split(name, ",") {
+			key = http.CanonicalHeaderKey(strings.TrimSpace(key))
+			if values, ok := header[key]; ok {
+				trailers[key] = values
+			}
+		}
+	}
+	for key, values := range header {
+		if strings.HasPrefix(key, http.TrailerPrefix) {
+			trailers[http.CanonicalHeaderKey(strings.TrimPrefix(key, http.TrailerPrefix))] = values
+		}
+	}
+	return trailers
+}
+
+// StartGRPCProxy starts an h2c/HTTP2 proxy that understands gRPC framing and
+// records each RPC with its messages decoded to JSON
+func StartGRPCProxy(ctx context.Context, cfg *config.Config, database *sql.DB, records *db.Writer) Server {
+	if !cfg.GRPC.Enabled {
+		return nil
+	}
+
+	srv, _ := newReloadableServer(ctx, "gRPC", cfg, func(ctx context.Context, cfg *config.Config) (http.Handler, error) {
+		return buildGRPCHandler(ctx, cfg, records), nil
+	}, nil)
+
+	protocols := new(http.Protocols)
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	srv.Server = &http.Server{
+		Addr:      fmt.Sprintf(":%d", cfg.GRPC.Port),
+		Handler:   srv,
+		Protocols: protocols,
+		// Streaming RPCs may stay open indefinitely, so only bound the headers
+		ReadHeaderTimeout: 15 * time.Second,
+		IdleTimeout:       60 * time.Second,
+	}
+
+	go func() {
+		slog.Info("Starting gRPC proxy server (h2c)", "port", cfg.GRPC.Port, "target_url", grpcTargetURL(cfg, "/"))
+		switch {
+		case cfg.StrictOffline:
+			slog.Warn("Replay mode is not supported for gRPC, RPCs will fail with UNAVAILABLE while offline")
+		case cfg.IsReplaying():
+			slog.Warn("Replay mode is not supported for gRPC, RPCs will be proxied live")
+		}
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("gRPC proxy server error", "error", err)
+		}
+	}()
+
+	return srv
+}
+
+// buildGRPCHandler builds the gRPC proxy handler for one configuration
+// generation; ctx ends when the generation is retired
+func buildGRPCHandler(ctx context.Context, cfg *config.Config, records *db.Writer) http.Handler {
+	proxy := newGRPCReverseProxy(cfg)
+	transport := proxy.Transport.(*http.Transport)
+	// A retired generation does not keep its upstream connections open
+	context.AfterFunc(ctx, transport.CloseIdleConnections)
+
+	handler := createGRPCHandler(proxy, cfg, loadConfiguredDescriptors(cfg), records)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+		// Connections of RPCs that outlived their generation are not reused
+		if ctx.Err() != nil {
+			transport.CloseIdleConnections()
+		}
+	})
+}
+
+// loadConfiguredDescriptors loads the proto descriptors named in the gRPC
+// settings, or returns nil when there are none or they fail to load
+func loadConfiguredDescriptors(cfg *config.Config) *grpcDescriptors {
+	if len(cfg.GRPC.ImportPaths) == 0 && len(cfg.GRPC.ProtoFiles) == 0 {
+		return nil
+	}
+	descriptors, err := loadGRPCDescriptors(cfg.GRPC.ImportPaths, cfg.GRPC.ProtoFiles)
+	if err != nil {
+		slog.Warn("Failed to load proto descriptors, gRPC messages will be stored as raw bytes", "error", err)
+		return nil
+	}
+	slog.Info("Loaded proto descriptors for gRPC decoding", "methods", len(descriptors.methods))
+	return descriptors
+}
+
+// grpcTargetURL returns the upstream for an RPC path, preferring grpc.target_url
+func grpcTargetURL(cfg *config.Config, path string) string {
+	if cfg.GRPC.TargetURL != "" {
+		return cfg.GRPC.TargetURL
+	}
+	return cfg.GetTargetURL(path)
+}
+
+// newGRPCReverseProxy builds a reverse proxy that speaks HTTP/2 to the upstream,
+// using h2c for http:// targets and ALPN for https:// targets
+func newGRPCReverseProxy(cfg *config.Config) *httputil.ReverseProxy {
+	protocols := new(http.Protocols)
+	protocols.SetHTTP2(true)
+	protocols.SetUnencryptedHTTP2(true)
+
+	return &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			targetURLStr := grpcTargetURL(cfg, req.URL.Path)
+			target, err := url.Parse(targetURLStr)
+			if err != nil {
+				slog.Error("Invalid gRPC target URL", "url", targetURLStr, "error", err)
+				return
+			}
+			req.URL.Scheme = target.Scheme
+			req.URL.Host = target.Host
+			req.Host = target.Host
+		},
+		Transport: &http.Transport{
+			Protocols: protocols,
+			DialContext: (&net.Dialer{
+				Timeout:   10 * time.Second,
+				KeepAlive: 60 * time.Second,
+			}).DialContext,
+			TLSClientConfig:     cfg.GetTLSConfig(),
+			TLSHandshakeTimeout: 10 * time.Second,
+			IdleConnTimeout:     90 * time.Second,
+		},
+		// Flush immediately so server-streaming messages are not held back
+		FlushInterval: -1,
+		ErrorHandler: func(rw http.ResponseWriter, r *http.Request, err error) {
+			slog.Error("gRPC proxy error", "method", r.URL.Path, "error", err)
+			countUpstreamError("gRPC", r, err)
+			writeGRPCUnavailable(rw, "upstream unavailable")
+		},
+	}
+}
+
+// writeGRPCUnavailable reports a failure the gRPC way: a trailers-only
+// response with UNAVAILABLE
+func writeGRPCUnavailable(w http.ResponseWriter, message string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", "14")
+	w.Header().Set("Grpc-Message", message)
+	w.WriteHeader(http.StatusOK)
+}
+
+// createGRPCHandler returns the gRPC handler function
+func createGRPCHandler(
+	proxy *httputil.ReverseProxy,
+	cfg *config.Config,
+	descriptors *grpcDescriptors,
+	records *db.Writer,
+) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// RPCs cannot be replayed, and offline nothing may reach the target
+		if cfg.StrictOffline {
+			slog.Error("Strict offline replay miss", "method", r.URL.Path, "reason", "gRPC calls cannot be replayed")
+			writeGRPCUnavailable(w, "strict offline mode: gRPC calls cannot be replayed")
+			return
+		}
+		if !cfg.IsRecording() || !isGRPCRequest(r) {
+			proxy.ServeHTTP(w, r)
+			return
+		}
+
+		startTime := time.Now()
+		red := redactorFor(cfg)
+		reqHeadersBytes := redactedHeaderJSON(red, r.Header)
+
+		// Tee the request stream so client-streaming messages are captured as they are sent
+		var reqBody bytes.Buffer
+		if r.Body != nil {
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{io.TeeReader(r.Body, &limitedWriter{w: &reqBody, n: maxRequestSize}), r.Body}
+		}
+
+		recorder := &grpcResponseRecorder{ResponseWriter: w, statusCode: http.StatusOK}
+		proxy.ServeHTTP(recorder, r)
+
+		// Merge trailers into the stored headers so grpc-status is visible
+		respHeaders := w.Header().Clone()
+		for key := range respHeaders {
+			if strings.HasPrefix(key, http.TrailerPrefix) {
+				delete(respHeaders, key)
+			}
+		}
+		for key, values := range grpcTrailers(w.Header()) {
+			respHeaders[key] = values
+		}
+		respHeadersBytes := redactedHeaderJSON(red, respHeaders)
+
+		service := grpcServiceName(r.URL.Path)
+		requestBody := reqBody.Bytes()
+		responseBody := recorder.body.Bytes()
+		if method := descriptors.method(r.URL.Path); method != nil {
+			if decoded, err := decodeGRPCMessages(requestBody, r.Header.Get("Grpc-Encoding"), method.Input()); err != nil {
+				slog.Warn("Failed to decode gRPC request messages", "service", service, "error", err)
+			} else {
+				requestBody = decoded
+			}
+			if decoded, err := decodeGRPCMessages(responseBody, respHeaders.Get("Grpc-Encoding"), method.Output()); err != nil {
+				slog.Warn("Failed to decode gRPC response messages", "service", service, "error", err)
+			} else {
+				responseBody = decoded
+			}
+		} else {
+			slog.Debug("No descriptor for gRPC method, storing raw frames", "service", service)
+		}
+
+		slog.Info("Recorded gRPC call", "service", service, "grpc_status", respHeaders.Get("Grpc-Status"), "duration_ms", time.Since(startTime).Milliseconds())
+
+		record := db.TrafficRecord{
+			ID:              generateID(),
+			Timestamp:       startTime.UTC(),
+			Protocol:        "gRPC",
+			Method:          r.Method,
+			URL:             strings.TrimSuffix(grpcTargetURL(cfg, r.URL.Path), "/") + r.URL.Path,
+			Service:         service,
+			RequestHeaders:  string(reqHeadersBytes),
+			RequestBody:     append([]byte(nil), red.Body(requestBody)...),
+			ResponseStatus:  recorder.statusCode,
+			ResponseHeaders: string(respHeadersBytes),
+			ResponseBody:    append([]byte(nil), red.Body(responseBody)...),
+			Duration:        time.Since(startTime).Milliseconds(),
+			ClientIP:        getClientIP(r),
+			TestID:          r.Header.Get("X-Test-ID"),
+			SessionID:       r.Header.Get("X-Session-ID"),
+		}
+		if err := records.Write(record); err != nil {
+			slog.Warn("Error saving recorded gRPC traffic", "error", err)
+		}
+	}
+}
+
+// limitedWriter writes at most n bytes to w and silently discards the rest
+type limitedWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n <= 0 {
+		return len(p), nil
+	}
+	keep := p
+	if int64(len(keep)) > l.n {
+		keep = keep[:l.n]
+	}
+	n, err := l.w.Write(keep)
+	l.n -= int64(n)
+	if err != nil {
+		return n, err
+	}
+	return len(p), nil
+}
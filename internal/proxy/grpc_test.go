@@ -0,0 +1,248 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// grpcFrame length-prefixes a serialized message
+func grpcFrame(msg []byte) []byte {
+	frame := make([]byte, grpcFrameHeaderSize+len(msg))
+	binary.BigEndian.PutUint32(frame[1:grpcFrameHeaderSize], uint32(len(msg)))
+	copy(frame[grpcFrameHeaderSize:], msg)
+	return frame
+}
+
+// newEchoMessage builds a message for the echo.proto test descriptors
+func newEchoMessage(t *testing.T, desc protoreflect.MessageDescriptor, text string, index int32) []byte {
+	t.Helper()
+	msg := dynamicpb.NewMessage(desc)
+	msg.Set(desc.Fields().ByName("text"), protoreflect.ValueOfString(text))
+	if f := desc.Fields().ByName("index"); f != nil && index != 0 {
+		msg.Set(f, protoreflect.ValueOfInt32(index))
+	}
+	b, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Failed to marshal message: %v", err)
+	}
+	return b
+}
+
+func h2cProtocols() *http.Protocols {
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	return protocols
+}
+
+func TestGRPCProxyRecordsDecodedMessages(t *testing.T) {
+	descriptors, err := loadGRPCDescriptors([]string{"testdata"}, nil)
+	if err != nil {
+		t.Fatalf("Failed to load descriptors: %v", err)
+	}
+	stream := descriptors.method("/echo.v1.EchoService/Stream")
+	if stream == nil {
+		t.Fatal("Stream method not found in descriptors")
+	}
+
+	// Fake server-streaming upstream answering with three messages and a grpc-status trailer
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		w.WriteHeader(http.StatusOK)
+		for i := int32(1); i <= 3; i++ {
+			w.Write(grpcFrame(newEchoMessage(t, stream.Output(), "pong", i)))
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("Grpc-Status", "0")
+		w.Header().Set("Grpc-Message", "")
+	}))
+	upstream.Config.Protocols = h2cProtocols()
+	upstream.Start()
+	defer upstream.Close()
+
+	tempDB, err := os.CreateTemp("", "test_grpc_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		RecordingMode: true,
+		GRPC:          config.GRPCConfig{Enabled: true, TargetURL: upstream.URL},
+	}
+	proxyServer := httptest.NewUnstartedServer(http.HandlerFunc(createGRPCHandler(newGRPCReverseProxy(cfg), cfg, descriptors, records)))
+	proxyServer.Config.Protocols = h2cProtocols()
+	proxyServer.Start()
+	defer proxyServer.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{Protocols: h2cProtocols()},
+		Timeout:   10 * time.Second,
+	}
+	reqBody := grpcFrame(newEchoMessage(t, stream.Input(), "ping", 0))
+	req, _ := http.NewRequest(http.MethodPost, proxyServer.URL+"/echo.v1.EchoService/Stream", bytes.NewReader(reqBody))
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("gRPC request through proxy failed: %v", err)
+	}
+	respBody, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+
+	if got := resp.Trailer.Get("Grpc-Status"); got != "0" {
+		t.Errorf("Expected grpc-status trailer 0 at the client, got %q", got)
+	}
+	if frames, err := splitGRPCFrames(respBody, ""); err != nil || len(frames) != 3 {
+		t.Fatalf("Expected 3 streamed frames at the client, got %d (%v)", len(frames), err)
+	}
+
+	var service, recordedReq, recordedResp, recordedHeaders string
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := database.QueryRow(`SELECT service, request_body, response_body, response_headers FROM traffic_records WHERE protocol = 'gRPC'`).
+			Scan(&service, &recordedReq, &recordedResp, &recordedHeaders)
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("No gRPC record found: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if service != "echo.v1.EchoService/Stream" {
+		t.Errorf("Expected service echo.v1.EchoService/Stream, got %q", service)
+	}
+
+	var reqMessages []map[string]any
+	if err := json.Unmarshal([]byte(recordedReq), &reqMessages); err != nil {
+		t.Fatalf("Recorded request is not a JSON array: %v (%s)", err, recordedReq)
+	}
+	if len(reqMessages) != 1 || reqMessages[0]["text"] != "ping" {
+		t.Errorf("Unexpected decoded request: %s", recordedReq)
+	}
+
+	var respMessages []map[string]any
+	if err := json.Unmarshal([]byte(recordedResp), &respMessages); err != nil {
+		t.Fatalf("Recorded response is not a JSON array: %v (%s)", err, recordedResp)
+	}
+	if len(respMessages) != 3 {
+		t.Fatalf("Expected 3 decoded response messages, got %d: %s", len(respMessages), recordedResp)
+	}
+	if respMessages[2]["index"] != float64(3) {
+		t.Errorf("Expected last message index 3, got %v", respMessages[2]["index"])
+	}
+
+	if !strings.Contains(recordedHeaders, `"Grpc-Status":["0"]`) {
+		t.Errorf("Expected grpc-status trailer in recorded headers, got %s", recordedHeaders)
+	}
+}
+
+func TestGRPCStrictOfflineFailsCalls(t *testing.T) {
+	var upstreamCalls atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{
+		StrictOffline: true,
+		ReplayMode:    true,
+		GRPC:          config.GRPCConfig{Enabled: true, TargetURL: upstream.URL},
+	}
+	handler := createGRPCHandler(newGRPCReverseProxy(cfg), cfg, nil, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/echo.v1.EchoService/Stream", bytes.NewReader(nil))
+	req.Header.Set("Content-Type", "application/grpc")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if got := rr.Header().Get("Grpc-Status"); got != "14" {
+		t.Errorf("Expected grpc-status 14 (UNAVAILABLE), got %q", got)
+	}
+	if n := upstreamCalls.Load(); n != 0 {
+		t.Errorf("Expected no call to reach the upstream offline, got %d", n)
+	}
+}
+
+func TestGRPCGenerationClosesIdleConnections(t *testing.T) {
+	closed := make(chan struct{}, 1)
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	upstream.Config.Protocols = h2cProtocols()
+	upstream.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			closed <- struct{}{}
+		}
+	}
+	upstream.Start()
+	defer upstream.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := &config.Config{GRPC: config.GRPCConfig{Enabled: true, TargetURL: upstream.URL}}
+	handler := buildGRPCHandler(ctx, cfg, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/echo.v1.EchoService/Stream", bytes.NewReader(nil))
+	req.Header.Set("Content-Type", "application/grpc")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if got := rr.Header().Get("Grpc-Status"); got != "0" {
+		t.Fatalf("Expected grpc-status 0, got %q", got)
+	}
+
+	// Retiring the generation closes its idle upstream connection
+	cancel()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the retired generation's idle connection to be closed")
+	}
+}
+
+func TestSplitGRPCFrames(t *testing.T) {
+	data := append(grpcFrame([]byte("a")), grpcFrame([]byte("bc"))...)
+	frames, err := splitGRPCFrames(data, "")
+	if err != nil {
+		t.Fatalf("splitGRPCFrames failed: %v", err)
+	}
+	if len(frames) != 2 || string(frames[0]) != "a" || string(frames[1]) != "bc" {
+		t.Errorf("Unexpected frames: %q", frames)
+	}
+
+	if _, err := splitGRPCFrames(data[:len(data)-1], ""); err == nil {
+		t.Error("Expected an error for a truncated frame")
+	}
+}
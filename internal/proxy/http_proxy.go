@@ -21,9 +21,15 @@ import (
 	"time"
 
 	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/blob"
+	"github.com/dipjyotimetia/jarvis/internal/certs"
 	"github.com/dipjyotimetia/jarvis/internal/db"
+	"github.com/dipjyotimetia/jarvis/internal/metrics"
+	"github.com/dipjyotimetia/jarvis/internal/plugin"
 	"github.com/dipjyotimetia/jarvis/internal/validator"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Server interface allows for mocking in tests
@@ -38,48 +44,77 @@ var (
 			return bytes.NewBuffer(make([]byte, 0, 4096))
 		},
 	}
-	
-	recordPool = sync.Pool{
-		New: func() interface{} {
-			return &db.TrafficRecord{}
-		},
-	}
 )
 
 // Configuration constants
 const (
 	maxRequestSize  = 32 * 1024 * 1024 // 32MB
 	streamThreshold = 1024 * 1024       // 1MB - stream bodies larger than this
-)
 
-// StartHTTPProxy starts the HTTP proxy server
-func StartHTTPProxy(ctx context.Context, cfg *config.Config, db *sql.DB, insertStmt *sql.Stmt) Server {
-	// Create a custom director for path-based routing
-	director := func(req *http.Request) {
-		// Determine target URL based on request path
-		targetURLStr := cfg.GetTargetURL(req.URL.Path)
-
-		// Parse the target URL for this request
-		target, err := url.Parse(targetURLStr)
-		if err != nil {
-			slog.Error("Invalid target URL", "url", targetURLStr, "error", err)
-			return
-		}
+	// sourceHeader tells clients whether a response was replayed or live
+	sourceHeader = "X-Jarvis-Source"
+)
 
-		// Update request URL with correct scheme, host, etc. but keep the original path
-		originalPath := req.URL.Path
-		originalQuery := req.URL.RawQuery
+// newDirector returns a director that rewrites requests to their target URL,
+// applies the request rewrite rules and sets the forwarding headers
+func newDirector(cfg *config.Config) func(req *http.Request) {
+	rewriter := newRequestRewriter(cfg.Rewrites)
+	balancer := balancerFor(cfg)
+	return func(req *http.Request) {
 		originalHost := req.Host
+		// Rules are selected on the path the client sent
+		selected := rewriter.selectRules(req)
+		rewritten := len(selected) > 0
+		routing := routeStateFrom(req.Context())
+
+		// In forward-proxy mode requests already carry an absolute target URL
+		if cfg.ForwardProxy.Enabled && req.URL.Host != "" {
+			req.Host = req.URL.Host
+			if routing != nil {
+				routing.upstream = req.URL.Scheme + "://" + req.URL.Host
+				routing.forwarded = true
+			}
+		} else {
+			// Determine the target from the most specific matching route,
+			// balancing across its upstreams
+			match := routeMatchFor(req, cfg)
+			var target *url.URL
+			if pool := balancer.pool(routeOf(match)); pool != nil {
+				picked := pool.pick()
+				target = picked.url
+				if routing != nil {
+					routing.pool, routing.target = pool, picked
+				}
+			} else {
+				var err error
+				if target, err = url.Parse(cfg.HTTPTargetURL); err != nil {
+					slog.Error("Invalid target URL", "url", cfg.HTTPTargetURL, "error", err)
+					return
+				}
+			}
+			if routing != nil {
+				routing.upstream = target.Redacted()
+			}
+
+			// Update request URL with correct scheme, host, etc. but keep the original path
+			originalPath := req.URL.Path
+			originalQuery := req.URL.RawQuery
+			if match != nil && match.Route.StripPrefix {
+				originalPath = ensureLeadingSlash(strings.TrimPrefix(originalPath, strings.TrimSuffix(match.Route.PathPrefix, "/*")))
+				rewritten = true
+			}
+
+			// Set the scheme, host, etc. from the target
+			*req.URL = *target
 
-		// Set the scheme, host, etc. from the target
-		*req.URL = *target
+			// Restore original path and query
+			req.URL.Path = originalPath
+			req.URL.RawQuery = originalQuery
 
-		// Restore original path and query
-		req.URL.Path = originalPath
-		req.URL.RawQuery = originalQuery
+			// Set host header to target host
+			req.Host = target.Host
+		}
 
-		// Set host header to target host
-		req.Host = target.Host
 		// Forwarding headers
 		if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
 			if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
@@ -95,47 +130,27 @@ func StartHTTPProxy(ctx context.Context, cfg *config.Config, db *sql.DB, insertS
 		} else {
 			req.Header.Set("X-Forwarded-Proto", "http")
 		}
-	}
-
-	// Create a custom ReverseProxy with our director
-	proxy := &httputil.ReverseProxy{
-		Director: director,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   10 * time.Second,
-				KeepAlive: 60 * time.Second,
-			}).DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          200,
-			MaxIdleConnsPerHost:   100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			ResponseHeaderTimeout: 20 * time.Second,
-			// Allow outbound HTTPS targets to respect TLS settings
-			TLSClientConfig: cfg.GetTLSConfig(),
-		},
-		ErrorHandler: func(rw http.ResponseWriter, r *http.Request, err error) {
-			slog.Error("HTTP proxy error", "error", err)
-			rw.WriteHeader(http.StatusBadGateway)
-		},
-	}
 
-	// Buffer pool for the response writer wrapper
-	responseBufPool := sync.Pool{
-		New: func() interface{} {
-			return new(bytes.Buffer)
-		},
+		bodyPatched := rewriter.rewrite(req, selected)
+		if state := rewriteStateFrom(req.Context()); state != nil && rewritten {
+			for _, i := range selected {
+				state.rules = append(state.rules, &cfg.Rewrites[i])
+			}
+			captureUpstreamRequest(state, req, bodyPatched)
+		}
 	}
+}
 
-	// Create handler function with all dependencies
-	handler := createHTTPHandler(proxy, cfg, db, insertStmt, &responseBufPool)
+// StartHTTPProxy starts the HTTP proxy server
+func StartHTTPProxy(ctx context.Context, cfg *config.Config, database *sql.DB, records *db.Writer) Server {
+	srv, _ := newReloadableServer(ctx, "HTTP", cfg, func(ctx context.Context, cfg *config.Config) (http.Handler, error) {
+		return buildHTTPHandler(ctx, cfg, database, records, "HTTP proxy error"), nil
+	}, nil)
 
 	// Create the HTTP server
-	server := &http.Server{
+	srv.Server = &http.Server{
 		Addr:    fmt.Sprintf(":%d", cfg.HTTPPort),
-		Handler: http.HandlerFunc(handler),
+		Handler: srv,
 		// Set timeouts
 		ReadTimeout:  15 * time.Second,
 		WriteTimeout: 30 * time.Second,
@@ -145,165 +160,151 @@ func StartHTTPProxy(ctx context.Context, cfg *config.Config, db *sql.DB, insertS
 	// Start server in a goroutine
 	go func() {
 		slog.Info("Starting HTTP proxy server with path-based routing", "port", cfg.HTTPPort)
-		// Log the routing table
-		if len(cfg.TargetRoutes) > 0 {
-			slog.Info("Routing configuration:")
-			for _, route := range cfg.TargetRoutes {
-				slog.Info("Route mapping", "path_prefix", route.PathPrefix, "target_url", route.TargetURL)
-			}
-		}
-		if cfg.HTTPTargetURL != "" {
-			slog.Info("Default route mapping", "target_url", cfg.HTTPTargetURL)
+		if cfg.ForwardProxy.Enabled {
+			slog.Info("Forward proxy mode enabled, intercepting CONNECT tunnels", "ca_cert", cfg.ForwardProxy.CACert)
 		}
+		// Log the routing table
+		logRoutes(cfg)
 
-		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			slog.Error("HTTP server error", "error", err)
 		}
 	}()
 
-	return server
+	return srv
 }
 
-func StartHTTPSProxy(ctx context.Context, cfg *config.Config, db *sql.DB, insertStmt *sql.Stmt) Server {
+func StartHTTPSProxy(ctx context.Context, cfg *config.Config, database *sql.DB, records *db.Writer) Server {
 	if !cfg.TLS.Enabled {
 		slog.Warn("TLS is not enabled in configuration, skipping HTTPS proxy")
 		return nil
 	}
 
-	// Create a custom director for path-based routing
-	director := func(req *http.Request) {
-		// Determine target URL based on request path
-		targetURLStr := cfg.GetTargetURL(req.URL.Path)
+	srv, err := newReloadableServer(ctx, "HTTPS", cfg, func(ctx context.Context, cfg *config.Config) (http.Handler, error) {
+		return buildHTTPHandler(ctx, cfg, database, records, "HTTPS proxy error"), nil
+	}, serverTLSConfig)
+	if err != nil {
+		slog.Error("Failed to start HTTPS proxy", "error", err)
+		return nil
+	}
 
-		// Parse the target URL for this request
-		target, err := url.Parse(targetURLStr)
-		if err != nil {
-			slog.Error("Invalid target URL", "url", targetURLStr, "error", err)
-			return
+	// Create HTTPS server; certificates and client verification come from
+	// the current configuration so they can be reloaded
+	srv.Server = &http.Server{
+		Addr:    fmt.Sprintf(":%d", cfg.TLS.Port),
+		Handler: srv,
+		// Set timeouts
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  60 * time.Second,
+		TLSConfig:    &tls.Config{GetConfigForClient: srv.getConfigForClient},
+	}
+
+	// Start HTTPS server in a goroutine
+	go func() {
+		slog.Info("Starting HTTPS proxy server with TLS", "port", cfg.TLS.Port)
+		if cfg.TLS.ClientAuth && cfg.TLS.ClientCACert != "" {
+			slog.Info("mTLS is enabled - client certificates will be verified")
 		}
 
-		// Update request URL with correct scheme, host, etc. but keep the original path
-		originalPath := req.URL.Path
-		originalQuery := req.URL.RawQuery
-		originalHost := req.Host
+		// Log the routing table
+		logRoutes(cfg)
 
-		// Set the scheme, host, etc. from the target
-		*req.URL = *target
+		if err := srv.ListenAndServeTLS("", ""); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTPS server error", "error", err)
+		}
+	}()
 
-		// Restore original path and query
-		req.URL.Path = originalPath
-		req.URL.RawQuery = originalQuery
+	return srv
+}
 
-		// Set host header to target host
-		req.Host = target.Host
-		// Forwarding headers
-		if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
-			if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
-				req.Header.Set("X-Forwarded-For", prior+", "+ip)
-			} else {
-				req.Header.Set("X-Forwarded-For", ip)
-			}
-		}
-		req.Header.Set("X-Forwarded-Host", originalHost)
-		if req.TLS != nil {
-			req.Header.Set("X-Forwarded-Proto", "https")
-		} else {
-			req.Header.Set("X-Forwarded-Proto", "http")
-		}
-	}
+// buildHTTPHandler assembles the reverse proxy and request handler for cfg.
+// Background work such as health checks stops when ctx is done.
+func buildHTTPHandler(ctx context.Context, cfg *config.Config, database *sql.DB, records *db.Writer, errorLabel string) http.Handler {
+	transport := newRouteTransport(cfg, &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   10 * time.Second,
+			KeepAlive: 60 * time.Second,
+		}).DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          200,
+		MaxIdleConnsPerHost:   100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		ResponseHeaderTimeout: 20 * time.Second,
+		// Allow outbound HTTPS targets to respect TLS settings
+		TLSClientConfig: cfg.GetTLSConfig(),
+	})
+	// A retired generation does not keep its upstream connections open
+	context.AfterFunc(ctx, transport.CloseIdleConnections)
 
 	// Create a custom ReverseProxy with our director
 	proxy := &httputil.ReverseProxy{
-		Director: director,
-		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   10 * time.Second,
-				KeepAlive: 60 * time.Second,
-			}).DialContext,
-			ForceAttemptHTTP2:     true,
-			MaxIdleConns:          200,
-			MaxIdleConnsPerHost:   100,
-			IdleConnTimeout:       90 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			ResponseHeaderTimeout: 20 * time.Second,
-			// Apply TLS config for outbound connections to target servers
-			TLSClientConfig: cfg.GetTLSConfig(),
+		Director: newDirector(cfg),
+		ModifyResponse: func(resp *http.Response) error {
+			if err := rewriteResponse(resp); err != nil {
+				return err
+			}
+			return runResponsePlugins(resp)
 		},
+		Transport: transport,
 		ErrorHandler: func(rw http.ResponseWriter, r *http.Request, err error) {
-			slog.Error("HTTPS proxy error", "error", err)
-			rw.WriteHeader(http.StatusBadGateway)
+			slog.Error(errorLabel, "error", err)
+			countUpstreamError("HTTP", r, err)
+			writeProxyError(rw, err)
 		},
 	}
 
 	// Buffer pool for the response writer wrapper
-	responseBufPool := sync.Pool{
+	responseBufPool := &sync.Pool{
 		New: func() interface{} {
 			return new(bytes.Buffer)
 		},
 	}
 
 	// Create handler function with all dependencies
-	handler := createHTTPHandler(proxy, cfg, db, insertStmt, &responseBufPool)
+	handler := createHTTPHandler(proxy, cfg, database, records, responseBufPool)
+	balancerFor(cfg).startHealthChecks(ctx, cfg)
+	retainPlugins(ctx, cfg)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler(w, r)
+		// Connections of requests that outlived their generation are not reused
+		if ctx.Err() != nil {
+			transport.CloseIdleConnections()
+		}
+	})
+}
 
-	// Configure TLS for the server (inbound connections)
-	tlsConfig := &tls.Config{}
+// serverTLSConfig builds the inbound TLS configuration: the server
+// certificate and, with mTLS, the CA used to verify client certificates
+func serverTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading TLS certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		NextProtos:   []string{"h2", "http/1.1"},
+	}
 
 	// Configure client certificate verification for inbound connections (mTLS)
 	if cfg.TLS.ClientAuth && cfg.TLS.ClientCACert != "" {
 		// Load CA certificate for client verification
 		caCert, err := os.ReadFile(cfg.TLS.ClientCACert)
 		if err != nil {
-			slog.Error("Failed to read client CA certificate", "error", err)
-		} else {
-			caCertPool := x509.NewCertPool()
-			if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
-				slog.Error("Failed to parse client CA certificate")
-			} else {
-				// Set client certificate verification
-				tlsConfig.ClientCAs = caCertPool
-				tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
-				slog.Info("mTLS enabled: Client certificates will be verified")
-			}
-		}
-	}
-
-	// Create HTTPS server with TLS configuration
-	server := &http.Server{
-		Addr:    fmt.Sprintf(":%d", cfg.TLS.Port),
-		Handler: http.HandlerFunc(handler),
-		// Set timeouts
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 30 * time.Second,
-		IdleTimeout:  60 * time.Second,
-		TLSConfig:    tlsConfig,
-	}
-
-	// Start HTTPS server in a goroutine
-	go func() {
-		slog.Info("Starting HTTPS proxy server with TLS", "port", cfg.TLS.Port)
-		if tlsConfig.ClientAuth == tls.RequireAndVerifyClientCert {
-			slog.Info("mTLS is enabled - client certificates will be verified")
+			return nil, fmt.Errorf("reading client CA certificate: %w", err)
 		}
-
-		// Log the routing table
-		if len(cfg.TargetRoutes) > 0 {
-			slog.Info("Routing configuration:")
-			for _, route := range cfg.TargetRoutes {
-				slog.Info("Route mapping", "path_prefix", route.PathPrefix, "target_url", route.TargetURL)
-			}
-		}
-		if cfg.HTTPTargetURL != "" {
-			slog.Info("Default route mapping", "target_url", cfg.HTTPTargetURL)
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+			return nil, errors.New("parsing client CA certificate: no certificates found")
 		}
-
-		if err := server.ListenAndServeTLS(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
-			slog.Error("HTTPS server error", "error", err)
-		}
-	}()
-
-	return server
+		// Set client certificate verification
+		tlsConfig.ClientCAs = caCertPool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
 }
 
 // createHTTPHandler returns the HTTP handler function
@@ -311,36 +312,43 @@ func createHTTPHandler(
 	proxy *httputil.ReverseProxy,
 	cfg *config.Config,
 	database *sql.DB,
-	insertStmt *sql.Stmt,
+	records *db.Writer,
 	responseBufPool *sync.Pool,
 ) func(http.ResponseWriter, *http.Request) {
-	// Initialize API validator if enabled
-	var apiValidator *validator.APIValidator
-	if cfg.APIValidation.Enabled {
-		slog.Info("Initializing OpenAPI validator from spec", "spec_path", cfg.APIValidation.SpecPath)
-		validatorOptions := validator.APIValidatorOptions{
-			EnableRequestValidation:  cfg.APIValidation.ValidateRequests,
-			EnableResponseValidation: cfg.APIValidation.ValidateResponses,
-			StrictMode:               cfg.APIValidation.StrictMode,
-		}
+	// Load the OpenAPI specs of the proxy and its routes if validation is enabled
+	validators := newSpecValidators(cfg)
+
+	matcher := newRuleMatcher(cfg)
 
+	// Compile plugins before the first request rather than during it
+	plugins := pluginsFor(cfg)
+
+	handle := func(w http.ResponseWriter, r *http.Request) {
+		observeHTTP(w, r, cfg, func(w http.ResponseWriter, r *http.Request) {
+			handleHTTPRequest(w, r, proxy, cfg, database, records, responseBufPool, validators, matcher, plugins)
+		})
+	}
+
+	// Load the interception CA if running as a forward proxy
+	var ca *certs.CA
+	if cfg.ForwardProxy.Enabled {
 		var err error
-		apiValidator, err = validator.NewAPIValidator(cfg.APIValidation.SpecPath, validatorOptions)
+		ca, err = certs.LoadCA(cfg.ForwardProxy.CACert, cfg.ForwardProxy.CAKey)
 		if err != nil {
-			slog.Warn("Failed to initialize OpenAPI validator", "error", err)
-		} else {
-			// Log API details
-			apiInfo := apiValidator.GetOpenAPIInfo()
-			slog.Info("Loaded OpenAPI spec", "title", apiInfo["title"], "version", apiInfo["version"])
-			slog.Info("API paths loaded", "count", apiInfo["paths"])
-
-			// Log validation modes
-			slog.Info("API validation configuration", "request_validation", cfg.APIValidation.ValidateRequests, "response_validation", cfg.APIValidation.ValidateResponses)
+			slog.Error("Failed to load forward proxy CA, CONNECT tunnels will be rejected", "error", err)
 		}
 	}
 
 	return func(w http.ResponseWriter, r *http.Request) {
-		handleHTTPRequest(w, r, proxy, cfg, database, insertStmt, responseBufPool, apiValidator)
+		if r.Method == http.MethodConnect {
+			if ca == nil {
+				http.Error(w, "CONNECT is only supported in forward proxy mode", http.StatusMethodNotAllowed)
+				return
+			}
+			handleConnect(w, r, ca, handle)
+			return
+		}
+		handle(w, r)
 	}
 }
 
@@ -350,9 +358,12 @@ type responseRecorder struct {
 	statusCode    int
 	header        http.Header
 	body          *bytes.Buffer
-	streamMode    bool // Enable streaming mode for large responses
-	maxBufferSize int64 // Maximum size to buffer
-	bytesWritten  int64 // Track total bytes written
+	streamMode    bool           // Enable streaming mode for large responses
+	maxBufferSize int64          // Maximum size to buffer
+	bytesWritten  int64          // Track total bytes written
+	blobs         *blob.Store    // Captures bodies beyond maxBufferSize when set
+	spill         *blob.Writer   // Receives the whole body once it outgrows the buffer
+	stream        *streamCapture // Events of a streamed response as they arrive
 }
 
 // Header captures headers
@@ -370,6 +381,9 @@ func (r *responseRecorder) Header() http.Header {
 // WriteHeader captures status code and writes header to underlying writer
 func (r *responseRecorder) WriteHeader(statusCode int) {
 	r.statusCode = statusCode
+	if isStreamingResponse(r.header, statusCode) {
+		r.stream = newStreamCapture(r.header)
+	}
 	// Write headers captured *before* writing the status code
 	for k, v := range r.header {
 		r.ResponseWriter.Header()[k] = v
@@ -381,7 +395,26 @@ func (r *responseRecorder) WriteHeader(statusCode int) {
 func (r *responseRecorder) Write(b []byte) (int, error) {
 	// Track total bytes written
 	r.bytesWritten += int64(len(b))
-	
+	if r.stream != nil {
+		r.stream.write(b)
+	}
+
+	// Large bodies are teed to the blob store while streaming to the client
+	if r.spill == nil && r.blobs != nil && (r.streamMode || r.body.Len()+len(b) > int(r.maxBufferSize)) {
+		spill, err := r.blobs.NewWriter()
+		if err != nil {
+			slog.Warn("Error capturing large response body", "error", err)
+			r.blobs = nil
+		} else {
+			spill.Write(r.body.Bytes())
+			r.spill = spill
+		}
+	}
+	if r.spill != nil {
+		r.spill.Write(b)
+		return r.ResponseWriter.Write(b)
+	}
+
 	// If we're in stream mode or would exceed buffer size, only capture limited data
 	if r.streamMode || (r.maxBufferSize > 0 && r.body.Len() >= int(r.maxBufferSize)) {
 		// Only capture first chunk for metadata if buffer is empty
@@ -406,30 +439,125 @@ func (r *responseRecorder) Write(b []byte) (int, error) {
 	return r.ResponseWriter.Write(b)
 }
 
-// replayHTTPTraffic serves a response from the database
-func replayHTTPTraffic(w http.ResponseWriter, r *http.Request, database *sql.DB) {
-	// Consider matching on headers or body hash for more accuracy
-	query := `SELECT response_status, response_headers, response_body 
-              FROM traffic_records 
-              WHERE protocol = 'HTTP' AND method = ? AND url = ?
-              ORDER BY timestamp DESC LIMIT 1`
+// Unwrap exposes the underlying writer to http.ResponseController
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
 
-	row := database.QueryRow(query, r.Method, r.URL.String())
+// complete reports whether the buffer holds the whole body
+func (r *responseRecorder) complete() bool {
+	return r.spill == nil && r.bytesWritten == int64(r.body.Len())
+}
 
-	var status int
-	var headersStr string
-	var respBody []byte
+// discard drops a captured body that was not committed
+func (r *responseRecorder) discard() {
+	if r.spill != nil {
+		r.spill.Abort()
+	}
+}
 
-	err := row.Scan(&status, &headersStr, &respBody)
+// maxReplayCandidates bounds the recordings scored for a single replayed request
+const maxReplayCandidates = 200
+
+// replayHTTPTraffic serves a response from the database, using matcher to pick
+// the recording that best fits the request. Misses are answered by the AI
+// fallback when it is enabled. It returns false when the request should be
+// passed through to the target instead.
+func replayHTTPTraffic(w http.ResponseWriter, r *http.Request, database *sql.DB, records *db.Writer, matcher requestMatcher, apiValidator *validator.APIValidator, cfg *config.Config, reqBody []byte) bool {
+	candidates, err := loadReplayCandidates(database, r, cfg.Replay.AIFallback.Enabled)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			slog.Info("No replay record found", "method", r.Method, "url", r.URL.String())
-			http.Error(w, "No matching replay record found", http.StatusNotFound)
+		slog.Error("DB error during HTTP replay lookup", "method", r.Method, "url", r.URL.String(), "error", err)
+		http.Error(w, "Database error during replay", http.StatusInternalServerError)
+		return true
+	}
+
+	best, results := matcher.Match(r, reqBody, candidates)
+	if cfg.Replay.Debug {
+		for i := range results {
+			slog.Info("Replay match candidate", "method", r.Method, "url", r.URL.String(), "explanation", results[i].explain())
+		}
+	}
+	if best == nil {
+		metrics.ReplayLookups.WithLabelValues("HTTP", metrics.ResultMiss).Inc()
+		if cfg.RecordMissing {
+			slog.Info("No replay record found, recording live response", "method", r.Method, "url", r.URL.String(), "candidates", len(candidates))
+			return false
+		}
+		msg := "No matching replay record found"
+		if cfg.Replay.Debug && len(results) > 0 {
+			msg += "\n" + strings.Join(sortedCandidateExplanations(results, 5), "\n")
+		}
+		if cfg.StrictOffline {
+			failOffline(w, r, msg)
+			return true
+		}
+		if cfg.Replay.AIFallback.Enabled && generateReplayResponse(w, r, database, records, apiValidator, cfg, reqBody) {
+			return true
+		}
+		slog.Info("No replay record found", "method", r.Method, "url", r.URL.String(), "candidates", len(candidates))
+		http.Error(w, msg, http.StatusNotFound)
+		return true
+	}
+
+	// In sequential mode the Nth request of a session gets the Nth recording
+	if session := replaySessionID(r); cfg.Replay.Sequential && session != "" {
+		sequence := replaySequence(session, results, cfg.Replay.MinScore)
+		pos := replaySessions.advance(session, sequenceKey(r))
+		if pos < len(sequence) {
+			best = sequence[pos]
 		} else {
-			slog.Error("DB error during HTTP replay lookup", "method", r.Method, "url", r.URL.String(), "error", err)
-			http.Error(w, "Database error during replay", http.StatusInternalServerError)
+			switch cfg.Replay.OnExhausted {
+			case exhaustedNotFound:
+				metrics.ReplayLookups.WithLabelValues("HTTP", metrics.ResultMiss).Inc()
+				slog.Info("Replay sequence exhausted", "session_id", session, "method", r.Method, "url", r.URL.String(), "position", pos)
+				http.Error(w, "Replay sequence exhausted", http.StatusNotFound)
+				return true
+			case exhaustedPassthrough:
+				metrics.ReplayLookups.WithLabelValues("HTTP", metrics.ResultMiss).Inc()
+				if cfg.StrictOffline {
+					failOffline(w, r, fmt.Sprintf("Replay sequence exhausted after %d responses", len(sequence)))
+					return true
+				}
+				slog.Info("Replay sequence exhausted, passing through", "session_id", session, "method", r.Method, "url", r.URL.String(), "position", pos)
+				return false
+			default:
+				best = sequence[len(sequence)-1]
+			}
 		}
-		return
+		w.Header().Set("X-Jarvis-Replay-Sequence", fmt.Sprintf("%d/%d", pos+1, len(sequence)))
+	}
+
+	if err := loadReplayResponse(database, best.Candidate); err != nil {
+		slog.Error("DB error during HTTP replay lookup", "method", r.Method, "url", r.URL.String(), "error", err)
+		http.Error(w, "Database error during replay", http.StatusInternalServerError)
+		return true
+	}
+
+	status := best.Candidate.ResponseStatus
+	headersStr := best.Candidate.ResponseHeaders
+	respBody := best.Candidate.ResponseBody
+	if cfg.Replay.Debug {
+		w.Header().Set("X-Jarvis-Replay-Record", best.Candidate.ID)
+		w.Header().Set("X-Jarvis-Replay-Explanation", best.explain())
+	}
+
+	// Large bodies are streamed from the blob store
+	var respBlob io.ReadCloser
+	if ref := best.Candidate.ResponseBlob; ref != "" {
+		store := blobsFor(cfg)
+		if store == nil {
+			slog.Error("Recorded body is in the blob store but blob_store is disabled", "record_id", best.Candidate.ID)
+			http.Error(w, "Recorded response body is unavailable", http.StatusBadGateway)
+			return true
+		}
+		body, _, err := store.Open(ref)
+		if err != nil {
+			slog.Error("Error reading recorded body from the blob store", "record_id", best.Candidate.ID, "blob", ref, "error", err)
+			http.Error(w, "Recorded response body is unavailable", http.StatusBadGateway)
+			return true
+		}
+		defer body.Close()
+		respBlob = body
 	}
 
 	// Parse and set headers
@@ -446,16 +574,118 @@ func replayHTTPTraffic(w http.ResponseWriter, r *http.Request, database *sql.DB)
 		}
 	}
 
-	// Set status code and write response body
+	// Bodies recorded decoded are encoded again when the client accepts it
+	if enc := best.Candidate.BodyEncoding; enc != "" && respBlob == nil {
+		respBody = encodeReplayBody(w, r, enc, respBody)
+	}
+
+	// Streams are re-emitted event by event with their recorded pacing
+	var chunks []streamChunk
+	if recorded := best.Candidate.ResponseChunks; recorded != "" && respBlob == nil {
+		if err := json.Unmarshal([]byte(recorded), &chunks); err != nil {
+			slog.Warn("Error parsing recorded stream events, replaying the body at once", "record_id", best.Candidate.ID, "error", err)
+			chunks = nil
+		}
+	}
+
+	// Set status code and write response body; cached AI responses stay flagged
+	if best.Candidate.Source == db.SourceAI {
+		w.Header().Set(sourceHeader, db.SourceAI)
+	} else {
+		w.Header().Set(sourceHeader, db.SourceReplay)
+	}
+
+	// Recorded latency is reproduced when configured for the route
+	timing := replayTimingFor(cfg, r, best, results, chunks)
+	if cfg.Replay.Debug && timing.wait > 0 {
+		w.Header().Set("X-Jarvis-Replay-Latency", timing.wait.String())
+	}
+	if !waitForReplay(w, r, timing.wait) {
+		slog.Info("Client went away during replay latency", "method", r.Method, "url", r.URL.String(), "record_id", best.Candidate.ID)
+		return true
+	}
+
 	w.WriteHeader(status)
-	if len(respBody) > 0 {
+	if chunks != nil {
+		if err := replayStream(w, r, chunks, timing.pacing); err != nil {
+			slog.Warn("Error writing replayed HTTP stream", "method", r.Method, "url", r.URL.String(), "error", err)
+		}
+	} else if respBlob != nil {
+		if _, err := io.Copy(w, respBlob); err != nil {
+			slog.Warn("Error writing replayed HTTP response", "method", r.Method, "url", r.URL.String(), "error", err)
+		}
+	} else if len(respBody) > 0 {
 		_, err := w.Write(respBody)
 		if err != nil {
 			// Log error if writing response fails (e.g., client disconnected)
 			slog.Warn("Error writing replayed HTTP response", "method", r.Method, "url", r.URL.String(), "error", err)
 		}
 	}
-	slog.Info("Replayed HTTP response", "status", status, "method", r.Method, "url", r.URL.String())
+	metrics.ReplayLookups.WithLabelValues("HTTP", metrics.ResultHit).Inc()
+	slog.Info("Replayed HTTP response", "status", status, "method", r.Method, "url", r.URL.String(), "record_id", best.Candidate.ID, "score", best.Score)
+	return true
+}
+
+// failOffline rejects a request that has no recording in strict offline mode.
+// The error is logged and returned as a 502 so it cannot pass for a recorded 404.
+func failOffline(w http.ResponseWriter, r *http.Request, reason string) {
+	slog.Error("Strict offline replay miss", "method", r.Method, "url", r.URL.String(), "reason", reason)
+	w.Header().Set("X-Jarvis-Replay-Miss", "true")
+	http.Error(w, fmt.Sprintf("Strict offline mode: %s for %s %s", reason, r.Method, r.URL.String()), http.StatusBadGateway)
+}
+
+// loadReplayCandidates returns the HTTP recordings of the request's method and
+// path, newest first, with the columns needed to score them. Responses
+// generated by the AI fallback are included when includeAI is set. The
+// response of the chosen candidate is loaded with loadReplayResponse.
+func loadReplayCandidates(database *sql.DB, r *http.Request, includeAI bool) ([]replayCandidate, error) {
+	query := `SELECT id, timestamp, url, COALESCE(session_id, ''), COALESCE(test_id, ''),
+              request_headers, request_body, COALESCE(source, 'live'), COALESCE(duration_ms, 0)
+              FROM traffic_records
+              WHERE protocol = 'HTTP' AND method = ?
+              AND (url LIKE ? ESCAPE '\' OR url LIKE ? ESCAPE '\' OR url LIKE ? ESCAPE '\' OR url LIKE ? ESCAPE '\')
+              AND (COALESCE(source, 'live') = 'live' OR (? AND source = 'ai'))
+              ORDER BY timestamp DESC LIMIT ?`
+
+	// Recorded URLs are relative, or absolute in forward-proxy mode, with or
+	// without a query
+	path := escapeLike(r.URL.EscapedPath())
+	rows, err := database.Query(query, r.Method, path, path+"?%", "%://%"+path, "%://%"+path+"?%", includeAI, maxReplayCandidates)
+	if err != nil {
+		return nil, fmt.Errorf("querying replay candidates: %w", err)
+	}
+	defer rows.Close()
+
+	var candidates []replayCandidate
+	for rows.Next() {
+		var c replayCandidate
+		var reqHeaders string
+		if err := rows.Scan(&c.ID, &c.Timestamp, &c.URL, &c.SessionID, &c.TestID, &reqHeaders, &c.RequestBody, &c.Source, &c.Duration); err != nil {
+			return nil, fmt.Errorf("scanning replay candidate: %w", err)
+		}
+		if err := json.Unmarshal([]byte(reqHeaders), &c.RequestHeaders); err != nil {
+			c.RequestHeaders = http.Header{}
+		}
+		candidates = append(candidates, c)
+	}
+	return candidates, rows.Err()
+}
+
+// loadReplayResponse fills in the recorded response of a candidate
+func loadReplayResponse(database *sql.DB, c *replayCandidate) error {
+	err := database.QueryRow(`SELECT response_status, response_headers, response_body,
+              COALESCE(response_body_blob, ''), COALESCE(response_encoding, ''), COALESCE(response_chunks, '')
+              FROM traffic_records WHERE id = ?`, c.ID).
+		Scan(&c.ResponseStatus, &c.ResponseHeaders, &c.ResponseBody, &c.ResponseBlob, &c.BodyEncoding, &c.ResponseChunks)
+	if err != nil {
+		return fmt.Errorf("loading recorded response %s: %w", c.ID, err)
+	}
+	return nil
+}
+
+// escapeLike escapes the LIKE wildcards in s, which may hold percent-encoded bytes
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
 }
 
 // getClientIP extracts the client IP from the request
@@ -479,45 +709,6 @@ func getClientIP(r *http.Request) string {
 	return ip
 }
 
-// saveTrafficRecord saves a traffic record to SQLite
-func saveTrafficRecord(record db.TrafficRecord, insertStmt *sql.Stmt) error {
-	slog.Info("Attempting to save record to database", "record_id", record.ID)
-
-	// Log record details in a structured way
-	slog.Info("Record details", "method", record.Method, "url", record.URL, "status", record.ResponseStatus, "size_bytes", len(record.ResponseBody))
-
-	// Add timeout to database operation
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	_, err := insertStmt.ExecContext(ctx,
-		record.ID,
-		record.Timestamp,
-		record.Protocol,
-		record.Method,
-		record.URL,
-		record.Service,
-		record.RequestHeaders,
-		record.RequestBody,
-		record.ResponseStatus,
-		record.ResponseHeaders,
-		record.ResponseBody,
-		record.Duration,
-		record.ClientIP,
-		record.TestID,
-		record.SessionID,
-		record.ConnectionID,
-		record.MessageType,
-		record.Direction,
-	)
-	if err != nil {
-		return fmt.Errorf("saving record %s: %w", record.ID, err)
-	}
-
-	slog.Info("Record saved successfully", "record_id", record.ID)
-	return nil
-}
-
 // generateID creates a unique ID for a traffic record
 func generateID() string {
 	return uuid.NewString()
@@ -530,25 +721,76 @@ func handleHTTPRequest(
 	proxy *httputil.ReverseProxy,
 	cfg *config.Config,
 	database *sql.DB,
-	insertStmt *sql.Stmt,
+	records *db.Writer,
 	responseBufPool *sync.Pool,
-	apiValidator *validator.APIValidator,
+	validators *specValidators,
+	matcher requestMatcher,
+	loadedPlugins *pluginSet,
 ) {
 	startTime := time.Now()
 
+	// Join the caller's trace or start one; the record stores its ID
+	r, span := startServerSpan(r)
+	sw := &statusWriter{ResponseWriter: w}
+	w = sw
+	defer endServerSpan(span, sw)
+
 	// Add request size limit
 	if r.ContentLength > maxRequestSize {
 		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
 		return
 	}
 
+	// --- WebSocket Upgrade ---
+	// In record-missing mode connections without a recording reach the target
+	if isWebSocketUpgrade(r) {
+		if !cfg.IsReplaying() || !replayWebSocket(w, r, cfg, database) {
+			proxyWebSocket(w, r, proxy, cfg, records)
+		}
+		return
+	}
+
+	// Streamed responses are not cut off by the server's write timeout
+	w = &streamWriter{ResponseWriter: w}
+
+	// --- Fault Injection ---
+	fault := rollFault(selectFaultRule(cfg.Faults, r))
+	if fault != nil {
+		slog.Info("Injecting fault", "method", r.Method, "url", r.URL.String(), "fault", fault.String())
+		w.Header().Set(faultHeader, fault.String())
+		if !sleepContext(r.Context(), fault.Delay) {
+			return
+		}
+		if fault.Truncate || fault.Bandwidth > 0 {
+			fw := newFaultWriter(w, fault)
+			defer fw.finish(r)
+			w = fw
+		}
+	}
+
+	// The director and response rewriter share the selected rewrite rules and
+	// capture the upstream view of the exchange through the request context
+	r, routing := withRouteState(r, cfg)
+	r, rewrites := withRewriteState(r)
+	r, plugins := withPluginState(r, loadedPlugins)
+	defer plugins.done()
+
+	// Requests are validated against the OpenAPI spec of their route
+	apiValidator := validators.forRequest(r, cfg)
+	if validators != nil && apiValidator == nil {
+		reportUnvalidated(w, r, cfg)
+	}
+
 	// --- Request Handling ---
-	reqHeadersBytes, _ := json.Marshal(r.Header)
+	// Secrets and PII are masked before anything is logged or stored
+	red := redactorFor(cfg)
+	reqHeadersBytes := redactedHeaderJSON(red, r.Header)
+	recordedURL := red.URL(r.URL.String())
 	clientIP := getClientIP(r)
 
 	// Enhanced logging in record mode
-	if cfg.RecordingMode {
-		slog.Info("Recording request", "method", r.Method, "url", r.URL.String(), "client_ip", clientIP)
+	if cfg.IsRecording() {
+		slog.Info("Recording request", "method", r.Method, "url", recordedURL, "client_ip", clientIP)
 		slog.Info("Request headers", "headers", string(reqHeadersBytes))
 	}
 
@@ -556,13 +798,24 @@ func handleHTTPRequest(
 	var reqBodyBytes []byte
 	var reqBodyErr error
 	var isLargeBody bool
-	
-	if (cfg.RecordingMode || (apiValidator != nil && cfg.APIValidation.ValidateRequests)) && r.Body != nil && r.ContentLength != 0 {
+	var reqBlob *teeBody
+	blobs := blobsFor(cfg)
+
+	if (cfg.IsRecording() || cfg.IsReplaying() || (apiValidator != nil && cfg.APIValidation.ValidateRequests) || plugins.has(plugin.RequestReceived)) && r.Body != nil && r.ContentLength != 0 {
 		// Check if body is too large for full buffering
 		if r.ContentLength > streamThreshold {
 			isLargeBody = true
 			reqBodyBytes = []byte(fmt.Sprintf("<streaming-body-size:%d>", r.ContentLength))
 			slog.Info("Large request body detected, using streaming mode", "size", r.ContentLength)
+			if cfg.IsRecording() && blobs != nil {
+				if spill, err := blobs.NewWriter(); err != nil {
+					slog.Warn("Error capturing large request body", "error", err)
+				} else {
+					defer spill.Abort()
+					reqBlob = &teeBody{ReadCloser: r.Body, blob: spill}
+					r.Body = reqBlob
+				}
+			}
 		} else {
 			// Buffer small bodies for validation and recording
 			body, errRead := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
@@ -571,12 +824,13 @@ func handleHTTPRequest(
 				slog.Warn("Error reading request body", "method", r.Method, "url", r.URL.String(), "error", reqBodyErr)
 			} else {
 				reqBodyBytes = body
-				if cfg.RecordingMode && len(reqBodyBytes) > 0 {
+				if cfg.IsRecording() && len(reqBodyBytes) > 0 {
 					// Log the request body in a readable format
-					if len(reqBodyBytes) > 1024 {
-						slog.Info("Request body (truncated)", "body", string(reqBodyBytes[:1024]))
+					logBody := red.Body(reqBodyBytes)
+					if len(logBody) > 1024 {
+						slog.Info("Request body (truncated)", "body", string(logBody[:1024]))
 					} else {
-						slog.Info("Request body", "body", string(reqBodyBytes))
+						slog.Info("Request body", "body", string(logBody))
 					}
 				}
 				r.Body = io.NopCloser(bytes.NewReader(reqBodyBytes))
@@ -586,7 +840,17 @@ func handleHTTPRequest(
 		defer r.Body.Close()
 	}
 
+	// --- Plugins ---
+	// Plugins may change the request before it is validated, replayed or
+	// recorded, or answer it themselves
+	var pluginResp *plugin.Response
+	if plugins.has(plugin.RequestReceived) {
+		reqBodyBytes, pluginResp = plugins.requestReceived(r, reqBodyBytes, !isLargeBody && reqBodyErr == nil)
+		reqHeadersBytes = redactedHeaderJSON(red, r.Header)
+	}
+
 	// --- API Validation for Request ---
+	var validationErrs []db.ValidationError
 	if apiValidator != nil && cfg.APIValidation.ValidateRequests && !isLargeBody {
 		// Skip validation for large bodies to avoid memory issues
 		reqCopy := r.Clone(r.Context())
@@ -594,17 +858,24 @@ func handleHTTPRequest(
 			reqCopy.Body = io.NopCloser(bytes.NewReader(reqBodyBytes))
 		}
 
-		if err := apiValidator.ValidateRequest(reqCopy); err != nil {
+		_, validateSpan := tracer.Start(r.Context(), "validate request")
+		err := apiValidator.ValidateRequest(reqCopy)
+		endSpan(validateSpan, err)
+		if err != nil {
 			slog.Warn("OpenAPI request validation failed", "method", r.Method, "path", r.URL.Path, "error", err)
+			metrics.ValidationFailures.WithLabelValues(metrics.KindRequest).Inc()
 
 			// If we're not continuing on validation errors, return immediately
 			if !cfg.APIValidation.ContinueOnValidation {
-				http.Error(w, fmt.Sprintf("Request validation error: %v", err), http.StatusBadRequest)
+				body := writeValidationProblem(w, r, http.StatusBadRequest, err)
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write(body)
 				return
 			}
 
 			// Add validation error header if continuing
 			w.Header().Set("X-API-Validation-Error", "request")
+			validationErrs = validationRecords(err, validator.KindRequest, red, red.Body(reqBodyBytes))
 		} else {
 			slog.Info("Request passed OpenAPI validation", "method", r.Method, "path", r.URL.Path)
 		}
@@ -614,14 +885,25 @@ func handleHTTPRequest(
 
 	// --- Replay Mode ---
 	if cfg.ReplayMode {
-		replayHTTPTraffic(w, r, database)
+		if serveFault(w, fault) {
+			return
+		}
+		if pluginResp != nil {
+			writePluginResponse(w, pluginResp)
+			return
+		}
+		if replayHTTPTraffic(w, r, database, records, matcher, apiValidator, cfg, reqBodyBytes) {
+			return
+		}
+		w.Header().Set(sourceHeader, db.SourceLive)
+		proxy.ServeHTTP(w, r)
 		return
 	}
 
 	// --- Recording or Passthrough Mode ---
 	var recorder *responseRecorder
 	writer := w
-	var needsRecording = cfg.RecordingMode
+	var needsRecording = cfg.IsRecording()
 	var needsValidation = apiValidator != nil && cfg.APIValidation.ValidateResponses
 
 	// Always use recorder if we need to validate the response or record non-large responses
@@ -635,28 +917,63 @@ func handleHTTPRequest(
 			statusCode:     http.StatusOK,
 			body:           responseBuf,
 			header:         http.Header{},
-			streamMode:     isLargeBody, // Enable streaming for large responses
+			streamMode:     isLargeBody && blobs == nil, // Enable streaming for large responses
 			maxBufferSize:  streamThreshold,
 		}
+		if needsRecording && blobs != nil {
+			recorder.blobs = blobs
+			defer recorder.discard()
+		}
 		writer = recorder
 
 		// Log target URL in record mode
-		if cfg.RecordingMode {
-			targetURL := cfg.GetTargetURL(r.URL.Path)
+		if cfg.IsRecording() {
+			targetURL := cfg.TargetURLFor(r)
 			slog.Info("Proxying request to target", "target_url", targetURL)
 		}
 	}
 
-	// Serve the request using the proxy
-	proxy.ServeHTTP(writer, r)
+	// Serve the request using the proxy; in record-missing mode recorded
+	// matches are replayed and only misses reach the target
+	source := db.SourceLive
+	switch {
+	case serveFault(writer, fault):
+		source = db.SourceFault
+		if fault.Reset && recorder != nil {
+			recorder.statusCode = 0
+		}
+	case pluginResp != nil:
+		writePluginResponse(writer, pluginResp)
+		source = db.SourcePlugin
+	case cfg.RecordMissing && replayHTTPTraffic(writer, r, database, records, matcher, apiValidator, cfg, reqBodyBytes):
+		source = db.SourceReplay
+	default:
+		if cfg.RecordMissing {
+			writer.Header().Set(sourceHeader, db.SourceLive)
+		}
+		proxy.ServeHTTP(writer, r)
+		if plugins.servedByPlugin() {
+			source = db.SourcePlugin
+		}
+	}
+
+	// Compressed responses are validated, stored and displayed decoded; the
+	// client has already received the original bytes
+	var respBody []byte
+	var respEncoding string
+	if recorder != nil && recorder.complete() {
+		respBody, respEncoding = decodeResponse(recorder.Header(), recorder.body.Bytes())
+	}
 
 	// --- API Validation for Response ---
-	if apiValidator != nil && cfg.APIValidation.ValidateResponses && recorder != nil && !recorder.streamMode {
+	if apiValidator != nil && cfg.APIValidation.ValidateResponses && recorder != nil && recorder.complete() && source == db.SourceLive {
 		// Only validate non-streaming responses
-		respBody := recorder.body.Bytes()
+		_, validateSpan := tracer.Start(r.Context(), "validate response")
 		err := apiValidator.ValidateResponse(r, recorder.statusCode, recorder.header, respBody)
+		endSpan(validateSpan, err)
 		if err != nil {
 			slog.Warn("OpenAPI response validation failed", "method", r.Method, "path", r.URL.Path, "error", err)
+			metrics.ValidationFailures.WithLabelValues(metrics.KindResponse).Inc()
 
 			// If not continuing on validation errors and response isn't sent yet, return error
 			if !cfg.APIValidation.ContinueOnValidation {
@@ -669,15 +986,18 @@ func handleHTTPRequest(
 			if recorder != nil {
 				recorder.Header().Set("X-API-Validation-Error", "response")
 			}
+			validationErrs = append(validationErrs, validationRecords(err, validator.KindResponse, red, red.Body(respBody))...)
 		} else {
 			slog.Info("Response passed OpenAPI validation", "method", r.Method, "path", r.URL.Path)
 		}
-	} else if apiValidator != nil && recorder != nil && recorder.streamMode {
+	} else if apiValidator != nil && recorder != nil && !recorder.complete() {
 		slog.Info("Skipping response validation for streaming response")
 	}
 
 	// --- Recording (after response) ---
-	if cfg.RecordingMode && recorder != nil {
+	// Replay hits of record-missing mode are only stored if asked, so replayed
+	// runs do not grow the database
+	if cfg.IsRecording() && recorder != nil && (source != db.SourceReplay || cfg.Replay.LogHits) {
 		// Calculate duration
 		duration := time.Since(startTime).Milliseconds()
 
@@ -688,18 +1008,33 @@ func handleHTTPRequest(
 		
 		// Marshal headers using pooled buffer
 		encoder := json.NewEncoder(buf)
-		encoder.Encode(recorder.Header())
+		encoder.Encode(red.Header(recorder.Header()))
 		respHeadersBytes := make([]byte, buf.Len())
 		copy(respHeadersBytes, buf.Bytes())
 
 		// Handle response body based on streaming mode
 		var respBodyBytes []byte
-		if recorder.streamMode && recorder.body.Len() > streamThreshold {
-			// For large streaming responses, store metadata instead of full body
-			respBodyBytes = []byte(fmt.Sprintf("<streaming-response-size:%d>", recorder.body.Len()))
-			slog.Info("Large response body detected, storing metadata only", "size", recorder.body.Len())
-		} else {
-			respBodyBytes = recorder.body.Bytes()
+		var respBodyBlob string
+		if recorder.spill != nil {
+			respBodyBlob = commitBody(recorder.spill, red)
+		}
+		switch {
+		case respBodyBlob != "":
+			slog.Info("Large response body stored in the blob store", "size", recorder.bytesWritten, "blob", respBodyBlob)
+		case recorder.complete():
+			// Copy, the recorder's buffer returns to the pool before the record is stored
+			respBodyBytes = bytes.Clone(red.Body(respBody))
+		default:
+			// Only part of the body was captured, store its size instead
+			respBodyBytes = []byte(fmt.Sprintf("<streaming-response-size:%d>", recorder.bytesWritten))
+			slog.Info("Large response body detected, storing metadata only", "size", recorder.bytesWritten)
+		}
+
+		var reqBodyBlob string
+		if reqBlob != nil {
+			if reqBodyBlob = reqBlob.finish(red); reqBodyBlob != "" {
+				reqBodyBytes = nil
+			}
 		}
 
 		// Enhanced logging for response
@@ -707,7 +1042,7 @@ func handleHTTPRequest(
 		slog.Info("Response headers", "headers", string(respHeadersBytes))
 
 		// Log response body in a readable format (truncate if too large)
-		if len(respBodyBytes) > 0 && !recorder.streamMode {
+		if len(respBodyBytes) > 0 && recorder.complete() {
 			if len(respBodyBytes) > 1024 {
 				slog.Info("Response body (truncated)", "body", string(respBodyBytes[:1024]))
 			} else {
@@ -717,37 +1052,60 @@ func handleHTTPRequest(
 			slog.Info("Response body: <empty or streaming>")
 		}
 
-		// Save the record asynchronously using pooled record
-		go func() {
-			recordID := generateID()
-			slog.Info("Saving traffic record", "record_id", recordID)
-
-			record := recordPool.Get().(*db.TrafficRecord)
-			defer recordPool.Put(record)
-			
-			// Reset and populate record
-			*record = db.TrafficRecord{
-				ID:              recordID,
-				Timestamp:       time.Now().UTC(),
-				Protocol:        "HTTP",
-				Method:          r.Method,
-				URL:             r.URL.String(),
-				RequestHeaders:  string(reqHeadersBytes),
-				RequestBody:     reqBodyBytes,
-				ResponseStatus:  recorder.statusCode,
-				ResponseHeaders: string(respHeadersBytes),
-				ResponseBody:    respBodyBytes,
-				Duration:        duration,
-				ClientIP:        clientIP,
-				SessionID:       r.Header.Get("X-Session-ID"),
-				TestID:          r.Header.Get("X-Test-ID"),
-			}
+		// Streamed responses keep their events and pacing for replay
+		var respChunks string
+		if recorder.stream != nil && recorder.complete() {
+			respChunks = streamChunksJSON(recorder.stream.finish(), red)
+		}
 
-			if err := saveTrafficRecord(*record, insertStmt); err != nil {
-				slog.Warn("Error saving recorded HTTP traffic", "error", err)
-			} else {
-				slog.Info("Successfully saved record to database", "record_id", recordID)
-			}
-		}()
+		upstream := upstreamJSON(rewrites, red)
+		var upstreamTarget string
+		if source == db.SourceLive {
+			upstreamTarget = routing.upstream
+		}
+
+		record := db.TrafficRecord{
+			ID:               generateID(),
+			Timestamp:        time.Now().UTC(),
+			Protocol:         "HTTP",
+			Method:           r.Method,
+			URL:              recordedURL,
+			RequestHeaders:   string(reqHeadersBytes),
+			RequestBody:      red.Body(reqBodyBytes),
+			ResponseStatus:   recorder.statusCode,
+			ResponseHeaders:  string(respHeadersBytes),
+			ResponseBody:     respBodyBytes,
+			Duration:         duration,
+			ClientIP:         clientIP,
+			SessionID:        r.Header.Get("X-Session-ID"),
+			TestID:           r.Header.Get("X-Test-ID"),
+			Source:           source,
+			Upstream:         upstream,
+			UpstreamTarget:   upstreamTarget,
+			RequestBodyBlob:  reqBodyBlob,
+			ResponseBodyBlob: respBodyBlob,
+			ResponseEncoding: respEncoding,
+			TraceID:          traceIDOf(r),
+			ResponseChunks:   respChunks,
+			ValidationErrors: validationErrs,
+		}
+		if fault != nil {
+			record.Fault = fault.String()
+		}
+		if plugins != nil {
+			plugins.beforePersist(r.Context(), &record, !isLargeBody && reqBodyBlob == "", respBodyBlob == "" && recorder.complete())
+			record.Tags = plugins.tagsJSON()
+		}
+
+		// Queue the record; the writer applies backpressure when it falls behind
+		_, persistSpan := tracer.Start(r.Context(), "persist traffic record",
+			trace.WithAttributes(attribute.String("jarvis.record_id", record.ID)))
+		err := records.Write(record)
+		endSpan(persistSpan, err)
+		if err != nil {
+			slog.Warn("Error saving recorded HTTP traffic", "error", err)
+		} else {
+			slog.Info("Queued traffic record", "record_id", record.ID)
+		}
 	}
 }
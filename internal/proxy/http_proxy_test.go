@@ -4,18 +4,21 @@ import (
 	"bytes"
 	"context"
 	"database/sql"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"slices"
 	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
 )
 
 // MockServer creates a test HTTP server that returns predefined responses
@@ -80,18 +83,30 @@ func TestStartHTTPProxy_PassthroughMode(t *testing.T) {
             session_id TEXT,
 			connection_id TEXT,
         	message_type INTEGER,
-        	direction TEXT
+        	direction TEXT,
+        	source TEXT,
+        	fault TEXT,
+        	upstream TEXT,
+        	upstream_target TEXT,
+        	request_body_blob TEXT,
+        	response_body_blob TEXT,
+        	response_encoding TEXT,
+        	trace_id TEXT,
+        	response_chunks TEXT,
+        	tags TEXT
         );
     `)
 	if err != nil {
 		t.Fatalf("Failed to create table: %v", err)
 	}
 
-	stmt, err := db.Prepare(`INSERT INTO traffic_records VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	stmt, err := db.Prepare(`INSERT INTO traffic_records VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
 	if err != nil {
 		t.Fatalf("Failed to prepare statement: %v", err)
 	}
 	defer stmt.Close()
+	records := newTestWriter(db, stmt)
+	defer records.Close(context.Background())
 
 	// Create config for test
 	cfg := &config.Config{
@@ -105,7 +120,7 @@ func TestStartHTTPProxy_PassthroughMode(t *testing.T) {
 	defer cancel()
 
 	// Start proxy server
-	proxyServer := StartHTTPProxy(ctx, cfg, db, stmt)
+	proxyServer := StartHTTPProxy(ctx, cfg, db, records)
 	defer proxyServer.Shutdown(context.Background())
 
 	// Wait a moment for server to start
@@ -116,11 +131,11 @@ func TestStartHTTPProxy_PassthroughMode(t *testing.T) {
 	testHandler := func(w http.ResponseWriter, r *http.Request) {
 		handleHTTPRequest(w, r, httputil.NewSingleHostReverseProxy(
 			&url.URL{Scheme: "http", Host: strings.TrimPrefix(targetServer.URL, "http://")},
-		), cfg, db, stmt, &sync.Pool{
+		), cfg, db, records, &sync.Pool{
 			New: func() any {
 				return new(bytes.Buffer)
 			},
-		}, nil)
+		}, nil, newRuleMatcher(cfg), nil)
 	}
 
 	proxyTestServer := httptest.NewServer(http.HandlerFunc(testHandler))
@@ -147,4 +162,429 @@ func TestStartHTTPProxy_PassthroughMode(t *testing.T) {
 	}
 }
 
-// More tests can be added for recording mode, replay mode, etc.
+func TestRecordMissingMode(t *testing.T) {
+	var upstreamCalls int
+	var mu sync.Mutex
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		upstreamCalls++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"path":"` + r.URL.Path + `"}`))
+	}))
+	defer targetServer.Close()
+
+	tempDB, err := os.CreateTemp("", "test_hybrid_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		RecordMissing: true,
+		Replay:        config.ReplayConfig{MinScore: 1, LogHits: true},
+	}
+	target, _ := url.Parse(targetServer.URL)
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, database, records, pool)
+
+	get := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodGet, "/users", nil))
+		return rr
+	}
+
+	// The first request misses, goes upstream and is recorded
+	first := get()
+	if first.Code != http.StatusOK || first.Body.String() != `{"path":"/users"}` {
+		t.Fatalf("Unexpected live response: %d %s", first.Code, first.Body.String())
+	}
+	if got := first.Header().Get(sourceHeader); got != db.SourceLive {
+		t.Errorf("Expected %s header %q, got %q", sourceHeader, db.SourceLive, got)
+	}
+	waitForSource(t, database, db.SourceLive, 1)
+
+	// The second request is served from the recording
+	second := get()
+	if second.Body.String() != first.Body.String() {
+		t.Errorf("Replayed body %s does not match recorded %s", second.Body.String(), first.Body.String())
+	}
+	if got := second.Header().Get(sourceHeader); got != db.SourceReplay {
+		t.Errorf("Expected %s header %q, got %q", sourceHeader, db.SourceReplay, got)
+	}
+	mu.Lock()
+	calls := upstreamCalls
+	mu.Unlock()
+	if calls != 1 {
+		t.Errorf("Expected 1 upstream call, got %d", calls)
+	}
+
+	// Replayed exchanges are logged for the UI but never used as recordings
+	waitForSource(t, database, db.SourceReplay, 1)
+	third := get()
+	if third.Header().Get(sourceHeader) != db.SourceReplay {
+		t.Errorf("Expected third request to be replayed")
+	}
+}
+
+func TestRecordMissingSkipsReplayHits(t *testing.T) {
+	targetServer := createMockServer()
+	defer targetServer.Close()
+
+	tempDB, err := os.CreateTemp("", "test_hybrid_hits_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		RecordMissing: true,
+		Replay:        config.ReplayConfig{MinScore: 1},
+	}
+	target, _ := url.Parse(targetServer.URL)
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, database, records, pool)
+
+	handler(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/users", nil))
+	waitForSource(t, database, db.SourceLive, 1)
+	for i := 0; i < 3; i++ {
+		rr := httptest.NewRecorder()
+		handler(rr, httptest.NewRequest(http.MethodGet, "/users", nil))
+		if rr.Header().Get(sourceHeader) != db.SourceReplay {
+			t.Fatalf("Expected request %d to be replayed", i+2)
+		}
+	}
+	if err := records.Close(context.Background()); err != nil {
+		t.Fatalf("Failed to flush records: %v", err)
+	}
+
+	var count int
+	if err := database.QueryRow(`SELECT COUNT(*) FROM traffic_records`).Scan(&count); err != nil {
+		t.Fatalf("Failed to count records: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("Expected only the live exchange to be stored, got %d records", count)
+	}
+}
+
+func TestStrictOfflineFailsOnMiss(t *testing.T) {
+	targetServer := createMockServer()
+	defer targetServer.Close()
+
+	tempDB, err := os.CreateTemp("", "test_offline_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		ReplayMode:    true,
+		StrictOffline: true,
+		Replay:        config.ReplayConfig{MinScore: 1},
+	}
+	target, _ := url.Parse(targetServer.URL)
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, database, records, pool)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/users", nil))
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("Expected 502 for a miss in strict offline mode, got %d", rr.Code)
+	}
+	if rr.Header().Get("X-Jarvis-Replay-Miss") != "true" {
+		t.Error("Expected the miss to be flagged")
+	}
+	if strings.Contains(rr.Body.String(), "user1") {
+		t.Error("Strict offline mode must not reach the target")
+	}
+}
+
+func TestLoadReplayCandidatesByPath(t *testing.T) {
+	tempDB, err := os.CreateTemp("", "test_candidates_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	// Newer recordings of longer paths must not crowd out the requested one
+	start := time.Now().UTC()
+	urls := []string{"/users/1?page=2", "http://api.example.com/users/1", "/users/axb"}
+	for i := range maxReplayCandidates {
+		urls = append(urls, fmt.Sprintf("/users/1%d", i))
+	}
+	for i, u := range urls {
+		err := records.Write(db.TrafficRecord{
+			ID: fmt.Sprintf("rec-%d", i), Timestamp: start.Add(time.Duration(i) * time.Millisecond), Protocol: "HTTP",
+			Method: http.MethodGet, URL: u, RequestHeaders: "{}", ResponseStatus: http.StatusOK, ResponseHeaders: "{}",
+			ResponseBody: []byte(u),
+		})
+		if err != nil {
+			t.Fatalf("Failed to write record: %v", err)
+		}
+	}
+	waitForSource(t, database, db.SourceLive, len(urls))
+
+	candidates, err := loadReplayCandidates(database, httptest.NewRequest(http.MethodGet, "/users/1", nil), false)
+	if err != nil {
+		t.Fatalf("Failed to load candidates: %v", err)
+	}
+	if len(candidates) != 2 || candidates[0].URL != urls[1] || candidates[1].URL != urls[0] {
+		t.Fatalf("Expected the two recordings of /users/1, got %+v", candidates)
+	}
+	if candidates[0].ResponseBody != nil {
+		t.Error("Expected responses to be loaded for the chosen candidate only")
+	}
+	if err := loadReplayResponse(database, &candidates[0]); err != nil {
+		t.Fatalf("Failed to load response: %v", err)
+	}
+	if string(candidates[0].ResponseBody) != urls[1] || candidates[0].ResponseStatus != http.StatusOK {
+		t.Errorf("Unexpected response %d %q", candidates[0].ResponseStatus, candidates[0].ResponseBody)
+	}
+
+	// LIKE wildcards in the path are matched literally
+	candidates, err = loadReplayCandidates(database, httptest.NewRequest(http.MethodGet, "/users/a_b", nil), false)
+	if err != nil || len(candidates) != 0 {
+		t.Errorf("Expected no candidates for /users/a_b, got %d, %v", len(candidates), err)
+	}
+}
+
+// waitForSource waits until want records with the given source have been saved
+// newTestWriter returns a traffic writer that flushes quickly
+func newTestWriter(database *sql.DB, stmt *sql.Stmt) *db.Writer {
+	return db.NewWriter(database, stmt, db.WriterOptions{FlushInterval: 10 * time.Millisecond})
+}
+
+func waitForSource(t *testing.T, database *sql.DB, source string, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var count int
+		if err := database.QueryRow(`SELECT COUNT(*) FROM traffic_records WHERE source = ?`, source).Scan(&count); err == nil && count >= want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d %s records", want, source)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestRecordingRedactsSecrets(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=s3cr3t; Path=/")
+		w.Write([]byte(`{"email":"ada@example.com","plan":"pro"}`))
+	}))
+	defer targetServer.Close()
+
+	tempDB, err := os.CreateTemp("", "test_redaction_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		RecordingMode: true,
+		Redaction:     config.RedactionConfig{Rules: []config.RedactionRule{{Path: "$.email"}, {Path: "$.card", Mask: "preserve", KeepLast: 4}}},
+		Replay: config.ReplayConfig{
+			MinScore:   1,
+			MatchRules: []config.MatchRule{{PathPrefix: "/", Headers: []string{"Authorization"}, Body: "hash"}},
+		},
+	}
+	target, _ := url.Parse(targetServer.URL)
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, database, records, pool)
+
+	newRequest := func(token string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, "/account?api_key=k3y", strings.NewReader(`{"card":"4111-1111-1111-1234"}`))
+		req.Header.Set("Authorization", "Bearer "+token)
+		return req
+	}
+	rr := httptest.NewRecorder()
+	handler(rr, newRequest("t0k3n"))
+	if !strings.Contains(rr.Body.String(), "ada@example.com") {
+		t.Fatalf("The client must receive the unredacted response, got %s", rr.Body.String())
+	}
+	waitForSource(t, database, db.SourceLive, 1)
+
+	var recordedURL, reqHeaders, respHeaders string
+	var reqBody, respBody []byte
+	err = database.QueryRow(`SELECT url, request_headers, request_body, response_headers, response_body FROM traffic_records`).
+		Scan(&recordedURL, &reqHeaders, &reqBody, &respHeaders, &respBody)
+	if err != nil {
+		t.Fatalf("Failed to read record: %v", err)
+	}
+	stored := strings.Join([]string{recordedURL, reqHeaders, string(reqBody), respHeaders, string(respBody)}, "\n")
+	for _, secret := range []string{"k3y", "t0k3n", "s3cr3t", "ada@example.com", "4111"} {
+		if strings.Contains(stored, secret) {
+			t.Errorf("Secret %q was stored:\n%s", secret, stored)
+		}
+	}
+	if !strings.Contains(string(reqBody), "****-****-****-1234") {
+		t.Errorf("Expected a format-preserving card mask, got %s", reqBody)
+	}
+
+	// Replay compares redacted forms, so another token still matches the recording
+	cfg.RecordingMode, cfg.ReplayMode = false, true
+	rr = httptest.NewRecorder()
+	handler(rr, newRequest("other"))
+	if rr.Code != http.StatusOK || rr.Header().Get(sourceHeader) != db.SourceReplay {
+		t.Errorf("Expected the redacted recording to be replayed, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
+
+func TestRecordingLargeBodies(t *testing.T) {
+	large := bytes.Repeat([]byte("0123456789abcdef"), 3*streamThreshold/16)
+	var received int64
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/octet-stream")
+		// Stream the body in chunks like a download
+		for chunk := range slices.Chunk(large, 64*1024) {
+			w.Write(chunk)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer targetServer.Close()
+
+	tempDB, err := os.CreateTemp("", "test_blobs_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		RecordingMode: true,
+		BlobStore:     config.BlobStoreConfig{Dir: t.TempDir(), MaxBodySize: int64(len(large))},
+		Replay:        config.ReplayConfig{MinScore: 1},
+	}
+	target, _ := url.Parse(targetServer.URL)
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, database, records, pool)
+
+	upload := large[:2*streamThreshold]
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/download", bytes.NewReader(upload)))
+	if !bytes.Equal(rr.Body.Bytes(), large) || received != int64(len(upload)) {
+		t.Fatalf("Expected the full bodies to pass through, sent %d of %d and received %d of %d", received, len(upload), rr.Body.Len(), len(large))
+	}
+	waitForSource(t, database, db.SourceLive, 1)
+
+	var reqBody, respBody []byte
+	var reqRef, respRef string
+	err = database.QueryRow(`SELECT request_body, response_body, request_body_blob, response_body_blob FROM traffic_records`).
+		Scan(&reqBody, &respBody, &reqRef, &respRef)
+	if err != nil {
+		t.Fatalf("Failed to read record: %v", err)
+	}
+	if len(reqBody) != 0 || len(respBody) != 0 {
+		t.Errorf("Expected large bodies to be kept out of the table, got %d and %d bytes", len(reqBody), len(respBody))
+	}
+	store := blobsFor(cfg)
+	for ref, want := range map[string][]byte{reqRef: upload, respRef: large} {
+		body, _, err := store.Open(ref)
+		if err != nil {
+			t.Fatalf("Failed to open blob %q: %v", ref, err)
+		}
+		got, _ := io.ReadAll(body)
+		body.Close()
+		if !bytes.Equal(got, want) {
+			t.Errorf("Blob %q holds %d bytes, want %d", ref, len(got), len(want))
+		}
+	}
+
+	// Replay streams the body back from the blob store
+	cfg.RecordingMode, cfg.ReplayMode = false, true
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodPost, "/download", nil))
+	if rr.Header().Get(sourceHeader) != db.SourceReplay || !bytes.Equal(rr.Body.Bytes(), large) {
+		t.Errorf("Expected the large body to be replayed, got %d bytes from %q", rr.Body.Len(), rr.Header().Get(sourceHeader))
+	}
+
+	// Bodies over the maximum size are recorded by size only
+	cfg.RecordingMode, cfg.ReplayMode = true, false
+	cfg.BlobStore.MaxBodySize = streamThreshold
+	blobStores.Delete(cfg)
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/too-large", nil))
+	if !bytes.Equal(rr.Body.Bytes(), large) {
+		t.Fatalf("Expected the full body to pass through, got %d bytes", rr.Body.Len())
+	}
+	waitForSource(t, database, db.SourceLive, 2)
+	err = database.QueryRow(`SELECT response_body, COALESCE(response_body_blob, '') FROM traffic_records WHERE url = '/too-large'`).
+		Scan(&respBody, &respRef)
+	if err != nil {
+		t.Fatalf("Failed to read record: %v", err)
+	}
+	if respRef != "" || string(respBody) != fmt.Sprintf("<streaming-response-size:%d>", len(large)) {
+		t.Errorf("Expected a size placeholder, got %q and %q", respRef, respBody)
+	}
+	if entries, _ := os.ReadDir(cfg.BlobStore.Dir); len(entries) != 2 {
+		t.Errorf("Expected only the two stored blobs, got %d entries", len(entries))
+	}
+}
@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+// applyBodyPatches applies JSON Patch operations to a JSON document and
+// returns the re-encoded document
+func applyBodyPatches(body []byte, patches []config.BodyPatch) ([]byte, error) {
+	var doc any
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return nil, fmt.Errorf("decoding JSON body: %w", err)
+	}
+	for _, patch := range patches {
+		var err error
+		if doc, err = applyBodyPatch(doc, patch); err != nil {
+			return nil, fmt.Errorf("%s %s: %w", patch.Op, patch.Path, err)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// applyBodyPatch applies a single operation and returns the updated document
+func applyBodyPatch(doc any, patch config.BodyPatch) (any, error) {
+	path, err := patchPointer(patch.Path)
+	if err != nil {
+		return nil, err
+	}
+	value, err := normalizeJSONValue(patch.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	switch patch.Op {
+	case "add":
+		return pointerSet(doc, path, value, true)
+	case "replace":
+		return pointerSet(doc, path, value, false)
+	case "remove":
+		return pointerRemove(doc, path)
+	case "move", "copy":
+		from, err := patchPointer(patch.From)
+		if err != nil {
+			return nil, err
+		}
+		moved, err := pointerGet(doc, from)
+		if err != nil {
+			return nil, err
+		}
+		if patch.Op == "move" {
+			if doc, err = pointerRemove(doc, from); err != nil {
+				return nil, err
+			}
+		} else if moved, err = normalizeJSONValue(moved); err != nil {
+			// Copies must not share maps or slices with the source
+			return nil, err
+		}
+		return pointerSet(doc, path, moved, true)
+	case "test":
+		current, err := pointerGet(doc, path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(current, value) {
+			return nil, fmt.Errorf("test failed: %v != %v", current, value)
+		}
+		return doc, nil
+	}
+	return nil, fmt.Errorf("unsupported op %q", patch.Op)
+}
+
+// normalizeJSONValue round-trips a config value through JSON so it has the
+// same types as a decoded body (float64 numbers, map[string]any objects)
+func normalizeJSONValue(v any) (any, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, fmt.Errorf("encoding patch value: %w", err)
+	}
+	var out any
+	if err := json.Unmarshal(raw, &out); err != nil {
+		return nil, fmt.Errorf("decoding patch value: %w", err)
+	}
+	return out, nil
+}
+
+// patchPointer splits a JSON Pointer ("/a/0/b") or JSONPath ("$.a[0].b")
+// into reference tokens
+func patchPointer(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	if strings.HasPrefix(path, "/") {
+		tokens := strings.Split(path[1:], "/")
+		for i, t := range tokens {
+			tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+		}
+		return tokens, nil
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("path %q is neither a JSON Pointer nor a JSONPath", path)
+	}
+
+	var tokens []string
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			tokens = append(tokens, rest[:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated bracket in %q", path)
+			}
+			segment := rest[1:end]
+			if len(segment) >= 2 && (segment[0] == '\'' || segment[0] == '"') {
+				segment = segment[1 : len(segment)-1]
+			}
+			tokens = append(tokens, segment)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q in %q", rest[0], path)
+		}
+	}
+	return tokens, nil
+}
+
+// pointerGet returns the value at path
+func pointerGet(doc any, path []string) (any, error) {
+	current := doc
+	for _, token := range path {
+		switch node := current.(type) {
+		case map[string]any:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			current = v
+		case []any:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			current = node[i]
+		default:
+			return nil, fmt.Errorf("cannot descend into %T at %q", current, token)
+		}
+	}
+	return current, nil
+}
+
+// pointerSet sets the value at path. With insert, missing object members are
+// created and array elements are inserted ("-" appends); otherwise the target
+// must already exist and is replaced.
+func pointerSet(doc any, path []string, value any, insert bool) (any, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	return updateParent(doc, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			if _, ok := node[token]; !ok && !insert {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			node[token] = value
+			return node, nil
+		case []any:
+			if insert {
+				i := len(node)
+				if token != "-" {
+					var err error
+					if i, err = arrayIndex(token, len(node)); err != nil {
+						return nil, err
+					}
+				}
+				node = append(node, nil)
+				copy(node[i+1:], node[i:])
+				node[i] = value
+				return node, nil
+			}
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			node[i] = value
+			return node, nil
+		}
+		return nil, fmt.Errorf("cannot set %q in %T", token, parent)
+	})
+}
+
+// pointerRemove deletes the value at path
+func pointerRemove(doc any, path []string) (any, error) {
+	if len(path) == 0 {
+		return nil, fmt.Errorf("cannot remove the document root")
+	}
+	return updateParent(doc, path, func(parent any, token string) (any, error) {
+		switch node := parent.(type) {
+		case map[string]any:
+			if _, ok := node[token]; !ok {
+				return nil, fmt.Errorf("member %q not found", token)
+			}
+			delete(node, token)
+			return node, nil
+		case []any:
+			i, err := arrayIndex(token, len(node)-1)
+			if err != nil {
+				return nil, err
+			}
+			return append(node[:i], node[i+1:]...), nil
+		}
+		return nil, fmt.Errorf("cannot remove %q from %T", token, parent)
+	})
+}
+
+// updateParent walks to the parent of path, lets fn update it and stores the
+// result back, since updating an array may reallocate it
+func updateParent(doc any, path []string, fn func(parent any, token string) (any, error)) (any, error) {
+	if len(path) == 1 {
+		return fn(doc, path[0])
+	}
+	token := path[0]
+	switch node := doc.(type) {
+	case map[string]any:
+		child, ok := node[token]
+		if !ok {
+			return nil, fmt.Errorf("member %q not found", token)
+		}
+		updated, err := updateParent(child, path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[token] = updated
+		return node, nil
+	case []any:
+		i, err := arrayIndex(token, len(node)-1)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := updateParent(node[i], path[1:], fn)
+		if err != nil {
+			return nil, err
+		}
+		node[i] = updated
+		return node, nil
+	}
+	return nil, fmt.Errorf("cannot descend into %T at %q", doc, token)
+}
+
+// arrayIndex parses an array index token no greater than maxIndex
+func arrayIndex(token string, maxIndex int) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 || i > maxIndex {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	return i, nil
+}
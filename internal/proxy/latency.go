@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+// Replay latency modes
+const (
+	latencyRecorded     = "recorded"
+	latencyDistribution = "distribution"
+)
+
+// replayTiming is how long a replayed response waits before its headers, and
+// the scale of the recorded delays between its stream events
+type replayTiming struct {
+	wait   time.Duration
+	pacing float64
+}
+
+// replayTimingFor works out the timing of a replayed response from the
+// latency settings of its route. Without split timings, the whole latency is
+// spent before the headers; with them, only the time to first byte comes
+// first and the stream events are paced by stream_pacing scaled by the
+// latency factor.
+func replayTimingFor(cfg *config.Config, r *http.Request, best *matchResult, results []matchResult, chunks []streamChunk) replayTiming {
+	timing := replayTiming{pacing: cfg.Replay.StreamPacing}
+	latency := cfg.GetReplayLatency(r.URL.Path)
+
+	var total time.Duration
+	switch latency.Mode {
+	case latencyRecorded:
+		// The time a model took to generate a response is not latency
+		if best.Candidate.Source == db.SourceAI {
+			return timing
+		}
+		total = time.Duration(best.Candidate.Duration) * time.Millisecond
+	case latencyDistribution:
+		total = sampleRouteDuration(results)
+	default:
+		return timing
+	}
+	factor := latency.Scale()
+	total = scaleDuration(total, factor)
+
+	if latency.Split && len(chunks) > 0 {
+		transfer := scaleDuration(time.Duration(chunks[len(chunks)-1].Offset)*time.Millisecond, factor)
+		timing.wait = max(total-transfer, 0)
+		timing.pacing *= factor
+		return timing
+	}
+	timing.wait = total
+	return timing
+}
+
+// sampleRouteDuration draws the duration of a random recording of the same
+// route, so replayed latency follows the recorded distribution
+func sampleRouteDuration(results []matchResult) time.Duration {
+	var durations []int64
+	for i := range results {
+		if c := results[i].Candidate; results[i].Route && c.Source != db.SourceAI {
+			durations = append(durations, c.Duration)
+		}
+	}
+	if len(durations) == 0 {
+		return 0
+	}
+	return time.Duration(durations[rand.IntN(len(durations))]) * time.Millisecond
+}
+
+func scaleDuration(d time.Duration, factor float64) time.Duration {
+	return time.Duration(float64(d) * factor)
+}
+
+// waitForReplay holds a replayed response back for d. The server's write
+// timeout is lifted first so the reproduced latency does not cut it off.
+// It reports false when the client went away in the meantime.
+func waitForReplay(w http.ResponseWriter, r *http.Request, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		slog.Warn("Error lifting the write timeout of a delayed replay", "error", err)
+	}
+	return sleepContext(r.Context(), d)
+}
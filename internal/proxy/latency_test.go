@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+func TestReplayTimingFor(t *testing.T) {
+	live := &replayCandidate{ID: "live", Source: db.SourceLive, Duration: 400}
+	results := []matchResult{
+		{Candidate: live, Route: true},
+		{Candidate: &replayCandidate{ID: "other", Source: db.SourceLive, Duration: 900}, Route: false},
+		{Candidate: &replayCandidate{ID: "ai", Source: db.SourceAI, Duration: 5000}, Route: true},
+	}
+	chunks := []streamChunk{{Offset: 0}, {Offset: 100}, {Offset: 300}}
+
+	tests := []struct {
+		name       string
+		latency    config.ReplayLatencyConfig
+		rules      []config.MatchRule
+		noPacing   bool // replay.stream_pacing 0 instead of 0.5
+		best       *matchResult
+		chunks     []streamChunk
+		wantWait   time.Duration
+		wantPacing float64
+	}{
+		{name: "off", best: &results[0], wantPacing: 0.5},
+		{name: "recorded", latency: config.ReplayLatencyConfig{Mode: "recorded", Factor: factor(1)}, best: &results[0], wantWait: 400 * time.Millisecond, wantPacing: 0.5},
+		{name: "scaled", latency: config.ReplayLatencyConfig{Mode: "recorded", Factor: factor(0.5)}, best: &results[0], wantWait: 200 * time.Millisecond, wantPacing: 0.5},
+		{name: "scaled to nothing", latency: config.ReplayLatencyConfig{Mode: "recorded", Factor: factor(0)}, best: &results[0], wantPacing: 0.5},
+		{name: "unscaled by default", latency: config.ReplayLatencyConfig{Mode: "recorded"}, best: &results[0], wantWait: 400 * time.Millisecond, wantPacing: 0.5},
+		{name: "distribution skips other routes and AI", latency: config.ReplayLatencyConfig{Mode: "distribution", Factor: factor(1)}, best: &results[2], wantWait: 400 * time.Millisecond, wantPacing: 0.5},
+		{name: "AI response", latency: config.ReplayLatencyConfig{Mode: "recorded", Factor: factor(1)}, best: &results[2], wantPacing: 0.5},
+		{name: "stream without split", latency: config.ReplayLatencyConfig{Mode: "recorded", Factor: factor(1)}, best: &results[0], chunks: chunks, wantWait: 400 * time.Millisecond, wantPacing: 0.5},
+		{name: "split stream", latency: config.ReplayLatencyConfig{Mode: "recorded", Factor: factor(2), Split: true}, best: &results[0], chunks: chunks, wantWait: 200 * time.Millisecond, wantPacing: 1},
+		{name: "split stream without pacing", latency: config.ReplayLatencyConfig{Mode: "recorded", Factor: factor(2), Split: true}, noPacing: true, best: &results[0], chunks: chunks, wantWait: 200 * time.Millisecond},
+		{
+			name:       "route override",
+			latency:    config.ReplayLatencyConfig{Mode: "recorded", Factor: factor(1)},
+			rules:      []config.MatchRule{{PathPrefix: "/slow/", Latency: &config.ReplayLatencyConfig{Mode: "recorded", Factor: factor(3)}}},
+			best:       &results[0],
+			wantWait:   1200 * time.Millisecond,
+			wantPacing: 0.5,
+		},
+		{
+			name:       "route turned off",
+			latency:    config.ReplayLatencyConfig{Mode: "recorded", Factor: factor(1)},
+			rules:      []config.MatchRule{{PathPrefix: "/slow/", Latency: &config.ReplayLatencyConfig{Mode: "off"}}},
+			best:       &results[0],
+			wantPacing: 0.5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Replay: config.ReplayConfig{StreamPacing: 0.5, Latency: tt.latency, MatchRules: tt.rules}}
+			if tt.noPacing {
+				cfg.Replay.StreamPacing = 0
+			}
+			r := httptest.NewRequest(http.MethodGet, "/slow/1", nil)
+			got := replayTimingFor(cfg, r, tt.best, results, tt.chunks)
+			if got.wait != tt.wantWait || got.pacing != tt.wantPacing {
+				t.Errorf("replayTimingFor() = %v at pacing %v, want %v at pacing %v", got.wait, got.pacing, tt.wantWait, tt.wantPacing)
+			}
+		})
+	}
+}
+
+func TestReplayReproducesRecordedLatency(t *testing.T) {
+	tempDB, err := os.CreateTemp("", "test_replay_latency_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	if err := records.Write(db.TrafficRecord{
+		ID:              "slow",
+		Timestamp:       time.Now().UTC(),
+		Protocol:        "HTTP",
+		Method:          http.MethodGet,
+		URL:             "/orders",
+		RequestHeaders:  `{}`,
+		ResponseStatus:  http.StatusOK,
+		ResponseHeaders: `{"Content-Type":["application/json"]}`,
+		ResponseBody:    []byte(`[]`),
+		Duration:        300,
+	}); err != nil {
+		t.Fatalf("Failed to write recording: %v", err)
+	}
+	waitForSource(t, database, db.SourceLive, 1)
+
+	replay := func(latency config.ReplayLatencyConfig) (*httptest.ResponseRecorder, time.Duration) {
+		cfg := &config.Config{
+			HTTPTargetURL: "http://127.0.0.1:1",
+			ReplayMode:    true,
+			Replay:        config.ReplayConfig{MinScore: 1, Debug: true, Latency: latency},
+		}
+		target, _ := url.Parse(cfg.HTTPTargetURL)
+		pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+		handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, database, records, pool)
+		rr := httptest.NewRecorder()
+		start := time.Now()
+		handler(rr, httptest.NewRequest(http.MethodGet, "/orders", nil))
+		return rr, time.Since(start)
+	}
+
+	rr, elapsed := replay(config.ReplayLatencyConfig{})
+	if rr.Code != http.StatusOK || elapsed > 150*time.Millisecond {
+		t.Errorf("Expected an instant replay by default, got %d after %v", rr.Code, elapsed)
+	}
+
+	rr, elapsed = replay(config.ReplayLatencyConfig{Mode: "recorded", Factor: factor(1)})
+	if rr.Code != http.StatusOK || elapsed < 300*time.Millisecond {
+		t.Errorf("Expected the recorded 300ms latency, got %d after %v", rr.Code, elapsed)
+	}
+	if got := rr.Header().Get("X-Jarvis-Replay-Latency"); got != "300ms" {
+		t.Errorf("Expected the reproduced latency in the debug header, got %q", got)
+	}
+
+	// A client that gives up does not get a response
+	cfg := &config.Config{
+		HTTPTargetURL: "http://127.0.0.1:1",
+		ReplayMode:    true,
+		Replay:        config.ReplayConfig{MinScore: 1, Latency: config.ReplayLatencyConfig{Mode: "recorded", Factor: factor(10)}},
+	}
+	target, _ := url.Parse(cfg.HTTPTargetURL)
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, database, records, pool)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	rr = httptest.NewRecorder()
+	start := time.Now()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/orders", nil).WithContext(ctx))
+	if elapsed := time.Since(start); elapsed > time.Second || rr.Body.Len() != 0 {
+		t.Errorf("Expected the replay to stop with the client, got %q after %v", rr.Body.String(), elapsed)
+	}
+}
+
+func factor(f float64) *float64 { return &f }
@@ -0,0 +1,345 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/redact"
+)
+
+// replayCandidate is a recorded HTTP exchange considered for replay
+type replayCandidate struct {
+	ID              string
+	Timestamp       time.Time
+	URL             string
+	SessionID       string
+	TestID          string
+	RequestHeaders  http.Header
+	RequestBody     []byte
+	ResponseStatus  int
+	ResponseHeaders string
+	ResponseBody    []byte
+	ResponseBlob    string // Blob store reference when the body is too large for the table
+	BodyEncoding    string // Content-Encoding the stored response body was decoded from
+	Source          string // "live", or "ai" for a response generated by the AI fallback
+	ResponseChunks  string // JSON events of a streamed response, replayed with their pacing
+	Duration        int64  // Recorded duration in milliseconds, for reproducing latency
+}
+
+// matchCriterion is a single check performed against a candidate
+type matchCriterion struct {
+	Name    string
+	Matched bool
+	Detail  string
+}
+
+// matchResult is the outcome of matching a request against one candidate
+type matchResult struct {
+	Candidate *replayCandidate
+	Route     bool // Method, host and path matched; required for selection
+	Score     float64
+	Criteria  []matchCriterion
+}
+
+// explain renders the criteria as a one-line, human readable explanation
+func (m *matchResult) explain() string {
+	parts := make([]string, 0, len(m.Criteria))
+	for _, c := range m.Criteria {
+		mark := "ok"
+		if !c.Matched {
+			mark = "mismatch"
+		}
+		part := c.Name + " " + mark
+		if c.Detail != "" {
+			part += " (" + c.Detail + ")"
+		}
+		parts = append(parts, part)
+	}
+	return fmt.Sprintf("record %s score %.2f: %s", m.Candidate.ID, m.Score, strings.Join(parts, ", "))
+}
+
+// requestMatcher selects the recording to replay for a request. It returns the
+// selected candidate, or nil, along with the result for every candidate.
+type requestMatcher interface {
+	Match(r *http.Request, body []byte, candidates []replayCandidate) (*matchResult, []matchResult)
+}
+
+// ruleMatcher matches on a normalized route key and the per-route rules from
+// the replay configuration
+type ruleMatcher struct {
+	cfg      *config.Config
+	redactor *redact.Redactor
+}
+
+func newRuleMatcher(cfg *config.Config) *ruleMatcher {
+	return &ruleMatcher{cfg: cfg, redactor: redactorFor(cfg)}
+}
+
+// Match scores every candidate and picks the highest scoring one whose route
+// matches and whose score reaches replay.min_score. Candidates are expected
+// newest first, so ties go to the latest recording.
+func (m *ruleMatcher) Match(r *http.Request, body []byte, candidates []replayCandidate) (*matchResult, []matchResult) {
+	rule := m.cfg.GetMatchRule(r.URL.Path)
+
+	// Recordings are stored redacted, so compare against the redacted request
+	redacted := r.Clone(r.Context())
+	redacted.Header = m.redactor.Header(r.Header)
+	redacted.URL.RawQuery = m.redactor.Query(r.URL.RawQuery)
+	r, body = redacted, m.redactor.Body(body)
+
+	results := make([]matchResult, len(candidates))
+	var best *matchResult
+	for i := range candidates {
+		results[i] = m.score(r, body, rule, &candidates[i])
+		res := &results[i]
+		if !res.Route || res.Score < m.cfg.Replay.MinScore {
+			continue
+		}
+		if best == nil || res.Score > best.Score {
+			best = res
+		}
+	}
+	return best, results
+}
+
+// score evaluates a single candidate
+func (m *ruleMatcher) score(r *http.Request, body []byte, rule *config.MatchRule, c *replayCandidate) matchResult {
+	res := matchResult{Candidate: c}
+
+	recorded, err := url.Parse(c.URL)
+	if err != nil {
+		res.Criteria = append(res.Criteria, matchCriterion{Name: "route", Detail: "unparseable recorded URL"})
+		return res
+	}
+
+	// The route key ignores the scheme and host, since recordings carry the
+	// upstream URL while replayed requests arrive with a relative one. Hosts are
+	// only compared when both sides have one, as in forward-proxy mode.
+	wantKey := routeKey(r.Method, r.URL)
+	gotKey := routeKey(r.Method, recorded)
+	route := matchCriterion{Name: "route", Matched: wantKey == gotKey}
+	if route.Matched && r.URL.Host != "" && recorded.Host != "" && !strings.EqualFold(r.URL.Host, recorded.Host) {
+		route.Matched = false
+		route.Detail = fmt.Sprintf("host %s != %s", recorded.Host, r.URL.Host)
+	} else if !route.Matched {
+		route.Detail = fmt.Sprintf("%s != %s", gotKey, wantKey)
+	}
+	res.Criteria = append(res.Criteria, route)
+	res.Route = route.Matched
+
+	var checks []matchCriterion
+
+	queryMode := "sort"
+	var ignored []string
+	if rule != nil {
+		ignored = rule.IgnoreQuery
+		if rule.Query != "" {
+			queryMode = rule.Query
+		}
+	}
+	if queryMode != "ignore" {
+		want := normalizeQuery(r.URL.RawQuery, queryMode, ignored)
+		got := normalizeQuery(recorded.RawQuery, queryMode, ignored)
+		q := matchCriterion{Name: "query", Matched: want == got}
+		if !q.Matched {
+			q.Detail = fmt.Sprintf("%q != %q", got, want)
+		}
+		checks = append(checks, q)
+	}
+
+	if rule != nil {
+		for _, name := range rule.Headers {
+			want := strings.Join(r.Header.Values(name), ",")
+			got := strings.Join(c.RequestHeaders.Values(name), ",")
+			h := matchCriterion{Name: "header " + http.CanonicalHeaderKey(name), Matched: want == got}
+			if !h.Matched {
+				h.Detail = fmt.Sprintf("%q != %q", got, want)
+			}
+			checks = append(checks, h)
+		}
+
+		switch rule.Body {
+		case "hash":
+			want, got := bodyHash(body), bodyHash(c.RequestBody)
+			b := matchCriterion{Name: "body hash", Matched: want == got}
+			if !b.Matched {
+				b.Detail = fmt.Sprintf("%s != %s", got[:12], want[:12])
+			}
+			checks = append(checks, b)
+		case "jsonpath":
+			var wantDoc, gotDoc any
+			wantErr := json.Unmarshal(body, &wantDoc)
+			gotErr := json.Unmarshal(c.RequestBody, &gotDoc)
+			for _, path := range rule.BodyPaths {
+				b := matchCriterion{Name: "body " + path}
+				if wantErr != nil || gotErr != nil {
+					b.Detail = "body is not JSON"
+					checks = append(checks, b)
+					continue
+				}
+				want, wantOK := jsonPathLookup(wantDoc, path)
+				got, gotOK := jsonPathLookup(gotDoc, path)
+				b.Matched = wantOK == gotOK && reflect.DeepEqual(want, got)
+				if !b.Matched {
+					b.Detail = fmt.Sprintf("%v != %v", got, want)
+				}
+				checks = append(checks, b)
+			}
+		}
+	}
+
+	res.Criteria = append(res.Criteria, checks...)
+	if !res.Route {
+		return res
+	}
+	res.Score = 1
+	if len(checks) > 0 {
+		matched := 0
+		for _, c := range checks {
+			if c.Matched {
+				matched++
+			}
+		}
+		res.Score = float64(matched) / float64(len(checks))
+	}
+	return res
+}
+
+// routeKey returns the normalized "METHOD /path" key of a request
+func routeKey(method string, u *url.URL) string {
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	return strings.ToUpper(method) + " " + path
+}
+
+// normalizeQuery drops ignored params and, unless mode is "exact", sorts the
+// remaining params by name
+func normalizeQuery(rawQuery, mode string, ignored []string) string {
+	if mode == "exact" && len(ignored) == 0 {
+		return rawQuery
+	}
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return rawQuery
+	}
+	for _, name := range ignored {
+		values.Del(name)
+	}
+	if mode != "exact" {
+		return values.Encode()
+	}
+	// Keep the original order of the params that are left
+	var kept []string
+	for _, pair := range strings.Split(rawQuery, "&") {
+		name, _, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(name); err == nil && values.Has(name) {
+			kept = append(kept, pair)
+		}
+	}
+	return strings.Join(kept, "&")
+}
+
+// bodyHash returns the SHA-256 of a body, canonicalizing JSON first so
+// formatting and key order do not affect the hash
+func bodyHash(body []byte) string {
+	var doc any
+	if len(bytes.TrimSpace(body)) > 0 && json.Unmarshal(body, &doc) == nil {
+		if canonical, err := json.Marshal(doc); err == nil {
+			body = canonical
+		}
+	}
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// jsonPathLookup evaluates a JSONPath subset against a decoded JSON document:
+// "$" followed by ".field", "['field']" and "[index]" segments
+func jsonPathLookup(doc any, path string) (any, bool) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, false
+	}
+	rest := path[1:]
+	current := doc
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			key := rest[:end]
+			rest = rest[end:]
+			obj, ok := current.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			if current, ok = obj[key]; !ok {
+				return nil, false
+			}
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, false
+			}
+			segment := rest[1:end]
+			rest = rest[end+1:]
+			if len(segment) >= 2 && (segment[0] == '\'' || segment[0] == '"') {
+				obj, ok := current.(map[string]any)
+				if !ok {
+					return nil, false
+				}
+				if current, ok = obj[segment[1:len(segment)-1]]; !ok {
+					return nil, false
+				}
+				continue
+			}
+			index, err := strconv.Atoi(segment)
+			arr, ok := current.([]any)
+			if err != nil || !ok || index < 0 || index >= len(arr) {
+				return nil, false
+			}
+			current = arr[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// sortedCandidateExplanations returns explanations for the best scoring
+// candidates first, limited to n entries
+func sortedCandidateExplanations(results []matchResult, n int) []string {
+	ordered := make([]*matchResult, len(results))
+	for i := range results {
+		ordered[i] = &results[i]
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		if ordered[i].Route != ordered[j].Route {
+			return ordered[i].Route
+		}
+		return ordered[i].Score > ordered[j].Score
+	})
+	if len(ordered) > n {
+		ordered = ordered[:n]
+	}
+	explanations := make([]string, len(ordered))
+	for i, res := range ordered {
+		explanations[i] = res.explain()
+	}
+	return explanations
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+func TestRuleMatcher(t *testing.T) {
+	tests := []struct {
+		name       string
+		rules      []config.MatchRule
+		minScore   float64
+		request    *http.Request
+		candidates []replayCandidate
+		wantID     string
+	}{
+		{
+			name:    "Recorded full URL matches relative request",
+			request: httptest.NewRequest(http.MethodGet, "/users?id=1", nil),
+			candidates: []replayCandidate{
+				{ID: "a", URL: "https://api.example.com/users?id=1"},
+			},
+			wantID: "a",
+		},
+		{
+			name:    "Query parameter order is ignored",
+			request: httptest.NewRequest(http.MethodGet, "/users?b=2&a=1", nil),
+			candidates: []replayCandidate{
+				{ID: "a", URL: "https://api.example.com/users?a=1&b=2"},
+			},
+			wantID: "a",
+		},
+		{
+			name:    "Different query does not match",
+			request: httptest.NewRequest(http.MethodGet, "/users?id=2", nil),
+			candidates: []replayCandidate{
+				{ID: "a", URL: "https://api.example.com/users?id=1"},
+			},
+		},
+		{
+			name:    "Different path does not match",
+			request: httptest.NewRequest(http.MethodGet, "/users/1", nil),
+			candidates: []replayCandidate{
+				{ID: "a", URL: "https://api.example.com/users/12"},
+			},
+		},
+		{
+			name:    "Ignored query params",
+			rules:   []config.MatchRule{{PathPrefix: "/users", IgnoreQuery: []string{"ts"}}},
+			request: httptest.NewRequest(http.MethodGet, "/users?id=1&ts=999", nil),
+			candidates: []replayCandidate{
+				{ID: "a", URL: "https://api.example.com/users?ts=123&id=1"},
+			},
+			wantID: "a",
+		},
+		{
+			name:    "Exact query mode keeps order",
+			rules:   []config.MatchRule{{PathPrefix: "/users", Query: "exact"}},
+			request: httptest.NewRequest(http.MethodGet, "/users?b=2&a=1", nil),
+			candidates: []replayCandidate{
+				{ID: "a", URL: "https://api.example.com/users?a=1&b=2"},
+			},
+		},
+		{
+			name:    "Selected header picks the recording",
+			rules:   []config.MatchRule{{PathPrefix: "/", Headers: []string{"X-Tenant"}}},
+			request: requestWithHeader(http.MethodGet, "/users", "X-Tenant", "blue"),
+			candidates: []replayCandidate{
+				{ID: "red", URL: "/users", RequestHeaders: http.Header{"X-Tenant": {"red"}}},
+				{ID: "blue", URL: "/users", RequestHeaders: http.Header{"X-Tenant": {"blue"}}},
+			},
+			wantID: "blue",
+		},
+		{
+			name:    "Body hash ignores JSON formatting",
+			rules:   []config.MatchRule{{PathPrefix: "/orders", Body: "hash"}},
+			request: httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"b": 2, "a": 1}`)),
+			candidates: []replayCandidate{
+				{ID: "other", URL: "/orders", RequestBody: []byte(`{"a":1,"b":3}`)},
+				{ID: "same", URL: "/orders", RequestBody: []byte(`{"a":1,"b":2}`)},
+			},
+			wantID: "same",
+		},
+		{
+			name:    "JSONPath subset",
+			rules:   []config.MatchRule{{PathPrefix: "/orders", Body: "jsonpath", BodyPaths: []string{"$.customer.id", "$.items[0].sku"}}},
+			request: httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"customer":{"id":7},"items":[{"sku":"x"}],"note":"new"}`)),
+			candidates: []replayCandidate{
+				{ID: "a", URL: "/orders", RequestBody: []byte(`{"customer":{"id":7},"items":[{"sku":"x"}],"note":"old"}`)},
+			},
+			wantID: "a",
+		},
+		{
+			name:     "Best partial match above min score",
+			rules:    []config.MatchRule{{PathPrefix: "/", Headers: []string{"X-A", "X-B"}}},
+			minScore: 0.5,
+			request:  requestWithHeader(http.MethodGet, "/users", "X-A", "1"),
+			candidates: []replayCandidate{
+				{ID: "none", URL: "/users", RequestHeaders: http.Header{"X-A": {"2"}, "X-B": {"2"}}},
+				{ID: "partial", URL: "/users", RequestHeaders: http.Header{"X-A": {"1"}, "X-B": {"2"}}},
+			},
+			wantID: "partial",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{Replay: config.ReplayConfig{MatchRules: tt.rules, MinScore: tt.minScore}}
+			if cfg.Replay.MinScore == 0 {
+				cfg.Replay.MinScore = 1
+			}
+			body, _ := io.ReadAll(tt.request.Body)
+			for i := range tt.candidates {
+				if tt.candidates[i].RequestHeaders == nil {
+					tt.candidates[i].RequestHeaders = http.Header{}
+				}
+			}
+
+			best, results := newRuleMatcher(cfg).Match(tt.request, body, tt.candidates)
+			gotID := ""
+			if best != nil {
+				gotID = best.Candidate.ID
+			}
+			if gotID != tt.wantID {
+				t.Errorf("Selected %q, want %q; explanations: %v", gotID, tt.wantID, sortedCandidateExplanations(results, len(results)))
+			}
+		})
+	}
+}
+
+func requestWithHeader(method, target, name, value string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set(name, value)
+	return req
+}
+
+func TestJSONPathLookup(t *testing.T) {
+	doc := map[string]any{
+		"user":  map[string]any{"name": "ada", "tags": []any{"a", "b"}},
+		"a.b":   true,
+		"count": float64(2),
+	}
+
+	tests := []struct {
+		path   string
+		want   any
+		wantOK bool
+	}{
+		{"$.user.name", "ada", true},
+		{"$.user.tags[1]", "b", true},
+		{"$['a.b']", true, true},
+		{"$.count", float64(2), true},
+		{"$.user.tags[5]", nil, false},
+		{"$.missing", nil, false},
+		{"user.name", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, ok := jsonPathLookup(doc, tt.path)
+			if ok != tt.wantOK || (ok && got != tt.want) {
+				t.Errorf("jsonPathLookup(%s) = %v, %v; want %v, %v", tt.path, got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestReplayMatchesRecordedUpstreamURL(t *testing.T) {
+	tempDB, err := os.CreateTemp("", "test_replay_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	// Recordings carry the upstream URL produced by the director
+	err = db.Insert(context.Background(), stmt, db.TrafficRecord{
+		ID:              "rec-1",
+		Timestamp:       time.Now().UTC(),
+		Protocol:        "HTTP",
+		Method:          http.MethodGet,
+		URL:             "https://api.example.com/users?page=2&sort=name",
+		RequestHeaders:  "{}",
+		ResponseStatus:  http.StatusOK,
+		ResponseHeaders: `{"Content-Type":["application/json"]}`,
+		ResponseBody:    []byte(`{"users":[]}`),
+	})
+	if err != nil {
+		t.Fatalf("Failed to save record: %v", err)
+	}
+
+	cfg := &config.Config{
+		HTTPTargetURL: "https://api.example.com",
+		ReplayMode:    true,
+		Replay:        config.ReplayConfig{MinScore: 1, Debug: true},
+	}
+	reverseProxy := &httputil.ReverseProxy{Director: newDirector(cfg)}
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(reverseProxy, cfg, database, records, pool)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/users?sort=name&page=2", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected replayed 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Body.String() != `{"users":[]}` {
+		t.Errorf("Unexpected replayed body: %s", rr.Body.String())
+	}
+	if got := rr.Header().Get("X-Jarvis-Replay-Record"); got != "rec-1" {
+		t.Errorf("Expected debug header naming rec-1, got %q", got)
+	}
+
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/users?page=3&sort=name", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Fatalf("Expected 404 for unmatched query, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "query mismatch") {
+		t.Errorf("Expected the miss to be explained, got %s", rr.Body.String())
+	}
+}
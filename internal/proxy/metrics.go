@@ -0,0 +1,106 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/metrics"
+)
+
+// observeHTTP serves a request with next and counts it, with its latency, by
+// route, method and status
+func observeHTTP(w http.ResponseWriter, r *http.Request, cfg *config.Config, next func(http.ResponseWriter, *http.Request)) {
+	start := time.Now()
+	r, routing := withRouteState(r, cfg)
+	sw := &statusWriter{ResponseWriter: w}
+	next(sw, r)
+
+	status := sw.status
+	if status == 0 {
+		status = http.StatusOK // net/http sends 200 when the handler writes nothing
+	}
+	labels := []string{routeLabel(routing.match), methodLabel(r.Method), strconv.Itoa(status)}
+	metrics.HTTPRequests.WithLabelValues(labels...).Inc()
+	metrics.HTTPRequestDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+}
+
+// countUpstreamError counts a request that got no response from its upstream.
+// Requests cancelled by the client are not the upstream's fault. Hosts chosen
+// by forward-proxy clients share one label so they cannot grow it unbounded.
+func countUpstreamError(protocol string, r *http.Request, err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	upstream := "unknown"
+	if state := routeStateFrom(r.Context()); state != nil && state.upstream != "" {
+		upstream = state.upstream
+		if state.forwarded {
+			upstream = "forward_proxy"
+		}
+	}
+	metrics.UpstreamErrors.WithLabelValues(protocol, upstream).Inc()
+}
+
+// routeLabel names a route for metrics, keeping label values bounded by the
+// configuration rather than by request paths
+func routeLabel(match *config.RouteMatch) string {
+	route := routeOf(match)
+	switch {
+	case route == nil:
+		return "default"
+	case route.Name != "":
+		return route.Name
+	default:
+		return route.String()
+	}
+}
+
+// methodLabel keeps arbitrary methods out of metric labels
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// statusWriter records the status code sent to the client
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(statusCode int) {
+	if w.status == 0 && statusCode >= http.StatusOK { // Skip informational responses
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Hijack hands the connection to WebSocket proxying, which answers with 101
+func (w *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, buf, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil && w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return conn, buf, err
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
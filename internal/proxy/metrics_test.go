@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+	"github.com/dipjyotimetia/jarvis/internal/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestHTTPMetrics(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer targetServer.Close()
+
+	tempDB, err := os.CreateTemp("", "test_metrics_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		TargetRoutes:  []config.TargetRoute{{Name: "metrics-down", PathPrefix: "/down", TargetURL: "http://127.0.0.1:1"}},
+		Replay:        config.ReplayConfig{MinScore: 1},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := buildHTTPHandler(ctx, cfg, database, records, "HTTP proxy error")
+
+	created := metrics.HTTPRequests.WithLabelValues("default", "POST", "201")
+	failed := metrics.HTTPRequests.WithLabelValues("metrics-down", "GET", "502")
+	upstreamErrors := metrics.UpstreamErrors.WithLabelValues("HTTP", "http://127.0.0.1:1")
+	misses := metrics.ReplayLookups.WithLabelValues("HTTP", metrics.ResultMiss)
+	before := []float64{testutil.ToFloat64(created), testutil.ToFloat64(failed), testutil.ToFloat64(upstreamErrors), testutil.ToFloat64(misses)}
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/users", nil),
+		httptest.NewRequest(http.MethodGet, "/down/x", nil),
+	} {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	cfg.ReplayMode = true
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/never-recorded", nil))
+
+	after := []float64{testutil.ToFloat64(created), testutil.ToFloat64(failed), testutil.ToFloat64(upstreamErrors), testutil.ToFloat64(misses)}
+	for i, name := range []string{"created requests", "failed requests", "upstream errors", "replay misses"} {
+		if after[i]-before[i] != 1 {
+			t.Errorf("Expected %s to increase by 1, went from %v to %v", name, before[i], after[i])
+		}
+	}
+}
+
+func TestForwardedUpstreamErrorsShareALabel(t *testing.T) {
+	cfg := &config.Config{ForwardProxy: config.ForwardProxyConfig{Enabled: true}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := buildHTTPHandler(ctx, cfg, nil, nil, "HTTP proxy error")
+
+	forwarded := metrics.UpstreamErrors.WithLabelValues("HTTP", "forward_proxy")
+	before := testutil.ToFloat64(forwarded)
+	for _, host := range []string{"127.0.0.1:1", "127.0.0.1:2"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "http://"+host+"/x", nil))
+	}
+	if got := testutil.ToFloat64(forwarded) - before; got != 2 {
+		t.Errorf("Expected both client-chosen hosts to be counted as forward_proxy, got %v", got)
+	}
+}
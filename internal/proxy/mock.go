@@ -0,0 +1,359 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+	"github.com/dipjyotimetia/jarvis/internal/metrics"
+	"github.com/dipjyotimetia/jarvis/internal/validator"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// StartMockServer serves the operations of the OpenAPI spec at
+// cfg.Mock.SpecPath on the HTTP port, without an upstream. Served traffic is
+// recorded like proxied traffic.
+func StartMockServer(ctx context.Context, cfg *config.Config, records *db.Writer) Server {
+	srv, err := newReloadableServer(ctx, "Mock", cfg, func(ctx context.Context, cfg *config.Config) (http.Handler, error) {
+		return newMockHandler(cfg, records)
+	}, nil)
+	if err != nil {
+		slog.Error("Failed to start mock server", "error", err)
+		return nil
+	}
+
+	srv.Server = &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.HTTPPort),
+		Handler:      srv,
+		ReadTimeout:  15 * time.Second,
+		WriteTimeout: 30 * time.Second,
+		IdleTimeout:  60 * time.Second,
+	}
+
+	go func() {
+		slog.Info("Starting mock server", "port", cfg.HTTPPort, "spec", cfg.Mock.SpecPath)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Mock server error", "error", err)
+		}
+	}()
+
+	return srv
+}
+
+// mockHandler answers requests with responses described by an OpenAPI spec
+type mockHandler struct {
+	cfg     *config.Config
+	spec    *validator.APIValidator
+	service string // Title of the spec, stored as the service of records
+	records *db.Writer
+}
+
+func newMockHandler(cfg *config.Config, records *db.Writer) (http.Handler, error) {
+	spec, err := validator.NewAPIValidator(cfg.Mock.SpecPath, validator.APIValidatorOptions{
+		EnableRequestValidation: cfg.APIValidation.ValidateRequests,
+		StrictMode:              cfg.APIValidation.StrictMode,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("loading mock spec: %w", err)
+	}
+	info := spec.GetOpenAPIInfo()
+	slog.Info("Mocking OpenAPI spec", "title", info["title"], "version", info["version"], "paths", info["paths"])
+
+	h := &mockHandler{cfg: cfg, spec: spec, service: info["title"], records: records}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		observeHTTP(w, r, cfg, h.serve)
+	}), nil
+}
+
+// mockPreference holds the choices a client makes with the Prefer header:
+// Prefer: code=404, example=notFound, dynamic=true
+type mockPreference struct {
+	code    int    // Response status to serve
+	example string // Named example to serve
+	dynamic bool   // Generate the body from the schema, ignoring examples
+}
+
+// parsePrefer reads the mock preferences of a request; other preferences
+// are ignored
+func parsePrefer(r *http.Request) (mockPreference, error) {
+	var pref mockPreference
+	for _, header := range r.Header.Values("Prefer") {
+		for _, token := range strings.FieldsFunc(header, func(c rune) bool { return c == ',' || c == ';' }) {
+			name, value, _ := strings.Cut(strings.TrimSpace(token), "=")
+			value = strings.Trim(strings.TrimSpace(value), `"`)
+			switch strings.ToLower(strings.TrimSpace(name)) {
+			case "code":
+				code, err := strconv.Atoi(value)
+				if err != nil || code < 100 || code > 599 {
+					return pref, fmt.Errorf("invalid Prefer code %q", value)
+				}
+				pref.code = code
+			case "example":
+				pref.example = value
+			case "dynamic":
+				pref.dynamic = value == "" || strings.EqualFold(value, "true")
+			}
+		}
+	}
+	return pref, nil
+}
+
+func (h *mockHandler) serve(w http.ResponseWriter, r *http.Request) {
+	startTime := time.Now()
+
+	if r.ContentLength > maxRequestSize {
+		http.Error(w, "Request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var reqBody []byte
+	if r.Body != nil {
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+		if err != nil {
+			http.Error(w, "Error reading request body", http.StatusBadRequest)
+			return
+		}
+		reqBody = body
+		r.Body = io.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	var status int
+	var body []byte
+	validationErr := h.validateRequest(r, reqBody)
+	if validationErr != nil && !h.cfg.APIValidation.ContinueOnValidation {
+		status, body = http.StatusBadRequest, writeValidationProblem(w, r, http.StatusBadRequest, validationErr)
+	} else {
+		if validationErr != nil {
+			w.Header().Set("X-API-Validation-Error", "request")
+		}
+		status, body = h.respond(w, r, reqBody)
+	}
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+	h.record(r, reqBody, w.Header(), status, body, time.Since(startTime), validationErr)
+}
+
+// validateRequest validates a request as the proxy does. Requests matching
+// no operation are left for respond to turn away.
+func (h *mockHandler) validateRequest(r *http.Request, reqBody []byte) error {
+	if !h.cfg.APIValidation.ValidateRequests {
+		return nil
+	}
+	reqCopy := r.Clone(r.Context())
+	reqCopy.Body = io.NopCloser(bytes.NewReader(reqBody))
+	err := h.spec.ValidateRequest(reqCopy)
+	var routeErr *validator.RouteError
+	if err == nil || errors.As(err, &routeErr) {
+		return nil
+	}
+	slog.Warn("OpenAPI request validation failed", "method", r.Method, "path", r.URL.Path, "error", err)
+	metrics.ValidationFailures.WithLabelValues(metrics.KindRequest).Inc()
+	return err
+}
+
+// respond sets the response headers of a request and returns its status and
+// body
+func (h *mockHandler) respond(w http.ResponseWriter, r *http.Request, reqBody []byte) (int, []byte) {
+	route, _, err := h.spec.FindRoute(r)
+	if err != nil {
+		var routeErr *validator.RouteError
+		switch {
+		case errors.As(err, &routeErr) && routeErr.MethodNotAllowed:
+			return mockError(w, http.StatusMethodNotAllowed, err.Error())
+		case errors.As(err, &routeErr):
+			return mockError(w, http.StatusNotFound, err.Error())
+		}
+		return mockError(w, http.StatusInternalServerError, err.Error())
+	}
+
+	pref, err := parsePrefer(r)
+	if err != nil {
+		return mockError(w, http.StatusBadRequest, err.Error())
+	}
+	status, response, err := selectMockResponse(route.Operation.Responses, pref.code)
+	if err != nil {
+		return mockError(w, http.StatusBadRequest, err.Error())
+	}
+
+	for name, header := range response.Headers {
+		if header == nil || header.Value == nil || strings.EqualFold(name, "Content-Type") {
+			continue
+		}
+		value := header.Value.Example
+		if value == nil {
+			value = generateValue(header.Value.Schema, pref.dynamic, 0)
+		}
+		if value != nil {
+			w.Header().Set(name, fmt.Sprint(value))
+		}
+	}
+
+	contentType, media := negotiateMedia(response.Content, r.Header.Get("Accept"))
+	if media == nil {
+		return status, nil
+	}
+	payload, err := mockExample(media, pref.example, pref.dynamic)
+	if err != nil {
+		return mockError(w, http.StatusBadRequest, err.Error())
+	}
+	body, err := encodeMockBody(contentType, payload)
+	if err != nil {
+		return mockError(w, http.StatusInternalServerError, err.Error())
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+	return status, body
+}
+
+// mockError prepares a plain text error response, as written by http.Error
+func mockError(w http.ResponseWriter, status int, msg string) (int, []byte) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	return status, []byte(msg + "\n")
+}
+
+// selectMockResponse picks the response for the preferred status, falling
+// back to the status range and the default response. Without a preference
+// the lowest 2xx response is served, then the default response as 200.
+func selectMockResponse(responses *openapi3.Responses, code int) (int, *openapi3.Response, error) {
+	if responses == nil {
+		return 0, nil, errors.New("operation defines no responses")
+	}
+	if code > 0 {
+		if ref := responses.Status(code); ref != nil && ref.Value != nil {
+			return code, ref.Value, nil
+		}
+		if ref := responses.Default(); ref != nil && ref.Value != nil {
+			return code, ref.Value, nil
+		}
+		return 0, nil, fmt.Errorf("no response for status %d in the spec", code)
+	}
+
+	var codes []int
+	for key := range responses.Map() {
+		if status, err := strconv.Atoi(key); err == nil {
+			codes = append(codes, status)
+		}
+	}
+	sort.Ints(codes)
+	for _, status := range codes {
+		if status >= 200 && status < 300 {
+			return status, responses.Value(strconv.Itoa(status)).Value, nil
+		}
+	}
+	if ref := responses.Value("2XX"); ref != nil && ref.Value != nil {
+		return http.StatusOK, ref.Value, nil
+	}
+	if ref := responses.Default(); ref != nil && ref.Value != nil {
+		return http.StatusOK, ref.Value, nil
+	}
+	if len(codes) > 0 {
+		return codes[0], responses.Value(strconv.Itoa(codes[0])).Value, nil
+	}
+	return 0, nil, errors.New("operation defines no responses")
+}
+
+// negotiateMedia picks the media type of a response that the Accept header
+// allows, preferring JSON. Responses without content return a nil media
+// type; when nothing is acceptable the preferred type is served anyway.
+func negotiateMedia(content openapi3.Content, accept string) (string, *openapi3.MediaType) {
+	if len(content) == 0 {
+		return "", nil
+	}
+	types := make([]string, 0, len(content))
+	for contentType := range content {
+		types = append(types, contentType)
+	}
+	sort.Slice(types, func(i, j int) bool {
+		ji, jj := strings.Contains(types[i], "json"), strings.Contains(types[j], "json")
+		if ji != jj {
+			return ji
+		}
+		return types[i] < types[j]
+	})
+
+	for _, part := range strings.Split(accept, ",") {
+		want, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		if want == "" || q <= 0 {
+			continue
+		}
+		for _, contentType := range types {
+			if mediaMatches(want, contentType) {
+				return contentType, content[contentType]
+			}
+		}
+	}
+	return types[0], content[types[0]]
+}
+
+// mediaMatches reports whether an Accept media range covers a content type
+func mediaMatches(want, contentType string) bool {
+	switch {
+	case want == "*/*":
+		return true
+	case strings.HasSuffix(want, "/*"):
+		return strings.HasPrefix(contentType, strings.TrimSuffix(want, "*"))
+	}
+	return strings.EqualFold(want, contentType)
+}
+
+// encodeMockBody serializes a payload for its content type: JSON for JSON
+// types, and strings as they are otherwise
+func encodeMockBody(contentType string, payload any) ([]byte, error) {
+	if s, ok := payload.(string); ok && !strings.Contains(contentType, "json") {
+		return []byte(s), nil
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("encoding mock response: %w", err)
+	}
+	return body, nil
+}
+
+// record queues a served exchange for storage, masking secrets like the proxy
+func (h *mockHandler) record(r *http.Request, reqBody []byte, header http.Header, status int, body []byte, duration time.Duration, validationErr error) {
+	if h.records == nil {
+		return
+	}
+	red := redactorFor(h.cfg)
+	record := db.TrafficRecord{
+		ID:              generateID(),
+		Timestamp:       time.Now().UTC(),
+		Protocol:        "HTTP",
+		Method:          r.Method,
+		URL:             red.URL(r.URL.String()),
+		Service:         h.service,
+		RequestHeaders:  string(redactedHeaderJSON(red, r.Header)),
+		RequestBody:     red.Body(reqBody),
+		ResponseStatus:  status,
+		ResponseHeaders: string(redactedHeaderJSON(red, header)),
+		ResponseBody:    red.Body(body),
+		Duration:        duration.Milliseconds(),
+		ClientIP:        getClientIP(r),
+		SessionID:       r.Header.Get("X-Session-ID"),
+		TestID:          r.Header.Get("X-Test-ID"),
+		Source:          db.SourceMock,
+	}
+	if validationErr != nil {
+		record.ValidationErrors = validationRecords(validationErr, validator.KindRequest, red, record.RequestBody)
+	}
+	if err := h.records.Write(record); err != nil {
+		slog.Warn("Error saving mocked HTTP traffic", "error", err)
+	}
+}
@@ -0,0 +1,268 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+const mockSpec = `openapi: 3.0.0
+info:
+  title: Users API
+  version: "1.0"
+paths:
+  /users:
+    get:
+      responses:
+        "200":
+          description: Users
+          headers:
+            X-Total-Count:
+              schema:
+                type: integer
+                minimum: 3
+          content:
+            application/json:
+              schema:
+                type: array
+                items:
+                  $ref: "#/components/schemas/User"
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              $ref: "#/components/schemas/User"
+      responses:
+        "201":
+          description: Created
+          content:
+            application/json:
+              example: {"id": 7, "email": "new@example.com", "role": "member"}
+        "400":
+          description: Invalid user
+  /users/{id}:
+    get:
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema:
+            type: integer
+      responses:
+        "200":
+          description: User
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/User"
+              examples:
+                admin:
+                  value: {"id": 1, "email": "admin@example.com", "role": "admin"}
+                member:
+                  value: {"id": 2, "email": "member@example.com", "role": "member"}
+        "404":
+          description: Not found
+          content:
+            application/json:
+              schema:
+                type: object
+                properties:
+                  message:
+                    type: string
+                    example: user not found
+components:
+  schemas:
+    User:
+      type: object
+      required: [email]
+      properties:
+        id:
+          type: integer
+          minimum: 1
+        email:
+          type: string
+          format: email
+        role:
+          type: string
+          enum: [member, admin]
+        password:
+          type: string
+          writeOnly: true
+`
+
+func newTestMockHandler(t *testing.T, cfg *config.Config, records *db.Writer) http.Handler {
+	t.Helper()
+	specPath := filepath.Join(t.TempDir(), "users.yaml")
+	if err := os.WriteFile(specPath, []byte(mockSpec), 0o644); err != nil {
+		t.Fatalf("Failed to write spec: %v", err)
+	}
+	cfg.Mock.SpecPath = specPath
+	handler, err := newMockHandler(cfg, records)
+	if err != nil {
+		t.Fatalf("newMockHandler() error: %v", err)
+	}
+	return handler
+}
+
+func TestMockResponses(t *testing.T) {
+	cfg := &config.Config{APIValidation: config.APIValidationConfig{ValidateRequests: true}}
+	handler := newTestMockHandler(t, cfg, nil)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		prefer     string
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "first named example", method: http.MethodGet, path: "/users/1", wantStatus: 200,
+			wantBody: `{"email":"admin@example.com","id":1,"role":"admin"}`},
+		{name: "example chosen with Prefer", method: http.MethodGet, path: "/users/2", prefer: "example=member", wantStatus: 200,
+			wantBody: `{"email":"member@example.com","id":2,"role":"member"}`},
+		{name: "status chosen with Prefer", method: http.MethodGet, path: "/users/3", prefer: "code=404", wantStatus: 404,
+			wantBody: `{"message":"user not found"}`},
+		{name: "generated from the schema", method: http.MethodGet, path: "/users/1", prefer: "dynamic=true", wantStatus: 200,
+			wantBody: `{"email":"user@example.com","id":1,"role":"member"}`},
+		{name: "media type example", method: http.MethodPost, path: "/users", body: `{"email":"new@example.com"}`, wantStatus: 201,
+			wantBody: `{"email":"new@example.com","id":7,"role":"member"}`},
+		{name: "response without content", method: http.MethodPost, path: "/users", body: `{"email":"new@example.com"}`, prefer: "code=400", wantStatus: 400},
+		{name: "undefined status", method: http.MethodGet, path: "/users/1", prefer: "code=503", wantStatus: 400},
+		{name: "unknown example", method: http.MethodGet, path: "/users/1", prefer: "example=owner", wantStatus: 400},
+		{name: "invalid request", method: http.MethodPost, path: "/users", body: `{"name":"no email"}`, wantStatus: 400},
+		{name: "invalid path parameter", method: http.MethodGet, path: "/users/abc", wantStatus: 400},
+		{name: "unknown path", method: http.MethodGet, path: "/orders", wantStatus: 404},
+		{name: "unknown method", method: http.MethodDelete, path: "/users", wantStatus: 405},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set("Content-Type", "application/json")
+			}
+			if tt.prefer != "" {
+				req.Header.Set("Prefer", tt.prefer)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if tt.wantBody != "" && rr.Body.String() != tt.wantBody {
+				t.Errorf("Expected body %s, got %s", tt.wantBody, rr.Body.String())
+			}
+		})
+	}
+
+	// Arrays and headers are generated from their schemas
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/users", nil))
+	var users []map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &users); err != nil || len(users) != 1 {
+		t.Fatalf("Expected one generated user, got %s", rr.Body.String())
+	}
+	if _, ok := users[0]["password"]; ok {
+		t.Error("Write-only properties must not appear in responses")
+	}
+	if rr.Header().Get("X-Total-Count") != "3" {
+		t.Errorf("Expected X-Total-Count 3, got %q", rr.Header().Get("X-Total-Count"))
+	}
+}
+
+func TestMockContinueOnValidation(t *testing.T) {
+	cfg := &config.Config{APIValidation: config.APIValidationConfig{ValidateRequests: true, ContinueOnValidation: true}}
+	handler := newTestMockHandler(t, cfg, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated || rr.Header().Get("X-API-Validation-Error") != "request" {
+		t.Errorf("Expected a 201 flagged as invalid, got %d with headers %v", rr.Code, rr.Header())
+	}
+}
+
+func TestMockRecordsTraffic(t *testing.T) {
+	tempDB, err := os.CreateTemp("", "test_mock_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	handler := newTestMockHandler(t, &config.Config{}, records)
+	req := httptest.NewRequest(http.MethodGet, "/users/1?token=s3cr3t", nil)
+	req.Header.Set("Authorization", "Bearer s3cr3t")
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	waitForSource(t, database, db.SourceMock, 1)
+
+	var service, url, headers string
+	var status int
+	err = database.QueryRow(`SELECT service, url, request_headers, response_status FROM traffic_records`).Scan(&service, &url, &headers, &status)
+	if err != nil {
+		t.Fatalf("Failed to read record: %v", err)
+	}
+	if service != "Users API" || status != http.StatusOK {
+		t.Errorf("Expected a 200 record for Users API, got %d for %q", status, service)
+	}
+	if strings.Contains(url, "s3cr3t") || strings.Contains(headers, "s3cr3t") {
+		t.Errorf("Expected secrets to be redacted, got %s and %s", url, headers)
+	}
+
+	// Mocked traffic is never replayed
+	candidates, err := loadReplayCandidates(database, httptest.NewRequest(http.MethodGet, "/users/1", nil), true)
+	if err != nil || len(candidates) != 0 {
+		t.Errorf("Expected no replay candidates, got %d, %v", len(candidates), err)
+	}
+}
+
+func TestGenerateValue(t *testing.T) {
+	minimum := 10.0
+	maxLen := uint64(3)
+	tests := []struct {
+		name   string
+		schema *openapi3.Schema
+		want   any
+	}{
+		{"example", &openapi3.Schema{Type: &openapi3.Types{"string"}, Example: "ok"}, "ok"},
+		{"default", &openapi3.Schema{Type: &openapi3.Types{"integer"}, Default: 5}, 5},
+		{"minimum", &openapi3.Schema{Type: &openapi3.Types{"integer"}, Min: &minimum}, int64(10)},
+		{"exclusive minimum", &openapi3.Schema{Type: &openapi3.Types{"number"}, Min: &minimum, ExclusiveMin: true}, 11.0},
+		{"max length", &openapi3.Schema{Type: &openapi3.Types{"string"}, Format: "uuid", MaxLength: &maxLen}, "000"},
+		{"boolean", &openapi3.Schema{Type: &openapi3.Types{"boolean"}}, true},
+		{"oneOf", &openapi3.Schema{OneOf: openapi3.SchemaRefs{openapi3.NewDateTimeSchema().NewRef()}}, "2024-01-01T00:00:00Z"},
+	}
+	for _, tt := range tests {
+		if got := generateValue(tt.schema.NewRef(), false, 0); got != tt.want {
+			t.Errorf("%s: generateValue() = %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+
+	// Recursive schemas stop at the depth limit
+	node := &openapi3.Schema{Type: &openapi3.Types{"object"}, Properties: openapi3.Schemas{}}
+	node.Properties["child"] = node.NewRef()
+	if generateValue(node.NewRef(), false, 0) == nil {
+		t.Error("Expected a value for a recursive schema")
+	}
+}
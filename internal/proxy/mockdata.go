@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/getkin/kin-openapi/openapi3"
+)
+
+// maxGenerateDepth stops generating nested objects of recursive schemas
+const maxGenerateDepth = 8
+
+// mockExample returns the payload of a media type: the example named name, its
+// example, its first example in name order, the schema's example, or a
+// payload generated from the schema. With dynamic, the examples are skipped.
+func mockExample(media *openapi3.MediaType, name string, dynamic bool) (any, error) {
+	if name != "" {
+		ref, ok := media.Examples[name]
+		if !ok || ref == nil || ref.Value == nil {
+			return nil, fmt.Errorf("no example named %q", name)
+		}
+		return ref.Value.Value, nil
+	}
+	if !dynamic {
+		if media.Example != nil {
+			return media.Example, nil
+		}
+		names := make([]string, 0, len(media.Examples))
+		for name, ref := range media.Examples {
+			if ref != nil && ref.Value != nil {
+				names = append(names, name)
+			}
+		}
+		if len(names) > 0 {
+			sort.Strings(names)
+			return media.Examples[names[0]].Value.Value, nil
+		}
+	}
+	return generateValue(media.Schema, dynamic, 0), nil
+}
+
+// generateValue builds a payload that satisfies a schema, preferring its example,
+// default and first enum value unless dynamic
+func generateValue(ref *openapi3.SchemaRef, dynamic bool, depth int) any {
+	if ref == nil || ref.Value == nil || depth > maxGenerateDepth {
+		return nil
+	}
+	s := ref.Value
+	if !dynamic && s.Example != nil {
+		return s.Example
+	}
+	if s.Default != nil {
+		return s.Default
+	}
+	if len(s.Enum) > 0 {
+		return s.Enum[0]
+	}
+
+	switch {
+	case len(s.AllOf) > 0:
+		// Subschemas of allOf describe parts of one object
+		merged := map[string]any{}
+		for _, sub := range s.AllOf {
+			if obj, ok := generateValue(sub, dynamic, depth+1).(map[string]any); ok {
+				for k, v := range obj {
+					merged[k] = v
+				}
+			}
+		}
+		for k, v := range generateObject(s, dynamic, depth) {
+			merged[k] = v
+		}
+		return merged
+	case len(s.OneOf) > 0:
+		return generateValue(s.OneOf[0], dynamic, depth+1)
+	case len(s.AnyOf) > 0:
+		return generateValue(s.AnyOf[0], dynamic, depth+1)
+	}
+
+	switch {
+	case s.Type.Is(openapi3.TypeObject) || (s.Type == nil && len(s.Properties) > 0):
+		return generateObject(s, dynamic, depth)
+	case s.Type.Is(openapi3.TypeArray):
+		n := max(int(s.MinItems), 1)
+		items := make([]any, n)
+		for i := range items {
+			items[i] = generateValue(s.Items, dynamic, depth+1)
+		}
+		return items
+	case s.Type.Is(openapi3.TypeString):
+		return generateString(s)
+	case s.Type.Is(openapi3.TypeInteger):
+		return int64(generateNumber(s, 1))
+	case s.Type.Is(openapi3.TypeNumber):
+		return generateNumber(s, 0.5)
+	case s.Type.Is(openapi3.TypeBoolean):
+		return true
+	case s.Nullable || s.Type.Is(openapi3.TypeNull):
+		return nil
+	}
+	return nil
+}
+
+// generateObject fills every property of an object schema except write-only
+// ones, which never appear in responses
+func generateObject(s *openapi3.Schema, dynamic bool, depth int) map[string]any {
+	obj := make(map[string]any, len(s.Properties))
+	for name, prop := range s.Properties {
+		if prop == nil || prop.Value == nil || prop.Value.WriteOnly {
+			continue
+		}
+		obj[name] = generateValue(prop, dynamic, depth+1)
+	}
+	return obj
+}
+
+// generateString returns a sample value for a string format
+func generateString(s *openapi3.Schema) string {
+	var v string
+	switch s.Format {
+	case "date-time":
+		v = "2024-01-01T00:00:00Z"
+	case "date":
+		v = "2024-01-01"
+	case "time":
+		v = "00:00:00"
+	case "email":
+		v = "user@example.com"
+	case "uuid":
+		v = "00000000-0000-4000-8000-000000000000"
+	case "uri", "url":
+		v = "https://example.com"
+	case "hostname":
+		v = "example.com"
+	case "ipv4":
+		v = "192.0.2.1"
+	case "ipv6":
+		v = "2001:db8::1"
+	case "byte":
+		v = "c3RyaW5n"
+	default:
+		v = "string"
+	}
+	for uint64(len(v)) < s.MinLength {
+		v += "x"
+	}
+	if s.MaxLength != nil && uint64(len(v)) > *s.MaxLength {
+		v = v[:*s.MaxLength]
+	}
+	return v
+}
+
+// generateNumber returns fallback, or the closest value the schema's bounds
+// allow
+func generateNumber(s *openapi3.Schema, fallback float64) float64 {
+	v := fallback
+	if s.Min != nil && v <= *s.Min {
+		v = *s.Min
+		if s.ExclusiveMin {
+			v++
+		}
+	}
+	if s.Max != nil && v >= *s.Max {
+		v = *s.Max
+		if s.ExclusiveMax {
+			v--
+		}
+	}
+	return v
+}
@@ -0,0 +1,470 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+	"github.com/dipjyotimetia/jarvis/internal/metrics"
+	"github.com/dipjyotimetia/jarvis/internal/plugin"
+)
+
+// pluginSets caches the plugins loaded for each configuration, so compiled
+// modules and their instances are shared by all requests
+var pluginSets sync.Map // *config.Config -> *pluginSet
+
+// pluginSet holds the plugins of a configuration in the order configured.
+// It is closed once the handlers retaining it are retired and the requests
+// they started have finished.
+type pluginSet struct {
+	once    sync.Once
+	plugins []*plugin.Plugin
+
+	mu       sync.Mutex
+	retained bool
+	handlers int // Handlers serving with the set
+	requests int // Requests running the set's hooks
+	closed   bool
+}
+
+// pluginsFor returns the plugins of cfg, loading them the first time, or nil
+// if there are none. Plugins that fail to load are logged and skipped.
+func pluginsFor(cfg *config.Config) *pluginSet {
+	if len(cfg.Plugins) == 0 {
+		return nil
+	}
+	v, _ := pluginSets.LoadOrStore(cfg, &pluginSet{})
+	set := v.(*pluginSet)
+	set.once.Do(func() {
+		for _, pc := range cfg.Plugins {
+			p, err := plugin.Load(context.Background(), pc)
+			if err != nil {
+				slog.Error("Failed to load plugin, skipping it", "plugin", pc.Name, "path", pc.Path, "error", err)
+				continue
+			}
+			slog.Info("Loaded plugin", "plugin", pc.Name, "path", pc.Path)
+			set.plugins = append(set.plugins, p)
+		}
+	})
+	if len(set.plugins) == 0 {
+		return nil
+	}
+	return set
+}
+
+// retainPlugins keeps the plugins of cfg loaded until ctx is done. They are
+// closed once no handler of cfg uses them and their last request finished.
+func retainPlugins(ctx context.Context, cfg *config.Config) {
+	set := pluginsFor(cfg)
+	if set == nil {
+		return
+	}
+	set.mu.Lock()
+	set.retained = true
+	set.handlers++
+	set.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		set.mu.Lock()
+		defer set.mu.Unlock()
+		if set.handlers--; set.handlers == 0 {
+			// Handlers hold the set, so it is never loaded again for cfg
+			pluginSets.CompareAndDelete(cfg, set)
+		}
+		set.closeIfUnused()
+	})
+}
+
+// acquire keeps the set open for a request, reporting false if it was closed
+func (s *pluginSet) acquire() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return false
+	}
+	s.requests++
+	return true
+}
+
+// release ends a request started with acquire
+func (s *pluginSet) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests--
+	s.closeIfUnused()
+}
+
+// closeIfUnused closes the plugins of a retired set nothing uses any more.
+// The caller holds s.mu.
+func (s *pluginSet) closeIfUnused() {
+	if !s.retained || s.handlers > 0 || s.requests > 0 || s.closed {
+		return
+	}
+	s.closed = true
+	for _, p := range s.plugins {
+		if err := p.Close(context.Background()); err != nil {
+			slog.Warn("Error closing plugin", "plugin", p.Name(), "error", err)
+		}
+	}
+}
+
+// with returns the plugins implementing hook
+func (s *pluginSet) with(hook plugin.Hook) []*plugin.Plugin {
+	var out []*plugin.Plugin
+	for _, p := range s.plugins {
+		if p.Has(hook) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// pluginState carries the plugins of a request and the tags they attach to
+// it through the request context. Every hook of an exchange runs on the
+// goroutine serving it.
+type pluginState struct {
+	set    *pluginSet
+	tags   map[string]string
+	served bool // A plugin answered in place of the target
+}
+
+type pluginStateKey struct{}
+
+// withPluginState attaches a handler's plugins to a request, if it has any.
+// The set stays open until the request calls done.
+func withPluginState(r *http.Request, set *pluginSet) (*http.Request, *pluginState) {
+	if set == nil || !set.acquire() {
+		return r, nil
+	}
+	state := &pluginState{set: set}
+	return r.WithContext(context.WithValue(r.Context(), pluginStateKey{}, state)), state
+}
+
+// done releases the request's hold on its plugins
+func (s *pluginState) done() {
+	if s != nil {
+		s.set.release()
+	}
+}
+
+func pluginStateFrom(ctx context.Context) *pluginState {
+	state, _ := ctx.Value(pluginStateKey{}).(*pluginState)
+	return state
+}
+
+// call runs a hook and collects the tags it returns. Failed calls are logged
+// and the exchange continues as if the plugin had left it unchanged.
+func (s *pluginState) call(ctx context.Context, p *plugin.Plugin, in plugin.Input) *plugin.Result {
+	in.Tags = s.tags
+	result, err := p.Call(ctx, in)
+	if err != nil {
+		slog.Warn("Plugin hook failed, continuing without it", "plugin", p.Name(), "hook", in.Hook, "error", err)
+		metrics.PluginErrors.WithLabelValues(p.Name(), string(in.Hook)).Inc()
+		return nil
+	}
+	if result != nil && len(result.Tags) > 0 {
+		if s.tags == nil {
+			s.tags = make(map[string]string, len(result.Tags))
+		}
+		maps.Copy(s.tags, result.Tags)
+	}
+	return result
+}
+
+// has reports whether any plugin of the request implements hook
+func (s *pluginState) has(hook plugin.Hook) bool {
+	return s != nil && len(s.set.with(hook)) > 0
+}
+
+// servedByPlugin reports whether a plugin answered the request in place of
+// the target
+func (s *pluginState) servedByPlugin() bool {
+	return s != nil && s.served
+}
+
+// tagsJSON returns the tags attached to the exchange as a JSON object, or ""
+func (s *pluginState) tagsJSON() string {
+	if s == nil || len(s.tags) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(s.tags)
+	return string(b)
+}
+
+// requestReceived runs the request_received hooks on the client's request.
+// body is the buffered request body, if buffered; plugins may replace it
+// and its headers. The first response a plugin returns is served instead.
+func (s *pluginState) requestReceived(r *http.Request, body []byte, buffered bool) ([]byte, *plugin.Response) {
+	for _, p := range s.set.with(plugin.RequestReceived) {
+		in := &plugin.Request{Method: r.Method, URL: r.URL.String(), Headers: r.Header}
+		if buffered {
+			in.Body = nonNilBody(body)
+		}
+		result := s.call(r.Context(), p, plugin.Input{Hook: plugin.RequestReceived, Request: in})
+		if result == nil {
+			continue
+		}
+		if req := result.Request; req != nil {
+			if req.Headers != nil {
+				r.Header = req.Headers
+			}
+			if req.Body != nil && buffered {
+				body = req.Body
+				r.Body = io.NopCloser(bytes.NewReader(body))
+				r.ContentLength = int64(len(body))
+			}
+		}
+		if result.Response != nil {
+			return body, result.Response
+		}
+	}
+	return body, nil
+}
+
+// beforeUpstream runs the before_upstream hooks on an outbound request,
+// returning the request to send or the response a plugin returned instead
+func (s *pluginState) beforeUpstream(req *http.Request) (*http.Request, *http.Response, error) {
+	hooked := s.set.with(plugin.BeforeUpstream)
+	if len(hooked) == 0 {
+		return req, nil, nil
+	}
+	req = req.Clone(req.Context()) // A RoundTripper must not modify the caller's request
+	body, rest, err := bufferBody(req.Body, req.ContentLength)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading request body for plugins: %w", err)
+	}
+	req.Body = rest
+
+	for _, p := range hooked {
+		in := &plugin.Request{Method: req.Method, URL: req.URL.String(), Headers: req.Header, Body: body}
+		result := s.call(req.Context(), p, plugin.Input{Hook: plugin.BeforeUpstream, Request: in})
+		if result == nil {
+			continue
+		}
+		if out := result.Request; out != nil {
+			if out.Headers != nil {
+				req.Header = out.Headers
+			}
+			if out.Body != nil && body != nil {
+				body = out.Body
+				req.Body = io.NopCloser(bytes.NewReader(body))
+				req.GetBody = func() (io.ReadCloser, error) {
+					return io.NopCloser(bytes.NewReader(body)), nil
+				}
+				req.ContentLength = int64(len(body))
+			}
+		}
+		if result.Response != nil {
+			s.served = true
+			return req, pluginHTTPResponse(req, result.Response), nil
+		}
+	}
+	return req, nil, nil
+}
+
+// runResponsePlugins is a ReverseProxy.ModifyResponse hook running the
+// response_received hooks on the target's response. Server-Sent Events,
+// compressed responses and bodies too large to buffer are passed without
+// their body.
+func runResponsePlugins(resp *http.Response) error {
+	s := pluginStateFrom(resp.Request.Context())
+	if s == nil || s.served {
+		return nil
+	}
+	hooked := s.set.with(plugin.ResponseReceived)
+	if len(hooked) == 0 {
+		return nil
+	}
+
+	body, err := responseBodyForPlugins(resp)
+	if err != nil {
+		return err
+	}
+
+	for _, p := range hooked {
+		in := &plugin.Response{Status: resp.StatusCode, Headers: resp.Header, Body: body}
+		result := s.call(resp.Request.Context(), p, plugin.Input{
+			Hook:     plugin.ResponseReceived,
+			Request:  &plugin.Request{Method: resp.Request.Method, URL: resp.Request.URL.String(), Headers: resp.Request.Header},
+			Response: in,
+		})
+		if result == nil || result.Response == nil {
+			continue
+		}
+		out := result.Response
+		if out.Status != 0 {
+			resp.StatusCode = out.Status
+			resp.Status = fmt.Sprintf("%d %s", out.Status, http.StatusText(out.Status))
+		}
+		if out.Headers != nil {
+			resp.Header = out.Headers
+		}
+		if out.Body != nil && body != nil {
+			body = out.Body
+			resp.Body = io.NopCloser(bytes.NewReader(body))
+			resp.ContentLength = int64(len(body))
+			resp.Header.Set("Content-Length", strconv.Itoa(len(body)))
+		}
+	}
+	return nil
+}
+
+// responseBodyForPlugins buffers a response body for the response_received
+// hooks, or returns nil for bodies they do not see. Chunked bodies other than
+// Server-Sent Events are buffered like any other, up to streamThreshold.
+func responseBodyForPlugins(resp *http.Response) ([]byte, error) {
+	if resp.Header.Get("Content-Encoding") != "" || isEventStream(resp.Header) {
+		return nil, nil
+	}
+	body, rest, err := bufferBody(resp.Body, resp.ContentLength)
+	if err != nil {
+		return nil, fmt.Errorf("reading response body for plugins: %w", err)
+	}
+	resp.Body = rest
+	return body, nil
+}
+
+// beforePersist runs the before_persist hooks on a record about to be
+// stored. The plugins see the record after redaction; bodies kept in the
+// blob store or only partly captured are passed as null.
+func (s *pluginState) beforePersist(ctx context.Context, record *db.TrafficRecord, reqBuffered, respBuffered bool) {
+	hooked := s.set.with(plugin.BeforePersist)
+	if len(hooked) == 0 {
+		return
+	}
+	req := &plugin.Request{Method: record.Method, URL: record.URL, Headers: decodeHeaderJSON(record.RequestHeaders)}
+	if reqBuffered {
+		req.Body = nonNilBody(record.RequestBody)
+	}
+	resp := &plugin.Response{Status: record.ResponseStatus, Headers: decodeHeaderJSON(record.ResponseHeaders)}
+	if respBuffered {
+		resp.Body = nonNilBody(record.ResponseBody)
+	}
+
+	for _, p := range hooked {
+		result := s.call(ctx, p, plugin.Input{Hook: plugin.BeforePersist, Request: req, Response: resp})
+		if result == nil {
+			continue
+		}
+		if out := result.Request; out != nil {
+			if out.Headers != nil {
+				req.Headers = out.Headers
+			}
+			if out.Body != nil && req.Body != nil {
+				req.Body = out.Body
+			}
+		}
+		if out := result.Response; out != nil {
+			if out.Headers != nil {
+				resp.Headers = out.Headers
+			}
+			if out.Body != nil && resp.Body != nil {
+				resp.Body = out.Body
+			}
+		}
+	}
+
+	headers, _ := json.Marshal(req.Headers)
+	record.RequestHeaders = string(headers)
+	headers, _ = json.Marshal(resp.Headers)
+	record.ResponseHeaders = string(headers)
+	if reqBuffered {
+		record.RequestBody = req.Body
+	}
+	if respBuffered {
+		record.ResponseBody = resp.Body
+	}
+}
+
+// writePluginResponse serves the response a plugin returned for a request
+func writePluginResponse(w http.ResponseWriter, resp *plugin.Response) {
+	for name, values := range resp.Headers {
+		w.Header()[name] = values
+	}
+	w.Header().Set(sourceHeader, db.SourcePlugin)
+	w.Header().Set("Content-Length", strconv.Itoa(len(resp.Body)))
+	w.WriteHeader(pluginStatus(resp))
+	w.Write(resp.Body)
+}
+
+// pluginHTTPResponse turns the response a plugin returned for an outbound
+// request into the transport's response
+func pluginHTTPResponse(req *http.Request, resp *plugin.Response) *http.Response {
+	status := pluginStatus(resp)
+	header := resp.Headers.Clone()
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Length", strconv.Itoa(len(resp.Body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(resp.Body)),
+		ContentLength: int64(len(resp.Body)),
+		Request:       req,
+	}
+}
+
+func pluginStatus(resp *plugin.Response) int {
+	if resp.Status == 0 {
+		return http.StatusOK
+	}
+	return resp.Status
+}
+
+// bufferBody reads a body of up to streamThreshold bytes for plugins,
+// returning it with a reader replaying it. Larger bodies are returned as a
+// nil body with a reader over the whole body.
+func bufferBody(body io.ReadCloser, length int64) ([]byte, io.ReadCloser, error) {
+	if body == nil || body == http.NoBody {
+		return []byte{}, body, nil
+	}
+	if length > streamThreshold {
+		return nil, body, nil
+	}
+	buf, err := io.ReadAll(io.LimitReader(body, streamThreshold+1))
+	if err != nil {
+		body.Close()
+		return nil, nil, err
+	}
+	if len(buf) > streamThreshold {
+		return nil, &multiReadCloser{Reader: io.MultiReader(bytes.NewReader(buf), body), Closer: body}, nil
+	}
+	body.Close()
+	return buf, io.NopCloser(bytes.NewReader(buf)), nil
+}
+
+type multiReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// decodeHeaderJSON parses headers stored as JSON
+func decodeHeaderJSON(s string) http.Header {
+	h := http.Header{}
+	if s != "" {
+		json.Unmarshal([]byte(s), &h)
+	}
+	return h
+}
+
+// nonNilBody returns body, or an empty body if nil, so plugins can tell an
+// empty body from one they were not given
+func nonNilBody(body []byte) []byte {
+	if body == nil {
+		return []byte{}
+	}
+	return body
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+// buildExamplePlugin compiles the tagger example to WebAssembly, skipping
+// the test if the toolchain cannot
+func buildExamplePlugin(t *testing.T) string {
+	t.Helper()
+	out := filepath.Join(t.TempDir(), "tagger.wasm")
+	cmd := exec.Command("go", "build", "-buildmode=c-shared", "-o", out, "../../examples/plugins/tagger")
+	cmd.Env = append(os.Environ(), "GOOS=wasip1", "GOARCH=wasm")
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Skipf("Cannot build the example plugin: %v\n%s", err, output)
+	}
+	return out
+}
+
+func TestPluginHooks(t *testing.T) {
+	wasm := buildExamplePlugin(t)
+
+	var upstreamCalls atomic.Int32
+	var pluginHeader atomic.Value
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCalls.Add(1)
+		pluginHeader.Store(r.Header.Get("X-Jarvis-Plugin"))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id":7,"token":"t0k3n"}`))
+	}))
+	defer targetServer.Close()
+
+	tempDB, err := os.CreateTemp("", "test_plugins_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		RecordingMode: true,
+		Plugins: []config.PluginConfig{{
+			Name:    "tagger",
+			Path:    wasm,
+			Timeout: 5 * time.Second,
+			Config:  map[string]string{"health_path": "/healthz", "mask_fields": "password,token"},
+		}},
+		Redaction: config.RedactionConfig{Disabled: true},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := buildHTTPHandler(ctx, cfg, database, records, "HTTP proxy error")
+
+	// Proxied requests pass through every hook
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"user":"ada","password":"hunter2"}`))
+	req.Header.Set("User-Agent", "curl/8.5.0")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"id":7,"token":"t0k3n"}` {
+		t.Fatalf("Expected the target's response, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if got := pluginHeader.Load(); got != "tagger" {
+		t.Errorf("Expected the before_upstream hook to set a header, target saw %v", got)
+	}
+	if rr.Header().Get("X-Jarvis-Tagged") != "true" {
+		t.Errorf("Expected the response_received hook to set a header, got %v", rr.Header())
+	}
+
+	// Health checks are answered by the plugin
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != `{"status":"ok"}` {
+		t.Errorf("Expected the plugin's health response, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if rr.Header().Get(sourceHeader) != db.SourcePlugin {
+		t.Errorf("Expected the response to be marked as served by a plugin, got %q", rr.Header().Get(sourceHeader))
+	}
+	if n := upstreamCalls.Load(); n != 1 {
+		t.Errorf("Expected the health check not to reach the target, got %d calls", n)
+	}
+
+	waitForSource(t, database, db.SourceLive, 1)
+	waitForSource(t, database, db.SourcePlugin, 1)
+
+	var reqBody, respBody []byte
+	var tags string
+	err = database.QueryRow(`SELECT request_body, response_body, tags FROM traffic_records WHERE url = '/login'`).Scan(&reqBody, &respBody, &tags)
+	if err != nil {
+		t.Fatalf("Failed to query record: %v", err)
+	}
+	// The before_persist hook masks fields in the stored exchange only
+	if strings.Contains(string(reqBody), "hunter2") || strings.Contains(string(respBody), "t0k3n") {
+		t.Errorf("Expected masked bodies to be stored, got %s and %s", reqBody, respBody)
+	}
+	var gotTags map[string]string
+	if err := json.Unmarshal([]byte(tags), &gotTags); err != nil {
+		t.Fatalf("Expected tags stored as JSON, got %q: %v", tags, err)
+	}
+	if gotTags["client"] != "curl" || gotTags["status_class"] != "2xx" {
+		t.Errorf("Expected client and status class tags, got %v", gotTags)
+	}
+}
+
+func TestPluginsOutliveRetiredHandler(t *testing.T) {
+	wasm := buildExamplePlugin(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		w.Write([]byte(`{}`))
+	}))
+	defer targetServer.Close()
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		Plugins:       []config.PluginConfig{{Name: "tagger", Path: wasm, Timeout: 5 * time.Second}},
+		Redaction:     config.RedactionConfig{Disabled: true},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := buildHTTPHandler(ctx, cfg, nil, nil, "HTTP proxy error")
+	set := pluginsFor(cfg)
+
+	inFlight := make(chan *httptest.ResponseRecorder)
+	go func() {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/slow", nil))
+		inFlight <- rr
+	}()
+	<-started
+
+	// Retiring the handler keeps the plugins of the running request open
+	state := func() (handlers int, closed bool) {
+		set.mu.Lock()
+		defer set.mu.Unlock()
+		return set.handlers, set.closed
+	}
+	cancel()
+	for deadline := time.Now().Add(2 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if handlers, _ := state(); handlers == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Handler did not release the plugins")
+		}
+	}
+	if _, closed := state(); closed {
+		t.Fatal("Expected the plugins to stay open for the in-flight request")
+	}
+	close(release)
+	select {
+	case rr := <-inFlight:
+		if rr.Header().Get("X-Jarvis-Tagged") != "true" {
+			t.Errorf("Expected the in-flight request to finish its hooks, got %v", rr.Header())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("In-flight request did not finish")
+	}
+	if _, closed := state(); !closed {
+		t.Error("Expected the plugins to be closed after the last request")
+	}
+
+	// Late requests on the retired handler do not load the plugins again
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if rr.Header().Get("X-Jarvis-Tagged") != "" {
+		t.Errorf("Expected the retired handler to run without plugins, got %v", rr.Header())
+	}
+	if _, ok := pluginSets.Load(cfg); ok {
+		t.Error("Expected the retired plugins not to be cached")
+	}
+}
+
+func TestResponseBodyForPlugins(t *testing.T) {
+	large := strings.Repeat("x", streamThreshold+1)
+	tests := []struct {
+		name     string
+		header   http.Header
+		body     string
+		wantBody bool
+	}{
+		{"Chunked JSON", http.Header{"Content-Type": {"application/json"}}, `{"id":7}`, true},
+		{"Server-Sent Events", http.Header{"Content-Type": {"text/event-stream"}}, "data: 1\n\n", false},
+		{"Compressed", http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"gzip"}}, "gz", false},
+		{"Too large", http.Header{"Content-Type": {"application/json"}}, large, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// A body of unknown length, as sent in chunks
+			resp := &http.Response{StatusCode: http.StatusOK, Header: tt.header, ContentLength: -1, Body: io.NopCloser(strings.NewReader(tt.body))}
+			body, err := responseBodyForPlugins(resp)
+			if err != nil {
+				t.Fatalf("responseBodyForPlugins() error: %v", err)
+			}
+			if tt.wantBody && string(body) != tt.body {
+				t.Errorf("Expected the buffered body %q, got %q", tt.body, body)
+			}
+			if !tt.wantBody && body != nil {
+				t.Errorf("Expected no body for plugins, got %d bytes", len(body))
+			}
+			// The client still gets the whole body
+			if rest, _ := io.ReadAll(resp.Body); string(rest) != tt.body {
+				t.Errorf("Expected the full body to remain readable, got %d bytes", len(rest))
+			}
+		})
+	}
+}
@@ -0,0 +1,16 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+// RegisterRecorderRoutes registers the recorder counters on the UI server mux
+func RegisterRecorderRoutes(mux *http.ServeMux, records *db.Writer) {
+	mux.HandleFunc("GET /api/recorder/stats", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(records.Stats())
+	})
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"sync"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/redact"
+)
+
+// redactors caches the redactor built for each configuration
+var redactors sync.Map // *config.Config -> *redact.Redactor
+
+// redactorFor returns the redactor for cfg. Invalid rules are rejected when the
+// config is loaded; a hand-built config with bad rules falls back to the
+// built-in rules rather than storing traffic unredacted.
+func redactorFor(cfg *config.Config) *redact.Redactor {
+	if r, ok := redactors.Load(cfg); ok {
+		return r.(*redact.Redactor)
+	}
+	r, err := redact.New(cfg.Redaction)
+	if err != nil {
+		slog.Error("Invalid redaction rules, using the built-in rules only", "error", err)
+		r, _ = redact.New(config.RedactionConfig{})
+	}
+	actual, _ := redactors.LoadOrStore(cfg, r)
+	return actual.(*redact.Redactor)
+}
+
+// redactedHeaderJSON encodes headers for storage with sensitive values masked
+func redactedHeaderJSON(red *redact.Redactor, h map[string][]string) []byte {
+	b, _ := json.Marshal(red.Header(h))
+	return b
+}
+
+// redactUpstream masks the rewritten request and original response of an
+// upstream exchange
+func redactUpstream(red *redact.Redactor, u *upstreamExchange) *upstreamExchange {
+	out := *u
+	out.URL = red.URL(u.URL)
+	out.RequestHeaders = red.Header(u.RequestHeaders)
+	out.RequestBody = red.Body(u.RequestBody)
+	out.ResponseHeaders = red.Header(u.ResponseHeaders)
+	out.ResponseBody = red.Body(u.ResponseBody)
+	return &out
+}
@@ -0,0 +1,223 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+// generation is the handler built for one configuration. Requests keep the
+// generation they started on, so a reload never changes a request mid-flight.
+type generation struct {
+	cfg       *config.Config
+	handler   http.Handler
+	tlsConfig *tls.Config // Inbound TLS, only for the HTTPS server
+	cancel    context.CancelFunc
+}
+
+// reloadableServer serves every request with the current generation and can
+// swap to a new configuration without restarting the listener
+type reloadableServer struct {
+	*http.Server
+	name    string
+	ctx     context.Context
+	build   func(ctx context.Context, cfg *config.Config) (http.Handler, error)
+	loadTLS func(cfg *config.Config) (*tls.Config, error)
+	current atomic.Pointer[generation]
+}
+
+// newReloadableServer builds the first generation for cfg. Background work of
+// a generation, such as health checks, stops when it is replaced or ctx is done.
+func newReloadableServer(
+	ctx context.Context,
+	name string,
+	cfg *config.Config,
+	build func(ctx context.Context, cfg *config.Config) (http.Handler, error),
+	loadTLS func(cfg *config.Config) (*tls.Config, error),
+) (*reloadableServer, error) {
+	srv := &reloadableServer{name: name, ctx: ctx, build: build, loadTLS: loadTLS}
+	gen, err := srv.prepare(cfg)
+	if err != nil {
+		return nil, err
+	}
+	srv.current.Store(gen)
+	return srv, nil
+}
+
+func (s *reloadableServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.current.Load().handler.ServeHTTP(w, r)
+}
+
+// getConfigForClient returns the inbound TLS settings of the current generation
+func (s *reloadableServer) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return s.current.Load().tlsConfig, nil
+}
+
+// prepare builds a generation for cfg without serving it yet
+func (s *reloadableServer) prepare(cfg *config.Config) (*generation, error) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	gen := &generation{cfg: cfg, cancel: cancel}
+	if s.loadTLS != nil {
+		tlsConfig, err := s.loadTLS(cfg)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("%s server: %w", s.name, err)
+		}
+		gen.tlsConfig = tlsConfig
+	}
+	handler, err := s.build(ctx, cfg)
+	if err != nil {
+		cancel()
+		return nil, fmt.Errorf("%s server: %w", s.name, err)
+	}
+	gen.handler = handler
+	return gen, nil
+}
+
+// swap makes gen current and returns the generation it replaced
+func (s *reloadableServer) swap(gen *generation) *generation {
+	return s.current.Swap(gen)
+}
+
+// retire stops the background work of a replaced generation and closes its
+// idle upstream connections. Requests still running on it finish normally.
+func (gen *generation) retire() {
+	gen.cancel()
+	balancers.Delete(gen.cfg)
+	redactors.Delete(gen.cfg)
+	blobStores.Delete(gen.cfg)
+	chatClients.Delete(gen.cfg)
+	authProviders.Delete(gen.cfg)
+}
+
+// restartOnlySettings lists settings that only take effect after a restart
+// because they decide which listeners and database are opened
+func restartOnlySettings(old, cfg *config.Config) []string {
+	var changed []string
+	if old.HTTPPort != cfg.HTTPPort {
+		changed = append(changed, "http_port")
+	}
+	if old.UIPort != cfg.UIPort {
+		changed = append(changed, "ui_port")
+	}
+	if old.TLS.Enabled != cfg.TLS.Enabled || old.TLS.Port != cfg.TLS.Port {
+		changed = append(changed, "tls.enabled/tls.port")
+	}
+	if old.GRPC.Enabled != cfg.GRPC.Enabled || old.GRPC.Port != cfg.GRPC.Port {
+		changed = append(changed, "grpc.enabled/grpc.port")
+	}
+	if old.SQLiteDBPath != cfg.SQLiteDBPath {
+		changed = append(changed, "sqlite_db_path")
+	}
+	if old.Recorder != cfg.Recorder {
+		changed = append(changed, "recorder")
+	}
+	if old.BlobStore != cfg.BlobStore {
+		changed = append(changed, "blob_store")
+	}
+	if old.Tracing != cfg.Tracing {
+		changed = append(changed, "tracing")
+	}
+	return changed
+}
+
+// Reloader swaps the configuration of running proxy servers. A new
+// configuration is only applied when every server accepts it; otherwise the
+// current one is kept.
+type Reloader struct {
+	mu      sync.Mutex
+	load    func() (*config.Config, error)
+	cfg     *config.Config
+	servers []*reloadableServer
+}
+
+// NewReloader returns a reloader for servers started with cfg. load reads and
+// validates the configuration again.
+func NewReloader(cfg *config.Config, load func() (*config.Config, error)) *Reloader {
+	return &Reloader{cfg: cfg, load: load}
+}
+
+// Add registers a server returned by one of the Start functions. Servers that
+// cannot be reloaded are ignored.
+func (r *Reloader) Add(srv Server) {
+	rs, ok := srv.(*reloadableServer)
+	if !ok || rs == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.servers = append(r.servers, rs)
+}
+
+// Config returns the configuration currently served
+func (r *Reloader) Config() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.cfg
+}
+
+// Reload loads the configuration and applies it to every server. On error the
+// current configuration stays in effect.
+func (r *Reloader) Reload() (*config.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := r.load()
+	if err != nil {
+		slog.Error("Rejected new configuration, keeping the current one", "error", err)
+		return nil, err
+	}
+	// The web UI reads bodies from the blob store opened at startup
+	changed := restartOnlySettings(r.cfg, cfg)
+	cfg.BlobStore = r.cfg.BlobStore
+
+	// Build every server's generation before swapping any, so a bad TLS
+	// certificate cannot leave the servers on different configurations
+	gens := make([]*generation, len(r.servers))
+	for i, srv := range r.servers {
+		gen, err := srv.prepare(cfg)
+		if err != nil {
+			for _, prepared := range gens[:i] {
+				prepared.retire()
+			}
+			slog.Error("Rejected new configuration, keeping the current one", "error", err)
+			return nil, err
+		}
+		gens[i] = gen
+	}
+	for i, srv := range r.servers {
+		srv.swap(gens[i]).retire()
+	}
+
+	if len(changed) > 0 {
+		slog.Warn("Some changed settings only take effect after a restart", "settings", changed)
+	}
+	r.cfg = cfg
+	slog.Info("Configuration reloaded", "servers", len(r.servers), "routes", len(cfg.TargetRoutes))
+	logRoutes(cfg)
+	return cfg, nil
+}
+
+// RegisterRoutes registers the reload endpoint on the UI server mux
+func (r *Reloader) RegisterRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("POST /api/config/reload", r.handleReload)
+}
+
+// handleReload reloads the configuration file on demand
+func (r *Reloader) handleReload(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	cfg, err := r.Reload()
+	if err != nil {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]any{"reloaded": false, "error": err.Error()})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"reloaded": true, "routes": len(cfg.TargetRoutes)})
+}
@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+func TestReloaderSwapsConfiguration(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+	oldClosed := make(chan struct{}, 1)
+	oldTarget := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+		io.WriteString(w, "old")
+	}))
+	oldTarget.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateClosed {
+			oldClosed <- struct{}{}
+		}
+	}
+	oldTarget.Start()
+	defer oldTarget.Close()
+	newTarget := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "new")
+	}))
+	defer newTarget.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := &config.Config{TargetRoutes: []config.TargetRoute{{PathPrefix: "/", TargetURL: oldTarget.URL}}}
+	srv, err := newReloadableServer(ctx, "HTTP", cfg, func(ctx context.Context, cfg *config.Config) (http.Handler, error) {
+		return buildHTTPHandler(ctx, cfg, nil, nil, "HTTP proxy error"), nil
+	}, nil)
+	if err != nil {
+		t.Fatalf("Failed to build server: %v", err)
+	}
+
+	var next *config.Config
+	var loadErr error
+	reloader := NewReloader(cfg, func() (*config.Config, error) { return next, loadErr })
+	reloader.Add(srv)
+	reloader.Add(nil) // Servers that are not started are ignored
+
+	get := func(path string) string {
+		rr := httptest.NewRecorder()
+		srv.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, path, nil))
+		return rr.Body.String()
+	}
+
+	// Start a request on the old configuration and keep it in flight
+	inFlight := make(chan string)
+	go func() { inFlight <- get("/slow") }()
+	<-started
+
+	next = &config.Config{TargetRoutes: []config.TargetRoute{{PathPrefix: "/", TargetURL: newTarget.URL}}}
+	if _, err := reloader.Reload(); err != nil {
+		t.Fatalf("Reload() error: %v", err)
+	}
+	if got := get("/fast"); got != "new" {
+		t.Errorf("Expected new requests to use the new configuration, got %q", got)
+	}
+
+	close(release)
+	select {
+	case got := <-inFlight:
+		if got != "old" {
+			t.Errorf("Expected the in-flight request to finish on the old configuration, got %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("In-flight request did not finish")
+	}
+	// The retired generation does not keep its upstream connection alive
+	select {
+	case <-oldClosed:
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the old generation's idle connection to be closed")
+	}
+
+	// An invalid configuration is rejected and the current one kept
+	loadErr = errors.New("target_routes[0]: path_prefix is required")
+	if _, err := reloader.Reload(); err == nil {
+		t.Fatal("Expected Reload() to fail")
+	}
+	if reloader.Config() != next {
+		t.Error("Expected the reloader to keep the current configuration")
+	}
+	if got := get("/fast"); got != "new" {
+		t.Errorf("Expected the current configuration to stay in effect, got %q", got)
+	}
+}
+
+func TestReloaderRejectsBadTLS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	cfg := &config.Config{HTTPTargetURL: "http://old"}
+	build := func(ctx context.Context, cfg *config.Config) (http.Handler, error) {
+		return http.NotFoundHandler(), nil
+	}
+	plain, _ := newReloadableServer(ctx, "HTTP", cfg, build, nil)
+	secure, _ := newReloadableServer(ctx, "HTTPS", cfg, build, func(cfg *config.Config) (*tls.Config, error) {
+		if cfg.TLS.CertFile == "missing.crt" {
+			return nil, errors.New("loading TLS certificate: no such file")
+		}
+		return &tls.Config{}, nil
+	})
+
+	next := &config.Config{HTTPTargetURL: "http://new"}
+	next.TLS.CertFile = "missing.crt"
+	reloader := NewReloader(cfg, func() (*config.Config, error) { return next, nil })
+	reloader.Add(plain)
+	reloader.Add(secure)
+
+	if _, err := reloader.Reload(); err == nil || !strings.Contains(err.Error(), "HTTPS server") {
+		t.Fatalf("Expected the HTTPS server to reject the reload, got %v", err)
+	}
+	if plain.current.Load().cfg != cfg || secure.current.Load().cfg != cfg {
+		t.Error("Expected every server to keep the old configuration")
+	}
+}
+
+func TestReloadEndpoint(t *testing.T) {
+	cfg := &config.Config{}
+	var loadErr error
+	reloader := NewReloader(cfg, func() (*config.Config, error) {
+		if loadErr != nil {
+			return nil, loadErr
+		}
+		return &config.Config{TargetRoutes: []config.TargetRoute{{PathPrefix: "/", TargetURL: "http://a"}}}, nil
+	})
+	mux := http.NewServeMux()
+	reloader.RegisterRoutes(mux)
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/config/reload", nil))
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"reloaded":true`) {
+		t.Errorf("Expected a successful reload, got %d %s", rr.Code, rr.Body.String())
+	}
+
+	loadErr = errors.New("reading config file: bad yaml")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/api/config/reload", nil))
+	if rr.Code != http.StatusUnprocessableEntity || !strings.Contains(rr.Body.String(), "bad yaml") {
+		t.Errorf("Expected the rejected reload to be reported, got %d %s", rr.Code, rr.Body.String())
+	}
+}
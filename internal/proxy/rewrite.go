@@ -0,0 +1,246 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/redact"
+)
+
+// upstreamExchange is the exchange as seen by the target when rewrite rules
+// changed it: the rewritten request and the response before it was rewritten.
+// It is stored next to the client's view of the exchange.
+type upstreamExchange struct {
+	URL             string      `json:"url"`
+	RequestHeaders  http.Header `json:"request_headers"`
+	RequestBody     []byte      `json:"request_body,omitempty"`
+	ResponseStatus  int         `json:"response_status,omitempty"`
+	ResponseHeaders http.Header `json:"response_headers,omitempty"`
+	ResponseBody    []byte      `json:"response_body,omitempty"`
+}
+
+// rewriteState carries the rules selected for a request from the director to
+// the response rewriter, along with the captured upstream exchange
+type rewriteState struct {
+	rules    []*config.RewriteRule
+	upstream *upstreamExchange
+}
+
+type rewriteStateKey struct{}
+
+// withRewriteState attaches an empty rewrite state to the request
+func withRewriteState(r *http.Request) (*http.Request, *rewriteState) {
+	state := &rewriteState{}
+	return r.WithContext(context.WithValue(r.Context(), rewriteStateKey{}, state)), state
+}
+
+func rewriteStateFrom(ctx context.Context) *rewriteState {
+	state, _ := ctx.Value(rewriteStateKey{}).(*rewriteState)
+	return state
+}
+
+// requestRewriter applies the request half of the rewrite rules
+type requestRewriter struct {
+	rules    []config.RewriteRule
+	patterns []*regexp.Regexp // Compiled path_pattern per rule, nil when unset
+}
+
+func newRequestRewriter(rules []config.RewriteRule) *requestRewriter {
+	rw := &requestRewriter{rules: rules, patterns: make([]*regexp.Regexp, len(rules))}
+	for i, rule := range rules {
+		if rule.Request.PathPattern == "" {
+			continue
+		}
+		pattern, err := regexp.Compile(rule.Request.PathPattern)
+		if err != nil {
+			// Rejected by config validation; only reachable with a hand-built config
+			slog.Warn("Ignoring invalid rewrite path pattern", "path_prefix", rule.PathPrefix, "error", err)
+			continue
+		}
+		rw.patterns[i] = pattern
+	}
+	return rw
+}
+
+// selectRules returns the indexes of every rule matching the request
+func (rw *requestRewriter) selectRules(r *http.Request) []int {
+	var selected []int
+	for i, rule := range rw.rules {
+		if !strings.HasPrefix(r.URL.Path, strings.TrimSuffix(rule.PathPrefix, "/*")) {
+			continue
+		}
+		if len(rule.Methods) > 0 && !containsFold(rule.Methods, r.Method) {
+			continue
+		}
+		selected = append(selected, i)
+	}
+	return selected
+}
+
+// rewrite applies the selected rules to an outgoing request and reports
+// whether the body was patched
+func (rw *requestRewriter) rewrite(req *http.Request, selected []int) (bodyPatched bool) {
+	var patches []config.BodyPatch
+	for _, i := range selected {
+		rule := rw.rules[i].Request
+		applyHeaderRewrite(req.Header, rule.Headers)
+
+		path := req.URL.Path
+		if rule.StripPrefix != "" {
+			path = ensureLeadingSlash(strings.TrimPrefix(path, strings.TrimSuffix(rule.StripPrefix, "/")))
+		}
+		if rule.AddPrefix != "" {
+			path = strings.TrimSuffix(rule.AddPrefix, "/") + path
+		}
+		if rw.patterns[i] != nil {
+			path = rw.patterns[i].ReplaceAllString(path, rule.PathReplacement)
+		}
+		if path != req.URL.Path {
+			req.URL.Path = path
+			req.URL.RawPath = ""
+		}
+
+		if len(rule.SetQuery) > 0 || len(rule.RemoveQuery) > 0 {
+			query := req.URL.Query()
+			for _, name := range rule.RemoveQuery {
+				query.Del(name)
+			}
+			for _, param := range rule.SetQuery {
+				query.Set(param.Name, param.Value)
+			}
+			req.URL.RawQuery = query.Encode()
+		}
+
+		patches = append(patches, rule.Body...)
+	}
+
+	if len(patches) == 0 || req.Body == nil || req.Body == http.NoBody {
+		return false
+	}
+	body, err := io.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		slog.Warn("Error reading request body for rewrite", "url", req.URL.String(), "error", err)
+		body = nil
+	}
+	patched, err := applyBodyPatches(body, patches)
+	if err != nil {
+		slog.Warn("Request body rewrite failed, forwarding the original body", "url", req.URL.String(), "error", err)
+		patched = body
+	} else {
+		bodyPatched = true
+	}
+	setRequestBody(req, patched)
+	return bodyPatched
+}
+
+// setRequestBody replaces a request body and its length
+func setRequestBody(req *http.Request, body []byte) {
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	req.ContentLength = int64(len(body))
+	req.Header.Set("Content-Length", strconv.Itoa(len(body)))
+}
+
+// captureUpstreamRequest records the request as it is sent to the target
+func captureUpstreamRequest(state *rewriteState, req *http.Request, bodyPatched bool) {
+	state.upstream = &upstreamExchange{
+		URL:            req.URL.String(),
+		RequestHeaders: req.Header.Clone(),
+	}
+	if bodyPatched && req.GetBody != nil {
+		if body, err := req.GetBody(); err == nil {
+			state.upstream.RequestBody, _ = io.ReadAll(body)
+		}
+	}
+}
+
+// rewriteResponse is a ReverseProxy.ModifyResponse hook applying the response
+// half of the rules the director selected for the request
+func rewriteResponse(resp *http.Response) error {
+	state := rewriteStateFrom(resp.Request.Context())
+	if state == nil || state.upstream == nil {
+		return nil
+	}
+	state.upstream.ResponseStatus = resp.StatusCode
+	state.upstream.ResponseHeaders = resp.Header.Clone()
+
+	var patches []config.BodyPatch
+	for _, rule := range state.rules {
+		applyHeaderRewrite(resp.Header, rule.Response.Headers)
+		patches = append(patches, rule.Response.Body...)
+	}
+	if len(patches) == 0 {
+		return nil
+	}
+
+	// Only plain JSON bodies small enough to buffer are patched; chunked ones
+	// are read up to streamThreshold
+	if !strings.Contains(resp.Header.Get("Content-Type"), "json") || resp.Header.Get("Content-Encoding") != "" {
+		slog.Info("Skipping response body rewrite", "url", resp.Request.URL.String(), "content_type", resp.Header.Get("Content-Type"))
+		return nil
+	}
+	body, rest, err := bufferBody(resp.Body, resp.ContentLength)
+	if err != nil {
+		return fmt.Errorf("reading response body for rewrite: %w", err)
+	}
+	if body == nil {
+		resp.Body = rest
+		slog.Info("Skipping response body rewrite of a large body", "url", resp.Request.URL.String())
+		return nil
+	}
+	state.upstream.ResponseBody = body
+
+	patched, err := applyBodyPatches(body, patches)
+	if err != nil {
+		slog.Warn("Response body rewrite failed, returning the original body", "url", resp.Request.URL.String(), "error", err)
+		patched = body
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(patched))
+	resp.ContentLength = int64(len(patched))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(patched)))
+	return nil
+}
+
+// applyHeaderRewrite removes, then sets, then adds headers
+func applyHeaderRewrite(h http.Header, rw config.HeaderRewrite) {
+	for _, name := range rw.Remove {
+		h.Del(name)
+	}
+	for name, value := range rw.Set {
+		h.Set(name, value)
+	}
+	for name, value := range rw.Add {
+		h.Add(name, value)
+	}
+}
+
+// upstreamJSON encodes the redacted upstream exchange for storage, or returns
+// "" if nothing was rewritten
+func upstreamJSON(state *rewriteState, red *redact.Redactor) string {
+	if state == nil || state.upstream == nil {
+		return ""
+	}
+	b, err := json.Marshal(redactUpstream(red, state.upstream))
+	if err != nil {
+		slog.Warn("Error encoding upstream exchange", "error", err)
+		return ""
+	}
+	return string(b)
+}
+
+func ensureLeadingSlash(path string) string {
+	if !strings.HasPrefix(path, "/") {
+		return "/" + path
+	}
+	return path
+}
@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+func TestApplyBodyPatches(t *testing.T) {
+	doc := `{"user":{"name":"ada","tags":["a","b"]},"count":1}`
+
+	tests := []struct {
+		name    string
+		patches []config.BodyPatch
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "Replace with JSON Pointer",
+			patches: []config.BodyPatch{{Op: "replace", Path: "/user/name", Value: "grace"}},
+			want:    `{"count":1,"user":{"name":"grace","tags":["a","b"]}}`,
+		},
+		{
+			name:    "Add with JSONPath",
+			patches: []config.BodyPatch{{Op: "add", Path: "$.user.id", Value: 7}},
+			want:    `{"count":1,"user":{"id":7,"name":"ada","tags":["a","b"]}}`,
+		},
+		{
+			name:    "Insert and append array elements",
+			patches: []config.BodyPatch{{Op: "add", Path: "/user/tags/0", Value: "z"}, {Op: "add", Path: "/user/tags/-", Value: "c"}},
+			want:    `{"count":1,"user":{"name":"ada","tags":["z","a","b","c"]}}`,
+		},
+		{
+			name:    "Remove array element with JSONPath",
+			patches: []config.BodyPatch{{Op: "remove", Path: "$.user.tags[0]"}},
+			want:    `{"count":1,"user":{"name":"ada","tags":["b"]}}`,
+		},
+		{
+			name:    "Move and copy",
+			patches: []config.BodyPatch{{Op: "move", From: "/count", Path: "/total"}, {Op: "copy", From: "/user/name", Path: "/owner"}},
+			want:    `{"owner":"ada","total":1,"user":{"name":"ada","tags":["a","b"]}}`,
+		},
+		{
+			name:    "Passing test",
+			patches: []config.BodyPatch{{Op: "test", Path: "/count", Value: 1}, {Op: "remove", Path: "/count"}},
+			want:    `{"user":{"name":"ada","tags":["a","b"]}}`,
+		},
+		{
+			name:    "Failing test",
+			patches: []config.BodyPatch{{Op: "test", Path: "/count", Value: 2}},
+			wantErr: true,
+		},
+		{
+			name:    "Replace missing member",
+			patches: []config.BodyPatch{{Op: "replace", Path: "/missing", Value: 1}},
+			wantErr: true,
+		},
+		{
+			name:    "Escaped pointer tokens",
+			patches: []config.BodyPatch{{Op: "add", Path: "/a~1b", Value: true}},
+			want:    `{"a/b":true,"count":1,"user":{"name":"ada","tags":["a","b"]}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyBodyPatches([]byte(doc), tt.patches)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("applyBodyPatches() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Errorf("applyBodyPatches() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRewriteRules(t *testing.T) {
+	// The target echoes what it received
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Internal", "secret")
+		json.NewEncoder(w).Encode(map[string]any{
+			"path":  r.URL.Path,
+			"query": r.URL.RawQuery,
+			"env":   r.Header.Get("X-Env"),
+			"body":  string(body),
+		})
+	}))
+	defer target.Close()
+
+	tempDB, err := os.CreateTemp("", "test_rewrite_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		RecordingMode: true,
+		TargetRoutes:  []config.TargetRoute{{PathPrefix: "/staging/*", TargetURL: target.URL, StripPrefix: true}},
+		Rewrites: []config.RewriteRule{{
+			PathPrefix: "/staging/orders",
+			Methods:    []string{http.MethodPost},
+			Request: config.RequestRewrite{
+				Headers:     config.HeaderRewrite{Set: map[string]string{"x-env": "staging"}, Remove: []string{"X-Debug"}},
+				AddPrefix:   "/v2",
+				SetQuery:    []config.QueryParam{{Name: "tenant", Value: "blue"}},
+				RemoveQuery: []string{"ts"},
+				Body:        []config.BodyPatch{{Op: "add", Path: "$.source", Value: "jarvis"}},
+			},
+			Response: config.ResponseRewrite{
+				Headers: config.HeaderRewrite{Remove: []string{"X-Internal"}, Add: map[string]string{"X-Rewritten": "true"}},
+				Body:    []config.BodyPatch{{Op: "remove", Path: "/env"}},
+			},
+		}},
+	}
+	proxy := &httputil.ReverseProxy{Director: newDirector(cfg), ModifyResponse: rewriteResponse}
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(proxy, cfg, database, records, pool)
+
+	req := httptest.NewRequest(http.MethodPost, "/staging/orders?ts=1&id=9", strings.NewReader(`{"item":"x"}`))
+	req.Header.Set("X-Debug", "1")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	var got map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("Failed to decode response %s: %v", rr.Body.String(), err)
+	}
+	if got["path"] != "/v2/orders" {
+		t.Errorf("Expected the prefix stripped and /v2 added, got %v", got["path"])
+	}
+	if got["query"] != "id=9&tenant=blue" {
+		t.Errorf("Expected rewritten query, got %v", got["query"])
+	}
+	if got["body"] != `{"item":"x","source":"jarvis"}` {
+		t.Errorf("Expected patched request body, got %v", got["body"])
+	}
+	if _, ok := got["env"]; ok {
+		t.Errorf("Expected env removed from the response body, got %v", got["env"])
+	}
+	if rr.Header().Get("X-Internal") != "" || rr.Header().Get("X-Rewritten") != "true" {
+		t.Errorf("Response headers not rewritten: %v", rr.Header())
+	}
+
+	waitForSource(t, database, db.SourceLive, 1)
+	var url, upstreamRaw string
+	var reqBody []byte
+	err = database.QueryRow(`SELECT url, request_body, upstream FROM traffic_records`).Scan(&url, &reqBody, &upstreamRaw)
+	if err != nil {
+		t.Fatalf("Failed to read record: %v", err)
+	}
+	if url != "/staging/orders?ts=1&id=9" || string(reqBody) != `{"item":"x"}` {
+		t.Errorf("Expected the original request to be recorded, got %s %s", url, reqBody)
+	}
+
+	var upstream upstreamExchange
+	if err := json.Unmarshal([]byte(upstreamRaw), &upstream); err != nil {
+		t.Fatalf("Failed to decode upstream exchange %q: %v", upstreamRaw, err)
+	}
+	if upstream.URL != target.URL+"/v2/orders?id=9&tenant=blue" {
+		t.Errorf("Unexpected upstream URL %s", upstream.URL)
+	}
+	if upstream.RequestHeaders.Get("X-Env") != "staging" || upstream.RequestHeaders.Get("X-Debug") != "" {
+		t.Errorf("Unexpected upstream request headers %v", upstream.RequestHeaders)
+	}
+	if string(upstream.RequestBody) != `{"item":"x","source":"jarvis"}` {
+		t.Errorf("Unexpected upstream request body %s", upstream.RequestBody)
+	}
+	if upstream.ResponseHeaders.Get("X-Internal") != "secret" || !strings.Contains(string(upstream.ResponseBody), `"env":"staging"`) {
+		t.Errorf("Expected the original response, got %v %s", upstream.ResponseHeaders, upstream.ResponseBody)
+	}
+}
+
+func TestRewriteResponseChunkedBody(t *testing.T) {
+	rule := &config.RewriteRule{Response: config.ResponseRewrite{Body: []config.BodyPatch{{Op: "remove", Path: "/env"}}}}
+	large := `{"env":"staging","pad":"` + strings.Repeat("x", streamThreshold) + `"}`
+
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"Small body is patched", `{"env":"staging","id":7}`, `{"id":7}`},
+		{"Large body is passed unchanged", large, large},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, state := withRewriteState(httptest.NewRequest(http.MethodGet, "/orders", nil))
+			state.rules = []*config.RewriteRule{rule}
+			state.upstream = &upstreamExchange{}
+			// A body of unknown length, as sent in chunks
+			resp := &http.Response{
+				StatusCode:    http.StatusOK,
+				Header:        http.Header{"Content-Type": {"application/json"}},
+				ContentLength: -1,
+				Body:          io.NopCloser(strings.NewReader(tt.body)),
+				Request:       req,
+			}
+			if err := rewriteResponse(resp); err != nil {
+				t.Fatalf("rewriteResponse() error: %v", err)
+			}
+			if got, _ := io.ReadAll(resp.Body); string(got) != tt.want {
+				t.Errorf("Expected a %d byte body, got %d bytes", len(tt.want), len(got))
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+// routeState carries the route resolved for a request and the upstream the
+// director picked for it, so the transport and the recorder agree on both
+type routeState struct {
+	match     *config.RouteMatch
+	pool      *upstreamPool   // nil for the default route
+	target    *upstreamTarget // nil for the default route
+	upstream  string          // Base URL the request was sent to
+	forwarded bool            // Sent to the host a forward-proxy client chose
+}
+
+type routeStateKey struct{}
+
+// withRouteState resolves the target route once per request
+func withRouteState(r *http.Request, cfg *config.Config) (*http.Request, *routeState) {
+	if state := routeStateFrom(r.Context()); state != nil {
+		return r, state
+	}
+	state := &routeState{match: cfg.MatchRoute(r)}
+	return r.WithContext(context.WithValue(r.Context(), routeStateKey{}, state)), state
+}
+
+func routeStateFrom(ctx context.Context) *routeState {
+	state, _ := ctx.Value(routeStateKey{}).(*routeState)
+	return state
+}
+
+// routeMatchFor returns the route resolved for the request, matching it
+// when the request did not pass through the handler
+func routeMatchFor(r *http.Request, cfg *config.Config) *config.RouteMatch {
+	if state := routeStateFrom(r.Context()); state != nil {
+		return state.match
+	}
+	return cfg.MatchRoute(r)
+}
+
+// routeOf returns the route of a match, or nil
+func routeOf(match *config.RouteMatch) *config.TargetRoute {
+	if match == nil {
+		return nil
+	}
+	return match.Route
+}
+
+// routeTransport runs plugins on outbound requests, applies per-route
+// timeouts, TLS settings and credentials to them and reports their outcome to
+// the load balancer. Routes without TLS overrides share the base transport.
+type routeTransport struct {
+	base       *http.Transport
+	cfg        *config.Config
+	transports sync.Map // *config.TargetRoute -> *http.Transport
+}
+
+func newRouteTransport(cfg *config.Config, base *http.Transport) *routeTransport {
+	return &routeTransport{base: base, cfg: cfg}
+}
+
+// RoundTrip implements http.RoundTripper, tracing the request to the upstream
+func (t *routeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req, span := startUpstreamSpan(req)
+	resp, err := t.roundTrip(req)
+	var upstream string
+	if state := routeStateFrom(req.Context()); state != nil {
+		upstream = state.upstream
+	}
+	endUpstreamSpan(span, upstream, resp, err)
+	return resp, err
+}
+
+func (t *routeTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	// Plugins see the request as it leaves, before credentials are added
+	if plugins := pluginStateFrom(req.Context()); plugins != nil {
+		var served *http.Response
+		var err error
+		if req, served, err = plugins.beforeUpstream(req); err != nil || served != nil {
+			return served, err
+		}
+	}
+
+	state := routeStateFrom(req.Context())
+	if state == nil || state.match == nil {
+		return t.base.RoundTrip(req)
+	}
+	route := state.match.Route
+
+	// Credentials are added as the request leaves, once it is final
+	if route.Auth != "" {
+		req = req.Clone(req.Context()) // A RoundTripper must not modify the caller's request
+		if err := authenticateUpstream(t.cfg, req); err != nil {
+			return nil, err
+		}
+	}
+
+	var release []func()
+	if state.target != nil {
+		state.target.active.Add(1)
+		release = append(release, func() { state.target.active.Add(-1) })
+	}
+	// Upgraded connections outlive the exchange, so they get no deadline
+	if route.Timeout > 0 && req.Header.Get("Upgrade") == "" {
+		ctx, cancel := context.WithTimeout(req.Context(), route.Timeout)
+		req = req.WithContext(ctx)
+		release = append(release, cancel)
+	}
+	done := func() {
+		for _, fn := range release {
+			fn()
+		}
+	}
+
+	resp, err := t.transportFor(route).RoundTrip(req)
+	if state.pool != nil {
+		state.pool.report(state.target, err == nil && resp.StatusCode < http.StatusInternalServerError)
+	}
+	if err != nil {
+		done()
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusSwitchingProtocols || len(release) == 0 {
+		// The body of an upgraded connection must stay writable
+		done()
+		return resp, nil
+	}
+	// The deadline and the connection count also cover reading the body
+	resp.Body = &closeHook{ReadCloser: resp.Body, fn: done}
+	return resp, nil
+}
+
+// transportFor returns the transport for a route, cloning the base transport
+// with the route's TLS settings the first time it is used
+func (t *routeTransport) transportFor(route *config.TargetRoute) *http.Transport {
+	if !route.TLS.IsSet() {
+		return t.base
+	}
+	if cached, ok := t.transports.Load(route); ok {
+		return cached.(*http.Transport)
+	}
+	tlsConfig, err := t.cfg.GetRouteTLSConfig(route)
+	if err != nil {
+		slog.Error("Invalid route TLS settings, using the global TLS config", "route", route.String(), "error", err)
+		return t.base
+	}
+	transport := t.base.Clone()
+	transport.TLSClientConfig = tlsConfig
+	actual, _ := t.transports.LoadOrStore(route, transport)
+	return actual.(*http.Transport)
+}
+
+// CloseIdleConnections closes the idle connections of the base transport and
+// of every route's transport
+func (t *routeTransport) CloseIdleConnections() {
+	t.base.CloseIdleConnections()
+	t.transports.Range(func(_, transport any) bool {
+		transport.(*http.Transport).CloseIdleConnections()
+		return true
+	})
+}
+
+// closeHook runs fn once when the response body is closed
+type closeHook struct {
+	io.ReadCloser
+	once sync.Once
+	fn   func()
+}
+
+func (c *closeHook) Close() error {
+	err := c.ReadCloser.Close()
+	c.once.Do(c.fn)
+	return err
+}
+
+// writeProxyError answers a failed upstream exchange with 504 when a route
+// timeout expired and 502 otherwise
+func writeProxyError(w http.ResponseWriter, err error) {
+	if errors.Is(err, context.DeadlineExceeded) {
+		w.WriteHeader(http.StatusGatewayTimeout)
+		return
+	}
+	w.WriteHeader(http.StatusBadGateway)
+}
+
+// logRoutes logs the routing table
+func logRoutes(cfg *config.Config) {
+	if len(cfg.TargetRoutes) > 0 {
+		slog.Info("Routing configuration:")
+		for _, route := range cfg.TargetRoutes {
+			slog.Info("Route mapping", "route", route.String(), "targets", route.Targets())
+		}
+	}
+	if cfg.HTTPTargetURL != "" {
+		slog.Info("Default route mapping", "target_url", cfg.HTTPTargetURL)
+	}
+}
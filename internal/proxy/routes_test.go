@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+func TestRouteSelectionAndTimeout(t *testing.T) {
+	newTarget := func(name string, delay time.Duration) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case <-time.After(delay):
+			case <-r.Context().Done():
+				return
+			}
+			io.WriteString(w, name+" "+r.URL.Path)
+		}))
+	}
+	users := newTarget("users", 0)
+	defer users.Close()
+	availability := newTarget("availability", 0)
+	defer availability.Close()
+	slow := newTarget("slow", 500*time.Millisecond)
+	defer slow.Close()
+
+	cfg := &config.Config{
+		TargetRoutes: []config.TargetRoute{
+			{PathPrefix: "/api/v1/users/", TargetURL: users.URL},
+			{PathPrefix: "/api/v1/users/is-available", TargetURL: availability.URL},
+			{Path: "/reports/{id}", Methods: []string{http.MethodGet}, TargetURL: slow.URL, Timeout: 50 * time.Millisecond},
+		},
+	}
+	proxy := &httputil.ReverseProxy{
+		Director:     newDirector(cfg),
+		Transport:    newRouteTransport(cfg, http.DefaultTransport.(*http.Transport).Clone()),
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) { writeProxyError(w, err) },
+	}
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(proxy, cfg, nil, nil, pool)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"Shorter prefix", "/api/v1/users/42", http.StatusOK, "users /api/v1/users/42"},
+		{"Longest prefix wins regardless of order", "/api/v1/users/is-available", http.StatusOK, "availability /api/v1/users/is-available"},
+		{"Route timeout returns 504", "/reports/1", http.StatusGatewayTimeout, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			start := time.Now()
+			handler(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, rr.Code)
+			}
+			if rr.Body.String() != tt.wantBody {
+				t.Errorf("Expected body %q, got %q", tt.wantBody, rr.Body.String())
+			}
+			if elapsed := time.Since(start); elapsed > 400*time.Millisecond {
+				t.Errorf("Request took %s, the route timeout was not applied", elapsed)
+			}
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package proxy
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Exhaustion policies for sequential replay
+const (
+	exhaustedRepeatLast  = "repeat_last"
+	exhaustedNotFound    = "not_found"
+	exhaustedPassthrough = "passthrough"
+)
+
+// sessionIdleTimeout is how long a replay session keeps its cursors without
+// requests; clients that never reset their sessions do not grow the cursors
+// without bound
+const sessionIdleTimeout = 30 * time.Minute
+
+// replaySessions holds the sequential replay cursors shared by all proxy servers
+var replaySessions = newSessionCursors(sessionIdleTimeout)
+
+// sessionCursors tracks, per session, how many times each request has been
+// replayed so the Nth request gets the Nth recorded response
+type sessionCursors struct {
+	mu       sync.Mutex
+	idle     time.Duration
+	pruned   time.Time // Last time idle sessions were dropped
+	sessions map[string]*sessionCursor
+}
+
+// sessionCursor holds the positions of one session
+type sessionCursor struct {
+	positions map[string]int // sequence key -> next position
+	lastUsed  time.Time
+}
+
+func newSessionCursors(idle time.Duration) *sessionCursors {
+	return &sessionCursors{idle: idle, pruned: time.Now(), sessions: make(map[string]*sessionCursor)}
+}
+
+// advance returns the current position for key in session and moves it forward
+func (s *sessionCursors) advance(session, key string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.pruneIdle(now)
+	cursor, ok := s.sessions[session]
+	if !ok {
+		cursor = &sessionCursor{positions: make(map[string]int)}
+		s.sessions[session] = cursor
+	}
+	cursor.lastUsed = now
+	pos := cursor.positions[key]
+	cursor.positions[key] = pos + 1
+	return pos
+}
+
+// pruneIdle drops the sessions idle for longer than s.idle, scanning them at
+// most once per idle period. The caller holds s.mu.
+func (s *sessionCursors) pruneIdle(now time.Time) {
+	if now.Sub(s.pruned) < s.idle {
+		return
+	}
+	s.pruned = now
+	for id, cursor := range s.sessions {
+		if now.Sub(cursor.lastUsed) > s.idle {
+			delete(s.sessions, id)
+		}
+	}
+}
+
+// reset clears the cursors of a session and reports how many were cleared
+func (s *sessionCursors) reset(session string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	if cursor, ok := s.sessions[session]; ok {
+		n = len(cursor.positions)
+	}
+	delete(s.sessions, session)
+	return n
+}
+
+// resetAll clears every session and reports how many sessions were cleared
+func (s *sessionCursors) resetAll() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.sessions)
+	s.sessions = make(map[string]*sessionCursor)
+	return n
+}
+
+// replaySessionID returns the session a replayed request belongs to
+func replaySessionID(r *http.Request) string {
+	if id := r.Header.Get("X-Session-ID"); id != "" {
+		return id
+	}
+	return r.Header.Get("X-Test-ID")
+}
+
+// sequenceKey identifies "the same request" within a session
+func sequenceKey(r *http.Request) string {
+	return routeKey(r.Method, r.URL) + "?" + normalizeQuery(r.URL.RawQuery, "sort", nil)
+}
+
+// replaySequence returns the best scoring eligible candidates in recording
+// order. Recordings made in the same session are preferred when there are any.
+func replaySequence(session string, results []matchResult, minScore float64) []*matchResult {
+	var eligible []*matchResult
+	topScore := -1.0
+	for i := range results {
+		res := &results[i]
+		if !res.Route || res.Score < minScore {
+			continue
+		}
+		switch {
+		case res.Score > topScore:
+			topScore = res.Score
+			eligible = []*matchResult{res}
+		case res.Score == topScore:
+			eligible = append(eligible, res)
+		}
+	}
+
+	var own []*matchResult
+	for _, res := range eligible {
+		if res.Candidate.SessionID == session || res.Candidate.TestID == session {
+			own = append(own, res)
+		}
+	}
+	if len(own) > 0 {
+		eligible = own
+	}
+
+	sort.SliceStable(eligible, func(i, j int) bool {
+		return eligible[i].Candidate.Timestamp.Before(eligible[j].Candidate.Timestamp)
+	})
+	return eligible
+}
+
+// RegisterReplayRoutes registers the replay session endpoints on mux
+func RegisterReplayRoutes(mux *http.ServeMux) {
+	mux.HandleFunc("DELETE /api/replay/sessions/{id}", handleResetSession)
+	mux.HandleFunc("DELETE /api/replay/sessions", handleResetAllSessions)
+}
+
+// handleResetSession rewinds a session so its next requests replay from the first recording
+func handleResetSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	cleared := replaySessions.reset(id)
+	slog.Info("Reset replay session", "session_id", id, "cursors", cleared)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"session_id": id, "cursors_reset": cleared})
+}
+
+// handleResetAllSessions rewinds every replay session
+func handleResetAllSessions(w http.ResponseWriter, r *http.Request) {
+	cleared := replaySessions.resetAll()
+	slog.Info("Reset all replay sessions", "sessions", cleared)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{"sessions_reset": cleared})
+}
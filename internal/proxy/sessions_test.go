@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+func TestSequentialReplay(t *testing.T) {
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"status":"live"}`))
+	}))
+	defer live.Close()
+
+	tempDB, err := os.CreateTemp("", "test_sequence_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	// A job polled three times in the recorded session, plus a newer recording from another test
+	start := time.Now().Add(-time.Minute).UTC()
+	recordings := []struct {
+		testID string
+		body   string
+	}{
+		{"job-flow", `{"status":"pending"}`},
+		{"job-flow", `{"status":"running"}`},
+		{"job-flow", `{"status":"done"}`},
+		{"other-test", `{"status":"unrelated"}`},
+	}
+	for i, rec := range recordings {
+		err := db.Insert(context.Background(), stmt, db.TrafficRecord{
+			ID:              fmt.Sprintf("rec-%d", i),
+			Timestamp:       start.Add(time.Duration(i) * time.Second),
+			Protocol:        "HTTP",
+			Method:          http.MethodGet,
+			URL:             "https://api.example.com/jobs/42",
+			RequestHeaders:  "{}",
+			ResponseStatus:  http.StatusOK,
+			ResponseHeaders: "{}",
+			ResponseBody:    []byte(rec.body),
+			TestID:          rec.testID,
+		})
+		if err != nil {
+			t.Fatalf("Failed to save record: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name        string
+		onExhausted string
+		want        []string
+	}{
+		{"Repeat last", "repeat_last", []string{"pending", "running", "done", "done"}},
+		{"Not found", "not_found", []string{"pending", "running", "done", "404"}},
+		{"Passthrough", "passthrough", []string{"pending", "running", "done", "live"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replaySessions.resetAll()
+			cfg := &config.Config{
+				HTTPTargetURL: live.URL,
+				ReplayMode:    true,
+				Replay:        config.ReplayConfig{MinScore: 1, Sequential: true, OnExhausted: tt.onExhausted},
+			}
+			target, _ := url.Parse(live.URL)
+			pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+			handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, database, records, pool)
+
+			for i, want := range tt.want {
+				req := httptest.NewRequest(http.MethodGet, "/jobs/42", nil)
+				req.Header.Set("X-Test-ID", "job-flow")
+				rr := httptest.NewRecorder()
+				handler(rr, req)
+
+				got := fmt.Sprint(rr.Code)
+				if rr.Code == http.StatusOK {
+					got = rr.Body.String()
+					got = got[len(`{"status":"`) : len(got)-2]
+				}
+				if got != want {
+					t.Errorf("Request %d: got %s, want %s", i+1, got, want)
+				}
+			}
+		})
+	}
+
+	// Resetting the session rewinds its cursor
+	replaySessions.resetAll()
+	cfg := &config.Config{HTTPTargetURL: live.URL, ReplayMode: true, Replay: config.ReplayConfig{MinScore: 1, Sequential: true}}
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(&httputil.ReverseProxy{Director: newDirector(cfg)}, cfg, database, records, pool)
+	replay := func() string {
+		req := httptest.NewRequest(http.MethodGet, "/jobs/42", nil)
+		req.Header.Set("X-Session-ID", "job-flow")
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		return rr.Body.String()
+	}
+	replay()
+	replay()
+
+	mux := http.NewServeMux()
+	RegisterReplayRoutes(mux)
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest(http.MethodDelete, "/api/replay/sessions/job-flow", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Reset endpoint returned %d", rr.Code)
+	}
+	if got := replay(); got != `{"status":"pending"}` {
+		t.Errorf("Expected the first response after reset, got %s", got)
+	}
+}
+
+func TestSessionCursorsExpireIdleSessions(t *testing.T) {
+	cursors := newSessionCursors(50 * time.Millisecond)
+	cursors.advance("idle", "GET /jobs/42?")
+	cursors.advance("busy", "GET /jobs/42?")
+
+	for range 4 {
+		time.Sleep(20 * time.Millisecond)
+		cursors.advance("busy", "GET /jobs/42?")
+	}
+
+	if n := cursors.reset("idle"); n != 0 {
+		t.Errorf("Expected the idle session to be dropped, it still had %d cursors", n)
+	}
+	if pos := cursors.advance("busy", "GET /jobs/42?"); pos != 5 {
+		t.Errorf("Expected the busy session to keep its cursor at 5, got %d", pos)
+	}
+}
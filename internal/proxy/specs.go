@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/metrics"
+	"github.com/dipjyotimetia/jarvis/internal/validator"
+)
+
+// unvalidatedHeader marks responses to requests that no OpenAPI spec covers
+const unvalidatedHeader = "X-API-Validation"
+
+// specValidators holds the OpenAPI validators of a configuration: one per
+// target route with its own spec, and the api_validation spec for every other
+// request
+type specValidators struct {
+	fallback *validator.APIValidator
+	routes   []*validator.APIValidator // By route index; nil for routes without a spec
+}
+
+// newSpecValidators loads the specs of cfg, once per spec and base path.
+// Specs that fail to load are logged and leave their routes unvalidated.
+// It returns nil when API validation is disabled.
+func newSpecValidators(cfg *config.Config) *specValidators {
+	if !cfg.APIValidation.Enabled {
+		return nil
+	}
+	options := validator.APIValidatorOptions{
+		EnableRequestValidation:  cfg.APIValidation.ValidateRequests,
+		EnableResponseValidation: cfg.APIValidation.ValidateResponses,
+		StrictMode:               cfg.APIValidation.StrictMode,
+	}
+	loaded := map[config.RouteOpenAPIConfig]*validator.APIValidator{}
+	load := func(spec config.RouteOpenAPIConfig, route string) *validator.APIValidator {
+		if v, ok := loaded[spec]; ok {
+			return v
+		}
+		slog.Info("Initializing OpenAPI validator from spec", "spec_path", spec.SpecPath, "route", route)
+		options.BasePath = spec.BasePath
+		v, err := validator.NewAPIValidator(spec.SpecPath, options)
+		if err != nil {
+			slog.Warn("Failed to initialize OpenAPI validator", "spec_path", spec.SpecPath, "error", err)
+		} else {
+			apiInfo := v.GetOpenAPIInfo()
+			slog.Info("Loaded OpenAPI spec", "title", apiInfo["title"], "version", apiInfo["version"], "paths", apiInfo["paths"])
+		}
+		loaded[spec] = v
+		return v
+	}
+
+	s := &specValidators{routes: make([]*validator.APIValidator, len(cfg.TargetRoutes))}
+	if cfg.APIValidation.SpecPath != "" {
+		s.fallback = load(config.RouteOpenAPIConfig{SpecPath: cfg.APIValidation.SpecPath}, routeLabel(nil))
+	}
+	for i := range cfg.TargetRoutes {
+		if spec := cfg.TargetRoutes[i].OpenAPI; spec.SpecPath != "" {
+			s.routes[i] = load(spec, routeLabel(&config.RouteMatch{Route: &cfg.TargetRoutes[i]}))
+		}
+	}
+	slog.Info("API validation configuration", "request_validation", cfg.APIValidation.ValidateRequests, "response_validation", cfg.APIValidation.ValidateResponses)
+	return s
+}
+
+// forRequest returns the validator of the spec covering the request's route,
+// or nil. Requests matching no route, or a route without a spec of its own,
+// use the api_validation spec.
+func (s *specValidators) forRequest(r *http.Request, cfg *config.Config) *validator.APIValidator {
+	if s == nil {
+		return nil
+	}
+	if m := routeMatchFor(r, cfg); m != nil && s.routes[m.Index] != nil {
+		return s.routes[m.Index]
+	}
+	return s.fallback
+}
+
+// reportUnvalidated marks a request that validation is enabled for but no
+// spec covers, instead of failing it as a path missing from a spec
+func reportUnvalidated(w http.ResponseWriter, r *http.Request, cfg *config.Config) {
+	route := routeLabel(routeMatchFor(r, cfg))
+	slog.Debug("No OpenAPI spec covers request, skipping validation", "method", r.Method, "path", r.URL.Path, "route", route)
+	metrics.UnvalidatedRequests.WithLabelValues(route).Inc()
+	w.Header().Set(unvalidatedHeader, "unvalidated")
+}
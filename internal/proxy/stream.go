@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/dipjyotimetia/jarvis/internal/redact"
+)
+
+// maxStreamChunks bounds the events recorded for a single streamed response;
+// longer streams are stored without their pacing
+const maxStreamChunks = 10000
+
+// streamChunk is one event of a streamed response
+type streamChunk struct {
+	Offset int64  `json:"offset_ms"` // Time since the response headers were sent
+	Data   string `json:"data"`
+}
+
+// isStreamingResponse reports whether a response is streamed to the client:
+// Server-Sent Events, or a body of unknown length sent in chunks
+func isStreamingResponse(header http.Header, status int) bool {
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		return false
+	}
+	if isEventStream(header) {
+		return true
+	}
+	return header.Get("Content-Length") == "" && header.Get("Content-Type") != ""
+}
+
+// isEventStream reports whether a response carries Server-Sent Events
+func isEventStream(header http.Header) bool {
+	mediaType, _, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	return mediaType == "text/event-stream"
+}
+
+// streamWriter lifts the server's write timeout once a response turns out to
+// be a stream, so long-lived streams are not cut off mid-way
+type streamWriter struct {
+	http.ResponseWriter
+}
+
+func (w *streamWriter) WriteHeader(statusCode int) {
+	if isStreamingResponse(w.Header(), statusCode) {
+		err := http.NewResponseController(w.ResponseWriter).SetWriteDeadline(time.Time{})
+		if err != nil && !errors.Is(err, http.ErrNotSupported) {
+			slog.Warn("Error lifting the write timeout of a streamed response", "error", err)
+		}
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// Unwrap exposes the underlying writer to http.ResponseController
+func (w *streamWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// streamCapture splits a streamed response into events as it is written,
+// noting when each one arrives. Server-Sent Events are split at the blank
+// line ending each event; other streams keep the chunks they arrived in.
+type streamCapture struct {
+	start   time.Time
+	sse     bool
+	pending []byte // Start of an event, or of a rune, still being written
+	last    int64  // Offset of the latest write
+	chunks  []streamChunk
+	dropped bool // Too many events, or binary data a JSON string cannot hold
+}
+
+func newStreamCapture(header http.Header) *streamCapture {
+	return &streamCapture{start: time.Now(), sse: isEventStream(header)}
+}
+
+// write notes a chunk of the body as it is sent to the client
+func (c *streamCapture) write(b []byte) {
+	if c.dropped || len(b) == 0 {
+		return
+	}
+	c.last = time.Since(c.start).Milliseconds()
+	c.pending = append(c.pending, b...)
+	if !c.sse {
+		// A rune split across writes is kept for the next chunk
+		n := runeBoundary(c.pending)
+		c.add(c.pending[:n])
+		c.pending = append([]byte(nil), c.pending[n:]...)
+		return
+	}
+	for {
+		end := sseEventEnd(c.pending)
+		if end < 0 {
+			return
+		}
+		c.add(c.pending[:end])
+		c.pending = append([]byte(nil), c.pending[end:]...)
+	}
+}
+
+func (c *streamCapture) add(data []byte) {
+	if len(data) == 0 || c.dropped {
+		return
+	}
+	if len(c.chunks) >= maxStreamChunks || !utf8.Valid(data) {
+		c.dropped = true
+		c.chunks = nil
+		return
+	}
+	c.chunks = append(c.chunks, streamChunk{Offset: c.last, Data: string(data)})
+}
+
+// finish returns the captured events, or nil when there is no pacing worth
+// replaying: a capture that was dropped, or a chunked body sent in one piece
+func (c *streamCapture) finish() []streamChunk {
+	c.add(c.pending)
+	c.pending = nil
+	if c.dropped || (!c.sse && len(c.chunks) < 2) {
+		return nil
+	}
+	return c.chunks
+}
+
+// sseEventEnd returns the length of the first complete event in b, including
+// the blank line that ends it, or -1 when b holds no complete event
+func sseEventEnd(b []byte) int {
+	end := -1
+	for _, sep := range [][]byte{[]byte("\n\n"), []byte("\r\n\r\n"), []byte("\r\r")} {
+		if i := bytes.Index(b, sep); i >= 0 && (end < 0 || i+len(sep) < end) {
+			end = i + len(sep)
+		}
+	}
+	return end
+}
+
+// runeBoundary returns the length of b without a trailing rune that is cut
+// off mid-way
+func runeBoundary(b []byte) int {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if utf8.FullRune(b[i:]) {
+				return len(b)
+			}
+			return i
+		}
+	}
+	return len(b)
+}
+
+// streamChunksJSON encodes captured events for storage with secrets masked
+// in each event
+func streamChunksJSON(chunks []streamChunk, red *redact.Redactor) string {
+	if len(chunks) == 0 {
+		return ""
+	}
+	masked := make([]streamChunk, len(chunks))
+	for i, chunk := range chunks {
+		masked[i] = streamChunk{Offset: chunk.Offset, Data: string(red.Body([]byte(chunk.Data)))}
+	}
+	b, err := json.Marshal(masked)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+// replayStream writes the events of a recorded stream with their recorded
+// delays scaled by pacing, flushing each one to the client
+func replayStream(w http.ResponseWriter, r *http.Request, chunks []streamChunk, pacing float64) error {
+	controller := http.NewResponseController(w)
+	start := time.Now()
+	for _, chunk := range chunks {
+		due := time.Duration(float64(chunk.Offset) * pacing * float64(time.Millisecond))
+		if !sleepContext(r.Context(), due-time.Since(start)) {
+			return r.Context().Err()
+		}
+		if _, err := io.WriteString(w, chunk.Data); err != nil {
+			return err
+		}
+		if err := controller.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+	}
+	return nil
+}
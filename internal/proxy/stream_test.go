@@ -0,0 +1,207 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+func TestStreamCapture(t *testing.T) {
+	sse := http.Header{"Content-Type": {"text/event-stream; charset=utf-8"}}
+	c := newStreamCapture(sse)
+	c.write([]byte("data: one\n\nda"))
+	c.write([]byte("ta: two\n\n"))
+	c.write([]byte("data: three"))
+	chunks := c.finish()
+	want := []string{"data: one\n\n", "data: two\n\n", "data: three"}
+	if len(chunks) != len(want) {
+		t.Fatalf("Expected %d events, got %+v", len(want), chunks)
+	}
+	for i := range want {
+		if chunks[i].Data != want[i] {
+			t.Errorf("Event %d = %q, want %q", i, chunks[i].Data, want[i])
+		}
+	}
+
+	// Chunked bodies keep their chunks, without splitting a rune
+	chunked := http.Header{"Content-Type": {"application/x-ndjson"}}
+	c = newStreamCapture(chunked)
+	euro := []byte("€")
+	c.write(append([]byte(`{"a":1}`+"\n"), euro[:1]...))
+	c.write(euro[1:])
+	chunks = c.finish()
+	if len(chunks) != 2 || chunks[0].Data != `{"a":1}`+"\n" || chunks[1].Data != "€" {
+		t.Errorf("Expected the split rune to move to the next chunk, got %+v", chunks)
+	}
+
+	// A body sent in one piece has no pacing worth keeping
+	c = newStreamCapture(chunked)
+	c.write([]byte(`{"a":1}`))
+	if chunks := c.finish(); chunks != nil {
+		t.Errorf("Expected no events for a single chunk, got %+v", chunks)
+	}
+
+	// Binary streams cannot be stored as text
+	c = newStreamCapture(sse)
+	c.write([]byte("data: \xff\xfe\n\n"))
+	c.write([]byte("data: ok\n\n"))
+	if chunks := c.finish(); chunks != nil {
+		t.Errorf("Expected binary events to be dropped, got %+v", chunks)
+	}
+}
+
+func TestIsStreamingResponse(t *testing.T) {
+	tests := []struct {
+		header http.Header
+		status int
+		want   bool
+	}{
+		{http.Header{"Content-Type": {"text/event-stream"}}, 200, true},
+		{http.Header{"Content-Type": {"text/event-stream"}, "Content-Length": {"10"}}, 200, true},
+		{http.Header{"Content-Type": {"application/json"}}, 200, true},
+		{http.Header{"Content-Type": {"application/json"}, "Content-Length": {"10"}}, 200, false},
+		{http.Header{"Content-Type": {"text/event-stream"}}, 204, false},
+		{http.Header{}, 200, false},
+	}
+	for _, tt := range tests {
+		if got := isStreamingResponse(tt.header, tt.status); got != tt.want {
+			t.Errorf("isStreamingResponse(%v, %d) = %v, want %v", tt.header, tt.status, got, tt.want)
+		}
+	}
+}
+
+// readEvents reads the events of an SSE response, noting when each arrived
+func readEvents(t *testing.T, resp *http.Response) ([]string, []time.Duration) {
+	t.Helper()
+	defer resp.Body.Close()
+	start := time.Now()
+	var events []string
+	var arrivals []time.Duration
+	reader := bufio.NewReader(resp.Body)
+	for {
+		line, err := reader.ReadString('\n')
+		if strings.HasPrefix(line, "data: ") {
+			events = append(events, strings.TrimSpace(strings.TrimPrefix(line, "data: ")))
+			arrivals = append(arrivals, time.Since(start))
+		}
+		if err == io.EOF {
+			return events, arrivals
+		}
+		if err != nil {
+			t.Fatalf("Error reading stream: %v", err)
+		}
+	}
+}
+
+func TestStreamingRecordAndReplay(t *testing.T) {
+	const gap = 150 * time.Millisecond
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		for i := 1; i <= 3; i++ {
+			if i > 1 {
+				time.Sleep(gap)
+			}
+			fmt.Fprintf(w, "data: token-%d\n\n", i)
+			w.(http.Flusher).Flush()
+		}
+	}))
+	defer target.Close()
+
+	tempDB, err := os.CreateTemp("", "test_stream_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	// The stream outlasts the server's write timeout
+	serve := func(cfg *config.Config) *httptest.Server {
+		targetURL, _ := url.Parse(target.URL)
+		pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+		proxy := httputil.NewSingleHostReverseProxy(targetURL)
+		srv := httptest.NewUnstartedServer(http.HandlerFunc(createHTTPHandler(proxy, cfg, database, records, pool)))
+		srv.Config.WriteTimeout = gap
+		srv.Start()
+		return srv
+	}
+
+	recording := serve(&config.Config{HTTPTargetURL: target.URL, RecordingMode: true})
+	defer recording.Close()
+	resp, err := http.Get(recording.URL + "/v1/chat")
+	if err != nil {
+		t.Fatalf("Error calling the proxy: %v", err)
+	}
+	events, arrivals := readEvents(t, resp)
+	if strings.Join(events, ",") != "token-1,token-2,token-3" {
+		t.Fatalf("Expected all events through the proxy, got %v", events)
+	}
+	if arrivals[0] > gap {
+		t.Errorf("Expected the first event to be flushed at once, got it after %v", arrivals[0])
+	}
+
+	waitForSource(t, database, db.SourceLive, 1)
+	var stored string
+	if err := database.QueryRow(`SELECT response_chunks FROM traffic_records`).Scan(&stored); err != nil {
+		t.Fatalf("Failed to read record: %v", err)
+	}
+	var chunks []streamChunk
+	if err := json.Unmarshal([]byte(stored), &chunks); err != nil || len(chunks) != 3 {
+		t.Fatalf("Expected 3 recorded events, got %s (%v)", stored, err)
+	}
+	if chunks[0].Data != "data: token-1\n\n" || chunks[2].Offset < (2*gap).Milliseconds()-20 {
+		t.Errorf("Expected events with their offsets, got %+v", chunks)
+	}
+
+	// Replay keeps the pacing, scaled by stream_pacing
+	for _, pacing := range []float64{1, 0} {
+		replaying := serve(&config.Config{
+			HTTPTargetURL: target.URL,
+			ReplayMode:    true,
+			Replay:        config.ReplayConfig{MinScore: 1, StreamPacing: pacing},
+		})
+		resp, err := http.Get(replaying.URL + "/v1/chat")
+		if err != nil {
+			t.Fatalf("Error calling the replaying proxy: %v", err)
+		}
+		if resp.Header.Get(sourceHeader) != db.SourceReplay {
+			t.Errorf("Expected a replayed response, got %q", resp.Header.Get(sourceHeader))
+		}
+		events, arrivals := readEvents(t, resp)
+		replaying.Close()
+		if strings.Join(events, ",") != "token-1,token-2,token-3" {
+			t.Fatalf("Expected all events replayed at pacing %v, got %v", pacing, events)
+		}
+		spread := arrivals[2] - arrivals[0]
+		if pacing == 1 && spread < 2*gap-50*time.Millisecond {
+			t.Errorf("Expected replayed events spread over about %v, got %v", 2*gap, spread)
+		}
+		if pacing == 0 && spread > gap {
+			t.Errorf("Expected events at once with pacing 0, got them over %v", spread)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the proxy's spans through the global provider, which does
+// nothing unless tracing is enabled
+var tracer = otel.Tracer("github.com/dipjyotimetia/jarvis/internal/proxy")
+
+// startServerSpan joins the trace named by the request's traceparent header,
+// or starts a new one, and returns the request carrying the span
+func startServerSpan(r *http.Request) (*http.Request, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, r.Method,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(r.Method),
+			semconv.URLPath(r.URL.Path),
+			semconv.ClientAddress(getClientIP(r)),
+		),
+	)
+	return r.WithContext(ctx), span
+}
+
+// endServerSpan records the status sent to the client and ends the span
+func endServerSpan(span trace.Span, w *statusWriter) {
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// endSpan records err, if any, on a span and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceIDOf returns the ID of the trace a request belongs to, or "" when it
+// is not traced
+func traceIDOf(r *http.Request) string {
+	sc := trace.SpanContextFromContext(r.Context())
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
+
+// startUpstreamSpan starts a client span for the request to the upstream and
+// propagates it in the traceparent header. Untraced requests are returned
+// unchanged.
+func startUpstreamSpan(req *http.Request) (*http.Request, trace.Span) {
+	if !trace.SpanContextFromContext(req.Context()).IsValid() {
+		return req, trace.SpanFromContext(req.Context())
+	}
+	ctx, span := tracer.Start(req.Context(), req.Method+" upstream",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPRequestMethodKey.String(req.Method),
+			semconv.ServerAddress(req.URL.Hostname()),
+			semconv.URLFull(req.URL.Redacted()),
+		),
+	)
+	// A RoundTripper must not modify the caller's request
+	req = req.Clone(ctx)
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	return req, span
+}
+
+// endUpstreamSpan ends a client span once the upstream's response headers
+// arrive or the round trip fails
+func endUpstreamSpan(span trace.Span, upstream string, resp *http.Response, err error) {
+	if upstream != "" {
+		span.SetAttributes(attribute.String("jarvis.upstream", upstream))
+	}
+	if resp != nil {
+		span.SetAttributes(semconv.HTTPResponseStatusCode(resp.StatusCode))
+		if resp.StatusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+		}
+	}
+	endSpan(span, err)
+}
@@ -0,0 +1,139 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracingSpec = `openapi: 3.0.0
+info:
+  title: Users
+  version: "1.0"
+paths:
+  /users:
+    get:
+      responses:
+        "200":
+          description: Users
+          content:
+            application/json:
+              schema:
+                type: object
+`
+
+func TestTracingJoinsIncomingTrace(t *testing.T) {
+	spans := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(spans))
+	defer provider.Shutdown(context.Background())
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+
+	var upstreamParent string
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamParent = r.Header.Get("traceparent")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"users":[]}`))
+	}))
+	defer targetServer.Close()
+
+	specPath := filepath.Join(t.TempDir(), "users.yaml")
+	if err := os.WriteFile(specPath, []byte(tracingSpec), 0o644); err != nil {
+		t.Fatalf("Failed to write spec: %v", err)
+	}
+
+	tempDB, err := os.CreateTemp("", "test_tracing_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		RecordingMode: true,
+		APIValidation: config.APIValidationConfig{
+			Enabled:           true,
+			SpecPath:          specPath,
+			ValidateRequests:  true,
+			ValidateResponses: true,
+		},
+		Replay: config.ReplayConfig{MinScore: 1},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := buildHTTPHandler(ctx, cfg, database, records, "HTTP proxy error")
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	req := httptest.NewRequest(http.MethodGet, "/users", nil)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	waitForSource(t, database, db.SourceLive, 1)
+
+	// Every span belongs to the caller's trace
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range spans.Ended() {
+		if span.SpanContext().TraceID().String() != traceID {
+			t.Errorf("Span %q has trace ID %s, want %s", span.Name(), span.SpanContext().TraceID(), traceID)
+		}
+		byName[span.Name()] = span
+	}
+	server, ok := byName["GET"]
+	if !ok {
+		t.Fatalf("Expected a server span, got %v", byName)
+	}
+	if server.SpanKind() != trace.SpanKindServer || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected a server span under the incoming parent, got kind %v, parent %s", server.SpanKind(), server.Parent().SpanID())
+	}
+	for _, name := range []string{"validate request", "validate response", "GET upstream", "persist traffic record"} {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("Expected a %q span", name)
+			continue
+		}
+		if span.Parent().SpanID() != server.SpanContext().SpanID() {
+			t.Errorf("Expected %q to be a child of the server span", name)
+		}
+	}
+
+	// The upstream is called as part of the same trace
+	if upstream, ok := byName["GET upstream"]; ok {
+		want := "00-" + traceID + "-" + upstream.SpanContext().SpanID().String() + "-01"
+		if upstreamParent != want {
+			t.Errorf("Upstream traceparent = %q, want %q", upstreamParent, want)
+		}
+	}
+
+	var stored string
+	if err := database.QueryRow(`SELECT trace_id FROM traffic_records`).Scan(&stored); err != nil {
+		t.Fatalf("Failed to read record: %v", err)
+	}
+	if stored != traceID {
+		t.Errorf("Stored trace_id = %q, want %q", stored, traceID)
+	}
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/dipjyotimetia/jarvis/internal/db"
+	"github.com/dipjyotimetia/jarvis/internal/redact"
+	"github.com/dipjyotimetia/jarvis/internal/validator"
+)
+
+// problemContentType is the media type of RFC 7807 problem details
+const problemContentType = "application/problem+json"
+
+// validationProblem is an RFC 7807 problem details object for a request
+// that breaks the OpenAPI spec, with its violations as an extension member
+type validationProblem struct {
+	Type     string                `json:"type"`
+	Title    string                `json:"title"`
+	Status   int                   `json:"status"`
+	Detail   string                `json:"detail"`
+	Instance string                `json:"instance"`
+	Errors   []validator.Violation `json:"errors"`
+}
+
+// writeValidationProblem sets the headers of a problem response for a request
+// that failed validation and returns its body
+func writeValidationProblem(w http.ResponseWriter, r *http.Request, status int, err error) []byte {
+	violations := validator.Violations(err)
+	details := make([]string, len(violations))
+	for i, v := range violations {
+		details[i] = violationSummary(v)
+	}
+	body, marshalErr := json.Marshal(validationProblem{
+		Type:     "about:blank",
+		Title:    "Request validation failed",
+		Status:   status,
+		Detail:   strings.Join(details, "; "),
+		Instance: r.URL.Path,
+		Errors:   violations,
+	})
+	if marshalErr != nil {
+		body = fmt.Appendf(nil, `{"title":"Request validation failed","status":%d}`, status)
+	}
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Del("Content-Length")
+	return body
+}
+
+// violationSummary describes a violation on one line, e.g.
+// "body /items/0/id: value must be a string"
+func violationSummary(v validator.Violation) string {
+	where := v.In
+	switch {
+	case v.Pointer != "":
+		where += " " + v.Pointer
+	case v.Parameter != "":
+		where += " " + v.Parameter
+	}
+	return where + ": " + v.Message
+}
+
+// validationRecords converts the violations of a validation error for
+// storage. Values taken from the exchange are masked like the record itself:
+// body values as they appear in the redacted body.
+func validationRecords(err error, kind string, red *redact.Redactor, redactedBody []byte) []db.ValidationError {
+	violations := validator.Violations(err)
+	if len(violations) == 0 {
+		return nil
+	}
+	var doc any
+	if red.MasksBodies() && len(redactedBody) > 0 {
+		if json.Unmarshal(redactedBody, &doc) != nil {
+			doc = nil
+		}
+	}
+
+	records := make([]db.ValidationError, len(violations))
+	for i, v := range violations {
+		actual := v.Actual
+		switch {
+		case v.Actual == nil:
+		case v.In == "body" && doc != nil:
+			actual, _ = lookupPointer(doc, v.Pointer)
+		case v.In == "query" && v.Parameter != "":
+			query := red.Query(url.Values{v.Parameter: {fmt.Sprint(v.Actual)}}.Encode())
+			if values, err := url.ParseQuery(query); err == nil && values.Get(v.Parameter) != fmt.Sprint(v.Actual) {
+				actual = values.Get(v.Parameter)
+			}
+		case v.In == "header" && v.Parameter != "":
+			masked := red.Header(http.Header{http.CanonicalHeaderKey(v.Parameter): {fmt.Sprint(v.Actual)}})
+			if value := masked.Get(v.Parameter); value != fmt.Sprint(v.Actual) {
+				actual = value
+			}
+		}
+		if s, ok := actual.(string); ok {
+			actual = red.String(s)
+		}
+		records[i] = db.ValidationError{
+			Kind:       kind,
+			In:         v.In,
+			Parameter:  v.Parameter,
+			Pointer:    v.Pointer,
+			SchemaPath: v.SchemaPath,
+			Keyword:    v.Keyword,
+			Expected:   rawJSON(v.Expected),
+			Actual:     rawJSON(actual),
+			Message:    v.Message,
+		}
+	}
+	return records
+}
+
+// lookupPointer resolves a JSON pointer in a decoded JSON document
+func lookupPointer(doc any, pointer string) (any, bool) {
+	if pointer == "" {
+		return doc, true
+	}
+	for _, seg := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		seg = strings.NewReplacer("~1", "/", "~0", "~").Replace(seg)
+		switch node := doc.(type) {
+		case map[string]any:
+			value, ok := node[seg]
+			if !ok {
+				return nil, false
+			}
+			doc = value
+		case []any:
+			var i int
+			if _, err := fmt.Sscan(seg, &i); err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			doc = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+func rawJSON(v any) json.RawMessage {
+	if v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
@@ -0,0 +1,220 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+func writeUsersSpec(t *testing.T) string {
+	t.Helper()
+	specPath := filepath.Join(t.TempDir(), "users.yaml")
+	if err := os.WriteFile(specPath, []byte(mockSpec), 0o644); err != nil {
+		t.Fatalf("Failed to write spec: %v", err)
+	}
+	return specPath
+}
+
+func TestRequestValidationProblem(t *testing.T) {
+	cfg := &config.Config{
+		HTTPTargetURL: "http://127.0.0.1:1",
+		APIValidation: config.APIValidationConfig{Enabled: true, SpecPath: writeUsersSpec(t), ValidateRequests: true},
+	}
+	target, _ := url.Parse(cfg.HTTPTargetURL)
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, nil, nil, pool)
+
+	req := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"id": 0, "role": "owner"}`))
+	req.Header.Set("Content-Type", "application/json")
+	rr := httptest.NewRecorder()
+	handler(rr, req)
+
+	if rr.Code != http.StatusBadRequest || rr.Header().Get("Content-Type") != problemContentType {
+		t.Fatalf("Expected a 400 problem, got %d %q: %s", rr.Code, rr.Header().Get("Content-Type"), rr.Body.String())
+	}
+	var problem struct {
+		Title    string `json:"title"`
+		Status   int    `json:"status"`
+		Detail   string `json:"detail"`
+		Instance string `json:"instance"`
+		Errors   []struct {
+			In         string          `json:"in"`
+			Pointer    string          `json:"pointer"`
+			SchemaPath string          `json:"schema_path"`
+			Keyword    string          `json:"keyword"`
+			Expected   json.RawMessage `json:"expected"`
+			Actual     json.RawMessage `json:"actual"`
+		} `json:"errors"`
+	}
+	if err := json.Unmarshal(rr.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("Failed to decode problem: %v", err)
+	}
+	if problem.Status != http.StatusBadRequest || problem.Instance != "/users" || !strings.Contains(problem.Detail, "body /id: ") {
+		t.Errorf("Unexpected problem members: %+v", problem)
+	}
+	keywords := map[string]string{}
+	for _, e := range problem.Errors {
+		keywords[e.Pointer] = e.Keyword
+		if e.Pointer == "/id" && (e.SchemaPath != "#/components/schemas/User/properties/id/minimum" || string(e.Expected) != "1" || string(e.Actual) != "0") {
+			t.Errorf("Expected the minimum violation with its values, got %+v", e)
+		}
+	}
+	for pointer, keyword := range map[string]string{"/id": "minimum", "/role": "enum", "/email": "required"} {
+		if keywords[pointer] != keyword {
+			t.Errorf("Expected a %s violation at %s, got %v", keyword, pointer, keywords)
+		}
+	}
+}
+
+func TestValidationErrorsRecorded(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 1, "email": "ada@example.com", "role": "owner"}`))
+	}))
+	defer targetServer.Close()
+
+	tempDB, err := os.CreateTemp("", "test_validation_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		RecordingMode: true,
+		APIValidation: config.APIValidationConfig{
+			Enabled:              true,
+			SpecPath:             writeUsersSpec(t),
+			ValidateRequests:     true,
+			ValidateResponses:    true,
+			ContinueOnValidation: true,
+		},
+		Redaction: config.RedactionConfig{Rules: []config.RedactionRule{{Path: "$.role"}}},
+	}
+	target, _ := url.Parse(targetServer.URL)
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, database, records, pool)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/users/abc", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get("X-API-Validation-Error") != "request" {
+		t.Fatalf("Expected the invalid request to be forwarded, got %d", rr.Code)
+	}
+	waitForSource(t, database, db.SourceLive, 1)
+
+	rows, err := database.Query(`SELECT v.kind, v.location, COALESCE(v.parameter, ''), COALESCE(v.pointer, ''), v.keyword, COALESCE(v.actual, '')
+		FROM validation_errors v JOIN traffic_records t ON t.id = v.record_id ORDER BY v.id`)
+	if err != nil {
+		t.Fatalf("Failed to query validation errors: %v", err)
+	}
+	defer rows.Close()
+	var got []string
+	for rows.Next() {
+		var kind, in, param, pointer, keyword, actual string
+		if err := rows.Scan(&kind, &in, &param, &pointer, &keyword, &actual); err != nil {
+			t.Fatalf("Failed to scan validation error: %v", err)
+		}
+		got = append(got, strings.Join([]string{kind, in, param + pointer, keyword, actual}, " "))
+	}
+	// The redacted role is stored masked, like the recorded body
+	want := []string{
+		`request path id type "abc"`,
+		`response body /role enum "[REDACTED]"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Expected stored violations\n%s\ngot\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestRouteSpecValidation(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 1, "email": "ada@example.com", "role": "member"}`))
+	}))
+	defer targetServer.Close()
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		TargetRoutes: []config.TargetRoute{
+			{PathPrefix: "/api/v1/users/", TargetURL: targetServer.URL, OpenAPI: config.RouteOpenAPIConfig{SpecPath: writeUsersSpec(t), BasePath: "/api/v1"}},
+			{PathPrefix: "/todos/", TargetURL: targetServer.URL},
+		},
+		APIValidation: config.APIValidationConfig{Enabled: true, ValidateRequests: true, ValidateResponses: true},
+	}
+	target, _ := url.Parse(targetServer.URL)
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, nil, nil, pool)
+
+	tests := []struct {
+		path        string
+		wantStatus  int
+		unvalidated bool
+	}{
+		{path: "/api/v1/users/1", wantStatus: http.StatusOK},
+		{path: "/api/v1/users/abc", wantStatus: http.StatusBadRequest},
+		{path: "/todos/1", wantStatus: http.StatusOK, unvalidated: true},
+		{path: "/other", wantStatus: http.StatusOK, unvalidated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			handler(rr, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, rr.Code, rr.Body.String())
+			}
+			if got := rr.Header().Get(unvalidatedHeader) == "unvalidated"; got != tt.unvalidated {
+				t.Errorf("Expected unvalidated %v, got %v", tt.unvalidated, got)
+			}
+		})
+	}
+}
+
+func TestGlobalSpecCoversRoutesWithoutSpec(t *testing.T) {
+	targetServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"id": 1, "email": "ada@example.com", "role": "member"}`))
+	}))
+	defer targetServer.Close()
+
+	cfg := &config.Config{
+		HTTPTargetURL: targetServer.URL,
+		TargetRoutes:  []config.TargetRoute{{PathPrefix: "/users/", TargetURL: targetServer.URL}},
+		APIValidation: config.APIValidationConfig{Enabled: true, SpecPath: writeUsersSpec(t), ValidateRequests: true},
+	}
+	target, _ := url.Parse(targetServer.URL)
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	handler := createHTTPHandler(httputil.NewSingleHostReverseProxy(target), cfg, nil, nil, pool)
+
+	rr := httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/users/abc", nil))
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("Expected the global spec to reject the request, got %d: %s", rr.Code, rr.Body.String())
+	}
+	rr = httptest.NewRecorder()
+	handler(rr, httptest.NewRequest(http.MethodGet, "/users/1", nil))
+	if rr.Code != http.StatusOK || rr.Header().Get(unvalidatedHeader) != "" {
+		t.Errorf("Expected a validated request, got %d with %s %q", rr.Code, unvalidatedHeader, rr.Header().Get(unvalidatedHeader))
+	}
+}
@@ -0,0 +1,563 @@
+package proxy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+	"github.com/dipjyotimetia/jarvis/internal/metrics"
+)
+
+// WebSocket opcodes (RFC 6455 section 5.2)
+const (
+	wsOpContinuation = 0x0
+	wsOpText         = 0x1
+	wsOpBinary       = 0x2
+	wsOpClose        = 0x8
+	wsOpPing         = 0x9
+	wsOpPong         = 0xA
+)
+
+// WebSocket record directions, relative to the client
+const (
+	wsDirectionOutbound = "outbound" // client -> server
+	wsDirectionInbound  = "inbound"  // server -> client
+)
+
+// wsAcceptGUID is the fixed GUID used to derive Sec-WebSocket-Accept
+const wsAcceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsFrame is a single decoded WebSocket frame
+type wsFrame struct {
+	fin     bool
+	opcode  byte
+	masked  bool
+	mask    [4]byte
+	header  []byte // raw header bytes, forwarded unchanged
+	payload []byte // raw (possibly masked) payload, forwarded unchanged
+}
+
+// data returns the unmasked payload
+func (f *wsFrame) data() []byte {
+	if !f.masked {
+		return f.payload
+	}
+	out := make([]byte, len(f.payload))
+	for i, b := range f.payload {
+		out[i] = b ^ f.mask[i%4]
+	}
+	return out
+}
+
+// isWebSocketUpgrade reports whether the request asks to switch to the WebSocket protocol
+func isWebSocketUpgrade(r *http.Request) bool {
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wsAcceptKey computes the Sec-WebSocket-Accept value for a client key
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsAcceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// readWSFrame reads one frame from r, keeping the raw bytes for forwarding
+func readWSFrame(r *bufio.Reader) (*wsFrame, error) {
+	head := make([]byte, 2, 14)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return nil, err
+	}
+
+	f := &wsFrame{
+		fin:    head[0]&0x80 != 0,
+		opcode: head[0] & 0x0F,
+		masked: head[1]&0x80 != 0,
+	}
+
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		head = append(head, ext...)
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		head = append(head, ext...)
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if length > maxRequestSize {
+		return nil, fmt.Errorf("websocket frame too large: %d bytes", length)
+	}
+
+	if f.masked {
+		if _, err := io.ReadFull(r, f.mask[:]); err != nil {
+			return nil, err
+		}
+		head = append(head, f.mask[:]...)
+	}
+
+	f.header = head
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// writeWSFrame writes a single unfragmented, unmasked (server-side) frame
+func writeWSFrame(w io.Writer, opcode byte, payload []byte) error {
+	head := make([]byte, 2, 10)
+	head[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n < 126:
+		head[1] = byte(n)
+	case n <= 0xFFFF:
+		head[1] = 126
+		head = binary.BigEndian.AppendUint16(head, uint16(n))
+	default:
+		head[1] = 127
+		head = binary.BigEndian.AppendUint64(head, uint64(n))
+	}
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// wsCloseCode extracts the status code from a close frame payload
+func wsCloseCode(payload []byte) int {
+	if len(payload) < 2 {
+		return 1005 // No status received
+	}
+	return int(binary.BigEndian.Uint16(payload[:2]))
+}
+
+// dialWebSocketUpstream opens a raw connection to the upstream named by target
+func dialWebSocketUpstream(req *http.Request, cfg *config.Config) (net.Conn, error) {
+	host := req.URL.Host
+	secure := req.URL.Scheme == "https" || req.URL.Scheme == "wss"
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		if secure {
+			host = net.JoinHostPort(host, "443")
+		} else {
+			host = net.JoinHostPort(host, "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 10 * time.Second, KeepAlive: 60 * time.Second}
+	if !secure {
+		return dialer.DialContext(req.Context(), "tcp", host)
+	}
+
+	tlsConfig := cfg.GetTLSConfig()
+	tlsConfig.ServerName = req.URL.Hostname()
+	tlsConfig.NextProtos = []string{"http/1.1"}
+	return (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(req.Context(), "tcp", host)
+}
+
+// proxyWebSocket relays a WebSocket connection to the upstream and records each message
+func proxyWebSocket(
+	w http.ResponseWriter,
+	r *http.Request,
+	proxy *httputil.ReverseProxy,
+	cfg *config.Config,
+	records *db.Writer,
+) {
+	startTime := time.Now()
+
+	// Reuse the proxy director so WebSocket routes follow the HTTP routing table;
+	// it notes the chosen upstream in the route state
+	r, routing := withRouteState(r, cfg)
+	outReq := r.Clone(r.Context())
+	proxy.Director(outReq)
+	outReq.RequestURI = ""
+	targetURL := outReq.URL.String()
+	if err := authenticateUpstream(cfg, outReq); err != nil {
+		slog.Error("WebSocket upstream authentication failed", "url", targetURL, "error", err)
+		http.Error(w, "WebSocket upstream unavailable", http.StatusBadGateway)
+		return
+	}
+
+	upstream, err := dialWebSocketUpstream(outReq, cfg)
+	if err != nil {
+		slog.Error("WebSocket upstream dial failed", "url", targetURL, "error", err)
+		http.Error(w, "WebSocket upstream unavailable", http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	if err := outReq.Write(upstream); err != nil {
+		slog.Error("Error writing WebSocket handshake upstream", "url", targetURL, "error", err)
+		http.Error(w, "WebSocket handshake failed", http.StatusBadGateway)
+		return
+	}
+
+	upstreamReader := bufio.NewReader(upstream)
+	resp, err := http.ReadResponse(upstreamReader, outReq)
+	if err != nil {
+		slog.Error("Error reading WebSocket handshake response", "url", targetURL, "error", err)
+		http.Error(w, "WebSocket handshake failed", http.StatusBadGateway)
+		return
+	}
+
+	// Upstream refused the upgrade: relay its answer as a plain HTTP response
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		defer resp.Body.Close()
+		slog.Warn("Upstream rejected WebSocket upgrade", "url", targetURL, "status", resp.StatusCode)
+		for name, values := range resp.Header {
+			for _, value := range values {
+				w.Header().Add(name, value)
+			}
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+		return
+	}
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		slog.Error("Response writer does not support hijacking, cannot proxy WebSocket")
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return
+	}
+	client, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		slog.Error("Error hijacking client connection", "error", err)
+		return
+	}
+	defer client.Close()
+	// The server may have set deadlines for the HTTP exchange; the stream must outlive them
+	_ = client.SetDeadline(time.Time{})
+
+	if err := writeSwitchingProtocols(clientBuf.Writer, resp.Header); err != nil {
+		slog.Warn("Error writing WebSocket handshake to client", "error", err)
+		return
+	}
+
+	connectionID := generateID()
+	handshake := time.Since(startTime)
+	slog.Info("WebSocket connection established", "connection_id", connectionID, "url", targetURL)
+
+	// Recordings are keyed on the URL the client asked for, as HTTP ones are
+	red := redactorFor(cfg)
+	recordedURL := red.URL(r.URL.String())
+
+	recording := cfg.IsRecording()
+	save := func(record db.TrafficRecord) {
+		if err := records.Write(record); err != nil {
+			slog.Warn("Error saving recorded WebSocket traffic", "error", err)
+		}
+	}
+	if recording {
+		save(db.TrafficRecord{
+			ID:              generateID(),
+			Timestamp:       startTime.UTC(),
+			Protocol:        "WebSocket",
+			Method:          "CONNECT",
+			URL:             recordedURL,
+			UpstreamTarget:  routing.upstream,
+			RequestHeaders:  string(redactedHeaderJSON(red, r.Header)),
+			ResponseStatus:  resp.StatusCode,
+			ResponseHeaders: string(redactedHeaderJSON(red, resp.Header)),
+			Duration:        handshake.Milliseconds(),
+			ClientIP:        getClientIP(r),
+			TestID:          r.Header.Get("X-Test-ID"),
+			SessionID:       r.Header.Get("X-Session-ID"),
+			ConnectionID:    connectionID,
+		})
+	}
+
+	// record builds a message record; Duration holds the offset from the handshake
+	// so that replay can reproduce the original timing.
+	record := func(direction string, opcode byte, payload []byte) {
+		if !recording {
+			return
+		}
+		rec := db.TrafficRecord{
+			ID:           generateID(),
+			Timestamp:    time.Now().UTC(),
+			Protocol:     "WebSocket",
+			Method:       "MESSAGE",
+			URL:          recordedURL,
+			Duration:     time.Since(startTime).Milliseconds(),
+			ClientIP:     getClientIP(r),
+			TestID:       r.Header.Get("X-Test-ID"),
+			SessionID:    r.Header.Get("X-Session-ID"),
+			ConnectionID: connectionID,
+			MessageType:  int(opcode),
+			Direction:    direction,
+		}
+		if opcode == wsOpClose {
+			rec.Method = "CLOSE"
+			rec.ResponseStatus = wsCloseCode(payload)
+		}
+		if opcode == wsOpText {
+			payload = red.Body(payload)
+		}
+		if direction == wsDirectionOutbound {
+			rec.RequestBody = payload
+		} else {
+			rec.ResponseBody = payload
+		}
+		save(rec)
+	}
+
+	done := make(chan struct{}, 2)
+	go func() {
+		relayWebSocketFrames(clientBuf.Reader, upstream, wsDirectionOutbound, record)
+		done <- struct{}{}
+	}()
+	go func() {
+		relayWebSocketFrames(upstreamReader, client, wsDirectionInbound, record)
+		done <- struct{}{}
+	}()
+
+	// Either side finishing tears the whole connection down
+	<-done
+	client.Close()
+	upstream.Close()
+	<-done
+
+	slog.Info("WebSocket connection closed", "connection_id", connectionID, "duration_ms", time.Since(startTime).Milliseconds())
+}
+
+// writeSwitchingProtocols writes a 101 handshake response and flushes it
+func writeSwitchingProtocols(w *bufio.Writer, header http.Header) error {
+	if _, err := w.WriteString("HTTP/1.1 101 Switching Protocols\r\n"); err != nil {
+		return err
+	}
+	if err := header.Write(w); err != nil {
+		return err
+	}
+	if _, err := w.WriteString("\r\n"); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// relayWebSocketFrames forwards frames from src to dst unchanged, reassembling
+// fragmented messages so each complete message is recorded once.
+func relayWebSocketFrames(src *bufio.Reader, dst io.Writer, direction string, record func(string, byte, []byte)) {
+	var msgOpcode byte
+	var msg []byte
+
+	for {
+		frame, err := readWSFrame(src)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				slog.Debug("WebSocket relay stopped", "direction", direction, "error", err)
+			}
+			return
+		}
+
+		if _, err := dst.Write(frame.header); err != nil {
+			return
+		}
+		if _, err := dst.Write(frame.payload); err != nil {
+			return
+		}
+
+		switch frame.opcode {
+		case wsOpPing, wsOpPong:
+			// Control frames are relayed but not recorded
+		case wsOpClose:
+			// Keep relaying so the peer's close reply reaches the other side
+			record(direction, wsOpClose, frame.data())
+		case wsOpContinuation:
+			msg = append(msg, frame.data()...)
+			if frame.fin {
+				record(direction, msgOpcode, msg)
+				msg = nil
+			}
+		default:
+			if frame.fin {
+				record(direction, frame.opcode, frame.data())
+				continue
+			}
+			msgOpcode = frame.opcode
+			msg = append(msg[:0], frame.data()...)
+		}
+	}
+}
+
+// replayedWSMessage is a recorded server-to-client message
+type replayedWSMessage struct {
+	opcode  byte
+	payload []byte
+	offset  time.Duration
+}
+
+// replayWebSocket answers an upgrade request from a recorded connection,
+// re-emitting the server-to-client messages at their original offsets. It
+// returns false when the connection should be proxied to the target instead.
+func replayWebSocket(w http.ResponseWriter, r *http.Request, cfg *config.Config, database *sql.DB) bool {
+	// Recorded URLs are the client's, redacted; the director is not run so
+	// balanced routes do not pick an upstream for a lookup
+	recordedURL := redactorFor(cfg).URL(r.URL.String())
+
+	var connectionID, headersStr string
+	err := database.QueryRow(`SELECT connection_id, response_headers
+              FROM traffic_records
+              WHERE protocol = 'WebSocket' AND method = 'CONNECT' AND url = ?
+              ORDER BY timestamp DESC LIMIT 1`, recordedURL).Scan(&connectionID, &headersStr)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			slog.Error("DB error during WebSocket replay lookup", "url", recordedURL, "error", err)
+			http.Error(w, "Database error during replay", http.StatusInternalServerError)
+			return true
+		}
+		metrics.ReplayLookups.WithLabelValues("WebSocket", metrics.ResultMiss).Inc()
+		switch {
+		case cfg.RecordMissing:
+			slog.Info("No WebSocket replay record found, recording live connection", "url", recordedURL)
+			return false
+		case cfg.StrictOffline:
+			failOffline(w, r, "No matching WebSocket replay record found")
+		default:
+			slog.Info("No WebSocket replay record found", "url", recordedURL)
+			http.Error(w, "No matching replay record found", http.StatusNotFound)
+		}
+		return true
+	}
+
+	rows, err := database.Query(`SELECT message_type, response_body, duration_ms
+              FROM traffic_records
+              WHERE protocol = 'WebSocket' AND connection_id = ? AND direction = ?
+              ORDER BY duration_ms, timestamp`, connectionID, wsDirectionInbound)
+	if err != nil {
+		slog.Error("DB error loading WebSocket replay messages", "connection_id", connectionID, "error", err)
+		http.Error(w, "Database error during replay", http.StatusInternalServerError)
+		return true
+	}
+	var messages []replayedWSMessage
+	for rows.Next() {
+		var msgType int
+		var payload []byte
+		var offset int64
+		if err := rows.Scan(&msgType, &payload, &offset); err != nil {
+			slog.Warn("Error scanning WebSocket replay message", "error", err)
+			continue
+		}
+		messages = append(messages, replayedWSMessage{
+			opcode:  byte(msgType),
+			payload: payload,
+			offset:  time.Duration(offset) * time.Millisecond,
+		})
+	}
+	rows.Close()
+	metrics.ReplayLookups.WithLabelValues("WebSocket", metrics.ResultHit).Inc()
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSocket not supported", http.StatusInternalServerError)
+		return true
+	}
+	client, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		slog.Error("Error hijacking client connection", "error", err)
+		return true
+	}
+	defer client.Close()
+	_ = client.SetDeadline(time.Time{})
+
+	header := http.Header{}
+	header.Set("Upgrade", "websocket")
+	header.Set("Connection", "Upgrade")
+	header.Set("Sec-WebSocket-Accept", wsAcceptKey(r.Header.Get("Sec-WebSocket-Key")))
+	var recorded http.Header
+	if err := json.Unmarshal([]byte(headersStr), &recorded); err == nil {
+		if protocol := recorded.Get("Sec-WebSocket-Protocol"); protocol != "" {
+			header.Set("Sec-WebSocket-Protocol", protocol)
+		}
+	}
+	if err := writeSwitchingProtocols(clientBuf.Writer, header); err != nil {
+		slog.Warn("Error writing WebSocket handshake to client", "error", err)
+		return true
+	}
+	slog.Info("Replaying WebSocket connection", "connection_id", connectionID, "messages", len(messages))
+
+	var writeMu sync.Mutex
+	writeFrame := func(opcode byte, payload []byte) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return writeWSFrame(client, opcode, payload)
+	}
+
+	// Drain client frames, answering pings and a client-initiated close
+	clientClosed := make(chan struct{})
+	go func() {
+		defer close(clientClosed)
+		for {
+			frame, err := readWSFrame(clientBuf.Reader)
+			if err != nil {
+				return
+			}
+			switch frame.opcode {
+			case wsOpPing:
+				_ = writeFrame(wsOpPong, frame.data())
+			case wsOpClose:
+				_ = writeFrame(wsOpClose, frame.data())
+				return
+			}
+		}
+	}()
+
+	start := time.Now()
+	for _, msg := range messages {
+		if wait := msg.offset - time.Since(start); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-clientClosed:
+				return true
+			}
+		}
+		if err := writeFrame(msg.opcode, msg.payload); err != nil {
+			slog.Warn("Error writing replayed WebSocket message", "connection_id", connectionID, "error", err)
+			return true
+		}
+		if msg.opcode == wsOpClose {
+			break
+		}
+	}
+
+	// Give the client a moment to complete the closing handshake
+	select {
+	case <-clientClosed:
+	case <-time.After(5 * time.Second):
+	}
+	slog.Info("Replayed WebSocket connection", "connection_id", connectionID, "url", recordedURL)
+	return true
+}
@@ -0,0 +1,368 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"github.com/dipjyotimetia/jarvis/internal/db"
+)
+
+// createWebSocketEchoServer upgrades every request and echoes text frames back
+// prefixed with "echo: ", sending a greeting first.
+func createWebSocketEchoServer(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !isWebSocketUpgrade(r) {
+			http.Error(w, "upgrade required", http.StatusUpgradeRequired)
+			return
+		}
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("hijack failed: %v", err)
+			return
+		}
+		defer conn.Close()
+
+		header := http.Header{}
+		header.Set("Upgrade", "websocket")
+		header.Set("Connection", "Upgrade")
+		header.Set("Sec-WebSocket-Accept", wsAcceptKey(r.Header.Get("Sec-WebSocket-Key")))
+		if err := writeSwitchingProtocols(buf.Writer, header); err != nil {
+			return
+		}
+		_ = writeWSFrame(conn, wsOpText, []byte("hello"))
+
+		for {
+			frame, err := readWSFrame(buf.Reader)
+			if err != nil {
+				return
+			}
+			switch frame.opcode {
+			case wsOpClose:
+				_ = writeWSFrame(conn, wsOpClose, frame.data())
+				return
+			case wsOpText:
+				time.Sleep(50 * time.Millisecond)
+				_ = writeWSFrame(conn, wsOpText, append([]byte("echo: "), frame.data()...))
+			}
+		}
+	}))
+}
+
+// wsTestClient is a minimal client side of the WebSocket protocol
+type wsTestClient struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func dialWSTestClient(t *testing.T, serverURL string) *wsTestClient {
+	t.Helper()
+	u, _ := url.Parse(serverURL)
+	conn, err := net.Dial("tcp", u.Host)
+	if err != nil {
+		t.Fatalf("Failed to dial proxy: %v", err)
+	}
+	handshake := "GET /ws HTTP/1.1\r\n" +
+		"Host: " + u.Host + "\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n\r\n"
+	if _, err := conn.Write([]byte(handshake)); err != nil {
+		t.Fatalf("Failed to write handshake: %v", err)
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("Failed to read handshake response: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101 Switching Protocols, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Unexpected Sec-WebSocket-Accept: %q", got)
+	}
+	_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+	return &wsTestClient{conn: conn, reader: reader}
+}
+
+// send writes a masked frame, as clients must
+func (c *wsTestClient) send(opcode byte, payload []byte) error {
+	mask := [4]byte{1, 2, 3, 4}
+	head := []byte{0x80 | opcode, 0x80 | byte(len(payload))}
+	head = append(head, mask[:]...)
+	masked := make([]byte, len(payload))
+	for i, b := range payload {
+		masked[i] = b ^ mask[i%4]
+	}
+	_, err := c.conn.Write(append(head, masked...))
+	return err
+}
+
+func (c *wsTestClient) receive(t *testing.T) *wsFrame {
+	t.Helper()
+	frame, err := readWSFrame(c.reader)
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	return frame
+}
+
+// closeNormally performs the client side of the closing handshake
+func (c *wsTestClient) closeNormally(t *testing.T) {
+	t.Helper()
+	payload := binary.BigEndian.AppendUint16(nil, 1000)
+	if err := c.send(wsOpClose, payload); err != nil {
+		t.Fatalf("Failed to send close: %v", err)
+	}
+	for {
+		frame, err := readWSFrame(c.reader)
+		if err != nil || frame.opcode == wsOpClose {
+			break
+		}
+	}
+	c.conn.Close()
+}
+
+func newWebSocketTestProxy(t *testing.T, cfg *config.Config, database *sql.DB, records *db.Writer) *httptest.Server {
+	proxy := &httputil.ReverseProxy{Director: newDirector(cfg)}
+	pool := &sync.Pool{New: func() any { return new(bytes.Buffer) }}
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handleHTTPRequest(w, r, proxy, cfg, database, records, pool, nil, newRuleMatcher(cfg), nil)
+	}))
+}
+
+func waitForRecords(t *testing.T, database *sql.DB, want int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		var count int
+		if err := database.QueryRow(`SELECT COUNT(*) FROM traffic_records WHERE protocol = 'WebSocket'`).Scan(&count); err != nil {
+			t.Fatalf("Failed to count records: %v", err)
+		}
+		if count >= want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %d WebSocket records, have %d", want, count)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestWebSocketRecordAndReplay(t *testing.T) {
+	upstream := createWebSocketEchoServer(t)
+	defer upstream.Close()
+
+	tempDB, err := os.CreateTemp("", "test_ws_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	// --- Record ---
+	recordCfg := &config.Config{HTTPPort: 8080, HTTPTargetURL: upstream.URL, RecordingMode: true}
+	recordProxy := newWebSocketTestProxy(t, recordCfg, database, records)
+
+	client := dialWSTestClient(t, recordProxy.URL)
+	if frame := client.receive(t); string(frame.data()) != "hello" {
+		t.Fatalf("Expected greeting, got %q", frame.data())
+	}
+	if err := client.send(wsOpText, []byte("ping-1")); err != nil {
+		t.Fatalf("Failed to send message: %v", err)
+	}
+	if frame := client.receive(t); string(frame.data()) != "echo: ping-1" {
+		t.Fatalf("Unexpected echo: %q", frame.data())
+	}
+	client.closeNormally(t)
+
+	// CONNECT, greeting, outbound message, echo, and a close from each side
+	waitForRecords(t, database, 6)
+	recordProxy.Close()
+
+	var inbound, outbound int
+	database.QueryRow(`SELECT COUNT(*) FROM traffic_records WHERE protocol = 'WebSocket' AND method = 'MESSAGE' AND direction = 'inbound'`).Scan(&inbound)
+	database.QueryRow(`SELECT COUNT(*) FROM traffic_records WHERE protocol = 'WebSocket' AND method = 'MESSAGE' AND direction = 'outbound'`).Scan(&outbound)
+	if inbound != 2 || outbound != 1 {
+		t.Errorf("Expected 2 inbound and 1 outbound messages, got %d and %d", inbound, outbound)
+	}
+
+	var recordedURL, upstreamTarget string
+	if err := database.QueryRow(`SELECT url, upstream_target FROM traffic_records WHERE method = 'CONNECT'`).Scan(&recordedURL, &upstreamTarget); err != nil {
+		t.Fatalf("Failed to load handshake record: %v", err)
+	}
+	if recordedURL != "/ws" || upstreamTarget != upstream.URL {
+		t.Errorf("Expected the client URL /ws served by %s, got %s served by %q", upstream.URL, recordedURL, upstreamTarget)
+	}
+
+	var body []byte
+	if err := database.QueryRow(`SELECT request_body FROM traffic_records WHERE direction = 'outbound' AND method = 'MESSAGE'`).Scan(&body); err != nil {
+		t.Fatalf("Failed to load outbound message: %v", err)
+	}
+	if string(body) != "ping-1" {
+		t.Errorf("Outbound message should be stored unmasked, got %q", body)
+	}
+
+	// --- Replay (upstream gone) ---
+	upstream.Close()
+	replayCfg := &config.Config{HTTPPort: 8080, HTTPTargetURL: upstream.URL, ReplayMode: true}
+	replayProxy := newWebSocketTestProxy(t, replayCfg, database, records)
+	defer replayProxy.Close()
+
+	start := time.Now()
+	client = dialWSTestClient(t, replayProxy.URL)
+	var got []string
+	for len(got) < 2 {
+		frame := client.receive(t)
+		if frame.opcode == wsOpText {
+			got = append(got, string(frame.data()))
+		}
+	}
+	elapsed := time.Since(start)
+	client.closeNormally(t)
+
+	if fmt.Sprint(got) != "[hello echo: ping-1]" {
+		t.Errorf("Unexpected replayed messages: %v", got)
+	}
+	if elapsed < 50*time.Millisecond {
+		t.Errorf("Replay should keep original timing, finished in %v", elapsed)
+	}
+}
+
+func TestWebSocketReplayOnBalancedRoute(t *testing.T) {
+	first, second := createWebSocketEchoServer(t), createWebSocketEchoServer(t)
+
+	tempDB, err := os.CreateTemp("", "test_ws_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	routes := []config.TargetRoute{{
+		PathPrefix: "/ws",
+		Upstreams:  []config.Upstream{{URL: first.URL}, {URL: second.URL}},
+	}}
+	recordCfg := &config.Config{HTTPTargetURL: first.URL, RecordingMode: true, TargetRoutes: routes}
+	recordProxy := newWebSocketTestProxy(t, recordCfg, database, records)
+	client := dialWSTestClient(t, recordProxy.URL)
+	client.receive(t)
+	client.closeNormally(t)
+	waitForRecords(t, database, 4)
+	recordProxy.Close()
+	first.Close()
+	second.Close()
+
+	// Every connection replays the recording, whichever upstream the route would pick
+	replayCfg := &config.Config{HTTPTargetURL: first.URL, ReplayMode: true, TargetRoutes: routes}
+	replayProxy := newWebSocketTestProxy(t, replayCfg, database, records)
+	defer replayProxy.Close()
+	for range 2 {
+		client = dialWSTestClient(t, replayProxy.URL)
+		if frame := client.receive(t); string(frame.data()) != "hello" {
+			t.Errorf("Expected the recorded greeting, got %q", frame.data())
+		}
+		client.closeNormally(t)
+	}
+}
+
+func TestWebSocketRecordMissing(t *testing.T) {
+	upstream := createWebSocketEchoServer(t)
+	defer upstream.Close()
+
+	tempDB, err := os.CreateTemp("", "test_ws_*.db")
+	if err != nil {
+		t.Fatalf("Failed to create temp database: %v", err)
+	}
+	tempDB.Close()
+	defer os.Remove(tempDB.Name())
+
+	database, stmt, err := db.Initialize(tempDB.Name())
+	if err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+	defer stmt.Close()
+	records := newTestWriter(database, stmt)
+	defer records.Close(context.Background())
+
+	// The strict offline proxy fails loudly while nothing is recorded
+	offlineCfg := &config.Config{HTTPTargetURL: upstream.URL, ReplayMode: true, StrictOffline: true}
+	offlineProxy := newWebSocketTestProxy(t, offlineCfg, database, records)
+	defer offlineProxy.Close()
+	req, _ := http.NewRequest(http.MethodGet, offlineProxy.URL+"/ws", nil)
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Upgrade request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || resp.Header.Get("X-Jarvis-Replay-Miss") != "true" {
+		t.Errorf("Expected a 502 replay miss, got %d with X-Jarvis-Replay-Miss %q", resp.StatusCode, resp.Header.Get("X-Jarvis-Replay-Miss"))
+	}
+
+	// A miss is proxied live and recorded, then replayed without the upstream
+	cfg := &config.Config{HTTPTargetURL: upstream.URL, RecordMissing: true}
+	proxy := newWebSocketTestProxy(t, cfg, database, records)
+	defer proxy.Close()
+	client := dialWSTestClient(t, proxy.URL)
+	if frame := client.receive(t); string(frame.data()) != "hello" {
+		t.Fatalf("Expected the live greeting, got %q", frame.data())
+	}
+	client.closeNormally(t)
+	waitForRecords(t, database, 4)
+	upstream.Close()
+
+	client = dialWSTestClient(t, proxy.URL)
+	if frame := client.receive(t); string(frame.data()) != "hello" {
+		t.Errorf("Expected the replayed greeting, got %q", frame.data())
+	}
+	client.closeNormally(t)
+}
+
+func TestIsWebSocketUpgrade(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/ws", nil)
+	if isWebSocketUpgrade(req) {
+		t.Error("Plain request should not be treated as an upgrade")
+	}
+	req.Header.Set("Upgrade", "WebSocket")
+	req.Header.Set("Connection", "keep-alive, Upgrade")
+	if !isWebSocketUpgrade(req) {
+		t.Error("Expected upgrade to be detected")
+	}
+}
@@ -0,0 +1,365 @@
+package redact
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+// Placeholder replaces redacted values in "replace" mode
+const Placeholder = "[REDACTED]"
+
+// Masking modes
+const (
+	MaskReplace  = "replace"  // Swap the value for Placeholder
+	MaskPreserve = "preserve" // Keep length and separators, hide letters and digits
+)
+
+// defaultHeaders are always redacted unless redaction is disabled
+var defaultHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"Cookie",
+	"Set-Cookie",
+	"X-Api-Key",
+	"Api-Key",
+	"X-Auth-Token",
+	"X-Amz-Security-Token",
+}
+
+// defaultQueryParams are always redacted from URLs unless redaction is disabled
+var defaultQueryParams = []string{"api_key", "apikey", "access_token", "client_secret", "token"}
+
+// Redactor masks secrets and PII in headers, bodies and URLs
+type Redactor struct {
+	disabled    bool
+	headers     map[string]bool
+	queryParams map[string]bool
+	paths       []pathRule
+	patterns    []patternRule
+}
+
+type pathRule struct {
+	tokens   []string
+	mask     string
+	keepLast int
+}
+
+type patternRule struct {
+	re       *regexp.Regexp
+	mask     string
+	keepLast int
+}
+
+// New creates a redactor with the built-in header and query rules plus the
+// configured body rules
+func New(cfg config.RedactionConfig) (*Redactor, error) {
+	r := &Redactor{
+		disabled:    cfg.Disabled,
+		headers:     make(map[string]bool),
+		queryParams: make(map[string]bool),
+	}
+	for _, name := range append(append([]string(nil), defaultHeaders...), cfg.Headers...) {
+		r.headers[http.CanonicalHeaderKey(name)] = true
+	}
+	for _, name := range append(append([]string(nil), defaultQueryParams...), cfg.QueryParams...) {
+		r.queryParams[strings.ToLower(name)] = true
+	}
+
+	for _, rule := range cfg.Rules {
+		mask := rule.Mask
+		if mask == "" {
+			mask = MaskReplace
+		}
+		if rule.Path != "" {
+			tokens, err := parsePath(rule.Path)
+			if err != nil {
+				return nil, fmt.Errorf("parsing redaction path %q: %w", rule.Path, err)
+			}
+			r.paths = append(r.paths, pathRule{tokens: tokens, mask: mask, keepLast: rule.KeepLast})
+		}
+		if rule.Pattern != "" {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("compiling redaction pattern %q: %w", rule.Pattern, err)
+			}
+			r.patterns = append(r.patterns, patternRule{re: re, mask: mask, keepLast: rule.KeepLast})
+		}
+	}
+	return r, nil
+}
+
+// Header returns a copy of h with sensitive values masked. Authorization
+// schemes and cookie names are kept so the record stays readable.
+func (r *Redactor) Header(h http.Header) http.Header {
+	if h == nil {
+		return nil
+	}
+	out := h.Clone()
+	if r.disabled {
+		return out
+	}
+	for name, values := range out {
+		canonical := http.CanonicalHeaderKey(name)
+		sensitive := r.headers[canonical] || isAPIKeyHeader(canonical)
+		for i, v := range values {
+			switch {
+			case !sensitive:
+				values[i] = r.String(v)
+			case canonical == "Authorization" || canonical == "Proxy-Authorization":
+				if scheme, _, ok := strings.Cut(v, " "); ok {
+					values[i] = scheme + " " + Placeholder
+				} else {
+					values[i] = Placeholder
+				}
+			case canonical == "Cookie":
+				values[i] = redactCookies(v, false)
+			case canonical == "Set-Cookie":
+				values[i] = redactCookies(v, true)
+			default:
+				values[i] = Placeholder
+			}
+		}
+	}
+	return out
+}
+
+// isAPIKeyHeader catches vendor specific API key headers such as X-Goog-Api-Key
+func isAPIKeyHeader(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.Contains(lower, "api-key") || strings.Contains(lower, "apikey")
+}
+
+// redactCookies masks cookie values, keeping names and, for Set-Cookie, attributes
+func redactCookies(v string, setCookie bool) string {
+	parts := strings.Split(v, ";")
+	for i, part := range parts {
+		if setCookie && i > 0 {
+			break
+		}
+		if name, _, ok := strings.Cut(part, "="); ok {
+			parts[i] = name + "=" + Placeholder
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+// URL masks sensitive query parameters and pattern matches in a URL
+func (r *Redactor) URL(raw string) string {
+	if r.disabled {
+		return raw
+	}
+	if base, query, ok := strings.Cut(raw, "?"); ok {
+		raw = base + "?" + r.Query(query)
+	}
+	return r.String(raw)
+}
+
+// Query masks sensitive parameters in a raw query string, keeping their order
+func (r *Redactor) Query(rawQuery string) string {
+	if r.disabled || rawQuery == "" {
+		return rawQuery
+	}
+	pairs := strings.Split(rawQuery, "&")
+	for i, pair := range pairs {
+		name, _, ok := strings.Cut(pair, "=")
+		if !ok {
+			continue
+		}
+		if unescaped, err := url.QueryUnescape(name); err == nil && r.queryParams[strings.ToLower(unescaped)] {
+			pairs[i] = name + "=" + url.QueryEscape(Placeholder)
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// MasksBodies reports whether Body can change a body, i.e. whether any body
+// rules are configured
+func (r *Redactor) MasksBodies() bool {
+	return !r.disabled && (len(r.paths) > 0 || len(r.patterns) > 0)
+}
+
+// Body masks the configured JSONPath fields of a JSON body and the pattern
+// matches of a text body. The input is returned unchanged when nothing matched.
+func (r *Redactor) Body(body []byte) []byte {
+	if r.disabled || len(body) == 0 {
+		return body
+	}
+	if len(r.paths) > 0 {
+		var doc any
+		if json.Unmarshal(body, &doc) == nil {
+			changed := false
+			for _, rule := range r.paths {
+				doc = maskPath(doc, rule.tokens, func(v any) any {
+					changed = true
+					return maskValue(v, rule.mask, rule.keepLast)
+				})
+			}
+			if changed {
+				var buf bytes.Buffer
+				enc := json.NewEncoder(&buf)
+				enc.SetEscapeHTML(false)
+				if enc.Encode(doc) == nil {
+					body = bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+				}
+			}
+		}
+	}
+	if len(r.patterns) > 0 && utf8.Valid(body) {
+		if masked := r.String(string(body)); masked != string(body) {
+			body = []byte(masked)
+		}
+	}
+	return body
+}
+
+// String masks pattern matches in s. Patterns with capture groups only mask
+// the groups, so "password=(\S+)" keeps the "password=" prefix.
+func (r *Redactor) String(s string) string {
+	if r.disabled {
+		return s
+	}
+	for _, rule := range r.patterns {
+		if rule.re.NumSubexp() == 0 {
+			s = rule.re.ReplaceAllStringFunc(s, func(m string) string {
+				return maskString(m, rule.mask, rule.keepLast)
+			})
+			continue
+		}
+		var out strings.Builder
+		last := 0
+		for _, loc := range rule.re.FindAllStringSubmatchIndex(s, -1) {
+			for g := 1; g <= rule.re.NumSubexp(); g++ {
+				start, end := loc[2*g], loc[2*g+1]
+				if start < last || start < 0 {
+					continue
+				}
+				out.WriteString(s[last:start])
+				out.WriteString(maskString(s[start:end], rule.mask, rule.keepLast))
+				last = end
+			}
+		}
+		out.WriteString(s[last:])
+		s = out.String()
+	}
+	return s
+}
+
+// maskValue masks a decoded JSON value. Objects and arrays are replaced as a whole.
+func maskValue(v any, mode string, keepLast int) any {
+	if s, ok := v.(string); ok {
+		return maskString(s, mode, keepLast)
+	}
+	if mode == MaskPreserve {
+		if _, ok := v.(float64); ok {
+			raw, _ := json.Marshal(v)
+			return maskString(string(raw), mode, keepLast)
+		}
+	}
+	return Placeholder
+}
+
+// maskString masks s. In preserve mode letters and digits become '*' except
+// for the last keepLast of them; everything else keeps its place.
+func maskString(s, mode string, keepLast int) string {
+	if mode != MaskPreserve {
+		return Placeholder
+	}
+	runes := []rune(s)
+	visible := 0
+	for i := len(runes) - 1; i >= 0; i-- {
+		if !unicode.IsLetter(runes[i]) && !unicode.IsDigit(runes[i]) {
+			continue
+		}
+		if visible < keepLast {
+			visible++
+			continue
+		}
+		runes[i] = '*'
+	}
+	return string(runes)
+}
+
+// parsePath splits a JSONPath ("$.a.b[0]", "$.items[*].card", "$['x-y']")
+// into tokens; "*" matches every member or element
+func parsePath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSONPath must start with $")
+	}
+	var tokens []string
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("empty segment")
+			}
+			tokens = append(tokens, rest[:end])
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated bracket")
+			}
+			segment := rest[1:end]
+			if len(segment) >= 2 && (segment[0] == '\'' || segment[0] == '"') {
+				segment = segment[1 : len(segment)-1]
+			}
+			tokens = append(tokens, segment)
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("unexpected %q", rest[0])
+		}
+	}
+	return tokens, nil
+}
+
+// maskPath applies mask to every value matched by tokens and returns the
+// updated node
+func maskPath(node any, tokens []string, mask func(any) any) any {
+	if len(tokens) == 0 {
+		return mask(node)
+	}
+	token, rest := tokens[0], tokens[1:]
+	switch n := node.(type) {
+	case map[string]any:
+		if token == "*" {
+			for k, v := range n {
+				n[k] = maskPath(v, rest, mask)
+			}
+		} else if v, ok := n[token]; ok {
+			n[token] = maskPath(v, rest, mask)
+		}
+	case []any:
+		if token == "*" {
+			for i, v := range n {
+				n[i] = maskPath(v, rest, mask)
+			}
+		} else if i, err := parseIndex(token); err == nil && i < len(n) {
+			n[i] = maskPath(n[i], rest, mask)
+		}
+	}
+	return node
+}
+
+func parseIndex(token string) (int, error) {
+	i, err := strconv.Atoi(token)
+	if err != nil || i < 0 {
+		return 0, fmt.Errorf("invalid index %q", token)
+	}
+	return i, nil
+}
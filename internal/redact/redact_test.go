@@ -0,0 +1,146 @@
+package redact
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/dipjyotimetia/jarvis/config"
+)
+
+func TestHeader(t *testing.T) {
+	r, err := New(config.RedactionConfig{Headers: []string{"X-Customer-Email"}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	h := http.Header{
+		"Authorization":    {"Bearer eyJhbGciOi"},
+		"Cookie":           {"session=abc123; theme=dark"},
+		"Set-Cookie":       {"id=42; Path=/; HttpOnly"},
+		"X-Goog-Api-Key":   {"AIza-secret"},
+		"X-Customer-Email": {"ada@example.com"},
+		"Content-Type":     {"application/json"},
+	}
+	got := r.Header(h)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Authorization", "Bearer [REDACTED]"},
+		{"Cookie", "session=[REDACTED]; theme=[REDACTED]"},
+		{"Set-Cookie", "id=[REDACTED]; Path=/; HttpOnly"},
+		{"X-Goog-Api-Key", "[REDACTED]"},
+		{"X-Customer-Email", "[REDACTED]"},
+		{"Content-Type", "application/json"},
+	}
+	for _, tt := range tests {
+		if v := got.Get(tt.name); v != tt.want {
+			t.Errorf("%s = %q, want %q", tt.name, v, tt.want)
+		}
+	}
+	if h.Get("Authorization") != "Bearer eyJhbGciOi" {
+		t.Error("Header() must not modify its input")
+	}
+}
+
+func TestURL(t *testing.T) {
+	r, err := New(config.RedactionConfig{QueryParams: []string{"ssn"}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"/users?id=1&api_key=abc", "/users?id=1&api_key=%5BREDACTED%5D"},
+		{"https://api.example.com/x?SSN=123&Token=t", "https://api.example.com/x?SSN=%5BREDACTED%5D&Token=%5BREDACTED%5D"},
+		{"/users", "/users"},
+	}
+	for _, tt := range tests {
+		if got := r.URL(tt.in); got != tt.want {
+			t.Errorf("URL(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBody(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []config.RedactionRule
+		body  string
+		want  string
+	}{
+		{
+			name:  "JSONPath replace",
+			rules: []config.RedactionRule{{Path: "$.customer.email"}},
+			body:  `{"customer":{"email":"ada@example.com","name":"Ada"}}`,
+			want:  `{"customer":{"email":"[REDACTED]","name":"Ada"}}`,
+		},
+		{
+			name:  "Wildcard with format-preserving mask",
+			rules: []config.RedactionRule{{Path: "$.cards[*].number", Mask: "preserve", KeepLast: 4}},
+			body:  `{"cards":[{"number":"4111-1111-1111-1234"},{"number":"5500 0000 0000 0004"}]}`,
+			want:  `{"cards":[{"number":"****-****-****-1234"},{"number":"**** **** **** 0004"}]}`,
+		},
+		{
+			name:  "Numbers keep their digit count",
+			rules: []config.RedactionRule{{Path: "$.pin", Mask: "preserve"}},
+			body:  `{"pin":1234}`,
+			want:  `{"pin":"****"}`,
+		},
+		{
+			name:  "Pattern capture group",
+			rules: []config.RedactionRule{{Pattern: `password=([^&]+)`}},
+			body:  `user=ada&password=hunter2&remember=1`,
+			want:  `user=ada&password=[REDACTED]&remember=1`,
+		},
+		{
+			name:  "Pattern without groups",
+			rules: []config.RedactionRule{{Pattern: `[\w.]+@[\w.]+`, Mask: "preserve"}},
+			body:  `contact ada@example.com today`,
+			want:  `contact ***@*******.*** today`,
+		},
+		{
+			name:  "Unmatched path leaves the body untouched",
+			rules: []config.RedactionRule{{Path: "$.missing"}},
+			body:  `{"b": 1,  "a": 2}`,
+			want:  `{"b": 1,  "a": 2}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := New(config.RedactionConfig{Rules: tt.rules})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			if got := string(r.Body([]byte(tt.body))); got != tt.want {
+				t.Errorf("Body() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	r, err := New(config.RedactionConfig{Disabled: true, Rules: []config.RedactionRule{{Path: "$.email"}}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if got := r.Header(http.Header{"Authorization": {"Bearer x"}}).Get("Authorization"); got != "Bearer x" {
+		t.Errorf("Disabled redactor changed a header: %q", got)
+	}
+	if got := string(r.Body([]byte(`{"email":"a@b.c"}`))); got != `{"email":"a@b.c"}` {
+		t.Errorf("Disabled redactor changed a body: %s", got)
+	}
+}
+
+func TestNewRejectsInvalidRules(t *testing.T) {
+	if _, err := New(config.RedactionConfig{Rules: []config.RedactionRule{{Pattern: "("}}}); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+	if _, err := New(config.RedactionConfig{Rules: []config.RedactionRule{{Path: "email"}}}); err == nil {
+		t.Error("Expected an error for a path without $")
+	}
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/dipjyotimetia/jarvis/config"
+	"go.opentelemetry.io/otel"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// collector stands in for an OTLP/HTTP collector and keeps the spans it receives
+type collector struct {
+	mu    sync.Mutex
+	spans map[string]string // Span name to service name
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/v1/traces" {
+		http.NotFound(w, r)
+		return
+	}
+	body, _ := io.ReadAll(r.Body)
+	var req coltracepb.ExportTraceServiceRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, rs := range req.ResourceSpans {
+		var service string
+		for _, attr := range rs.GetResource().GetAttributes() {
+			if attr.Key == "service.name" {
+				service = attr.GetValue().GetStringValue()
+			}
+		}
+		for _, ss := range rs.ScopeSpans {
+			for _, span := range ss.Spans {
+				c.spans[span.Name] = service
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/x-protobuf")
+	w.Write(nil)
+}
+
+func TestSetupExportsSpans(t *testing.T) {
+	c := &collector{spans: map[string]string{}}
+	server := httptest.NewServer(c)
+	defer server.Close()
+
+	shutdown, err := Setup(context.Background(), config.TracingConfig{
+		Enabled:     true,
+		Endpoint:    server.URL + "/v1/traces",
+		ServiceName: "jarvis-test",
+		SampleRatio: 1,
+	})
+	if err != nil {
+		t.Fatalf("Setup() error: %v", err)
+	}
+
+	_, span := otel.Tracer("test").Start(context.Background(), "proxy request")
+	if !span.SpanContext().IsSampled() {
+		t.Error("Expected a sampled span with sample_ratio 1")
+	}
+	span.End()
+
+	// Shutting down flushes the batch to the collector
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatalf("shutdown() error: %v", err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if service, ok := c.spans["proxy request"]; !ok || service != "jarvis-test" {
+		t.Errorf("Expected the span exported for jarvis-test, got %v", c.spans)
+	}
+}
+
+func TestSetupDisabled(t *testing.T) {
+	shutdown, err := Setup(context.Background(), config.TracingConfig{})
+	if err != nil {
+		t.Fatalf("Setup() error: %v", err)
+	}
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() error: %v", err)
+	}
+}
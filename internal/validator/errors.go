@@ -0,0 +1,389 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+)
+
+// Validation error kinds
+const (
+	KindRequest  = "request"
+	KindResponse = "response"
+)
+
+// Violation is one way a request or response breaks the spec
+type Violation struct {
+	In         string `json:"in"`                    // body, query, path, header, cookie, status or route
+	Parameter  string `json:"parameter,omitempty"`   // Name of the failing parameter or header
+	Pointer    string `json:"pointer,omitempty"`     // JSON pointer to the failing value in the body, e.g. /items/0/id
+	SchemaPath string `json:"schema_path,omitempty"` // JSON pointer into the spec to the failing keyword
+	Keyword    string `json:"keyword,omitempty"`     // Failing schema keyword, e.g. required or maxLength
+	Expected   any    `json:"expected,omitempty"`    // What the keyword asks for
+	Actual     any    `json:"actual,omitempty"`      // The value found instead
+	Message    string `json:"message"`
+}
+
+// ValidationError reports a request or response that breaks the spec, with
+// each of its violations
+type ValidationError struct {
+	Kind       string // KindRequest or KindResponse
+	Violations []Violation
+	err        error
+}
+
+func (e *ValidationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.err
+}
+
+// Violations returns the violations behind a validation error. Errors that
+// carry no detail, such as a request for a path outside the spec, yield a
+// single violation with their message.
+func Violations(err error) []Violation {
+	if err == nil {
+		return nil
+	}
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Violations
+	}
+	var routeErr *RouteError
+	if errors.As(err, &routeErr) {
+		return []Violation{{In: "route", Message: routeErr.Error()}}
+	}
+	return []Violation{{In: "route", Message: err.Error()}}
+}
+
+// newValidationError collects the violations of an error returned by
+// openapi3filter for route
+func newValidationError(kind string, route *routers.Route, err error) *ValidationError {
+	var violations []Violation
+	for _, e := range flattenErrors(err) {
+		violations = append(violations, violationsOf(route, e)...)
+	}
+	return &ValidationError{
+		Kind:       kind,
+		Violations: violations,
+		err:        fmt.Errorf("validating %s: %w", kind, err),
+	}
+}
+
+// flattenErrors expands the multi-errors openapi3filter returns with the
+// MultiError option
+func flattenErrors(err error) []error {
+	if multi, ok := err.(openapi3.MultiError); ok {
+		var errs []error
+		for _, e := range multi {
+			errs = append(errs, flattenErrors(e)...)
+		}
+		return errs
+	}
+	return []error{err}
+}
+
+func violationsOf(route *routers.Route, err error) []Violation {
+	var requestErr *openapi3filter.RequestError
+	var responseErr *openapi3filter.ResponseError
+	var securityErr *openapi3filter.SecurityRequirementsError
+	switch {
+	case errors.As(err, &requestErr):
+		if param := requestErr.Parameter; param != nil {
+			base := Violation{In: param.In, Parameter: param.Name, Message: requestErr.Error()}
+			return schemaViolations(base, requestErr.Err, param.Schema, parameterPath(route, param)+"/schema")
+		}
+		if body := requestErr.RequestBody; body != nil {
+			base := Violation{In: "body", Message: requestErr.Error()}
+			mediaType := contentType(requestErr.Input.Request.Header)
+			schema, path := contentSchema(body.Content, mediaType, operationPath(route)+"/requestBody")
+			return schemaViolations(base, requestErr.Err, schema, path)
+		}
+		return []Violation{{In: "request", Message: requestErr.Error()}}
+	case errors.As(err, &responseErr):
+		input := responseErr.Input
+		base := Violation{In: "body", Message: responseErr.Error()}
+		if responseErr.Reason == "status is not supported" {
+			return []Violation{{In: "status", Actual: input.Status, Message: responseErr.Error()}}
+		}
+		if strings.Contains(responseErr.Reason, "header") {
+			base.In = "header"
+			if i := strings.IndexByte(responseErr.Reason, '"'); i >= 0 {
+				base.Parameter, _ = strconv.Unquote(quotedPrefix(responseErr.Reason[i:]))
+			}
+			return schemaViolations(base, responseErr.Err, nil, "")
+		}
+		var schema *openapi3.SchemaRef
+		var path string
+		if route != nil && route.Operation != nil {
+			if key, response := responseFor(route.Operation.Responses, input.Status); response != nil {
+				schema, path = contentSchema(response.Content, contentType(input.Header),
+					operationPath(route)+"/responses/"+escapePointer(key))
+			}
+		}
+		return schemaViolations(base, responseErr.Err, schema, path)
+	case errors.As(err, &securityErr):
+		return []Violation{{In: "security", Message: securityErr.Error()}}
+	}
+	return []Violation{{In: "request", Message: err.Error()}}
+}
+
+// schemaViolations turns the schema errors within err into violations based
+// on base. root is the schema the value was validated against, found at
+// rootPath in the spec, and is used to work out schema paths.
+func schemaViolations(base Violation, err error, root *openapi3.SchemaRef, rootPath string) []Violation {
+	var violations []Violation
+	for _, e := range flattenErrors(err) {
+		var schemaErr *openapi3.SchemaError
+		if !errors.As(e, &schemaErr) {
+			continue
+		}
+		v := base
+		if base.In == "body" {
+			v.Pointer = jsonPointer(schemaErr.JSONPointer())
+		}
+		v.Keyword = schemaErr.SchemaField
+		v.Expected = expectedValue(schemaErr)
+		v.Actual = actualValue(schemaErr)
+		v.Message = schemaErr.Reason
+		pointer := schemaErr.JSONPointer()
+		path, ok := findSchemaPath(root, rootPath, pointer, schemaErr.Schema, 0)
+		if !ok && len(pointer) > 0 {
+			// Missing required properties point below the object that requires them
+			path, ok = findSchemaPath(root, rootPath, pointer[:len(pointer)-1], schemaErr.Schema, 0)
+		}
+		if ok && v.Keyword != "" && strings.HasPrefix(path, "#/") {
+			v.SchemaPath = path + "/" + v.Keyword
+		}
+		violations = append(violations, v)
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+
+	// Parameters that cannot be parsed as their type fail before any schema
+	// keyword is checked
+	var parseErr *openapi3filter.ParseError
+	if errors.As(err, &parseErr) && base.In != "body" && root != nil && root.Value != nil && root.Value.Type != nil {
+		base.Keyword = "type"
+		base.Expected = root.Value.Type.Slice()
+		base.Actual = parseErr.Value
+		base.Message = parseErr.Error()
+		if strings.HasPrefix(rootPath, "#/") {
+			base.SchemaPath = rootPath + "/type"
+		}
+	}
+	return []Violation{base}
+}
+
+// maxSchemaDepth bounds the search for a schema path in recursive schemas
+const maxSchemaDepth = 64
+
+// findSchemaPath returns where target sits in the spec, searching from ref
+// (found at path) along the value pointer. Keywords that apply to the same
+// value, such as allOf, are searched without consuming the pointer.
+func findSchemaPath(ref *openapi3.SchemaRef, path string, pointer []string, target *openapi3.Schema, depth int) (string, bool) {
+	if ref == nil || ref.Value == nil || depth > maxSchemaDepth {
+		return "", false
+	}
+	if strings.HasPrefix(ref.Ref, "#/") {
+		path = ref.Ref
+	}
+	s := ref.Value
+	if len(pointer) == 0 && s == target {
+		return path, true
+	}
+	for _, group := range []struct {
+		keyword string
+		refs    openapi3.SchemaRefs
+	}{{"allOf", s.AllOf}, {"anyOf", s.AnyOf}, {"oneOf", s.OneOf}} {
+		for i, sub := range group.refs {
+			if found, ok := findSchemaPath(sub, fmt.Sprintf("%s/%s/%d", path, group.keyword, i), pointer, target, depth+1); ok {
+				return found, true
+			}
+		}
+	}
+	if found, ok := findSchemaPath(s.Not, path+"/not", pointer, target, depth+1); ok {
+		return found, true
+	}
+	if len(pointer) == 0 {
+		return "", false
+	}
+	seg, rest := pointer[0], pointer[1:]
+	if prop, ok := s.Properties[seg]; ok {
+		return findSchemaPath(prop, path+"/properties/"+escapePointer(seg), rest, target, depth+1)
+	}
+	if _, err := strconv.Atoi(seg); err == nil && s.Items != nil {
+		return findSchemaPath(s.Items, path+"/items", rest, target, depth+1)
+	}
+	return findSchemaPath(s.AdditionalProperties.Schema, path+"/additionalProperties", rest, target, depth+1)
+}
+
+// expectedValue returns what the failing keyword of err asks for
+func expectedValue(err *openapi3.SchemaError) any {
+	s := err.Schema
+	if s == nil {
+		return nil
+	}
+	switch err.SchemaField {
+	case "type":
+		if s.Type != nil {
+			return s.Type.Slice()
+		}
+	case "enum":
+		return s.Enum
+	case "required":
+		return s.Required
+	case "format":
+		return s.Format
+	case "pattern":
+		return s.Pattern
+	case "minimum":
+		return deref(s.Min)
+	case "maximum":
+		return deref(s.Max)
+	case "multipleOf":
+		return deref(s.MultipleOf)
+	case "minLength":
+		return s.MinLength
+	case "maxLength":
+		return deref(s.MaxLength)
+	case "minItems":
+		return s.MinItems
+	case "maxItems":
+		return deref(s.MaxItems)
+	case "minProperties":
+		return s.MinProps
+	case "maxProperties":
+		return deref(s.MaxProps)
+	case "nullable":
+		return s.Nullable
+	}
+	return nil
+}
+
+// deref returns the value p points to, or nil
+func deref[T any](p *T) any {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// actualValue returns the failing value of err. Objects and arrays are left
+// out, since the pointer locates them, except for the size of an array that
+// breaks minItems or maxItems.
+func actualValue(err *openapi3.SchemaError) any {
+	switch value := err.Value.(type) {
+	case map[string]any:
+		if err.SchemaField == "minProperties" || err.SchemaField == "maxProperties" {
+			return len(value)
+		}
+		return nil
+	case []any:
+		if err.SchemaField == "minItems" || err.SchemaField == "maxItems" {
+			return len(value)
+		}
+		return nil
+	default:
+		return value
+	}
+}
+
+// responseFor returns the response of responses that covers status and its
+// key: the status itself, its range such as 2XX, or default
+func responseFor(responses *openapi3.Responses, status int) (string, *openapi3.Response) {
+	if responses == nil {
+		return "", nil
+	}
+	for _, key := range []string{strconv.Itoa(status), strconv.Itoa(status/100) + "XX", "default"} {
+		if ref := responses.Value(key); ref != nil && ref.Value != nil {
+			return key, ref.Value
+		}
+	}
+	return "", nil
+}
+
+// contentSchema returns the schema content holds for mediaType and where it
+// sits in the spec, below path
+func contentSchema(content openapi3.Content, mediaType, path string) (*openapi3.SchemaRef, string) {
+	media := content.Get(mediaType)
+	if media == nil {
+		return nil, ""
+	}
+	for key, candidate := range content {
+		if candidate == media {
+			return media.Schema, path + "/content/" + escapePointer(key) + "/schema"
+		}
+	}
+	return media.Schema, ""
+}
+
+// parameterPath is the JSON pointer to param in the spec, declared by the
+// operation of route or by its path
+func parameterPath(route *routers.Route, param *openapi3.Parameter) string {
+	if route == nil {
+		return ""
+	}
+	if route.Operation != nil {
+		for i, ref := range route.Operation.Parameters {
+			if ref.Value == param {
+				return fmt.Sprintf("%s/parameters/%d", operationPath(route), i)
+			}
+		}
+	}
+	if route.PathItem != nil {
+		for i, ref := range route.PathItem.Parameters {
+			if ref.Value == param {
+				return fmt.Sprintf("#/paths/%s/parameters/%d", escapePointer(route.Path), i)
+			}
+		}
+	}
+	return ""
+}
+
+// operationPath is the JSON pointer to the operation of route in the spec
+func operationPath(route *routers.Route) string {
+	if route == nil {
+		return ""
+	}
+	return "#/paths/" + escapePointer(route.Path) + "/" + strings.ToLower(route.Method)
+}
+
+// quotedPrefix returns the quoted string s starts with, if any
+func quotedPrefix(s string) string {
+	prefix, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return ""
+	}
+	return prefix
+}
+
+func contentType(header http.Header) string {
+	mediaType, _, _ := strings.Cut(header.Get("Content-Type"), ";")
+	return strings.TrimSpace(mediaType)
+}
+
+func jsonPointer(segments []string) string {
+	if len(segments) == 0 {
+		return ""
+	}
+	escaped := make([]string, len(segments))
+	for i, seg := range segments {
+		escaped[i] = escapePointer(seg)
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+// escapePointer escapes a JSON pointer segment as in RFC 6901
+func escapePointer(seg string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(seg)
+}
@@ -0,0 +1,126 @@
+package validator
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const ordersSpec = `
+openapi: 3.0.0
+info:
+  title: Orders API
+  version: 1.0.0
+paths:
+  /orders:
+    get:
+      parameters:
+        - name: limit
+          in: query
+          schema:
+            type: integer
+            maximum: 100
+      responses:
+        '200':
+          description: Orders
+          content:
+            application/json:
+              schema:
+                type: array
+                items:
+                  $ref: '#/components/schemas/Order'
+    post:
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              $ref: '#/components/schemas/Order'
+      responses:
+        '201':
+          description: Created
+components:
+  schemas:
+    Order:
+      type: object
+      required: [id, items]
+      properties:
+        id:
+          type: string
+        status:
+          type: string
+          enum: [open, shipped]
+        items:
+          type: array
+          minItems: 1
+          items:
+            type: object
+            properties:
+              quantity:
+                type: integer
+                minimum: 1
+`
+
+func newOrdersValidator(t *testing.T) *APIValidator {
+	t.Helper()
+	specPath := filepath.Join(t.TempDir(), "orders.yaml")
+	if err := os.WriteFile(specPath, []byte(ordersSpec), 0o644); err != nil {
+		t.Fatalf("Failed to write spec: %v", err)
+	}
+	v, err := NewAPIValidator(specPath, APIValidatorOptions{EnableRequestValidation: true, EnableResponseValidation: true})
+	if err != nil {
+		t.Fatalf("Failed to create API validator: %v", err)
+	}
+	return v
+}
+
+func TestViolations(t *testing.T) {
+	v := newOrdersValidator(t)
+
+	req := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(`{"id": 7, "items": [{"quantity": 0}]}`))
+	req.Header.Set("Content-Type", "application/json")
+	err := v.ValidateRequest(req)
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) || validationErr.Kind != KindRequest {
+		t.Fatalf("Expected a request *ValidationError, got %v", err)
+	}
+	if !strings.HasPrefix(err.Error(), "validating request: ") {
+		t.Errorf("Expected the flattened message to be kept, got %q", err.Error())
+	}
+	want := []Violation{
+		{In: "body", Pointer: "/id", SchemaPath: "#/components/schemas/Order/properties/id/type", Keyword: "type", Expected: []string{"string"}, Actual: float64(7), Message: "value must be a string"},
+		{In: "body", Pointer: "/items/0/quantity", SchemaPath: "#/components/schemas/Order/properties/items/items/properties/quantity/minimum", Keyword: "minimum", Expected: float64(1), Actual: float64(0), Message: "number must be at least 1"},
+	}
+	if got := Violations(err); !reflect.DeepEqual(got, want) {
+		t.Errorf("Violations() =\n%#v\nwant\n%#v", got, want)
+	}
+
+	req = httptest.NewRequest(http.MethodGet, "/orders?limit=500", nil)
+	want = []Violation{
+		{In: "query", Parameter: "limit", SchemaPath: "#/paths/~1orders/get/parameters/0/schema/maximum", Keyword: "maximum", Expected: float64(100), Actual: float64(500), Message: "number must be at most 100"},
+	}
+	if got := Violations(v.ValidateRequest(req)); !reflect.DeepEqual(got, want) {
+		t.Errorf("Violations() =\n%#v\nwant\n%#v", got, want)
+	}
+
+	header := http.Header{"Content-Type": {"application/json"}}
+	err = v.ValidateResponse(httptest.NewRequest(http.MethodGet, "/orders", nil), http.StatusOK, header, []byte(`[{"id": "1", "items": []}, {"items": [{"quantity": 1}]}]`))
+	want = []Violation{
+		{In: "body", Pointer: "/0/items", SchemaPath: "#/components/schemas/Order/properties/items/minItems", Keyword: "minItems", Expected: uint64(1), Actual: 0, Message: "minimum number of items is 1"},
+		{In: "body", Pointer: "/1/id", SchemaPath: "#/components/schemas/Order/required", Keyword: "required", Expected: []string{"id", "items"}, Message: `property "id" is missing`},
+	}
+	if got := Violations(err); !reflect.DeepEqual(got, want) {
+		t.Errorf("Violations() =\n%#v\nwant\n%#v", got, want)
+	}
+
+	// Requests outside the spec carry their message only
+	got := Violations(v.ValidateRequest(httptest.NewRequest(http.MethodGet, "/customers", nil)))
+	if len(got) != 1 || got[0].In != "route" || !strings.Contains(got[0].Message, "path not found") {
+		t.Errorf("Expected a route violation, got %+v", got)
+	}
+}
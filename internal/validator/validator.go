@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/getkin/kin-openapi/openapi3"
@@ -28,6 +29,9 @@ type APIValidatorOptions struct {
 	EnableResponseValidation bool
 	// StrictMode enables more rigorous validation checks
 	StrictMode bool
+	// BasePath is stripped from request paths before they are matched against
+	// the paths of the spec, for services mounted below a prefix
+	BasePath string
 }
 
 // NewAPIValidator creates a new API validator from OpenAPI spec file
@@ -56,24 +60,61 @@ func NewAPIValidator(specPath string, options APIValidatorOptions) (*APIValidato
 	}, nil
 }
 
-// ValidateRequest validates an HTTP request against the OpenAPI spec
-func (v *APIValidator) ValidateRequest(req *http.Request) error {
-	if !v.options.EnableRequestValidation {
-		return nil
+// RouteError reports a request that matches no operation of the spec
+type RouteError struct {
+	Method           string
+	Path             string
+	MethodNotAllowed bool // The path exists but has no operation for Method
+}
+
+func (e *RouteError) Error() string {
+	if e.MethodNotAllowed {
+		return fmt.Sprintf("method %s not allowed for path %s", e.Method, e.Path)
 	}
+	return fmt.Sprintf("path not found in API spec: %s", e.Path)
+}
 
-	// Find route
+// FindRoute returns the operation of the spec that serves req and the values
+// of its path parameters. Requests matching no operation return a *RouteError.
+func (v *APIValidator) FindRoute(req *http.Request) (*routers.Route, map[string]string, error) {
+	if base := strings.TrimSuffix(v.options.BasePath, "/"); base != "" {
+		rest, ok := strings.CutPrefix(req.URL.Path, base)
+		if !ok || (rest != "" && !strings.HasPrefix(rest, "/")) {
+			return nil, nil, &RouteError{Method: req.Method, Path: req.URL.Path}
+		}
+		stripped := *req
+		stripped.URL = new(url.URL)
+		*stripped.URL = *req.URL
+		stripped.URL.Path = "/" + strings.TrimPrefix(rest, "/")
+		stripped.URL.RawPath = ""
+		req = &stripped
+	}
 	route, pathParams, err := v.router.FindRoute(req)
 	if err != nil {
 		var routeError *routers.RouteError
 		if errors.As(err, &routeError) {
 			if routeError.Reason == routers.ErrPathNotFound.Error() {
-				return fmt.Errorf("path not found in API spec: %s", req.URL.Path)
+				return nil, nil, &RouteError{Method: req.Method, Path: req.URL.Path}
 			} else if routeError.Reason == routers.ErrMethodNotAllowed.Error() {
-				return fmt.Errorf("method %s not allowed for path %s", req.Method, req.URL.Path)
+				return nil, nil, &RouteError{Method: req.Method, Path: req.URL.Path, MethodNotAllowed: true}
 			}
 		}
-		return fmt.Errorf("finding route: %w", err)
+		return nil, nil, fmt.Errorf("finding route: %w", err)
+	}
+	return route, pathParams, nil
+}
+
+// ValidateRequest validates an HTTP request against the OpenAPI spec. Requests
+// that break it return a *ValidationError.
+func (v *APIValidator) ValidateRequest(req *http.Request) error {
+	if !v.options.EnableRequestValidation {
+		return nil
+	}
+
+	// Find route
+	route, pathParams, err := v.FindRoute(req)
+	if err != nil {
+		return err
 	}
 
 	// Create validation input
@@ -92,22 +133,23 @@ func (v *APIValidator) ValidateRequest(req *http.Request) error {
 	// Validate request
 	err = openapi3filter.ValidateRequest(context.Background(), requestValidationInput)
 	if err != nil {
-		return fmt.Errorf("validating request: %w", err)
+		return newValidationError(KindRequest, route, err)
 	}
 
 	return nil
 }
 
-// ValidateResponse validates an HTTP response against the OpenAPI spec
+// ValidateResponse validates an HTTP response against the OpenAPI spec.
+// Responses that break it return a *ValidationError.
 func (v *APIValidator) ValidateResponse(req *http.Request, status int, header http.Header, body []byte) error {
 	if !v.options.EnableResponseValidation {
 		return nil
 	}
 
 	// Find route
-	route, pathParams, err := v.router.FindRoute(req)
+	route, pathParams, err := v.FindRoute(req)
 	if err != nil {
-		return fmt.Errorf("finding route: %w", err)
+		return err
 	}
 
 	// Create validation input
@@ -130,7 +172,7 @@ func (v *APIValidator) ValidateResponse(req *http.Request, status int, header ht
 	// Validate response
 	err = openapi3filter.ValidateResponse(context.Background(), responseValidationInput)
 	if err != nil {
-		return fmt.Errorf("validating response: %w", err)
+		return newValidationError(KindResponse, route, err)
 	}
 
 	return nil
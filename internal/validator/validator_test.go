@@ -1,6 +1,7 @@
 package validator
 
 import (
+	"errors"
 	"net/http"
 	"net/http/httptest"
 	"os"
@@ -166,6 +167,58 @@ paths:
 	})
 }
 
+func TestAPIValidatorBasePath(t *testing.T) {
+	specPath := filepath.Join(t.TempDir(), "products.yaml")
+	specContent := `
+openapi: 3.0.0
+info:
+  title: Products API
+  version: 1.0.0
+paths:
+  /products/{id}:
+    get:
+      parameters:
+        - name: id
+          in: path
+          required: true
+          schema:
+            type: integer
+      responses:
+        '200':
+          description: Successful response
+`
+	if err := os.WriteFile(specPath, []byte(specContent), 0o644); err != nil {
+		t.Fatalf("Failed to write spec: %v", err)
+	}
+	validator, err := NewAPIValidator(specPath, APIValidatorOptions{EnableRequestValidation: true, BasePath: "/api/v1/"})
+	if err != nil {
+		t.Fatalf("Failed to create API validator: %v", err)
+	}
+
+	tests := []struct {
+		path     string
+		wantErr  bool
+		notFound bool
+	}{
+		{path: "/api/v1/products/7"},
+		{path: "/api/v1/products/seven", wantErr: true},
+		{path: "/products/7", wantErr: true, notFound: true},
+		{path: "/api/v10/products/7", wantErr: true, notFound: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			err := validator.ValidateRequest(httptest.NewRequest(http.MethodGet, tt.path, nil))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			var routeErr *RouteError
+			if errors.As(err, &routeErr) != tt.notFound {
+				t.Errorf("Expected path not found %v, got %v", tt.notFound, err)
+			}
+		})
+	}
+}
+
 // Test helper for path normalization
 func TestNormalizePathForSpec(t *testing.T) {
 	specPaths := map[string]struct{}{
@@ -5,20 +5,30 @@ import (
 	"embed"
 	"encoding/json"
 	"html/template"
+	"io"
 	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+
+	"github.com/dipjyotimetia/jarvis/internal/blob"
+	"github.com/dipjyotimetia/jarvis/internal/db"
 )
 
 //go:embed index.html
 var templateFS embed.FS
 
+// bodyPreviewSize bounds how much of a blob-stored body the detail view
+// inlines; the full body is served by the body endpoint
+const bodyPreviewSize = 1024 * 1024
+
 // UIHandler manages the web interface for browsing recorded transactions
 type UIHandler struct {
 	database *sql.DB
+	blobs    *blob.Store
 	tmpl     *template.Template
+	traceURL string // Link to a trace, with {trace_id} replaced
 }
 
 // TransactionListResponse represents the response structure for transaction listings
@@ -31,49 +41,75 @@ type TransactionListResponse struct {
 
 // TransactionSummary contains a summarized view of a transaction
 type TransactionSummary struct {
-	ID          string    `json:"id"`
-	Timestamp   time.Time `json:"timestamp"`
-	Protocol    string    `json:"protocol"`
-	Method      string    `json:"method"`
-	URL         string    `json:"url"`
-	Status      int       `json:"status"`
-	Duration    int64     `json:"duration_ms"`
-	ContentType string    `json:"content_type"`
+	ID             string    `json:"id"`
+	Timestamp      time.Time `json:"timestamp"`
+	Protocol       string    `json:"protocol"`
+	Method         string    `json:"method"`
+	URL            string    `json:"url"`
+	Service        string    `json:"service,omitempty"`
+	Source         string    `json:"source"`
+	Fault          string    `json:"fault,omitempty"`
+	UpstreamTarget string    `json:"upstream_target,omitempty"` // Upstream that served a live response
+	Status         int       `json:"status"`
+	Duration       int64     `json:"duration_ms"`
+	ContentType    string    `json:"content_type"`
 }
 
 // TransactionDetail contains complete transaction details
 type TransactionDetail struct {
-	ID                  string    `json:"id"`
-	Timestamp           time.Time `json:"timestamp"`
-	Protocol            string    `json:"protocol"`
-	Method              string    `json:"method"`
-	URL                 string    `json:"url"`
-	RequestHeaders      string    `json:"request_headers"`
-	RequestBody         []byte    `json:"request_body"`
-	ResponseStatus      int       `json:"response_status"`
-	ResponseHeaders     string    `json:"response_headers"`
-	ResponseBody        []byte    `json:"response_body"`
-	Duration            int64     `json:"duration_ms"`
-	ClientIP            string    `json:"client_ip"`
-	TestID              string    `json:"test_id"`
-	SessionID           string    `json:"session_id"`
-	ConnectionID        string    `json:"connection_id,omitempty"`
-	MessageType         int       `json:"message_type,omitempty"`
-	Direction           string    `json:"direction,omitempty"`
-	ValidationError     string    `json:"validation_error,omitempty"`
-	ValidationErrorType string    `json:"validation_error_type,omitempty"`
+	ID                  string               `json:"id"`
+	Timestamp           time.Time            `json:"timestamp"`
+	Protocol            string               `json:"protocol"`
+	Method              string               `json:"method"`
+	URL                 string               `json:"url"`
+	Service             string               `json:"service,omitempty"`
+	Source              string               `json:"source"`
+	Fault               string               `json:"fault,omitempty"`
+	Upstream            string               `json:"upstream,omitempty"` // Rewritten request and original response, as JSON
+	UpstreamTarget      string               `json:"upstream_target,omitempty"`
+	RequestHeaders      string               `json:"request_headers"`
+	RequestBody         []byte               `json:"request_body"`
+	ResponseStatus      int                  `json:"response_status"`
+	ResponseHeaders     string               `json:"response_headers"`
+	ResponseBody        []byte               `json:"response_body"`
+	Duration            int64                `json:"duration_ms"`
+	ClientIP            string               `json:"client_ip"`
+	TestID              string               `json:"test_id"`
+	SessionID           string               `json:"session_id"`
+	ConnectionID        string               `json:"connection_id,omitempty"`
+	MessageType         int                  `json:"message_type,omitempty"`
+	Direction           string               `json:"direction,omitempty"`
+	ValidationError     string               `json:"validation_error,omitempty"`
+	ValidationErrorType string               `json:"validation_error_type,omitempty"`
+	ValidationErrors    []db.ValidationError `json:"validation_errors,omitempty"` // Each OpenAPI violation, located in the body
+	RequestBodyBlob     string               `json:"request_body_blob,omitempty"`
+	ResponseBodyBlob    string               `json:"response_body_blob,omitempty"`
+	BodyTruncated       bool                 `json:"body_truncated,omitempty"`    // A blob-stored body is only partly inlined
+	ResponseEncoding    string               `json:"response_encoding,omitempty"` // Content-Encoding the response body was decoded from
+	TraceID             string               `json:"trace_id,omitempty"`
+	TraceURL            string               `json:"trace_url,omitempty"`       // Link to the trace in a tracing backend
+	ResponseChunks      string               `json:"response_chunks,omitempty"` // Events of a streamed response with their offsets, as JSON
+	Tags                map[string]string    `json:"tags,omitempty"`            // Tags plugins attached to the exchange
 }
 
-// NewUIHandler creates a new web interface handler
-func NewUIHandler(database *sql.DB) *UIHandler {
+// NewUIHandler creates a new web interface handler. blobs holds the large
+// bodies of recorded traffic and may be nil.
+func NewUIHandler(database *sql.DB, blobs *blob.Store) *UIHandler {
 	// Load HTML template from embedded filesystem
 	tmpl := template.Must(template.ParseFS(templateFS, "index.html"))
 	return &UIHandler{
 		database: database,
+		blobs:    blobs,
 		tmpl:     tmpl,
 	}
 }
 
+// SetTraceURL links transaction details to their trace. The template's
+// {trace_id} is replaced with the trace ID of the transaction.
+func (h *UIHandler) SetTraceURL(tmpl string) {
+	h.traceURL = tmpl
+}
+
 // RegisterRoutes sets up the HTTP routes for the web interface
 func (h *UIHandler) RegisterRoutes(mux *http.ServeMux) {
 	// Static assets
@@ -98,6 +134,9 @@ func (h *UIHandler) handleTransactionsList(w http.ResponseWriter, r *http.Reques
 	protocol := r.URL.Query().Get("protocol")
 	method := r.URL.Query().Get("method")
 	url := r.URL.Query().Get("url")
+	source := r.URL.Query().Get("source")
+	upstream := r.URL.Query().Get("upstream_target")
+	traceID := r.URL.Query().Get("trace_id")
 	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
 	pageSize, _ := strconv.Atoi(r.URL.Query().Get("pageSize"))
 
@@ -113,7 +152,8 @@ func (h *UIHandler) handleTransactionsList(w http.ResponseWriter, r *http.Reques
 	// Build query
 	queryParams := []any{}
 	query := `SELECT 
-        id, timestamp, protocol, method, url, response_status, duration_ms, response_headers
+        id, timestamp, protocol, method, url, COALESCE(service, ''), COALESCE(source, 'live'), COALESCE(fault, ''),
+        COALESCE(upstream_target, ''), response_status, duration_ms, response_headers
         FROM traffic_records WHERE 1=1`
 
 	if protocol != "" {
@@ -128,6 +168,18 @@ func (h *UIHandler) handleTransactionsList(w http.ResponseWriter, r *http.Reques
 		query += " AND url LIKE ?"
 		queryParams = append(queryParams, "%"+url+"%")
 	}
+	if source != "" {
+		query += " AND COALESCE(source, 'live') = ?"
+		queryParams = append(queryParams, source)
+	}
+	if upstream != "" {
+		query += " AND upstream_target = ?"
+		queryParams = append(queryParams, upstream)
+	}
+	if traceID != "" {
+		query += " AND trace_id = ?"
+		queryParams = append(queryParams, traceID)
+	}
 
 	// Add count query
 	countQuery := "SELECT COUNT(*) FROM traffic_records WHERE 1=1"
@@ -140,6 +192,15 @@ func (h *UIHandler) handleTransactionsList(w http.ResponseWriter, r *http.Reques
 	if url != "" {
 		countQuery += " AND url LIKE ?"
 	}
+	if source != "" {
+		countQuery += " AND COALESCE(source, 'live') = ?"
+	}
+	if upstream != "" {
+		countQuery += " AND upstream_target = ?"
+	}
+	if traceID != "" {
+		countQuery += " AND trace_id = ?"
+	}
 
 	// Add pagination
 	query += " ORDER BY timestamp DESC LIMIT ? OFFSET ?"
@@ -168,7 +229,7 @@ func (h *UIHandler) handleTransactionsList(w http.ResponseWriter, r *http.Reques
 	for rows.Next() {
 		var t TransactionSummary
 		var respHeaders string
-		err := rows.Scan(&t.ID, &t.Timestamp, &t.Protocol, &t.Method, &t.URL, &t.Status, &t.Duration, &respHeaders)
+		err := rows.Scan(&t.ID, &t.Timestamp, &t.Protocol, &t.Method, &t.URL, &t.Service, &t.Source, &t.Fault, &t.UpstreamTarget, &t.Status, &t.Duration, &respHeaders)
 		if err != nil {
 			slog.Warn("Error scanning transaction row", "error", err)
 			continue
@@ -213,19 +274,28 @@ func (h *UIHandler) handleTransactionDetail(w http.ResponseWriter, r *http.Reque
 		http.Error(w, "Transaction ID is required", http.StatusBadRequest)
 		return
 	}
+	if id, part, ok := strings.Cut(id, "/body/"); ok {
+		h.handleTransactionBody(w, r, id, part)
+		return
+	}
 
 	// Query transaction details
 	query := `SELECT 
-        id, timestamp, protocol, method, url, request_headers, request_body,
+        id, timestamp, protocol, method, url, COALESCE(service, ''), COALESCE(source, 'live'), COALESCE(fault, ''), COALESCE(upstream, ''), COALESCE(upstream_target, ''), request_headers, request_body,
         response_status, response_headers, response_body, duration_ms,
-        client_ip, test_id, session_id, connection_id, message_type, direction
+        client_ip, test_id, session_id, connection_id, message_type, direction,
+        COALESCE(request_body_blob, ''), COALESCE(response_body_blob, ''), COALESCE(response_encoding, ''),
+        COALESCE(trace_id, ''), COALESCE(response_chunks, ''), COALESCE(tags, '')
         FROM traffic_records WHERE id = ?`
 
 	var t TransactionDetail
+	var tags string
 	err := h.database.QueryRow(query, id).Scan(
-		&t.ID, &t.Timestamp, &t.Protocol, &t.Method, &t.URL, &t.RequestHeaders, &t.RequestBody,
+		&t.ID, &t.Timestamp, &t.Protocol, &t.Method, &t.URL, &t.Service, &t.Source, &t.Fault, &t.Upstream, &t.UpstreamTarget, &t.RequestHeaders, &t.RequestBody,
 		&t.ResponseStatus, &t.ResponseHeaders, &t.ResponseBody, &t.Duration,
 		&t.ClientIP, &t.TestID, &t.SessionID, &t.ConnectionID, &t.MessageType, &t.Direction,
+		&t.RequestBodyBlob, &t.ResponseBodyBlob, &t.ResponseEncoding,
+		&t.TraceID, &t.ResponseChunks, &tags,
 	)
 	if err != nil {
 		if err == sql.ErrNoRows {
@@ -237,6 +307,16 @@ func (h *UIHandler) handleTransactionDetail(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
+	if tags != "" {
+		if err := json.Unmarshal([]byte(tags), &t.Tags); err != nil {
+			slog.Warn("Invalid tags on transaction", "id", t.ID, "error", err)
+		}
+	}
+
+	if t.TraceID != "" && h.traceURL != "" {
+		t.TraceURL = strings.ReplaceAll(h.traceURL, "{trace_id}", t.TraceID)
+	}
+
 	// Extract API validation error information from headers
 	var respHeaders map[string][]string
 	if err := json.Unmarshal([]byte(t.ResponseHeaders), &respHeaders); err == nil {
@@ -255,5 +335,121 @@ func (h *UIHandler) handleTransactionDetail(w http.ResponseWriter, r *http.Reque
 		}
 	}
 
+	validationErrs, err := h.validationErrors(t.ID)
+	if err != nil {
+		slog.Warn("Error querying validation errors", "id", t.ID, "error", err)
+	}
+	t.ValidationErrors = validationErrs
+	if len(validationErrs) > 0 && t.ValidationErrorType == "" {
+		t.ValidationErrorType = validationErrs[0].Kind
+		t.ValidationError = "Failed OpenAPI schema validation"
+	}
+
+	// Inline the start of bodies kept in the blob store
+	if t.RequestBodyBlob != "" {
+		t.RequestBody = h.previewBlob(t.RequestBodyBlob, &t.BodyTruncated)
+	}
+	if t.ResponseBodyBlob != "" {
+		t.ResponseBody = h.previewBlob(t.ResponseBodyBlob, &t.BodyTruncated)
+	}
+
 	json.NewEncoder(w).Encode(t)
 }
+
+// validationErrors returns the OpenAPI violations stored for a record
+func (h *UIHandler) validationErrors(id string) ([]db.ValidationError, error) {
+	rows, err := h.database.Query(`SELECT kind, COALESCE(location, ''), COALESCE(parameter, ''), COALESCE(pointer, ''),
+        COALESCE(schema_path, ''), COALESCE(keyword, ''), COALESCE(expected, ''), COALESCE(actual, ''), COALESCE(message, '')
+        FROM validation_errors WHERE record_id = ? ORDER BY id`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var errs []db.ValidationError
+	for rows.Next() {
+		var e db.ValidationError
+		var expected, actual string
+		if err := rows.Scan(&e.Kind, &e.In, &e.Parameter, &e.Pointer, &e.SchemaPath, &e.Keyword, &expected, &actual, &e.Message); err != nil {
+			return nil, err
+		}
+		if expected != "" {
+			e.Expected = json.RawMessage(expected)
+		}
+		if actual != "" {
+			e.Actual = json.RawMessage(actual)
+		}
+		errs = append(errs, e)
+	}
+	return errs, rows.Err()
+}
+
+// previewBlob returns up to bodyPreviewSize bytes of a blob, setting truncated
+// when there is more
+func (h *UIHandler) previewBlob(ref string, truncated *bool) []byte {
+	if h.blobs == nil {
+		return nil
+	}
+	body, size, err := h.blobs.Open(ref)
+	if err != nil {
+		slog.Warn("Error reading body from the blob store", "blob", ref, "error", err)
+		return nil
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, bodyPreviewSize))
+	if err != nil {
+		slog.Warn("Error reading body from the blob store", "blob", ref, "error", err)
+		return nil
+	}
+	if size > int64(len(data)) {
+		*truncated = true
+	}
+	return data
+}
+
+// handleTransactionBody streams the full request or response body of a
+// transaction, reading it from the blob store when it is kept there
+func (h *UIHandler) handleTransactionBody(w http.ResponseWriter, r *http.Request, id, part string) {
+	column, blobColumn := "request_body", "request_body_blob"
+	switch part {
+	case "request":
+	case "response":
+		column, blobColumn = "response_body", "response_body_blob"
+	default:
+		http.Error(w, "Body must be request or response", http.StatusBadRequest)
+		return
+	}
+
+	var data []byte
+	var ref string
+	query := "SELECT " + column + ", COALESCE(" + blobColumn + ", '') FROM traffic_records WHERE id = ?"
+	if err := h.database.QueryRow(query, id).Scan(&data, &ref); err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Transaction not found", http.StatusNotFound)
+		} else {
+			slog.Error("Error querying transaction body", "error", err)
+			http.Error(w, "Database error", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment; filename=\""+id+"-"+part+".bin\"")
+	if ref == "" {
+		w.Write(data)
+		return
+	}
+	if h.blobs == nil {
+		http.Error(w, "Body is in the blob store, which is disabled", http.StatusNotFound)
+		return
+	}
+	body, size, err := h.blobs.Open(ref)
+	if err != nil {
+		slog.Warn("Error reading body from the blob store", "blob", ref, "error", err)
+		http.Error(w, "Body not found in the blob store", http.StatusNotFound)
+		return
+	}
+	defer body.Close()
+	w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	io.Copy(w, body)
+}
@@ -1,6 +1,7 @@
 package web
 
 import (
+	"bytes"
 	"database/sql"
 	"encoding/json"
 	"net/http"
@@ -11,6 +12,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/dipjyotimetia/jarvis/internal/blob"
 	_ "github.com/ncruces/go-sqlite3/driver"
 	_ "github.com/ncruces/go-sqlite3/embed"
 )
@@ -52,7 +54,30 @@ func setupTestDB(t *testing.T) (*sql.DB, string) {
 		session_id TEXT,
 		connection_id TEXT,
 		message_type INTEGER,
-		direction TEXT
+		direction TEXT,
+		source TEXT DEFAULT 'live',
+		fault TEXT,
+		upstream TEXT,
+		upstream_target TEXT,
+		request_body_blob TEXT,
+		response_body_blob TEXT,
+		response_encoding TEXT,
+		trace_id TEXT,
+		response_chunks TEXT,
+		tags TEXT
+	);
+	CREATE TABLE IF NOT EXISTS validation_errors (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		record_id TEXT NOT NULL,
+		kind TEXT NOT NULL,
+		location TEXT,
+		parameter TEXT,
+		pointer TEXT,
+		schema_path TEXT,
+		keyword TEXT,
+		expected TEXT,
+		actual TEXT,
+		message TEXT
 	)`)
 	if err != nil {
 		db.Close()
@@ -182,7 +207,7 @@ func TestNewUIHandler(t *testing.T) {
 	db, dbPath := setupTestDB(t)
 	defer cleanupTestDB(db, dbPath)
 
-	handler := NewUIHandler(db)
+	handler := NewUIHandler(db, nil)
 
 	if handler == nil {
 		t.Fatal("NewUIHandler returned nil")
@@ -201,7 +226,7 @@ func TestRegisterRoutes(t *testing.T) {
 	db, dbPath := setupTestDB(t)
 	defer cleanupTestDB(db, dbPath)
 
-	handler := NewUIHandler(db)
+	handler := NewUIHandler(db, nil)
 	mux := http.NewServeMux()
 	handler.RegisterRoutes(mux)
 
@@ -238,7 +263,7 @@ func TestHandleIndex(t *testing.T) {
 	db, dbPath := setupTestDB(t)
 	defer cleanupTestDB(db, dbPath)
 
-	handler := NewUIHandler(db)
+	handler := NewUIHandler(db, nil)
 
 	// Create a request to test the handler
 	req, err := http.NewRequest("GET", "/ui/", nil)
@@ -272,7 +297,7 @@ func TestHandleTransactionsList(t *testing.T) {
 	db, dbPath := setupTestDB(t)
 	defer cleanupTestDB(db, dbPath)
 
-	handler := NewUIHandler(db)
+	handler := NewUIHandler(db, nil)
 
 	// Test cases for different query parameters
 	tests := []struct {
@@ -320,6 +345,26 @@ func TestHandleTransactionsList(t *testing.T) {
 			queryParams:   "page=3&pageSize=2",
 			expectedCount: 0, // Third page, no items
 		},
+		{
+			name:          "Filter by live source",
+			queryParams:   "source=live",
+			expectedCount: 4, // Records default to live
+		},
+		{
+			name:          "Filter by replayed source",
+			queryParams:   "source=replay",
+			expectedCount: 0, // No replayed records
+		},
+		{
+			name:          "Filter by injected fault source",
+			queryParams:   "source=fault",
+			expectedCount: 0, // No injected faults
+		},
+		{
+			name:          "Filter by upstream target",
+			queryParams:   "upstream_target=http://10.0.0.2:8080",
+			expectedCount: 0, // No load-balanced records
+		},
 		{
 			name:          "Complex filter",
 			queryParams:   "protocol=HTTP&method=POST&pageSize=10",
@@ -420,7 +465,7 @@ func TestHandleTransactionDetail(t *testing.T) {
 	db, dbPath := setupTestDB(t)
 	defer cleanupTestDB(db, dbPath)
 
-	handler := NewUIHandler(db)
+	handler := NewUIHandler(db, nil)
 
 	// Test cases
 	tests := []struct {
@@ -500,7 +545,7 @@ func TestDatabaseErrorHandling(t *testing.T) {
 	// Create a database connection that will be closed immediately
 	// to simulate database errors
 	db, dbPath := setupTestDB(t)
-	handler := NewUIHandler(db)
+	handler := NewUIHandler(db, nil)
 
 	// Close the database to cause errors
 	db.Close()
@@ -528,3 +573,143 @@ func TestDatabaseErrorHandling(t *testing.T) {
 		}
 	})
 }
+
+func TestTransactionBodiesFromBlobStore(t *testing.T) {
+	db, dbPath := setupTestDB(t)
+	defer cleanupTestDB(db, dbPath)
+
+	blobs := blob.NewStore(t.TempDir(), 0)
+	large := bytes.Repeat([]byte("x"), bodyPreviewSize+10)
+	ref, err := blobs.Put(large)
+	if err != nil {
+		t.Fatalf("Failed to store blob: %v", err)
+	}
+	if _, err := db.Exec(`UPDATE traffic_records SET response_body = NULL, response_body_blob = ? WHERE id = 'http-1'`, ref); err != nil {
+		t.Fatalf("Failed to update record: %v", err)
+	}
+	handler := NewUIHandler(db, blobs)
+
+	// The detail inlines the start of the body
+	rr := httptest.NewRecorder()
+	handler.handleTransactionDetail(rr, httptest.NewRequest("GET", "/api/transactions/http-1", nil))
+	var detail TransactionDetail
+	if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("Failed to decode detail: %v", err)
+	}
+	if detail.ResponseBodyBlob != ref || !detail.BodyTruncated || len(detail.ResponseBody) != bodyPreviewSize {
+		t.Errorf("Expected a truncated preview of the blob, got ref %q truncated %v and %d bytes", detail.ResponseBodyBlob, detail.BodyTruncated, len(detail.ResponseBody))
+	}
+
+	// The body endpoint serves it in full
+	rr = httptest.NewRecorder()
+	handler.handleTransactionDetail(rr, httptest.NewRequest("GET", "/api/transactions/http-1/body/response", nil))
+	if rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), large) {
+		t.Errorf("Expected the full body, got %d with %d bytes", rr.Code, rr.Body.Len())
+	}
+
+	// Bodies kept in the table are served too
+	rr = httptest.NewRecorder()
+	handler.handleTransactionDetail(rr, httptest.NewRequest("GET", "/api/transactions/http-2/body/request", nil))
+	if rr.Body.String() != `{"name": "New User"}` {
+		t.Errorf("Expected the stored request body, got %q", rr.Body.String())
+	}
+
+	for path, want := range map[string]int{
+		"/api/transactions/http-1/body/headers":  http.StatusBadRequest,
+		"/api/transactions/missing/body/request": http.StatusNotFound,
+	} {
+		rr = httptest.NewRecorder()
+		handler.handleTransactionDetail(rr, httptest.NewRequest("GET", path, nil))
+		if rr.Code != want {
+			t.Errorf("GET %s returned %d, want %d", path, rr.Code, want)
+		}
+	}
+}
+
+func TestTransactionTraces(t *testing.T) {
+	db, dbPath := setupTestDB(t)
+	defer cleanupTestDB(db, dbPath)
+
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	if _, err := db.Exec(`UPDATE traffic_records SET trace_id = ? WHERE id = 'http-2'`, traceID); err != nil {
+		t.Fatalf("Failed to update record: %v", err)
+	}
+	handler := NewUIHandler(db, nil)
+	handler.SetTraceURL("http://localhost:16686/trace/{trace_id}")
+
+	// The list filters by trace
+	rr := httptest.NewRecorder()
+	handler.handleTransactionsList(rr, httptest.NewRequest("GET", "/api/transactions?trace_id="+traceID, nil))
+	var list TransactionListResponse
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatalf("Failed to decode list: %v", err)
+	}
+	if list.Total != 1 || len(list.Transactions) != 1 || list.Transactions[0].ID != "http-2" {
+		t.Errorf("Expected only http-2 for the trace, got %+v", list)
+	}
+
+	// The detail links to the trace
+	rr = httptest.NewRecorder()
+	handler.handleTransactionDetail(rr, httptest.NewRequest("GET", "/api/transactions/http-2", nil))
+	var detail TransactionDetail
+	if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("Failed to decode detail: %v", err)
+	}
+	if detail.TraceID != traceID || detail.TraceURL != "http://localhost:16686/trace/"+traceID {
+		t.Errorf("Expected the trace ID and link, got %q and %q", detail.TraceID, detail.TraceURL)
+	}
+}
+
+func TestTransactionTags(t *testing.T) {
+	db, dbPath := setupTestDB(t)
+	defer cleanupTestDB(db, dbPath)
+
+	if _, err := db.Exec(`UPDATE traffic_records SET tags = '{"client":"curl","status_class":"2xx"}' WHERE id = 'http-2'`); err != nil {
+		t.Fatalf("Failed to update record: %v", err)
+	}
+	handler := NewUIHandler(db, nil)
+
+	rr := httptest.NewRecorder()
+	handler.handleTransactionDetail(rr, httptest.NewRequest("GET", "/api/transactions/http-2", nil))
+	var detail TransactionDetail
+	if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("Failed to decode detail: %v", err)
+	}
+	if detail.Tags["client"] != "curl" || detail.Tags["status_class"] != "2xx" {
+		t.Errorf("Expected the plugin tags, got %v", detail.Tags)
+	}
+}
+
+func TestTransactionValidationErrors(t *testing.T) {
+	db, dbPath := setupTestDB(t)
+	defer cleanupTestDB(db, dbPath)
+
+	_, err := db.Exec(`INSERT INTO validation_errors (record_id, kind, location, pointer, schema_path, keyword, expected, actual, message)
+		VALUES ('http-2', 'request', 'body', '/name', '#/components/schemas/User/properties/name/maxLength', 'maxLength', '5', '"New User"', 'maximum string length is 5')`)
+	if err != nil {
+		t.Fatalf("Failed to insert validation error: %v", err)
+	}
+	handler := NewUIHandler(db, nil)
+
+	rr := httptest.NewRecorder()
+	handler.handleTransactionDetail(rr, httptest.NewRequest("GET", "/api/transactions/http-2", nil))
+	var detail TransactionDetail
+	if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil {
+		t.Fatalf("Failed to decode detail: %v", err)
+	}
+	if len(detail.ValidationErrors) != 1 || detail.ValidationErrorType != "request" {
+		t.Fatalf("Expected the stored request violation, got %+v", detail)
+	}
+	v := detail.ValidationErrors[0]
+	if v.Pointer != "/name" || v.Keyword != "maxLength" || string(v.Expected) != "5" || string(v.Actual) != `"New User"` {
+		t.Errorf("Expected the violation with its pointer and values, got %+v", v)
+	}
+
+	// Records without violations have none
+	rr = httptest.NewRecorder()
+	handler.handleTransactionDetail(rr, httptest.NewRequest("GET", "/api/transactions/http-1", nil))
+	detail = TransactionDetail{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &detail); err != nil || len(detail.ValidationErrors) != 0 {
+		t.Errorf("Expected no violations for http-1, got %+v (%v)", detail.ValidationErrors, err)
+	}
+}
@@ -2,6 +2,9 @@ package ollama
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
@@ -77,7 +80,11 @@ func New(ctx context.Context) (Client, error) {
 
 // NewWithURL creates a new Ollama client with a specific URL
 func NewWithURL(baseURL string) (Client, error) {
-	apiClient := api.NewClient(nil, nil)
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("parsing Ollama URL: %w", err)
+	}
+	apiClient := api.NewClient(base, http.DefaultClient)
 
 	return &client{
 		apiClient: apiClient,